@@ -42,12 +42,20 @@ import (
 type ainfo_item struct {
     ip          net.IP
     port        string
+    proto       string
 }
 
 func (self *ainfo_item) HostPort() *sippy_net.HostPort {
     return sippy_net.NewHostPort(self.ip.String(), self.port)
 }
 
+func (self *ainfo_item) String() string {
+    if self.proto == "" {
+        return self.HostPort().String()
+    }
+    return strings.ToLower(self.proto) + ":" + self.HostPort().String()
+}
+
 type B2BRoute struct {
     cld             string
     cld_set         bool
@@ -68,6 +76,7 @@ type B2BRoute struct {
     extra_headers   []sippy_header.SipHeader
     rtpp            bool
     outbound_proxy  *sippy_net.HostPort
+    transport       string
     rnum            int
     params          map[string]string
 }
@@ -115,23 +124,6 @@ func NewB2BRoute(sroute string, global_config sippy_conf.Config) (*B2BRoute, err
         ipv6only = true
         self.hostonly = "[" + hostport[0] + "]"
     }
-    var port *sippy_net.MyPort
-    if len(hostport) == 1 {
-        port = global_config.GetMyPort()
-    } else {
-        port = sippy_net.NewMyPort(hostport[1])
-    }
-    self.ainfo = make([]*ainfo_item, 0)
-    ips, err := net.LookupIP(hostport[0])
-    if err != nil {
-        return nil, errors.New("NewB2BRoute: error resolving host IP '" + hostport[0] + "': " + err.Error())
-    }
-    for _, ip := range ips {
-        if ipv6only && sippy_net.IsIP4(ip) {
-            continue
-        }
-        self.ainfo = append(self.ainfo, &ainfo_item{ ip, port.String() })
-    }
     for _, x := range route[1:] {
         av := strings.SplitN(x, "=", 2)
         if len(av) != 2 {
@@ -211,10 +203,50 @@ func NewB2BRoute(sroute string, global_config sippy_conf.Config) (*B2BRoute, err
             } else {
                 self.outbound_proxy = sippy_net.NewHostPort(host_port[0], host_port[1])
             }
+        case "transport":
+            self.transport = strings.ToLower(s_v)
         default:
             self.params[a] = s_v
         }
     }
+    if resolver := global_config.GetResolver(); resolver != nil {
+        port := ""
+        if len(hostport) > 1 {
+            port = hostport[1]
+        }
+        targets, err := resolver.Resolve(hostport[0], port, self.transport, false)
+        if err != nil {
+            return nil, errors.New("NewB2BRoute: error resolving '" + hostport[0] + "': " + err.Error())
+        }
+        self.ainfo = make([]*ainfo_item, 0)
+        for _, target := range targets {
+            ip := target.Address.ParseIP()
+            if ipv6only && sippy_net.IsIP4(ip) {
+                continue
+            }
+            self.ainfo = append(self.ainfo, &ainfo_item{ ip, target.Address.Port.String(), target.Proto })
+        }
+        return self, nil
+    }
+    var port *sippy_net.MyPort
+    if len(hostport) > 1 {
+        port = sippy_net.NewMyPort(hostport[1])
+    } else if self.transport == "tls" {
+        port = global_config.GetTlsPort()
+    } else {
+        port = global_config.GetMyPort()
+    }
+    self.ainfo = make([]*ainfo_item, 0)
+    ips, err := net.LookupIP(hostport[0])
+    if err != nil {
+        return nil, errors.New("NewB2BRoute: error resolving host IP '" + hostport[0] + "': " + err.Error())
+    }
+    for _, ip := range ips {
+        if ipv6only && sippy_net.IsIP4(ip) {
+            continue
+        }
+        self.ainfo = append(self.ainfo, &ainfo_item{ ip, port.String(), "" })
+    }
     return self, nil
 }
 
@@ -259,17 +291,43 @@ func (self *B2BRoute) getCopy() *B2BRoute {
 }
 
 func (self *B2BRoute) getNHAddr(source *sippy_net.HostPort) *sippy_net.HostPort {
+    return self.getNHTarget(source).HostPort()
+}
+
+// getNHTargets returns the next hop addresses in the order they should
+// be tried. The blacklisted ones are skipped unless all of them are.
+func (self *B2BRoute) getNHTargets(source *sippy_net.HostPort, blacklist *TargetBlacklist) []*ainfo_item {
+    first := self.getNHTarget(source)
+    ret := []*ainfo_item{ first }
+    for _, it := range self.ainfo {
+        if it != first {
+            ret = append(ret, it)
+        }
+    }
+    active := make([]*ainfo_item, 0, len(ret))
+    for _, it := range ret {
+        if ! blacklist.IsBlacklisted(it.String()) {
+            active = append(active, it)
+        }
+    }
+    if len(active) == 0 {
+        return ret
+    }
+    return active
+}
+
+func (self *B2BRoute) getNHTarget(source *sippy_net.HostPort) *ainfo_item {
     src_ip := net.ParseIP(source.Host.String())
     if src_ip == nil {
-        return self.ainfo[0].HostPort()
+        return self.ainfo[0]
     }
     src_is_ipv4 := sippy_net.IsIP4(src_ip)
     for _, it := range self.ainfo {
         if src_is_ipv4 && sippy_net.IsIP4(it.ip) {
-            return it.HostPort()
+            return it
         } else if ! src_is_ipv4 && ! sippy_net.IsIP4(it.ip) {
-            return it.HostPort()
+            return it
         }
     }
-    return self.ainfo[0].HostPort()
+    return self.ainfo[0]
 }
@@ -70,6 +70,26 @@ type callController struct {
     sdp_session     *sippy.SdpSession
     cmap            *CallMap
     auth_proc       Cancellable
+    oroute          *B2BRoute
+    oattempt        int
+    group_timer     *sippy.Timeout
+    otarget         *ainfo_item
+    otargets        []*ainfo_item
+    uaN             sippy_types.UA
+    uaR             sippy_types.UA
+    uaR_gone        bool
+    replacing       bool
+    refer_sub       *sippy.ReferSubscription
+    reinvite_pending bool
+    xfer_cli        string
+    xfer_cld        string
+    xfer_routes     []*B2BRoute
+    xfer_huntstop   []int
+    xfer_acct       *RadiusAccounting
+    xfer_lookup     *replacesLookup
+    rpl_remote_ip   *sippy_net.MyAddress
+    rpl_challenge   *sippy_header.SipWWWAuthenticate
+    rpl_event       *sippy.CCEventTry
 }
 
 func NewCallController(id int64, remote_ip *sippy_net.MyAddress, source *sippy_net.HostPort, global_config *myConfigParser,
@@ -93,6 +113,8 @@ func NewCallController(id int64, remote_ip *sippy_net.MyAddress, source *sippy_n
     }
     self.uaA = sippy.NewUA(sip_tm, global_config, nil, self, self.lock, nil)
     self.uaA.SetKaInterval(self.global_config.Keepalive_ans_dur)
+    self.uaA.SetSessionTimer(self.global_config.Session_expires_dur, sippy.SESSION_MIN_SE)
+    self.uaA.SetUseUpdate(self.global_config.Use_update)
     self.uaA.SetLocalUA(sippy_header.NewSipUserAgent(self.global_config.GetMyUAName()))
     self.uaA.SetConnCb(self.aConn)
     self.uaA.SetDiscCb(self.aDisc)
@@ -102,6 +124,13 @@ func NewCallController(id int64, remote_ip *sippy_net.MyAddress, source *sippy_n
 }
 
 func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.UA) {
+    if self.uaR != nil && self.recvReplacesEvent(event, ua) {
+        return
+    }
+    if ua != self.uaA && ua != self.uaO {
+        // The leg replaced earlier
+        return
+    }
     if ua == self.uaA {
         if self.state == CCStateIdle {
             ev_try, ok := event.(*sippy.CCEventTry)
@@ -179,6 +208,7 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
             }
             self.eTry = ev_try
             self.state = CCStateWaitRoute
+            self.cmap.ccStateChanged(self)
             if ! self.global_config.Auth_enable {
                 self.username = self.remote_ip.String()
                 self.rDone_nolock(NewRadiusResult())
@@ -206,13 +236,53 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
             }
             return
         }
+        ev_disc, is_ev_disc := event.(*sippy.CCEventDisconnect)
+        if is_ev_disc && self.state == CCStateConnected {
+            if self.uaA.GetState() != sippy_types.UA_STATE_CONNECTED {
+                // aDisc() has kept the call for the transfer
+                if self.recvTransfer(ev_disc, ua) {
+                    return
+                }
+                self.aDisc(event.GetRtime(), event.GetOrigin(), 0, nil)
+            } else if ev_disc.GetReferSubscription() != nil && self.recvTransfer(ev_disc, ua) {
+                return
+            }
+        }
         if (self.state != CCStateARComplete && self.state != CCStateConnected && self.state != CCStateDisconnecting) || self.uaO == nil {
+            if is_ev_disc && ev_disc.GetReferSubscription() != nil {
+                // There is no one to pass the transfer on to
+                ev_disc.GetReferSubscription().RecvEvent(event)
+            }
             return
         }
         self.uaO.RecvEvent(event)
     } else {
         ev_fail, is_ev_fail := event.(*sippy.CCEventFail)
-        _, is_ev_disconnect := event.(*sippy.CCEventFail)
+        ev_disconnect, is_ev_disconnect := event.(*sippy.CCEventDisconnect)
+        if is_ev_disconnect && self.state == CCStateConnected && self.recvTransfer(ev_disconnect, ua) {
+            return
+        }
+        if self.otarget != nil {
+            if self.isTargetFailure(event) {
+                self.cmap.blacklist.Failure(self.otarget.String())
+                if self.state == CCStateARComplete && len(self.otargets) > 0 &&
+                  (self.uaA.GetState() == sippy_types.UAS_STATE_TRYING ||
+                  self.uaA.GetState() == sippy_types.UAS_STATE_RINGING) {
+                    // Try the next address of the same route
+                    target := self.otargets[0]
+                    self.otargets = self.otargets[1:]
+                    self.placeOriginateTarget(self.oroute, target)
+                    return
+                }
+            } else if ! is_ev_disconnect {
+                self.cmap.blacklist.Success(self.otarget.String())
+            }
+            self.otarget = nil
+        }
+        // The originate leg that has been disconnected before being
+        // answered (i.e. no final response has been received before
+        // the local timeout) moves on to the next route the same way
+        // as the failed one does.
         if (is_ev_fail || is_ev_disconnect) && self.state == CCStateARComplete &&
           (self.uaA.GetState() == sippy_types.UAS_STATE_TRYING ||
           self.uaA.GetState() == sippy_types.UAS_STATE_RINGING) && len(self.routes) > 0 {
@@ -272,14 +342,32 @@ func (self *callController) rDone_nolock(results *RadiusResult) {
         self.acctA.Disc(self.uaA, rtime, "caller", 0)
         return
     }
-    cli := ""
+    routes, reason := self.getRoutes(results, self.cli, self.cld)
+    if reason != "" {
+        self.uaA.RecvEvent(sippy.NewCCEventFail(500, reason, nil, ""))
+        self.state = CCStateDead
+        return
+    }
+    self.routes = routes
+    self.state = CCStateARComplete
+    self.cmap.ccStateChanged(self)
+    route := self.routes[0]
+    self.routes = self.routes[1:]
+    self.placeOriginate(route)
+}
+
+// getRoutes builds the list of routes for the call from cli to cld out
+// of the authorisation results. The non-empty reason is returned when
+// there is no route to use.
+func (self *callController) getRoutes(results *RadiusResult, cli, cld string) ([]*B2BRoute, string) {
+    avp_cli := ""
     caller_name := ""
     credit_time := time.Duration(0)
     credit_time_found := false
     for _, avp := range results.Avps {
         if avp.name == "h323-ivr-in" {
-            if cli == "" && strings.HasPrefix(avp.value, "CLI:") {
-                cli = avp.value[4:]
+            if avp_cli == "" && strings.HasPrefix(avp.value, "CLI:") {
+                avp_cli = avp.value[4:]
             }
             if caller_name == "" && strings.HasPrefix(avp.value, "CNAM:") {
                 caller_name = avp.value[5:]
@@ -294,6 +382,7 @@ func (self *callController) rDone_nolock(results *RadiusResult) {
         }
     }
     routing := []*B2BRoute{}
+    routes := []*B2BRoute{}
 
     if self.cmap.static_route == nil {
         for _, avp := range results.Avps {
@@ -305,9 +394,7 @@ func (self *callController) rDone_nolock(results *RadiusResult) {
             }
         }
         if len(routing) == 0 {
-            self.uaA.RecvEvent(sippy.NewCCEventFail(500, "Internal Server Error (2)", nil, ""))
-            self.state = CCStateDead
-            return
+            return nil, "Internal Server Error (2)"
         }
     } else {
         routing = []*B2BRoute{ self.cmap.static_route.getCopy() }
@@ -315,26 +402,49 @@ func (self *callController) rDone_nolock(results *RadiusResult) {
     rnum := 0
     for _, oroute := range routing {
         rnum += 1
-        oroute.customize(rnum, self.cld, self.cli, 0, self.pass_headers, 0)
-        oroute.customize(rnum, self.cld, self.cli, credit_time, self.pass_headers, time.Duration(self.global_config.Max_credit_time) * time.Second)
+        oroute.customize(rnum, cld, cli, 0, self.pass_headers, 0)
+        oroute.customize(rnum, cld, cli, credit_time, self.pass_headers, time.Duration(self.global_config.Max_credit_time) * time.Second)
         if oroute.credit_time == 0 || oroute.expires == 0 {
             continue
         }
-        self.routes = append(self.routes, oroute)
+        routes = append(routes, oroute)
         //println "Got route:", oroute.hostport, oroute.cld
     }
-    if len(self.routes) == 0 {
-        self.uaA.RecvEvent(sippy.NewCCEventFail(500, "Internal Server Error (3)", nil, ""))
-        self.state = CCStateDead
-        return
+    if len(routes) == 0 {
+        return nil, "Internal Server Error (3)"
     }
-    self.state = CCStateARComplete
-    route := self.routes[0]
-    self.routes = self.routes[1:]
-    self.placeOriginate(route)
+    return routes, ""
+}
+
+// isTargetFailure returns true if the event means that the next hop
+// is not available, i.e. it has timed out or responded 503 without
+// Retry-After.
+func (self *callController) isTargetFailure(event sippy_types.CCEvent) bool {
+    switch ev := event.(type) {
+    case *sippy.CCEventFail:
+        return ev.GetScode() == 408 || (ev.GetScode() == 503 && ev.GetRetryAfter() == nil)
+    case *sippy.CCEventDisconnect:
+        // Nothing has been received from the target
+        return self.uaO != nil && self.uaO.GetP100Ts() == nil
+    }
+    return false
 }
 
 func (self *callController) placeOriginate(oroute *B2BRoute) {
+    self.oroute = oroute
+    self.oattempt = 0
+    self.otargets = nil
+    self.startGroupTimer(oroute)
+    if oroute.hostport == "sip-ua" {
+        self.placeOriginateTarget(oroute, nil)
+        return
+    }
+    targets := oroute.getNHTargets(self.source, self.cmap.blacklist)
+    self.otargets = targets[1:]
+    self.placeOriginateTarget(oroute, targets[0])
+}
+
+func (self *callController) placeOriginateTarget(oroute *B2BRoute, nh_target *ainfo_item) {
     //cId, cGUID, cli, cld, body, auth, caller_name = self.eTry.getData()
     cld := oroute.cld
     self.huntstop_scodes = oroute.huntstop_scodes
@@ -349,42 +459,36 @@ func (self *callController) placeOriginate(oroute *B2BRoute) {
     }
     var nh_address *sippy_net.HostPort
     var host string
-    if oroute.hostport == "sip-ua" {
+    transport := oroute.transport
+    self.otarget = nh_target
+    if nh_target == nil {
         host = self.source.Host.String()
         nh_address = self.source
     } else {
         host = oroute.hostonly
-        nh_address = oroute.getNHAddr(self.source)
+        nh_address = nh_target.HostPort()
+        if transport == "" && nh_target.proto != "" && nh_target.proto != "UDP" {
+            // The transport has been selected by the RFC 3263 resolver
+            transport = strings.ToLower(nh_target.proto)
+        }
     }
     if ! oroute.forward_on_fail && self.global_config.Acct_enable {
-        self.acctO = NewRadiusAccounting(self.global_config, "originate", self.cmap.radius_client)
-        bill_to := self.username
-        if v, ok := oroute.params["bill-to"]; ok {
-            bill_to = v
-        }
-        cli := oroute.cli
-        if v, ok := oroute.params["bill-cli"]; ok {
-            cli = v
-        }
-        cld := oroute.cld
-        if v, ok := oroute.params["bill-cld"]; ok {
-            cld = v
-        }
-        self.acctO.SetParams(bill_to, cli, cld, self.cGUID.StringBody(), self.cId.StringBody(), host, "")
+        self.acctO = self.newOriginateAcct(oroute, host)
     } else {
         self.acctO = nil
     }
     //self.acctA.credit_time = oroute.credit_time
     self.uaO = sippy.NewUA(self.sip_tm, self.global_config, nh_address, self, self.lock, nil)
     self.uaO.SetUsername(oroute.user)
+    self.uaO.SetRTargetTransport(transport)
     self.uaO.SetPassword(oroute.passw)
     if oroute.credit_time > 0 {
         self.uaO.SetCreditTime(oroute.credit_time)
     }
     self.uaO.SetConnCb(self.oConn)
     if ! oroute.forward_on_fail && self.global_config.Acct_enable {
-        self.uaO.SetDiscCb(func(rtime *sippy_time.MonoTime, origin string, scode int, req sippy_types.SipRequest) { self.acctO.Disc(self.uaO, rtime, origin, scode) })
-        self.uaO.SetFailCb(func(rtime *sippy_time.MonoTime, origin string, scode int) { self.acctO.Disc(self.uaO, rtime, origin, scode) })
+        self.uaO.SetDiscCb(self.oDisc)
+        self.uaO.SetFailCb(self.oFail)
     }
     self.uaO.SetDeadCb(self.oDead)
     if oroute.expires > 0 {
@@ -410,29 +514,20 @@ func (self *callController) placeOriginate(oroute *B2BRoute) {
         self.proxied = true
     }
     self.uaO.SetKaInterval(self.global_config.Keepalive_orig_dur)
-    if gt, ok := oroute.params["gt"]; ok {
-        arr := strings.SplitN(gt, ",", 2)
-        if len(arr) == 2 {
-            timeout, err := strconv.Atoi(arr[0])
-            if err == nil {
-                var skipto int
-                skipto, err = strconv.Atoi(arr[1])
-                if err == nil {
-                    go func() {
-                        time.Sleep(time.Duration(timeout) * time.Second)
-                        self.lock.Lock()
-                        defer self.lock.Unlock()
-                        self.group_expires(skipto)
-                    }()
-                }
-            }
-        }
+    self.uaO.SetSessionTimer(self.global_config.Session_expires_dur, sippy.SESSION_MIN_SE)
+    self.uaO.SetUseUpdate(self.global_config.Use_update)
+    // Each attempt is a new dialog, so the next address of the same
+    // route gets its own Call-ID.
+    suffix := fmt.Sprintf("-b2b_%d", oroute.rnum)
+    if self.oattempt > 0 {
+        suffix += fmt.Sprintf("_%d", self.oattempt)
     }
+    self.oattempt++
     var cId *sippy_header.SipCallId
     if self.global_config.Hide_call_id {
-        cId = sippy_header.NewSipCallIdFromString(fmt.Sprintf("%x-b2b_%d", md5.Sum([]byte(self.eTry.GetSipCallId().CallId)), oroute.rnum))
+        cId = sippy_header.NewSipCallIdFromString(fmt.Sprintf("%x", md5.Sum([]byte(self.eTry.GetSipCallId().CallId))) + suffix)
     } else {
-        cId = sippy_header.NewSipCallIdFromString(self.eTry.GetSipCallId().CallId + fmt.Sprintf("-b2b_%d", oroute.rnum))
+        cId = sippy_header.NewSipCallIdFromString(self.eTry.GetSipCallId().CallId + suffix)
     }
     caller_name := oroute.caller_name
     if caller_name == "" {
@@ -458,6 +553,24 @@ func (self *callController) placeOriginate(oroute *B2BRoute) {
     self.uaO.RecvEvent(event)
 }
 
+func (self *callController) newOriginateAcct(oroute *B2BRoute, host string) *RadiusAccounting {
+    acct := NewRadiusAccounting(self.global_config, "originate", self.cmap.radius_client)
+    bill_to := self.username
+    if v, ok := oroute.params["bill-to"]; ok {
+        bill_to = v
+    }
+    cli := oroute.cli
+    if v, ok := oroute.params["bill-cli"]; ok {
+        cli = v
+    }
+    cld := oroute.cld
+    if v, ok := oroute.params["bill-cld"]; ok {
+        cld = v
+    }
+    acct.SetParams(bill_to, cli, cld, self.cGUID.StringBody(), self.cId.StringBody(), host, "")
+    return acct
+}
+
 func (self *callController) disconnect(rtime *sippy_time.MonoTime) {
     self.uaA.Disconnect(rtime, "")
 }
@@ -468,8 +581,20 @@ func (self *callController) oConn(rtime *sippy_time.MonoTime, origin string) {
     }
 }
 
+func (self *callController) oDisc(rtime *sippy_time.MonoTime, origin string, result int, inreq sippy_types.SipRequest) {
+    if target := self.transferRequested(self.uaO, inreq); target != nil {
+        self.acctO.SetTransfer("to", target.Username)
+    }
+    self.acctO.Disc(self.uaO, rtime, origin, result)
+}
+
+func (self *callController) oFail(rtime *sippy_time.MonoTime, origin string, result int) {
+    self.acctO.Disc(self.uaO, rtime, origin, result)
+}
+
 func (self *callController) aConn(rtime *sippy_time.MonoTime, origin string) {
     self.state = CCStateConnected
+    self.cmap.ccStateChanged(self)
     self.acctA.Conn(self.uaA, rtime, origin)
 }
 
@@ -478,6 +603,18 @@ func (self *callController) aFail(rtime *sippy_time.MonoTime, origin string, res
 }
 
 func (self *callController) aDisc(rtime *sippy_time.MonoTime, origin string, result int, inreq sippy_types.SipRequest) {
+    if target := self.transferRequested(self.uaA, inreq); target != nil {
+        // Keep the call and the RTP session, the transfer is started
+        // once the disconnect event from uaA is received.
+        self.acctA.SetTransfer("to", target.Username)
+        self.acctA.Disc(self.uaA, rtime, origin, result)
+        return
+    }
+    if self.replacing && self.uaR == self.uaA && self.uaR_gone {
+        // The transferor has been notified of the outcome, the
+        // accounting has been stopped by recvTransfer().
+        return
+    }
     if self.state == CCStateWaitRoute && self.auth_proc != nil {
         self.auth_proc.Cancel()
         self.auth_proc = nil
@@ -487,6 +624,9 @@ func (self *callController) aDisc(rtime *sippy_time.MonoTime, origin string, res
     } else {
         self.state = CCStateDead
     }
+    if self.cmap != nil {
+        self.cmap.ccStateChanged(self)
+    }
     if self.acctA != nil {
         self.acctA.Disc(self.uaA, rtime, origin, result)
     }
@@ -519,6 +659,36 @@ func (self *callController) oDead() {
     }
 }
 
+// startGroupTimer (re)starts the group timeout of the route, the
+// timer of the previous route is cancelled. The addresses of the same
+// route share the timer.
+func (self *callController) startGroupTimer(oroute *B2BRoute) {
+    if self.group_timer != nil {
+        self.group_timer.Cancel()
+        self.group_timer = nil
+    }
+    gt, ok := oroute.params["gt"]
+    if ! ok {
+        return
+    }
+    arr := strings.SplitN(gt, ",", 2)
+    if len(arr) != 2 {
+        return
+    }
+    timeout, err := strconv.Atoi(arr[0])
+    if err != nil {
+        return
+    }
+    skipto, err := strconv.Atoi(arr[1])
+    if err != nil {
+        return
+    }
+    self.group_timer = sippy.StartTimeout(func() {
+        self.group_timer = nil
+        self.group_expires(skipto)
+    }, self.lock, time.Duration(timeout) * time.Second, 1, self.global_config.ErrorLogger())
+}
+
 func (self *callController) group_expires(skipto int) {
     if self.state != CCStateARComplete || len(self.routes) == 0 || self.routes[0].rnum > skipto ||
       ((self.uaA.GetState() != sippy_types.UAS_STATE_TRYING) && (self.uaA.GetState() != sippy_types.UAS_STATE_RINGING)) {
@@ -535,5 +705,8 @@ func (self *callController) group_expires(skipto int) {
     for self.routes[0].rnum != skipto {
         self.routes = self.routes[1:]
     }
+    // Move on to the next route, not the next address of this one
+    self.otarget = nil
+    self.otargets = nil
     self.uaO.Disconnect(nil, "")
 }
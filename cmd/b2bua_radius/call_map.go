@@ -31,6 +31,7 @@ import (
     "os"
     "os/exec"
     "os/signal"
+    "sort"
     "strconv"
     "strings"
     "sync"
@@ -49,17 +50,27 @@ type CallMap struct {
     ccmap_lock      sync.Mutex
     gc_timeout      time.Duration
     debug_mode      bool
-    safe_restart    bool
     Sip_tm          sippy_types.SipTransactionManager
     Proxy           sippy_types.StatefulProxy
+    Dialog_info     *DialogInfoPublisher
     cc_id           int64
     cc_id_lock      sync.Mutex
     rtp_proxy_clients []sippy_types.RtpProxyClient
     static_route    *B2BRoute
     radius_client   *RadiusClient
     radius_auth     *RadiusAuthorisation
+    blacklist       *TargetBlacklist
+    drain_lock      sync.Mutex
+    draining        bool
+    drain_restart   bool
+    drain_deadline  time.Time
+    drain_cancel    chan struct{}
 }
 
+// After the drain deadline the remaining calls are disconnected and
+// the process is stopped once they are gone or this much time passes.
+const DRAIN_HARD_STOP = 32 * time.Second
+
 func NewCallMap(global_config *myConfigParser, rtp_proxy_clients []sippy_types.RtpProxyClient,
   static_route *B2BRoute, radius_client *RadiusClient, radius_auth *RadiusAuthorisation) *CallMap {
     self := &CallMap{
@@ -67,11 +78,11 @@ func NewCallMap(global_config *myConfigParser, rtp_proxy_clients []sippy_types.R
         ccmap           : make(map[int64]*callController),
         gc_timeout      : time.Minute,
         debug_mode      : false,
-        safe_restart    : false,
         rtp_proxy_clients: rtp_proxy_clients,
         static_route    : static_route,
         radius_client   : radius_client,
         radius_auth     : radius_auth,
+        blacklist       : NewTargetBlacklist(global_config.Blacklist_failures, time.Duration(global_config.Blacklist_time) * time.Second),
     }
     go func() {
         sighup_ch := make(chan os.Signal, 1)
@@ -82,6 +93,8 @@ func NewCallMap(global_config *myConfigParser, rtp_proxy_clients []sippy_types.R
         signal.Notify(sigprof_ch, syscall.SIGPROF)
         sigterm_ch := make(chan os.Signal, 1)
         signal.Notify(sigterm_ch, syscall.SIGTERM)
+        sigusr1_ch := make(chan os.Signal, 1)
+        signal.Notify(sigusr1_ch, syscall.SIGUSR1)
         for {
             select {
             case <-sighup_ch:
@@ -92,6 +105,9 @@ func NewCallMap(global_config *myConfigParser, rtp_proxy_clients []sippy_types.R
                 self.safeRestart()
             case <-sigterm_ch:
                 self.safeStop()
+            case <-sigusr1_ch:
+                println("Signal received, draining calls before exit")
+                self.startDrain(false, time.Duration(self.global_config.Drain_timeout) * time.Second)
             }
         }
     }()
@@ -119,6 +135,11 @@ func (self *CallMap) OnNewDialog(req sippy_types.SipRequest, sip_t sippy_types.S
         //println("-" * 70)
         //sys.stdout.flush()
         //return (nil, nil, nil)
+    if self.Dialog_info != nil {
+        if rr := self.Dialog_info.Lookup(req); rr != nil {
+            return nil, rr, nil
+        }
+    }
     if to_body.GetTag() != "" {
         // Request within dialog, but no such dialog
         return nil, nil, req.GenResponse(481, "Call Leg/Transaction Does Not Exist", nil, nil)
@@ -126,6 +147,12 @@ func (self *CallMap) OnNewDialog(req sippy_types.SipRequest, sip_t sippy_types.S
     if req.GetMethod() == "INVITE" {
         // New dialog
         var via *sippy_header.SipViaBody
+
+        if self.isDraining() {
+            resp := req.GenResponse(503, "Service Unavailable", nil, nil)
+            resp.AppendHeader(sippy_header.NewSipGenericHF("Retry-After", strconv.Itoa(self.global_config.Drain_retry_after)))
+            return nil, nil, resp
+        }
         vias := req.GetVias()
         if len(vias) > 1 {
             via, err = vias[1].GetBody()
@@ -160,6 +187,9 @@ func (self *CallMap) OnNewDialog(req sippy_types.SipRequest, sip_t sippy_types.S
                 return nil, nil, resp
             }
         }
+        if replaces := req.GetReplaces(); replaces != nil {
+            return self.onReplaces(req, replaces, remote_ip, challenge)
+        }
         pass_headers := []sippy_header.SipHeader{}
         for _, header := range self.global_config.Pass_headers_arr {
             hfs := req.GetHFs(header)
@@ -184,6 +214,13 @@ func (self *CallMap) OnNewDialog(req sippy_types.SipRequest, sip_t sippy_types.S
         self.ccmap_lock.Unlock()
         return cc.uaA, cc.uaA, nil
     }
+    if self.Dialog_info != nil && req.GetMethod() == "SUBSCRIBE" {
+        if ! self.global_config.checkIP(req.GetSource().Host.String()) {
+            return nil, nil, req.GenResponse(403, "Forbidden", nil, nil)
+        }
+        rr, resp := self.Dialog_info.OnSubscribe(req)
+        return nil, rr, resp
+    }
     if self.Proxy != nil && (req.GetMethod() == "REGISTER" || req.GetMethod() == "SUBSCRIBE") {
         return nil, self.Proxy, nil
     }
@@ -226,7 +263,95 @@ func (self *CallMap) toggleDebug() {
 
 func (self *CallMap) safeRestart() {
     println("Signal received, scheduling safe restart")
-    self.safe_restart = true
+    self.startDrain(true, time.Duration(self.global_config.Drain_timeout) * time.Second)
+}
+
+func (self *CallMap) isDraining() bool {
+    self.drain_lock.Lock()
+    defer self.drain_lock.Unlock()
+    return self.draining
+}
+
+// startDrain rejects all new calls with 503 and waits for the existing
+// ones to end. Once there are no calls left the process exits or
+// restarts itself. The zero timeout means no deadline, otherwise the
+// calls still active when the deadline passes are disconnected.
+func (self *CallMap) startDrain(restart bool, timeout time.Duration) {
+    self.drain_lock.Lock()
+    defer self.drain_lock.Unlock()
+    self.drain_restart = restart
+    if timeout > 0 {
+        self.drain_deadline = time.Now().Add(timeout)
+    } else {
+        self.drain_deadline = time.Time{}
+    }
+    if self.draining {
+        return
+    }
+    self.draining = true
+    self.drain_cancel = make(chan struct{})
+    go self.drainLoop(self.drain_cancel)
+}
+
+func (self *CallMap) stopDrain() bool {
+    self.drain_lock.Lock()
+    defer self.drain_lock.Unlock()
+    if ! self.draining {
+        return false
+    }
+    self.draining = false
+    close(self.drain_cancel)
+    return true
+}
+
+func (self *CallMap) numCalls() int {
+    self.ccmap_lock.Lock()
+    defer self.ccmap_lock.Unlock()
+    return len(self.ccmap)
+}
+
+func (self *CallMap) drainLoop(cancel chan struct{}) {
+    disconnected := false
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-cancel:
+            return
+        case <-ticker.C:
+        }
+        self.drain_lock.Lock()
+        restart, deadline := self.drain_restart, self.drain_deadline
+        self.drain_lock.Unlock()
+        ncalls := self.numCalls()
+        now := time.Now()
+        if ncalls == 0 || (disconnected && now.After(deadline.Add(DRAIN_HARD_STOP))) {
+            println(fmt.Sprintf("Drain completed, %d calls left", ncalls))
+            self.Sip_tm.Shutdown()
+            if restart {
+                self.restart()
+            }
+            os.Exit(0)
+        }
+        if ! disconnected && ! deadline.IsZero() && now.After(deadline) {
+            println(fmt.Sprintf("Drain deadline has passed, disconnecting %d calls", ncalls))
+            self.discAll(0)
+            disconnected = true
+        }
+    }
+}
+
+func (self *CallMap) restart() {
+    //os.chdir(self.global_config["_orig_cwd"])
+    cmd := exec.Command(os.Args[0], os.Args[1:]...)
+    cmd.Env = os.Environ()
+    err := cmd.Start()
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    os.Exit(0)
+    // Should not reach this point!
 }
 
 func (self *CallMap) GClector() {
@@ -240,22 +365,6 @@ func (self *CallMap) GClector() {
     //    fmt.Printf("[%d]: %d client, %d server transactions in memory\n",
     //      os.getpid(), len(self.global_config["_sip_tm"].tclient), len(self.global_config["_sip_tm"].tserver))
     }
-    if self.safe_restart {
-        if len(self.ccmap) == 0 {
-            self.Sip_tm.Shutdown()
-            //os.chdir(self.global_config["_orig_cwd"])
-            cmd := exec.Command(os.Args[0], os.Args[1:]...)
-            cmd.Env = os.Environ()
-            err := cmd.Start()
-            if err != nil {
-                fmt.Println(err)
-                os.Exit(1)
-            }
-            os.Exit(0)
-            // Should not reach this point!
-        }
-        self.gc_timeout = time.Second
-    }
 }
 
 func (self *CallMap) RecvCommand(clim sippy_cli.CLIManagerIface, data string) {
@@ -291,36 +400,43 @@ func (self *CallMap) RecvCommand(clim sippy_cli.CLIManagerIface, data string) {
         }
         clim.Send(res + fmt.Sprintf("Total: %d\n", total))
         return
-/*
-    case "lt":
-        res = "In-memory server transactions:\n"
-        for tid, t in self.global_config["_sip_tm"].tserver.iteritems() {
-            res += "%s %s %s\n" % (tid, t.method, t.state)
-        }
-        res += "In-memory client transactions:\n"
-        for tid, t in self.global_config["_sip_tm"].tclient.iteritems():
-            res += "%s %s %s\n" % (tid, t.method, t.state)
-        return res
     case "lt", "llt":
-        if cmd == "llt":
+        mindur := 0.0
+        if cmd == "llt" {
             mindur = 60.0
-        else:
-            mindur = 0.0
-        ctime = time()
-        res = "In-memory server transactions:\n"
-        for tid, t in self.global_config["_sip_tm"].tserver.iteritems():
-            duration = ctime - t.rtime
-            if duration < mindur:
-                continue
-            res += "%s %s %s %s\n" % (tid, t.method, t.state, duration)
-        res += "In-memory client transactions:\n"
-        for tid, t in self.global_config["_sip_tm"].tclient.iteritems():
-            duration = ctime - t.rtime
-            if duration < mindur:
+        }
+        if len(args) > 0 {
+            var err error
+            mindur, err = strconv.ParseFloat(args[0], 64)
+            if err != nil || mindur < 0 {
+                clim.Send("ERROR: syntax error: " + cmd + " [min_duration]\n")
+                return
+            }
+        }
+        if self.Sip_tm == nil {
+            clim.Send("ERROR: transaction manager is not running\n")
+            return
+        }
+        sres := "In-memory server transactions:\n"
+        cres := "In-memory client transactions:\n"
+        for _, t := range self.Sip_tm.GetTransactions() {
+            duration := t.Age.Seconds()
+            if duration < mindur {
                 continue
-            res += "%s %s %s %s\n" % (tid, t.method, t.state, duration)
-        return res
-*/
+            }
+            raddr := "N/A"
+            if t.RAddress != nil {
+                raddr = t.RAddress.String()
+            }
+            line := fmt.Sprintf("%s %s %s %s %.3f\n", t.TID.String(), t.Method, t.State, raddr, duration)
+            if t.Server {
+                sres += line
+            } else {
+                cres += line
+            }
+        }
+        clim.Send(sres + cres)
+        return
     case "d":
         if len(args) != 1 {
             clim.Send("ERROR: syntax error: d <call-id>\n")
@@ -349,6 +465,85 @@ func (self *CallMap) RecvCommand(clim sippy_cli.CLIManagerIface, data string) {
         }
         clim.Send("OK\n")
         return
+    case "rl":
+        if self.global_config.Rate_limiter == nil {
+            clim.Send("ERROR: rate limiting is not enabled\n")
+            return
+        }
+        stats := self.global_config.Rate_limiter.GetStats()
+        res := fmt.Sprintf("Admitted: %d\nAllowed: %d\nRejected: %d\nDropped: %d\nSources: %d\n",
+          stats.Admitted, stats.Allowed, stats.Rejected, stats.Dropped, stats.Sources)
+        methods := make([]string, 0, len(stats.Limited))
+        for method := range stats.Limited {
+            methods = append(methods, method)
+        }
+        sort.Strings(methods)
+        for _, method := range methods {
+            res += fmt.Sprintf("Limited %s: %d\n", method, stats.Limited[method])
+        }
+        clim.Send(res)
+        return
+    case "lr":
+        if len(self.global_config.Registrations) == 0 {
+            clim.Send("No trunk registrations\n")
+            return
+        }
+        res := ""
+        for _, reg := range self.global_config.Registrations {
+            res += reg.String() + "\n"
+        }
+        clim.Send(res)
+        return
+    case "drain":
+        restart := false
+        timeout := time.Duration(self.global_config.Drain_timeout) * time.Second
+        if len(args) > 0 {
+            switch args[0] {
+            case "exit":
+            case "restart":
+                restart = true
+            default:
+                clim.Send("ERROR: syntax error: drain [exit|restart] [timeout]\n")
+                return
+            }
+        }
+        if len(args) > 1 {
+            secs, err := strconv.Atoi(args[1])
+            if err != nil || secs < 0 {
+                clim.Send("ERROR: non-integer argument: " + args[1] + "\n")
+                return
+            }
+            timeout = time.Duration(secs) * time.Second
+        }
+        self.startDrain(restart, timeout)
+        clim.Send("OK\n")
+        return
+    case "undrain":
+        if ! self.stopDrain() {
+            clim.Send("ERROR: not draining\n")
+            return
+        }
+        clim.Send("OK\n")
+        return
+    case "drain_status":
+        self.drain_lock.Lock()
+        draining, restart, deadline := self.draining, self.drain_restart, self.drain_deadline
+        self.drain_lock.Unlock()
+        res := fmt.Sprintf("Draining: %t\nCalls left: %d\n", draining, self.numCalls())
+        if draining {
+            if restart {
+                res += "On completion: restart\n"
+            } else {
+                res += "On completion: exit\n"
+            }
+            if deadline.IsZero() {
+                res += "Deadline: none\n"
+            } else {
+                res += fmt.Sprintf("Deadline: %.0f seconds\n", time.Until(deadline).Seconds())
+            }
+        }
+        clim.Send(res)
+        return
     case "r":
         if len(args) != 1 {
             clim.Send("ERROR: syntax error: r [<id>]\n")
@@ -384,6 +579,16 @@ func (self *CallMap) RecvCommand(clim sippy_cli.CLIManagerIface, data string) {
 
 func (self *CallMap) DropCC(cc_id int64) {
     self.ccmap_lock.Lock()
+    cc, ok := self.ccmap[cc_id]
     delete(self.ccmap, cc_id)
     self.ccmap_lock.Unlock()
+    if ok && self.Dialog_info != nil {
+        self.Dialog_info.Update(cc, /*dropped*/ true)
+    }
+}
+
+func (self *CallMap) ccStateChanged(cc *callController) {
+    if self.Dialog_info != nil {
+        self.Dialog_info.Update(cc, /*dropped*/ false)
+    }
 }
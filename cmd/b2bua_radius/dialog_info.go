@@ -0,0 +1,246 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "encoding/xml"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/sippy/go-b2bua/sippy"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+type dialogInfoSubscription struct {
+    notifier    *sippy.SipNotifier
+    user        string
+    entity      string
+    version     int
+    added       bool
+}
+
+// dialogInfoDialog is the snapshot of the call state taken when the
+// call changes its state.
+type dialogInfoDialog struct {
+    id          int64
+    cli         string
+    cld         string
+    call_id     string
+    state       string
+}
+
+// DialogInfoPublisher accepts the subscriptions to the dialog event
+// package (RFC 4235) for the CLI/CLD and notifies the subscribers as
+// the calls to and from it pass through the B2BUA, so that the phones
+// can show them on the busy-lamp fields.
+//
+// The publisher keeps its own copy of the call states, so it never
+// takes the locks of the calls or of the CallMap. The lock of the
+// publisher is taken last, the NOTIFYs are sent with no call locked.
+type DialogInfoPublisher struct {
+    cmap        *CallMap
+    smap        *sippy.SubscriptionMap
+    lock        sync.Mutex
+    subs        map[string][]*dialogInfoSubscription
+    dialogs     map[int64]*dialogInfoDialog
+}
+
+func NewDialogInfoPublisher(cmap *CallMap) *DialogInfoPublisher {
+    return &DialogInfoPublisher{
+        cmap    : cmap,
+        smap    : sippy.NewSubscriptionMap(cmap.global_config),
+        subs    : make(map[string][]*dialogInfoSubscription),
+        dialogs : make(map[int64]*dialogInfoDialog),
+    }
+}
+
+func (self *DialogInfoPublisher) Lookup(req sippy_types.SipRequest) sippy_types.RequestReceiver {
+    return self.smap.Lookup(req)
+}
+
+func (self *DialogInfoPublisher) NumSubscriptions() int {
+    return self.smap.Len()
+}
+
+// OnSubscribe returns the notifier for the new SUBSCRIBE or the
+// response to reject it with.
+func (self *DialogInfoPublisher) OnSubscribe(req sippy_types.SipRequest) (sippy_types.RequestReceiver, sippy_types.SipResponse) {
+    event, ok := req.GetFirstHF("event").(*sippy_header.SipEvent)
+    if ok {
+        if body, err := event.GetBody(); err != nil || body.Package != "dialog" {
+            ok = false
+        }
+    }
+    if ! ok {
+        resp := req.GenResponse(489, "Bad Event", nil, nil)
+        resp.AppendHeader(sippy_header.NewSipAllowEvents("dialog"))
+        return nil, resp
+    }
+    ruri := req.GetRURI()
+    if ruri.Username == "" {
+        return nil, req.GenResponse(404, "Not Found", nil, nil)
+    }
+    sub := &dialogInfoSubscription{
+        user    : ruri.Username,
+        entity  : "sip:" + ruri.Username + "@" + ruri.Host.String(),
+    }
+    sub.notifier = sippy.NewSipNotifier(self.cmap.global_config, self.cmap.Sip_tm, self.smap, func(n *sippy.SipNotifier, req sippy_types.SipRequest) (int, string) {
+        self.lock.Lock()
+        if ! sub.added {
+            sub.added = true
+            self.subs[sub.user] = append(self.subs[sub.user], sub)
+        }
+        body := self.dialogInfo(sub)
+        self.lock.Unlock()
+        sub.notifier.Notify(sippy_header.SUBSCRIPTION_STATE_ACTIVE, body, "")
+        return 200, "OK"
+    })
+    sub.notifier.SetTermCb(func(string) { self.remove(sub) })
+    return sub.notifier, nil
+}
+
+func (self *DialogInfoPublisher) remove(sub *dialogInfoSubscription) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    subs := self.subs[sub.user]
+    for i, s := range subs {
+        if s == sub {
+            subs = append(subs[:i], subs[i + 1:]...)
+            break
+        }
+    }
+    if len(subs) == 0 {
+        delete(self.subs, sub.user)
+    } else {
+        self.subs[sub.user] = subs
+    }
+}
+
+// Update notifies the subscribers of the CLI and the CLD of the call
+// about the change of its state. The dropped call is reported as
+// terminated once and then forgotten. Must be called with the call's
+// lock held.
+func (self *DialogInfoPublisher) Update(cc *callController, dropped bool) {
+    dlg := &dialogInfoDialog{
+        id      : cc.id,
+        cli     : cc.cli,
+        cld     : cc.cld,
+        state   : dialogInfoState(cc.state),
+    }
+    if cc.cId != nil {
+        dlg.call_id = cc.cId.CallId
+    }
+    if dropped {
+        dlg.state = "terminated"
+    }
+    type notification struct {
+        sub     *dialogInfoSubscription
+        body    sippy_types.MsgBody
+    }
+    notifications := []notification{}
+    self.lock.Lock()
+    self.dialogs[dlg.id] = dlg
+    for _, user := range []string{ dlg.cli, dlg.cld } {
+        if user == "" || (user == dlg.cld && dlg.cld == dlg.cli) {
+            continue
+        }
+        for _, sub := range self.subs[user] {
+            notifications = append(notifications, notification{ sub, self.dialogInfo(sub) })
+        }
+    }
+    if dlg.state == "terminated" {
+        delete(self.dialogs, dlg.id)
+    }
+    self.lock.Unlock()
+    if len(notifications) == 0 {
+        return
+    }
+    go func() {
+        for _, n := range notifications {
+            n.sub.notifier.Lock.Lock()
+            if ! n.sub.notifier.IsTerminated() {
+                n.sub.notifier.Notify(sippy_header.SUBSCRIPTION_STATE_ACTIVE, n.body, "")
+            }
+            n.sub.notifier.Lock.Unlock()
+        }
+    }()
+}
+
+func dialogInfoState(state CCState) string {
+    switch state {
+    case CCStateARComplete:
+        return "early"
+    case CCStateConnected:
+        return "confirmed"
+    case CCStateDead, CCStateDisconnecting:
+        return "terminated"
+    }
+    return "trying"
+}
+
+func xmlEscape(s string) string {
+    var buf strings.Builder
+    xml.EscapeText(&buf, []byte(s))
+    return buf.String()
+}
+
+// Builds the full dialog-info document of the user from the known
+// calls. Must be called with the publisher's lock held.
+func (self *DialogInfoPublisher) dialogInfo(sub *dialogInfoSubscription) sippy_types.MsgBody {
+    res := "<?xml version=\"1.0\"?>\n" +
+      "<dialog-info xmlns=\"urn:ietf:params:xml:ns:dialog-info\" version=\"" + strconv.Itoa(sub.version) +
+      "\" state=\"full\" entity=\"" + xmlEscape(sub.entity) + "\">\n"
+    sub.version++
+    ids := make([]int64, 0, len(self.dialogs))
+    for id := range self.dialogs {
+        ids = append(ids, id)
+    }
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    for _, id := range ids {
+        dlg := self.dialogs[id]
+        var direction, remote string
+        switch sub.user {
+        case dlg.cli:
+            direction, remote = "initiator", dlg.cld
+        case dlg.cld:
+            direction, remote = "recipient", dlg.cli
+        default:
+            continue
+        }
+        res += "  <dialog id=\"" + strconv.FormatInt(dlg.id, 10) + "\" call-id=\"" + xmlEscape(dlg.call_id) +
+          "\" direction=\"" + direction + "\">\n" +
+          "    <state>" + dlg.state + "</state>\n"
+        if remote != "" {
+            res += "    <remote><identity>" + xmlEscape(remote) + "</identity></remote>\n"
+        }
+        res += "  </dialog>\n"
+    }
+    res += "</dialog-info>\n"
+    return sippy.NewMsgBody(res, "application/dialog-info+xml")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/time"
+)
+
+func TestDialogInfo(t *testing.T) {
+    dip := &DialogInfoPublisher{
+        subs    : make(map[string][]*dialogInfoSubscription),
+        dialogs : make(map[int64]*dialogInfoDialog),
+    }
+    for _, cc := range []*callController{
+            &callController{ id : 1, cli : "100", cld : "200", state : CCStateConnected },
+            &callController{ id : 2, cli : "300", cld : "100", state : CCStateARComplete },
+            &callController{ id : 3, cli : "300", cld : "400", state : CCStateConnected },
+        } {
+        dip.Update(cc, false)
+    }
+    sub := &dialogInfoSubscription{ user : "100", entity : "sip:100@example.com", version : 5 }
+    body := dip.dialogInfo(sub).String()
+    for _, s := range []string{
+        "version=\"5\" state=\"full\" entity=\"sip:100@example.com\"",
+        "<dialog id=\"1\" call-id=\"\" direction=\"initiator\">\n    <state>confirmed</state>",
+        "<dialog id=\"2\" call-id=\"\" direction=\"recipient\">\n    <state>early</state>",
+    } {
+        if ! strings.Contains(body, s) {
+            t.Fatalf("%q is missing in:\n%s", s, body)
+        }
+    }
+    if strings.Contains(body, "id=\"3\"") {
+        t.Fatal("The call of the other users is included")
+    }
+}
+
+func TestDialogInfoNotify(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    cmap.Dialog_info = NewDialogInfoPublisher(cmap)
+    tfactory.feed([]string{
+        "SUBSCRIBE sip:100@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK1a2b3c",
+        "Max-Forwards: 70",
+        "From: <sip:blf@example.com>;tag=blf1",
+        "To: <sip:100@example.com>",
+        "Contact: <sip:blf@1.1.1.1:5060>",
+        "Call-ID: blf-1@example.com",
+        "CSeq: 1 SUBSCRIBE",
+        "Event: dialog",
+        "Expires: 600",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    rtime, _ := sippy_time.NewMonoTime()
+    next_notify := func() string {
+        for {
+            data := tfactory.get(t)
+            if strings.HasPrefix(data, "SIP/2.0 ") {
+                continue
+            }
+            req, err := sippy.ParseSipRequest([]byte(data), rtime, cmap.global_config)
+            if err != nil {
+                t.Fatal("Cannot parse NOTIFY: " + err.Error())
+            }
+            if req.GetMethod() != "NOTIFY" {
+                t.Fatal("NOTIFY expected, got " + req.GetMethod())
+            }
+            resp := req.GenResponse(200, "OK", nil, nil)
+            tfactory.feed([]string{ resp.LocalStr(nil, false) })
+            return req.GetBody().String()
+        }
+    }
+    if body := next_notify(); strings.Contains(body, "<dialog ") {
+        t.Fatalf("No dialogs expected in the initial NOTIFY:\n%s", body)
+    }
+    cc := &callController{
+        id      : 1,
+        cli     : "100",
+        cld     : "200",
+        cId     : sippy_header.NewSipCallIdFromString("call-1"),
+        state   : CCStateConnected,
+        lock    : new(sync.Mutex),
+        cmap    : cmap,
+    }
+    cmap.ccmap[cc.id] = cc
+    // Neither the call's nor the CallMap's lock is needed to notify
+    // the subscribers.
+    cc.lock.Lock()
+    cmap.ccmap_lock.Lock()
+    cmap.ccStateChanged(cc)
+    cmap.ccmap_lock.Unlock()
+    cc.lock.Unlock()
+    if body := next_notify(); ! strings.Contains(body, "call-id=\"call-1\" direction=\"initiator\">\n    <state>confirmed</state>") {
+        t.Fatalf("The confirmed dialog is missing:\n%s", body)
+    }
+    cc.lock.Lock()
+    cc.state = CCStateDead
+    cmap.DropCC(cc.id)
+    cc.lock.Unlock()
+    if body := next_notify(); ! strings.Contains(body, "<state>terminated</state>") {
+        t.Fatalf("The terminated dialog is missing:\n%s", body)
+    }
+    // The terminated dialog is reported only once
+    cc2 := &callController{ id : 2, cli : "300", cld : "100", state : CCStateARComplete, lock : new(sync.Mutex), cmap : cmap }
+    cc2.lock.Lock()
+    cmap.ccStateChanged(cc2)
+    cc2.lock.Unlock()
+    if body := next_notify(); strings.Contains(body, "id=\"1\"") || ! strings.Contains(body, "<dialog id=\"2\"") {
+        t.Fatalf("Unexpected dialogs:\n%s", body)
+    }
+}
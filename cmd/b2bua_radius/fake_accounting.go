@@ -44,3 +44,6 @@ func (self *fakeAccounting) Conn(sippy_types.UA, *sippy_time.MonoTime, string) {
 
 func (self *fakeAccounting) Disc(sippy_types.UA, *sippy_time.MonoTime, string, int) {
 }
+
+func (self *fakeAccounting) SetTransfer(string, string) {
+}
@@ -38,4 +38,5 @@ type Cancellable interface {
 type Accounting interface {
     Conn(sippy_types.UA, *sippy_time.MonoTime, string)
     Disc(sippy_types.UA, *sippy_time.MonoTime, string, int)
+    SetTransfer(string, string)
 }
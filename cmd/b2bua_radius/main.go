@@ -95,6 +95,29 @@ func main() {
     if global_config.getdefault('xmpp_b2bua_id', nil) != nil:
         global_config['_xmpp_mode'] = true
 */
+    if global_config.Sip_tcp {
+        global_config.SetSipTransportFactoryByProto("TCP", sippy.NewTcpSipTransportFactory(global_config))
+    }
+    if global_config.Tls_cert_file != "" {
+        tls_factory, err := sippy.NewTlsSipTransportFactory(global_config)
+        if err != nil {
+            println("Cannot initialize TLS transport: " + err.Error())
+            return
+        }
+        global_config.SetSipTransportFactoryByProto("TLS", tls_factory)
+    }
+    if global_config.Ws_port > 0 {
+        ws_factory := sippy.NewWsSipTransportFactory(global_config, sippy_net.NewMyPort(strconv.Itoa(global_config.Ws_port)))
+        global_config.SetSipTransportFactoryByProto("WS", ws_factory)
+    }
+    if global_config.Wss_port > 0 {
+        wss_factory, err := sippy.NewWssSipTransportFactory(global_config, sippy_net.NewMyPort(strconv.Itoa(global_config.Wss_port)))
+        if err != nil {
+            println("Cannot initialize WSS transport: " + err.Error())
+            return
+        }
+        global_config.SetSipTransportFactoryByProto("WSS", wss_factory)
+    }
     sip_tm, err := sippy.NewSipTransactionManager(global_config, cmap)
     if err != nil {
         println("Cannot initialize SipTransactionManager: " + err.Error())
@@ -102,6 +125,19 @@ func main() {
     }
     //sip_tm.nat_traversal = global_config.nat_traversal
     cmap.Sip_tm = sip_tm
+    if global_config.Dialog_info {
+        cmap.Dialog_info = NewDialogInfoPublisher(cmap)
+    }
+    if global_config.Overload_control {
+        oc := sippy.NewOverloadControl()
+        oc.SetThreshold(float64(global_config.Oc_threshold) / 100)
+        if global_config.Oc_max_calls > 0 {
+            oc.AddLoadSource(func() float64 {
+                return float64(cmap.numCalls()) / float64(global_config.Oc_max_calls)
+            })
+        }
+        sip_tm.SetOverloadControl(oc)
+    }
     if global_config.Sip_proxy != "" {
         var sip_proxy *sippy_net.HostPort
         host_port := strings.SplitN(global_config.Sip_proxy, ":", 2)
@@ -112,6 +148,9 @@ func main() {
         }
         cmap.Proxy = sippy.NewStatefulProxy(sip_tm, sip_proxy, global_config)
     }
+    for _, reg := range global_config.Registrations {
+        reg.start(global_config, sip_tm)
+    }
 
     cmdfile := global_config.B2bua_socket
     if strings.HasPrefix(cmdfile, "unix:") {
@@ -124,6 +163,15 @@ func main() {
     }
     cli_server.Start()
 
+    if global_config.Metrics_address != "" {
+        metrics_server, err := NewMetricsServer(cmap, global_config.Metrics_address, global_config.ErrorLogger())
+        if err != nil {
+            println("Cannot initialize metrics server: " + err.Error())
+            return
+        }
+        metrics_server.Start()
+    }
+
     if ! global_config.Foreground {
         fd, err := os.OpenFile(global_config.Pidfile, os.O_WRONLY | os.O_CREATE, 0644)
         if err != nil {
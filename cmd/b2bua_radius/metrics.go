@@ -0,0 +1,226 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/log"
+)
+
+var radius_latency_buckets = []float64{ 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10 }
+
+// radiusStats accumulates the number of RADIUS requests sent, their
+// outcome and the latency histogram.
+type radiusStats struct {
+    lock        sync.Mutex
+    accepted    uint64
+    rejected    uint64
+    failed      uint64
+    buckets     []uint64
+    sum         float64
+}
+
+func newRadiusStats() *radiusStats {
+    return &radiusStats{
+        buckets     : make([]uint64, len(radius_latency_buckets)),
+    }
+}
+
+func (self *radiusStats) record(results *RadiusResult, delay time.Duration) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    switch {
+    case results != nil && results.Rcode == 0:
+        self.accepted++
+    case results != nil && results.Rcode == 1:
+        self.rejected++
+    default:
+        self.failed++
+    }
+    secs := delay.Seconds()
+    self.sum += secs
+    for i, le := range radius_latency_buckets {
+        if secs <= le {
+            self.buckets[i]++
+        }
+    }
+}
+
+func (self *radiusStats) write(w *bytes.Buffer, typ string) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    total := self.accepted + self.rejected + self.failed
+    fmt.Fprintf(w, "b2bua_radius_requests_total{type=\"%s\",result=\"accepted\"} %d\n", typ, self.accepted)
+    fmt.Fprintf(w, "b2bua_radius_requests_total{type=\"%s\",result=\"rejected\"} %d\n", typ, self.rejected)
+    fmt.Fprintf(w, "b2bua_radius_requests_total{type=\"%s\",result=\"failed\"} %d\n", typ, self.failed)
+    for i, le := range radius_latency_buckets {
+        fmt.Fprintf(w, "b2bua_radius_latency_seconds_bucket{type=\"%s\",le=\"%g\"} %d\n", typ, le, self.buckets[i])
+    }
+    fmt.Fprintf(w, "b2bua_radius_latency_seconds_bucket{type=\"%s\",le=\"+Inf\"} %d\n", typ, total)
+    fmt.Fprintf(w, "b2bua_radius_latency_seconds_sum{type=\"%s\"} %g\n", typ, self.sum)
+    fmt.Fprintf(w, "b2bua_radius_latency_seconds_count{type=\"%s\"} %d\n", typ, total)
+}
+
+// rtppStatsSource is implemented by the RTPproxy clients that keep
+// the statistics reported by the proxy in the heartbeat replies.
+type rtppStatsSource interface {
+    GetProxyAddress() string
+    IsOnline() bool
+    GetActiveSessions() int64
+    GetSessionsCreated() int64
+    GetActiveStreams() int64
+    GetPReceived() int64
+    GetPTransmitted() int64
+    GetRtpcDelay() float64
+}
+
+// MetricsServer exposes the B2BUA statistics over HTTP in the
+// Prometheus text format.
+type MetricsServer struct {
+    cmap        *CallMap
+    logger      sippy_log.ErrorLogger
+    listener    net.Listener
+}
+
+func NewMetricsServer(cmap *CallMap, address string, logger sippy_log.ErrorLogger) (*MetricsServer, error) {
+    listener, err := net.Listen("tcp", address)
+    if err != nil {
+        return nil, err
+    }
+    return &MetricsServer{
+        cmap        : cmap,
+        logger      : logger,
+        listener    : listener,
+    }, nil
+}
+
+func (self *MetricsServer) Start() {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/metrics", self.handle)
+    go func() {
+        err := http.Serve(self.listener, mux)
+        if err != nil {
+            self.logger.Error("Metrics server has stopped: " + err.Error())
+        }
+    }()
+}
+
+func (self *MetricsServer) handle(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    w.Write(self.collect())
+}
+
+func (self *MetricsServer) collect() []byte {
+    var w bytes.Buffer
+
+    states := make(map[CCState]int)
+    for s := CCStateIdle; s <= CCStateDisconnecting; s++ {
+        states[s] = 0
+    }
+    // The call lock is never taken while holding the CallMap lock, DropCC()
+    // takes them in the opposite order
+    alist := []*callController{}
+    self.cmap.ccmap_lock.Lock()
+    for _, cc := range self.cmap.ccmap {
+        alist = append(alist, cc)
+    }
+    self.cmap.ccmap_lock.Unlock()
+    for _, cc := range alist {
+        cc.lock.Lock()
+        states[cc.state]++
+        cc.lock.Unlock()
+    }
+    w.WriteString("# HELP b2bua_calls_active Calls in memory by the call controller state.\n")
+    w.WriteString("# TYPE b2bua_calls_active gauge\n")
+    for s := CCStateIdle; s <= CCStateDisconnecting; s++ {
+        fmt.Fprintf(&w, "b2bua_calls_active{state=\"%s\"} %d\n", s.String(), states[s])
+    }
+
+    if sip_tm := self.cmap.Sip_tm; sip_tm != nil {
+        stats := sip_tm.GetStats()
+        w.WriteString("# HELP b2bua_sip_transactions Active SIP transactions.\n")
+        w.WriteString("# TYPE b2bua_sip_transactions gauge\n")
+        fmt.Fprintf(&w, "b2bua_sip_transactions{type=\"client\"} %d\n", stats.ClientTransactions)
+        fmt.Fprintf(&w, "b2bua_sip_transactions{type=\"server\"} %d\n", stats.ServerTransactions)
+        w.WriteString("# HELP b2bua_sip_retransmissions_total SIP messages retransmitted or received again.\n")
+        w.WriteString("# TYPE b2bua_sip_retransmissions_total counter\n")
+        fmt.Fprintf(&w, "b2bua_sip_retransmissions_total{direction=\"sent\"} %d\n", stats.RetransmitsSent)
+        fmt.Fprintf(&w, "b2bua_sip_retransmissions_total{direction=\"received\"} %d\n", stats.RetransmitsReceived)
+        w.WriteString("# HELP b2bua_sip_responses_total SIP responses by the status class.\n")
+        w.WriteString("# TYPE b2bua_sip_responses_total counter\n")
+        for i := 1; i < len(stats.ResponsesSent); i++ {
+            fmt.Fprintf(&w, "b2bua_sip_responses_total{direction=\"sent\",class=\"%dxx\"} %d\n", i, stats.ResponsesSent[i])
+            fmt.Fprintf(&w, "b2bua_sip_responses_total{direction=\"received\",class=\"%dxx\"} %d\n", i, stats.ResponsesReceived[i])
+        }
+    }
+
+    if rc := self.cmap.radius_client; rc != nil {
+        w.WriteString("# HELP b2bua_radius_requests_total RADIUS requests by the result.\n")
+        w.WriteString("# TYPE b2bua_radius_requests_total counter\n")
+        w.WriteString("# HELP b2bua_radius_latency_seconds RADIUS request latency.\n")
+        w.WriteString("# TYPE b2bua_radius_latency_seconds histogram\n")
+        rc.auth_stats.write(&w, "auth")
+        rc.acct_stats.write(&w, "acct")
+    }
+
+    if len(self.cmap.rtp_proxy_clients) > 0 {
+        w.WriteString("# HELP b2bua_rtpproxy_online Whether the RTPproxy is online.\n")
+        w.WriteString("# TYPE b2bua_rtpproxy_online gauge\n")
+        w.WriteString("# HELP b2bua_rtpproxy_sessions RTPproxy sessions as reported by the proxy.\n")
+        w.WriteString("# TYPE b2bua_rtpproxy_sessions gauge\n")
+        w.WriteString("# HELP b2bua_rtpproxy_streams Active RTPproxy streams.\n")
+        w.WriteString("# TYPE b2bua_rtpproxy_streams gauge\n")
+        w.WriteString("# HELP b2bua_rtpproxy_packets_total Packets handled by the RTPproxy.\n")
+        w.WriteString("# TYPE b2bua_rtpproxy_packets_total counter\n")
+        w.WriteString("# HELP b2bua_rtpproxy_command_delay_seconds RTPproxy command round trip time.\n")
+        w.WriteString("# TYPE b2bua_rtpproxy_command_delay_seconds gauge\n")
+    }
+    for _, rtpp := range self.cmap.rtp_proxy_clients {
+        src, ok := rtpp.(rtppStatsSource)
+        if ! ok {
+            continue
+        }
+        addr := src.GetProxyAddress()
+        online := 0
+        if src.IsOnline() {
+            online = 1
+        }
+        fmt.Fprintf(&w, "b2bua_rtpproxy_online{rtpproxy=\"%s\"} %d\n", addr, online)
+        fmt.Fprintf(&w, "b2bua_rtpproxy_sessions{rtpproxy=\"%s\",type=\"active\"} %d\n", addr, src.GetActiveSessions())
+        fmt.Fprintf(&w, "b2bua_rtpproxy_sessions{rtpproxy=\"%s\",type=\"created\"} %d\n", addr, src.GetSessionsCreated())
+        fmt.Fprintf(&w, "b2bua_rtpproxy_streams{rtpproxy=\"%s\"} %d\n", addr, src.GetActiveStreams())
+        fmt.Fprintf(&w, "b2bua_rtpproxy_packets_total{rtpproxy=\"%s\",direction=\"received\"} %d\n", addr, src.GetPReceived())
+        fmt.Fprintf(&w, "b2bua_rtpproxy_packets_total{rtpproxy=\"%s\",direction=\"transmitted\"} %d\n", addr, src.GetPTransmitted())
+        fmt.Fprintf(&w, "b2bua_rtpproxy_command_delay_seconds{rtpproxy=\"%s\"} %g\n", addr, src.GetRtpcDelay())
+    }
+    return w.Bytes()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/log"
+)
+
+func TestMetricsCollect(t *testing.T) {
+    rc := &RadiusClient{
+        auth_stats  : newRadiusStats(),
+        acct_stats  : newRadiusStats(),
+    }
+    rc.auth_stats.record(&RadiusResult{ Rcode : 0 }, 20 * time.Millisecond)
+    rc.auth_stats.record(&RadiusResult{ Rcode : 1 }, 200 * time.Millisecond)
+    rc.acct_stats.record(nil, 3 * time.Second)
+    cmap := &CallMap{
+        ccmap           : map[int64]*callController{ 1 : &callController{ state : CCStateConnected, lock : new(sync.Mutex) } },
+        radius_client   : rc,
+    }
+    ms := &MetricsServer{ cmap : cmap, logger : sippy_log.NewErrorLogger() }
+    out := string(ms.collect())
+    for _, s := range []string{
+            "b2bua_calls_active{state=\"Connected\"} 1\n",
+            "b2bua_calls_active{state=\"Idle\"} 0\n",
+            "b2bua_radius_requests_total{type=\"auth\",result=\"accepted\"} 1\n",
+            "b2bua_radius_requests_total{type=\"auth\",result=\"rejected\"} 1\n",
+            "b2bua_radius_requests_total{type=\"acct\",result=\"failed\"} 1\n",
+            "b2bua_radius_latency_seconds_bucket{type=\"auth\",le=\"0.025\"} 1\n",
+            "b2bua_radius_latency_seconds_bucket{type=\"auth\",le=\"+Inf\"} 2\n",
+            "b2bua_radius_latency_seconds_count{type=\"acct\"} 1\n",
+        } {
+        if ! strings.Contains(out, s) {
+            t.Fatalf("%q is missing in the output:\n%s", s, out)
+        }
+    }
+}
+
+func TestMetricsCollectLockOrder(t *testing.T) {
+    cc := &callController{ state : CCStateConnected, lock : new(sync.Mutex) }
+    cmap := &CallMap{
+        ccmap           : map[int64]*callController{ 1 : cc },
+    }
+    ms := &MetricsServer{ cmap : cmap, logger : sippy_log.NewErrorLogger() }
+    // The call is being dropped, see aDead()
+    cc.lock.Lock()
+    done := make(chan string, 1)
+    go func() { done <- string(ms.collect()) }()
+    time.Sleep(10 * time.Millisecond)
+    locked := make(chan struct{})
+    go func() {
+        cmap.ccmap_lock.Lock()
+        delete(cmap.ccmap, 1)
+        cmap.ccmap_lock.Unlock()
+        close(locked)
+    }()
+    select {
+    case <-locked:
+    case <-time.After(2 * time.Second):
+        t.Fatal("The CallMap lock is held while waiting for the call lock")
+    }
+    cc.lock.Unlock()
+    if out := <-done; ! strings.Contains(out, "b2bua_calls_active{state=\"Connected\"} 1\n") {
+        t.Fatalf("The call has not been counted:\n%s", out)
+    }
+}
@@ -29,12 +29,15 @@ package main
 import (
     "errors"
     "flag"
+    "math"
+    "net"
     "strconv"
     "strings"
     "time"
 
     "github.com/gookit/ini/v2"
 
+    "github.com/sippy/go-b2bua/sippy/admission"
     "github.com/sippy/go-b2bua/sippy/conf"
     "github.com/sippy/go-b2bua/sippy/log"
     "github.com/sippy/go-b2bua/sippy/net"
@@ -52,9 +55,12 @@ type myConfigParser struct {
     Hrtb_ival_dur       time.Duration
     Keepalive_ans_dur   time.Duration
     Keepalive_orig_dur  time.Duration
+    Session_expires_dur time.Duration
     Rtp_proxy_clients_arr []string
     Pass_headers_arr    []string
     Allowed_pts_map     map[string]bool
+    Rate_limiter        *sippy_admission.RateLimiter
+    Registrations       []*trunkRegistration
 
     Accept_ips          string
     Acct_enable         bool
@@ -66,6 +72,7 @@ type myConfigParser struct {
     Hide_call_id        bool
     Keepalive_ans       int
     Keepalive_orig      int
+    Session_expires     int
     Logfile             string
     Max_credit_time     int
     Max_radius_clients  int
@@ -87,6 +94,39 @@ type myConfigParser struct {
     Sip_port            int
     Sip_proxy           string
     Start_acct_enable   bool
+    Sip_tcp             bool
+    Tls_port            int
+    Tls_cert_file       string
+    Tls_key_file        string
+    Tls_ca_file         string
+    Tls_verify_client   bool
+    Ws_port             int
+    Auto_tcp_switch     bool
+    Tcp_switch_threshold int
+    Rfc3263             bool
+    Blacklist_time      int
+    Blacklist_failures  int
+    Wss_port            int
+    Hep_collector       string
+    Hep_agent_id        int
+    Hep_password        string
+    Log_format          string
+    Metrics_address     string
+    Rate_limit_source   string
+    Rate_limit_invite   string
+    Rate_limit_register string
+    Rate_limit_options  string
+    Rate_limit_action   string
+    Rate_limit_allow    string
+    Register            string
+    Drain_timeout       int
+    Drain_retry_after   int
+    Overload_control    bool
+    Dialog_info         bool
+    Use_update          bool
+    Handle_transfer     bool
+    Oc_max_calls        int
+    Oc_threshold        int
 
     bool_opts           []_bool_opt
     int_opts            []_int_opt
@@ -139,6 +179,22 @@ func NewMyConfigParser() *myConfigParser {
                              "then send a challenge and re-authenticate when " +
                              "challenge response comes in", &self.Digest_auth_only, false },
         { "nat_traversal", "enable NAT traversal for signalling", &self.Nat_traversal, false },
+        { "sip_tcp", "enable SIP over TCP transport", &self.Sip_tcp, false },
+        { "rfc3263", "locate the SIP servers in the routes using DNS NAPTR " +
+                             "and SRV records (RFC 3263)", &self.Rfc3263, false },
+        { "auto_tcp_switch", "send requests larger than tcp_switch_threshold " +
+                             "over TCP instead of UDP (requires sip_tcp)", &self.Auto_tcp_switch, true },
+        { "tls_verify_client", "require and verify the certificates of the SIP " +
+                             "over TLS clients (mutual TLS)", &self.Tls_verify_client, false },
+        { "overload_control", "enable the SIP overload control (RFC 7339), i.e. " +
+                             "advertise the load to the upstream servers and " +
+                             "honour the overload reported by the downstream ones", &self.Overload_control, false },
+        { "dialog_info", "accept SUBSCRIBE for the dialog event package (RFC 4235) " +
+                             "to provide the busy-lamp field of the CLI/CLD", &self.Dialog_info, false },
+        { "use_update", "pass the SDP changes to the call legs that allow it " +
+                             "with UPDATE (RFC 3311) instead of re-INVITE", &self.Use_update, false },
+        { "handle_transfer", "handle REFER and BYE/Also by placing the call to the " +
+                             "transfer target instead of disconnecting the call", &self.Handle_transfer, false },
     }
     self.int_opts = []_int_opt{
         { "alive_acct_int", "interval for sending alive Radius accounting in " +
@@ -151,10 +207,34 @@ func NewMyConfigParser() *myConfigParser {
                              "originating (egress) call leg and disconnect a call " +
                              "if the re-INVITE fails (period in seconds, 0 to " +
                              "disable)", &self.Keepalive_orig, 0 },
+        { "session_expires", "negotiate RFC 4028 session timers on both call " +
+                             "legs and disconnect a call if the session is not " +
+                             "refreshed (interval in seconds, 0 to disable)", &self.Session_expires, 0 },
         { "max_credit_time", "upper limit of session time for all calls in seconds", &self.Max_credit_time, -1 },
         { "max_radiusclients", "maximum number of Radius Client helper " +
                              "processes to start", &self.Max_radius_clients, 20 },
         { "sip_port", "local UDP port to listen for incoming SIP requests", &self.Sip_port, 5060 },
+        { "tls_port", "local TCP port to listen for incoming SIP over TLS requests", &self.Tls_port, 5061 },
+        { "tcp_switch_threshold", "size of the request in bytes above which it is " +
+                             "sent over TCP", &self.Tcp_switch_threshold, 1300 },
+        { "blacklist_time", "time in seconds to skip the route address that " +
+                             "has failed repeatedly (0 to disable)", &self.Blacklist_time, 60 },
+        { "blacklist_failures", "number of consecutive failures (timeouts or 503 " +
+                             "responses) after which the address is blacklisted", &self.Blacklist_failures, 3 },
+        { "ws_port", "local TCP port to listen for incoming SIP over WebSocket " +
+                             "connections (0 to disable)", &self.Ws_port, 0 },
+        { "wss_port", "local TCP port to listen for incoming SIP over secure " +
+                             "WebSocket connections (0 to disable)", &self.Wss_port, 0 },
+        { "drain_timeout", "time in seconds after which the calls still active " +
+                             "in the drain mode are disconnected (0 to wait forever)", &self.Drain_timeout, 0 },
+        { "drain_retry_after", "value of the Retry-After header in the 503 responses " +
+                             "sent to new calls in the drain mode", &self.Drain_retry_after, 60 },
+        { "oc_max_calls", "number of active calls that corresponds to the full " +
+                             "load for the overload control (0 to only use the " +
+                             "SIP send queue depth)", &self.Oc_max_calls, 0 },
+        { "oc_threshold", "load in percent above which the upstream servers are " +
+                             "asked to reduce the traffic", &self.Oc_threshold, 80 },
+        { "hep_agent_id", "capture agent ID to report to the HEP collector", &self.Hep_agent_id, 0 },
         { "rtpp_hrtb_ival", "rtpproxy hearbeat interval (seconds)", &self.Rtpp_hrtb_ival, 10 },
         { "rtpp_hrtb_retr_ival", "rtpproxy hearbeat retry interval (seconds)", &self.Rtpp_hrtb_retr_ival, 60 },
     }
@@ -162,6 +242,8 @@ func NewMyConfigParser() *myConfigParser {
         { "b2bua_socket", "path to the B2BUA command socket or address to listen " +
                              "for commands in the format \"udp:host[:port]\"", &self.B2bua_socket, "/var/run/b2bua.sock" },
         { "logfile", "path to the B2BUA log file", &self.Logfile, "/var/log/b2bua.log" },
+        { "log_format", "format of the SIP and error logs, \"text\" for the " +
+                             "classic sip.log format or \"json\" for JSON lines", &self.Log_format, "text" },
         { "pidfile", "path to the B2BUA PID file", &self.Pidfile, "/var/run/b2bua.pid" },
         { "radiusclient", "path to the radiusclient executable", &self.Radiusclient, "/usr/local/sbin/radiusclient" },
         { "radiusclient_conf", "path to the radiusclient.conf file", &self.Radiusclient_conf, "" },
@@ -191,6 +273,34 @@ func NewMyConfigParser() *myConfigParser {
         { "rtp_proxy_clients", "comma-separated list of paths or addresses of the " +
                              "RTPproxy control socket. Address in the format " +
                              "\"udp:host[:port]\" (comma-separated list)", &self.Rtp_proxy_clients, "" },
+        { "tls_cert_file", "path to the TLS certificate file, setting it " +
+                             "enables SIP over TLS transport", &self.Tls_cert_file, "" },
+        { "tls_key_file", "path to the TLS private key file", &self.Tls_key_file, "" },
+        { "tls_ca_file", "path to the CA bundle used to verify the TLS peers", &self.Tls_ca_file, "" },
+        { "hep_collector", "address of the HEPv3 (Homer) collector to send " +
+                             "copies of all SIP messages to. Address in the " +
+                             "format \"udp:host[:port]\" or \"tcp:host[:port]\"", &self.Hep_collector, "" },
+        { "metrics_address", "address in the format \"host:port\" to serve the " +
+                             "Prometheus metrics at the /metrics URL (empty to " +
+                             "disable)", &self.Metrics_address, "" },
+        { "hep_password", "password to authenticate to the HEP collector", &self.Hep_password, "" },
+        { "rate_limit_source", "maximum rate of all SIP messages accepted from a " +
+                             "single IP address in the format \"rate[/burst]\" " +
+                             "in messages per second (empty to disable)", &self.Rate_limit_source, "" },
+        { "rate_limit_invite", "maximum rate of INVITE requests accepted from a " +
+                             "single IP address in the format \"rate[/burst]\"", &self.Rate_limit_invite, "" },
+        { "rate_limit_register", "maximum rate of REGISTER requests accepted from a " +
+                             "single IP address in the format \"rate[/burst]\"", &self.Rate_limit_register, "" },
+        { "rate_limit_options", "maximum rate of OPTIONS requests accepted from a " +
+                             "single IP address in the format \"rate[/burst]\"", &self.Rate_limit_options, "" },
+        { "rate_limit_action", "action to take on the requests over the rate " +
+                             "limit, \"drop\" to discard them silently or " +
+                             "\"reject\" to reply with 503", &self.Rate_limit_action, "drop" },
+        { "rate_limit_allow", "IP addresses or networks that bypass the rate " +
+                             "limits (comma-separated list)", &self.Rate_limit_allow, "" },
+        { "register", "trunks to register with in the format " +
+                             "\"user[:password]@registrar[:port][/expires]\" " +
+                             "(comma-separated list)", &self.Register, "" },
     }
     return self
 }
@@ -247,6 +357,21 @@ func (self *myConfigParser) Parse() error {
     if self.Sip_port <= 0 || self.Sip_port > 65535 {
         return errors.New("sip_port should be in the range 1-65535")
     }
+    if self.Tls_port <= 0 || self.Tls_port > 65535 {
+        return errors.New("tls_port should be in the range 1-65535")
+    }
+    if self.Ws_port < 0 || self.Ws_port > 65535 {
+        return errors.New("ws_port should be in the range 0-65535")
+    }
+    if self.Wss_port < 0 || self.Wss_port > 65535 {
+        return errors.New("wss_port should be in the range 0-65535")
+    }
+    if self.Oc_threshold < 0 || self.Oc_threshold > 100 {
+        return errors.New("oc_threshold should be in the range 0-100")
+    }
+    if self.Hep_agent_id < 0 || int64(self.Hep_agent_id) > math.MaxUint32 {
+        return errors.New("hep_agent_id should be in the range 0-4294967295")
+    }
 
     arr := strings.Split(self.Rtp_proxy_clients, ",")
     for _, s := range arr {
@@ -292,11 +417,27 @@ func (self *myConfigParser) Parse() error {
     } else if self.Keepalive_orig < 0 {
         return errors.New("keepalive_orig should be non-negative")
     }
+    if self.Session_expires > 0 {
+        self.Session_expires_dur = time.Duration(self.Session_expires) * time.Second
+    } else if self.Session_expires < 0 {
+        return errors.New("session_expires should be non-negative")
+    }
     if self.Max_credit_time < 0 && self.Max_credit_time != -1 {
         return errors.New("max_credit_time should be more than zero")
     }
-    error_logger := sippy_log.NewErrorLogger()
-    sip_logger, err := sippy_log.NewSipLogger("b2bua", self.Logfile)
+    var error_logger sippy_log.ErrorLogger
+    var sip_logger sippy_log.SipLogger
+    var err error
+    switch self.Log_format {
+    case "", "text":
+        error_logger = sippy_log.NewErrorLogger()
+        sip_logger, err = sippy_log.NewSipLogger("b2bua", self.Logfile)
+    case "json":
+        error_logger = sippy_log.NewJsonErrorLogger()
+        sip_logger, err = sippy_log.NewJsonSipLogger("b2bua", self.Logfile)
+    default:
+        return errors.New("log_format should be either \"text\" or \"json\"")
+    }
     if err != nil {
         return err
     }
@@ -304,6 +445,43 @@ func (self *myConfigParser) Parse() error {
     self.Hrtb_retr_ival_dur = time.Duration(self.Rtpp_hrtb_retr_ival) * time.Second
     self.Config = sippy_conf.NewConfig(error_logger, sip_logger)
     self.SetMyPort(sippy_net.NewMyPort(strconv.Itoa(self.Sip_port)))
+    self.SetTlsPort(sippy_net.NewMyPort(strconv.Itoa(self.Tls_port)))
+    self.SetTlsCertFile(self.Tls_cert_file)
+    self.SetTlsKeyFile(self.Tls_key_file)
+    self.SetTlsCAFile(self.Tls_ca_file)
+    self.SetTlsVerifyClient(self.Tls_verify_client)
+    self.SetAutoTcpSwitch(self.Auto_tcp_switch)
+    self.SetTcpSwitchThreshold(self.Tcp_switch_threshold)
+    if self.Hep_collector != "" {
+        proto, hp, err := parseHepCollector(self.Hep_collector)
+        if err != nil {
+            return err
+        }
+        self.SetHepCollector(hp)
+        self.SetHepProto(proto)
+        self.SetHepAgentId(uint32(self.Hep_agent_id))
+        self.SetHepPassword(self.Hep_password)
+    }
+    err = self.setupRateLimiter()
+    if err != nil {
+        return err
+    }
+    self.Registrations, err = parseTrunkRegistrations(self.Register, self)
+    if err != nil {
+        return err
+    }
+    if self.Rfc3263 {
+        protos := []string{ "UDP" }
+        if self.Sip_tcp {
+            protos = append(protos, "TCP")
+        }
+        if self.Tls_cert_file != "" {
+            protos = append(protos, "TLS")
+        }
+        resolver := sippy_net.NewResolver(nil)
+        resolver.SetTransports(protos)
+        self.SetResolver(resolver)
+    }
     if auth_disable {
         self.Auth_enable = false
     }
@@ -409,3 +587,99 @@ func (self *myConfigParser) _pass_header_cb(val string) error {
     self.Pass_headers_arr = append(self.Pass_headers_arr, val)
     return nil
 }
+
+func parseRateLimit(opt, s string) (float64, float64, error) {
+    arr := strings.SplitN(s, "/", 2)
+    rate, err := strconv.ParseFloat(strings.TrimSpace(arr[0]), 64)
+    if err != nil || rate <= 0 {
+        return 0, 0, errors.New("bad " + opt + " rate: " + s)
+    }
+    burst := rate
+    if len(arr) == 2 {
+        burst, err = strconv.ParseFloat(strings.TrimSpace(arr[1]), 64)
+        if err != nil || burst < 1 {
+            return 0, 0, errors.New("bad " + opt + " burst: " + s)
+        }
+    }
+    return rate, burst, nil
+}
+
+func (self *myConfigParser) setupRateLimiter() error {
+    var action sippy_admission.Verdict
+
+    if self.Rate_limit_source == "" && self.Rate_limit_invite == "" && self.Rate_limit_register == "" && self.Rate_limit_options == "" {
+        return nil
+    }
+    switch self.Rate_limit_action {
+    case "", "drop":
+        action = sippy_admission.DROP
+    case "reject":
+        action = sippy_admission.REJECT
+    default:
+        return errors.New("rate_limit_action should be either \"drop\" or \"reject\"")
+    }
+    rl := sippy_admission.NewRateLimiter(action)
+    if self.Rate_limit_source != "" {
+        rate, burst, err := parseRateLimit("rate_limit_source", self.Rate_limit_source)
+        if err != nil {
+            return err
+        }
+        rl.SetSourceLimit(rate, burst)
+    }
+    for _, it := range []struct { method, opt, val string }{
+                { "INVITE", "rate_limit_invite", self.Rate_limit_invite },
+                { "REGISTER", "rate_limit_register", self.Rate_limit_register },
+                { "OPTIONS", "rate_limit_options", self.Rate_limit_options },
+            } {
+        if it.val == "" {
+            continue
+        }
+        rate, burst, err := parseRateLimit(it.opt, it.val)
+        if err != nil {
+            return err
+        }
+        rl.SetMethodLimit(it.method, rate, burst)
+    }
+    for _, s := range strings.Split(self.Rate_limit_allow, ",") {
+        s = strings.TrimSpace(s)
+        if s == "" {
+            continue
+        }
+        if ! strings.Contains(s, "/") {
+            ip := net.ParseIP(s)
+            if ip == nil {
+                return errors.New("bad rate_limit_allow address: " + s)
+            }
+            if ip.To4() != nil {
+                s += "/32"
+            } else {
+                s += "/128"
+            }
+        }
+        _, network, err := net.ParseCIDR(s)
+        if err != nil {
+            return errors.New("bad rate_limit_allow network: " + s)
+        }
+        rl.AddAllowed(network)
+    }
+    self.Rate_limiter = rl
+    self.SetAdmissionFilter(rl)
+    return nil
+}
+
+func parseHepCollector(s string) (string, *sippy_net.HostPort, error) {
+    proto := "UDP"
+    arr := strings.SplitN(s, ":", 2)
+    if len(arr) == 2 && (strings.ToLower(arr[0]) == "udp" || strings.ToLower(arr[0]) == "tcp") {
+        proto = strings.ToUpper(arr[0])
+        s = arr[1]
+    }
+    host, port, err := net.SplitHostPort(s)
+    if err != nil {
+        host, port = s, "9060"
+    }
+    if host == "" {
+        return "", nil, errors.New("bad hep_collector address: " + s)
+    }
+    return proto, sippy_net.NewHostPort(host, port), nil
+}
@@ -130,6 +130,13 @@ func (self *RadiusAccounting) SetParams(username, caller, callee, h323_cid, sip_
     self.complete = true
 }
 
+// SetTransfer marks the records of the call leg that has been transferred
+// ("to" the target) or placed to the transfer target ("from" the
+// transferor).
+func (self *RadiusAccounting) SetTransfer(direction, number string) {
+    self._attributes = append(self._attributes, RadiusAttribute{ "h323-ivr-out", "transfer-" + direction + ":" + number })
+}
+
 func (self *RadiusAccounting) Conn(ua sippy_types.UA, rtime *sippy_time.MonoTime, origin string) {
     if self.crec {
         return
@@ -267,7 +274,8 @@ func (self *RadiusAccounting) asend(typ string, rtime *sippy_time.MonoTime /*= n
         pattributes += fmt.Sprintf("%-32s = '%s'\n", attr.name, attr.value)
     }
     self.global_config.SipLogger().Write(rtime, self.sip_cid, pattributes)
-    self.radius_client.do_acct(attributes, func(results *RadiusResult) { self._process_result(results, self.sip_cid, time.Now()) })
+    btime := time.Now()
+    self.radius_client.do_acct(attributes, func(results *RadiusResult) { self._process_result(results, self.sip_cid, btime) })
 }
 
 func (self *RadiusAccounting) ftime(t time.Time) string {
@@ -80,7 +80,8 @@ func (self *RadiusAuthorisation) Do_auth(username, caller, callee string, h323_c
         message += fmt.Sprintf("%-32s = '%s'\n", attr.name, attr.value)
     }
     self.global_config.SipLogger().Write(nil, sip_cid.StringBody(), message)
-    return self.radius_client.do_auth(attributes, func(results *RadiusResult) { self._process_result(results, res_cb, sip_cid.StringBody(), time.Now()) })
+    btime := time.Now()
+    return self.radius_client.do_auth(attributes, func(results *RadiusResult) { self._process_result(results, res_cb, sip_cid.StringBody(), btime) })
 }
 
 func (self *RadiusAuthorisation) _process_result(results *RadiusResult, res_cb func(*RadiusResult), sip_cid string, btime time.Time) {
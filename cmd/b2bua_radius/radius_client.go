@@ -28,13 +28,17 @@ package main
 
 import (
     "fmt"
+    "strconv"
     "strings"
+    "time"
 )
 
 type RadiusClient struct {
     external_command    *ExternalCommand
     _avpair_names       map[string]bool
     _cisco_vsa_names    map[string]bool
+    auth_stats          *radiusStats
+    acct_stats          *radiusStats
 }
 
 func NewRadiusClient(global_config *myConfigParser) *RadiusClient {
@@ -47,6 +51,8 @@ func NewRadiusClient(global_config *myConfigParser) *RadiusClient {
     }
     return &RadiusClient{
         external_command    : external_command,
+        auth_stats          : newRadiusStats(),
+        acct_stats          : newRadiusStats(),
         _avpair_names       : map[string]bool {
             "call-id"                   : true,
             "h323-session-protocol"     : true,
@@ -96,17 +102,24 @@ func (self *RadiusClient) _prepare_attributes(typ string, attributes []RadiusAtt
 }
 
 func (self *RadiusClient) do_auth(attributes []RadiusAttribute, result_callback func(results *RadiusResult)) Cancellable {
-    return self.external_command.process_command(self._prepare_attributes("AUTH", attributes), func(results []string) { self.process_result(results, result_callback) })
+    btime := time.Now()
+    return self.external_command.process_command(self._prepare_attributes("AUTH", attributes), func(results []string) { self.process_result(results, result_callback, self.auth_stats, btime) })
 }
 
 func (self *RadiusClient) do_acct(attributes []RadiusAttribute, result_callback func(results *RadiusResult) /*= nil*/) {
-    self.external_command.process_command(self._prepare_attributes("ACCT", attributes), func (results []string) { self.process_result(results, result_callback) })
+    btime := time.Now()
+    self.external_command.process_command(self._prepare_attributes("ACCT", attributes), func (results []string) { self.process_result(results, result_callback, self.acct_stats, btime) })
 }
 
-func (self *RadiusClient) process_result(results []string, result_callback func(*RadiusResult)) {
-    if result_callback == nil {
-        return
+func (self *RadiusClient) process_result(results []string, result_callback func(*RadiusResult), stats *radiusStats, btime time.Time) {
+    result := self.parse_result(results)
+    stats.record(result, time.Now().Sub(btime))
+    if result_callback != nil {
+        result_callback(result)
     }
+}
+
+func (self *RadiusClient) parse_result(results []string) *RadiusResult {
     result := NewRadiusResult()
     if len(results) > 0 {
         for _, r := range results[:len(results)-1] {
@@ -127,6 +140,14 @@ func (self *RadiusClient) process_result(results []string, result_callback func(
             }
             result.Avps = append(result.Avps, RadiusAttribute{ attr, val })
         }
+        // The last line is the radiusclient return code
+        rcode, err := strconv.Atoi(strings.TrimSpace(results[len(results)-1]))
+        if err != nil {
+            rcode = -1
+        }
+        result.Rcode = rcode
+    } else {
+        result.Rcode = -1
     }
-    result_callback(result)
+    return result
 }
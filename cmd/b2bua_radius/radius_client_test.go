@@ -0,0 +1,71 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+)
+
+func Test_RadiusParseResult(t *testing.T) {
+    rc := &RadiusClient{
+        _cisco_vsa_names    : map[string]bool{ "h323-credit-time" : true },
+    }
+    for _, tc := range []struct{
+        results []string
+        rcode   int
+        avps    int
+    }{
+        { []string{ "h323-credit-time = 'h323-credit-time=60'", "0" }, 0, 1 },
+        { []string{ "Reply-Message = 'Blocked'", "1" }, 1, 1 },
+        { []string{ "garbage" }, -1, 0 },
+        { []string{}, -1, 0 },
+    } {
+        result := rc.parse_result(tc.results)
+        if result.Rcode != tc.rcode || len(result.Avps) != tc.avps {
+            t.Fatalf("%q: expected rcode %d with %d AVPs, got %d with %d", tc.results, tc.rcode, tc.avps, result.Rcode, len(result.Avps))
+        }
+    }
+    result := rc.parse_result([]string{ "h323-credit-time = 'h323-credit-time=60'", "0" })
+    if result.Avps[0].name != "h323-credit-time" || result.Avps[0].value != "60" {
+        t.Fatalf("the Cisco VSA has not been unwrapped: %v", result.Avps[0])
+    }
+}
+
+type test_radius_sip_logger struct {
+    msgs    chan string
+}
+
+func (self *test_radius_sip_logger) Write(rtime *sippy_time.MonoTime, call_id string, msg string) {
+    self.msgs <- msg
+}
+
+func Test_RadiusAuthDelay(t *testing.T) {
+    logger := sippy_log.NewErrorLogger()
+    sip_logger := &test_radius_sip_logger{ msgs : make(chan string, 10) }
+    global_config := NewMyConfigParser()
+    global_config.Config = sippy_conf.NewConfig(logger, sip_logger)
+    rc := &RadiusClient{
+        external_command    : newExternalCommand(1, logger, "/bin/sh", "-c", "while read l; do [ -z \"$l\" ] && sleep 0.2 && echo 0 && echo; done"),
+        auth_stats          : newRadiusStats(),
+        acct_stats          : newRadiusStats(),
+    }
+    done := make(chan *RadiusResult, 1)
+    NewRadiusAuthorisation(rc, global_config).Do_auth("alice", "alice", "bob", sippy_header.NewSipCiscoGUID(),
+        sippy_header.NewSipCallIdFromString("test"), sippy_net.NewMyAddress("127.0.0.1"),
+        func(results *RadiusResult) { done <- results }, "", "", "", "")
+    select {
+    case <-done:
+    case <-time.After(2 * time.Second):
+        t.Fatal("Timeout waiting for the AAA result")
+    }
+    <-sip_logger.msgs // the request
+    if msg := <-sip_logger.msgs; ! strings.HasPrefix(msg, "AAA request accepted (delay is 0.2") {
+        t.Fatalf("the delay of the request has not been measured: %q", msg)
+    }
+}
@@ -0,0 +1,542 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "github.com/sippy/go-b2bua/sippy"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+// onReplaces handles the INVITE with Replaces (RFC 3891). The new
+// dialog is spliced into the call that owns the dialog being replaced
+// instead of creating a new call. The INVITE is authorised the same way
+// as the one creating a new call, see authReplaces().
+func (self *CallMap) onReplaces(req sippy_types.SipRequest, replaces *sippy_header.SipReplaces, remote_ip *sippy_net.MyAddress,
+  challenge *sippy_header.SipWWWAuthenticate) (sippy_types.UA, sippy_types.RequestReceiver, sippy_types.SipResponse) {
+    body, err := replaces.GetBody()
+    if err != nil {
+        return nil, nil, req.GenResponse(400, "Malformed Replaces Header", nil, nil)
+    }
+    cc, uaR, _ := self.lookupReplaces(body, nil)
+    if cc == nil {
+        return nil, nil, req.GenResponse(481, "Call Leg/Transaction Does Not Exist", nil, nil)
+    }
+    defer cc.lock.Unlock()
+    ua, resp := cc.acceptReplaces(req, uaR, body, remote_ip, challenge)
+    if resp != nil {
+        return nil, nil, resp
+    }
+    return ua, ua, nil
+}
+
+// lookupReplaces finds the call leg whose dialog is identified by the
+// Replaces header body. The to-tag is our local tag of that dialog and
+// the from-tag is the remote one. The call found is returned locked
+// unless it is the held one, i.e. the call whose lock the caller holds
+// already.
+//
+// The calls are locked one by one with the CallMap lock released, the
+// call lock is never taken while holding the latter (DropCC() takes them
+// in the opposite order). With the lock of the held call taken the other
+// calls are locked in the ascending order of their ids, the lock of the
+// call with a lower id is only tried and the call is skipped if it is
+// busy. The dialog not found is reported busy if any call has been
+// skipped this way.
+func (self *CallMap) lookupReplaces(body *sippy_header.SipReplacesBody, held *callController) (*callController, sippy_types.UA, bool) {
+    alist := []*callController{}
+    self.ccmap_lock.Lock()
+    for _, cc := range self.ccmap {
+        alist = append(alist, cc)
+    }
+    self.ccmap_lock.Unlock()
+    busy := false
+    for _, cc := range alist {
+        if cc != held {
+            if held == nil || cc.id > held.id {
+                cc.lock.Lock()
+            } else if ! cc.lock.TryLock() {
+                busy = true
+                continue
+            }
+        }
+        if ua := cc.replacesLeg(body); ua != nil {
+            return cc, ua, false
+        }
+        if cc != held {
+            cc.lock.Unlock()
+        }
+    }
+    return nil, nil, busy
+}
+
+// replacesLeg returns the leg of the call whose dialog is identified by
+// the Replaces header body or nil if there is none.
+func (self *callController) replacesLeg(body *sippy_header.SipReplacesBody) sippy_types.UA {
+    for _, ua := range []sippy_types.UA{ self.uaA, self.uaO } {
+        if ua == nil || ua.GetCallId() == nil || ua.GetRUri() == nil {
+            continue
+        }
+        if ua.GetCallId().CallId != body.CallId || ua.GetLTag() != body.ToTag {
+            continue
+        }
+        rUri, err := ua.GetRUri().GetBody(self.global_config)
+        if err == nil && rUri.GetTag() == body.FromTag {
+            return ua
+        }
+    }
+    return nil
+}
+
+func (self *callController) peerOf(ua sippy_types.UA) sippy_types.UA {
+    switch ua {
+    case self.uaA:
+        return self.uaO
+    case self.uaO:
+        return self.uaA
+    }
+    return nil
+}
+
+// acceptReplaces creates the UAS that is going to take the place of
+// the uaR leg. Only confirmed dialogs can be replaced. The call must be
+// locked.
+func (self *callController) acceptReplaces(req sippy_types.SipRequest, uaR sippy_types.UA, body *sippy_header.SipReplacesBody,
+  remote_ip *sippy_net.MyAddress, challenge *sippy_header.SipWWWAuthenticate) (sippy_types.UA, sippy_types.SipResponse) {
+    uaP := self.peerOf(uaR)
+    if uaP == nil || self.state != CCStateConnected || uaR.GetState() != sippy_types.UA_STATE_CONNECTED ||
+      uaP.GetState() != sippy_types.UA_STATE_CONNECTED {
+        return nil, req.GenResponse(481, "Call Leg/Transaction Does Not Exist", nil, nil)
+    }
+    if body.EarlyOnly || self.replacing {
+        return nil, req.GenResponse(486, "Busy Here", nil, nil)
+    }
+    if uaR == self.uaO && req.GetSource().Host.String() != uaR.GetRAddr0().Host.String() {
+        // Only the callee's side can replace the callee
+        return nil, req.GenResponse(403, "Forbidden", nil, nil)
+    }
+    self.uaN = self.newReplacingLeg(uaR, nil, req.GetSource())
+    self.uaR = uaR
+    self.uaR_gone = false
+    self.replacing = true
+    self.rpl_remote_ip = remote_ip
+    self.rpl_challenge = challenge
+    return self.uaN, nil
+}
+
+// authReplaces authorises the INVITE with Replaces received on uaN. The
+// request is authorised in the context of the call being replaced and
+// only the caller's account can replace the caller.
+func (self *callController) authReplaces(event *sippy.CCEventTry) {
+    self.rpl_event = event
+    username := self.rpl_remote_ip.String()
+    if ! self.global_config.Auth_enable {
+        self.rDoneReplaces_nolock(NewRadiusResult(), username)
+        return
+    }
+    var auth *sippy_header.SipAuthorizationBody
+    var err error
+    if auth_hf := event.GetSipAuthorizationHF(); auth_hf != nil {
+        auth, err = auth_hf.GetBody()
+        if err != nil {
+            self.dropReplacing(500, "Internal Server Error (6)", event.GetRtime())
+            return
+        }
+    }
+    if auth == nil || auth.GetUsername() == "" {
+        self.auth_proc = self.cmap.radius_auth.Do_auth(username, event.GetCLI(), event.GetCLD(), self.cGUID,
+          event.GetSipCallId(), self.rpl_remote_ip, func(results *RadiusResult) { self.rDoneReplaces(results, username) },
+          "", "", "", "")
+    } else {
+        username = auth.GetUsername()
+        self.auth_proc = self.cmap.radius_auth.Do_auth(username, event.GetCLI(), event.GetCLD(), self.cGUID,
+          event.GetSipCallId(), self.rpl_remote_ip, func(results *RadiusResult) { self.rDoneReplaces(results, username) },
+          auth.GetRealm(), auth.GetNonce(), auth.GetUri(), auth.GetResponse())
+    }
+}
+
+func (self *callController) rDoneReplaces(results *RadiusResult, username string) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if ! self.replacing || self.rpl_event == nil {
+        // The splice has been aborted meanwhile
+        return
+    }
+    self.auth_proc = nil
+    self.rDoneReplaces_nolock(results, username)
+}
+
+func (self *callController) rDoneReplaces_nolock(results *RadiusResult, username string) {
+    event := self.rpl_event
+    self.rpl_event = nil
+    if results == nil || results.Rcode != 0 {
+        if self.rpl_challenge != nil {
+            ev_fail := sippy.NewCCEventFail(401, "Unauthorized", nil, "")
+            ev_fail.AppendExtraHeader(self.rpl_challenge)
+            uaN := self.uaN
+            self.uaN = nil
+            self.dropReplacing(0, "", nil)
+            uaN.RecvEvent(ev_fail)
+        } else {
+            self.dropReplacing(403, "Auth Failed", event.GetRtime())
+        }
+        return
+    }
+    if self.uaR == self.uaA && username != self.username {
+        self.dropReplacing(403, "Forbidden", event.GetRtime())
+        return
+    }
+    self.offerReplacing(event)
+}
+
+// offerReplacing passes the offer from uaN to the remaining leg in a
+// re-INVITE.
+func (self *callController) offerReplacing(event sippy_types.CCEvent) {
+    body := event.GetBody()
+    if body == nil || self.reinvite_pending {
+        self.abortReplaces(488, "Not Acceptable Here", event.GetRtime())
+        return
+    }
+    uaP := self.peerOf(self.uaR)
+    if uaP == self.uaA {
+        self.sdp_session.FixupVersion(body)
+    }
+    self.reinvite_pending = true
+    uaP.RecvEvent(sippy.NewCCEventUpdate(event.GetRtime(), event.GetOrigin(), nil, nil, body))
+}
+
+// transferReplaces turns the REFER received on the ua leg with the
+// Replaces embedded into the Refer-To into the INVITE with Replaces
+// towards the other party of the dialog being replaced. Returns false
+// if the transfer cannot be handled this way.
+func (self *callController) transferReplaces(event *sippy.CCEventDisconnect, ua sippy_types.UA) bool {
+    also := event.GetRedirectURL()
+    uaP := self.peerOf(ua)
+    rl := self.xfer_lookup
+    self.xfer_lookup = nil
+    if also == nil || self.replacing || uaP == nil || uaP.GetState() != sippy_types.UA_STATE_CONNECTED {
+        return false
+    }
+    if rl == nil || rl.rstr != also.GetUrl().Headers["replaces"] {
+        rl = self.referReplaces(also.GetUrl())
+    }
+    if rl == nil {
+        return false
+    }
+    if rl.busy {
+        // Neither splice the dialog nor pass the transfer on, the dialog
+        // may well be ours
+        self.uaR = ua
+        self.uaR_gone = true
+        self.replacing = true
+        self.refer_sub = event.GetReferSubscription()
+        self.abortReplaces(491, "Request Pending", event.GetRtime())
+        return true
+    }
+    if rl.replaces == nil {
+        return false
+    }
+    replaces, cld, raddr := rl.replaces, rl.cld, rl.raddr
+    cli := self.cli
+    if ua == self.uaA {
+        cli = self.cld
+    }
+    ev_try, err := sippy.NewCCEventTry(nil, cli, cld, nil, nil, "", event.GetRtime(), "", replaces)
+    if err != nil {
+        return false
+    }
+    // The INVITE is sent without SDP, the offer from the transfer
+    // target is then passed to the remaining leg in a re-INVITE.
+    self.uaN = self.newReplacingLeg(ua, raddr, raddr)
+    self.uaR = ua
+    self.uaR_gone = true
+    self.replacing = true
+    self.refer_sub = event.GetReferSubscription()
+    self.xfer_acct = nil
+    if self.global_config.Acct_enable {
+        acct := NewRadiusAccounting(self.global_config, "originate", self.cmap.radius_client)
+        acct.SetParams(self.username, cli, cld, self.cGUID.StringBody(), self.cId.StringBody(), raddr.Host.String(), "")
+        self.xfer_acct = self.newTransferAcct(self.uaN, acct)
+    }
+    self.uaN.RecvEvent(ev_try)
+    return true
+}
+
+// replacesLookup is the outcome of referReplaces(). It is kept from
+// transferRequested() till transferReplaces(), so that the dialog is
+// looked up once per transfer and both take the same decision.
+type replacesLookup struct {
+    rstr        string
+    replaces    *sippy_header.SipReplaces
+    cld         string
+    raddr       *sippy_net.HostPort
+    busy        bool
+}
+
+// referReplaces looks up the dialog identified by the Replaces embedded
+// into the transfer target URL. The dialog has to belong to another call
+// of ours with the other party still connected. The lookup has the
+// Replaces header identifying the dialog with that party along with its
+// user and address or none if the transfer cannot be handled by
+// transferReplaces(). Returns nil if there is no Replaces in the URL.
+// The call must be locked.
+func (self *callController) referReplaces(url *sippy_header.SipURL) *replacesLookup {
+    rstr, ok := url.Headers["replaces"]
+    if ! ok {
+        return nil
+    }
+    rl := &replacesLookup{ rstr : rstr }
+    body, err := sippy_header.CreateSipReplaces(rstr)[0].(*sippy_header.SipReplaces).GetBody()
+    if err != nil {
+        return rl
+    }
+    cc, uaL, busy := self.cmap.lookupReplaces(body, self)
+    rl.busy = busy
+    if cc == nil || cc == self {
+        return rl
+    }
+    defer cc.lock.Unlock()
+    rl.replaces, rl.cld, rl.raddr = cc.peerReplaces(uaL)
+    return rl
+}
+
+// peerReplaces returns the Replaces header identifying the dialog with
+// the other party of the ua leg along with the user and the address of
+// that party. The call must be locked.
+func (self *callController) peerReplaces(ua sippy_types.UA) (*sippy_header.SipReplaces, string, *sippy_net.HostPort) {
+    uaF := self.peerOf(ua)
+    if uaF == nil || uaF.GetState() != sippy_types.UA_STATE_CONNECTED {
+        return nil, "", nil
+    }
+    rUri, err := uaF.GetRUri().GetBody(self.global_config)
+    if err != nil {
+        return nil, "", nil
+    }
+    replaces := sippy_header.NewSipReplaces(uaF.GetCallId().CallId, uaF.GetLTag(), rUri.GetTag(), false)
+    return replaces, uaF.GetRTarget().Username, uaF.GetRAddr().GetCopy()
+}
+
+func (self *callController) newReplacingLeg(uaR sippy_types.UA, nh_address, raddr *sippy_net.HostPort) sippy_types.UA {
+    ua := sippy.NewUA(self.sip_tm, self.global_config, nh_address, self, self.lock, nil)
+    ua.SetLocalUA(sippy_header.NewSipUserAgent(self.global_config.GetMyUAName()))
+    ua.SetSessionTimer(self.global_config.Session_expires_dur, sippy.SESSION_MIN_SE)
+    ua.SetUseUpdate(self.global_config.Use_update)
+    if uaR == self.uaA {
+        ua.SetKaInterval(self.global_config.Keepalive_ans_dur)
+        if self.rtp_proxy_session != nil {
+            self.rtp_proxy_session.SetCalleeRaddress(raddr)
+        }
+    } else {
+        ua.SetKaInterval(self.global_config.Keepalive_orig_dur)
+        if self.rtp_proxy_session != nil && self.proxied {
+            ua.SetOnLocalSdpChange(self.rtp_proxy_session.OnCallerSdpChange)
+            ua.SetOnRemoteSdpChange(self.rtp_proxy_session.OnCalleeSdpChange)
+            self.rtp_proxy_session.SetCallerRaddress(raddr)
+        }
+    }
+    return ua
+}
+
+// recvReplacesEvent handles the events while the uaN leg is being
+// spliced in place of uaR. The offer from uaN is sent to the remaining
+// leg in a re-INVITE and the answer is passed back to uaN, so that the
+// media is re-anchored through the same RTP session. Returns true if
+// the event has been consumed.
+func (self *callController) recvReplacesEvent(event sippy_types.CCEvent, ua sippy_types.UA) bool {
+    if ! self.replacing {
+        // Nothing of interest is left on the replaced leg
+        return ua == self.uaR
+    }
+    uaP := self.peerOf(self.uaR)
+    switch ua {
+    case self.uaN:
+        switch ev := event.(type) {
+        case *sippy.CCEventTry:
+            self.authReplaces(ev)
+        case *sippy.CCEventPreConnect:
+            if self.refer_sub != nil {
+                self.refer_sub.RecvEvent(event)
+            }
+            self.offerReplacing(event)
+        case *sippy.CCEventConnect:
+            if ! self.reinvite_pending {
+                // Connected without an offer
+                self.abortReplaces(488, "Not Acceptable Here", event.GetRtime())
+                break
+            }
+            // The ACK held by the remaining leg, see the uaP case below
+            uaP.RecvEvent(event)
+            self.completeReplaces(event.GetRtime())
+        case *sippy.CCEventFail, *sippy.CCEventRedirect, *sippy.CCEventDisconnect:
+            self.uaN = nil
+            if ! self.reinvite_pending && self.nextTransferRoute(ev) {
+                return true
+            }
+            if self.refer_sub != nil {
+                self.refer_sub.RecvEvent(event)
+            }
+            self.abortReplaces(0, "", event.GetRtime())
+        default:
+            if self.refer_sub != nil {
+                self.refer_sub.RecvEvent(event)
+            }
+        }
+        return true
+    case uaP:
+        if _, ok := event.(*sippy.CCEventDisconnect); ok {
+            // The replaced leg is to be disconnected as usual if it
+            // is still there
+            uaR_gone := self.uaR_gone
+            self.abortReplaces(487, "Request Terminated", event.GetRtime())
+            return uaR_gone
+        }
+        if ! self.reinvite_pending {
+            return false
+        }
+        switch ev := event.(type) {
+        case *sippy.CCEventConnect:
+            if self.uaR == self.uaA {
+                self.sdp_session.FixupVersion(event.GetBody())
+            }
+            self.uaN.RecvEvent(event)
+            self.completeReplaces(event.GetRtime())
+        case *sippy.CCEventPreConnect:
+            if self.uaR == self.uaA {
+                self.sdp_session.FixupVersion(event.GetBody())
+            }
+            if self.uaN.GetState() != sippy_types.UA_STATE_CONNECTED {
+                // The ACK is sent once uaN has been connected
+                self.uaN.RecvEvent(event)
+                break
+            }
+            // uaN is the UAC waiting for the answer to send it in ACK
+            ev_connect := sippy.NewCCEventConnect(ev.GetScode(), ev.GetScodeReason(), nil, event.GetRtime(), event.GetOrigin())
+            self.uaN.RecvEvent(sippy.NewCCEventConnect(ev.GetScode(), ev.GetScodeReason(), event.GetBody(), event.GetRtime(), event.GetOrigin()))
+            self.completeReplaces(event.GetRtime())
+            // The ACK held by uaP cannot be sent before the response
+            // has been processed by its transaction
+            sippy.StartTimeout(func() { uaP.RecvEvent(ev_connect) }, self.lock, 0, 1, self.global_config.ErrorLogger())
+        case *sippy.CCEventFail, *sippy.CCEventRedirect:
+            self.abortReplaces(488, "Not Acceptable Here", event.GetRtime())
+        }
+        return true
+    case self.uaR:
+        if self.uaR_gone {
+            return true
+        }
+        if _, ok := event.(*sippy.CCEventDisconnect); ok {
+            self.dropReplacing(481, "Call Leg/Transaction Does Not Exist", event.GetRtime())
+        }
+    }
+    return false
+}
+
+// completeReplaces puts uaN in place of uaR and disconnects the latter.
+func (self *callController) completeReplaces(rtime *sippy_time.MonoTime) {
+    uaR, uaN := self.uaR, self.uaN
+    if self.uaR_gone {
+        self.setTransferAcct(uaN)
+    } else {
+        uaN.SetConnCb(uaR.GetConnCb())
+        uaN.SetDiscCb(uaR.GetDiscCb())
+        uaN.SetFailCb(uaR.GetFailCb())
+        uaN.SetDeadCb(uaR.GetDeadCb())
+    }
+    uaR.SetConnCb(nil)
+    uaR.SetDiscCb(nil)
+    uaR.SetFailCb(nil)
+    uaR.SetDeadCb(nil)
+    if uaR == self.uaA {
+        self.uaA = uaN
+    } else {
+        self.uaO = uaN
+    }
+    self.uaN = nil
+    self.replacing = false
+    self.refer_sub = nil
+    self.reinvite_pending = false
+    self.xfer_routes = nil
+    self.xfer_acct = nil
+    // The transferor is disconnected once it has been notified of the
+    // outcome, see sippy.ReferSubscription.
+    if ! self.uaR_gone && uaR.GetState() == sippy_types.UA_STATE_CONNECTED {
+        uaR.Disconnect(rtime, "")
+    }
+    self.cmap.ccStateChanged(self)
+}
+
+// dropReplacing disconnects uaN and leaves the call as it was.
+func (self *callController) dropReplacing(scode int, reason string, rtime *sippy_time.MonoTime) {
+    if self.uaN != nil {
+        switch self.uaN.GetState() {
+        case sippy_types.UAS_STATE_IDLE, sippy_types.UAS_STATE_TRYING, sippy_types.UAS_STATE_RINGING:
+            self.uaN.RecvEvent(sippy.NewCCEventFail(scode, reason, rtime, ""))
+        default:
+            self.uaN.RecvEvent(sippy.NewCCEventDisconnect(nil, rtime, ""))
+        }
+    }
+    if self.auth_proc != nil {
+        self.auth_proc.Cancel()
+        self.auth_proc = nil
+    }
+    if self.refer_sub != nil && scode != 0 {
+        self.refer_sub.Progress(scode, reason)
+    }
+    self.uaN = nil
+    self.uaR = nil
+    self.replacing = false
+    self.refer_sub = nil
+    self.reinvite_pending = false
+    self.xfer_routes = nil
+    self.xfer_acct = nil
+    self.xfer_cli = ""
+    self.xfer_cld = ""
+    self.rpl_event = nil
+    self.rpl_challenge = nil
+}
+
+// abortReplaces is the same as dropReplacing() but if the replaced leg
+// is already gone, i.e. it has sent us REFER or BYE with Also, the call
+// is disconnected as well.
+func (self *callController) abortReplaces(scode int, reason string, rtime *sippy_time.MonoTime) {
+    uaR, uaR_gone, uaP := self.uaR, self.uaR_gone, self.peerOf(self.uaR)
+    if rtime == nil {
+        rtime, _ = sippy_time.NewMonoTime()
+    }
+    self.dropReplacing(scode, reason, rtime)
+    if ! uaR_gone {
+        return
+    }
+    if uaP != nil && uaP.GetState() == sippy_types.UA_STATE_CONNECTED {
+        uaP.RecvEvent(sippy.NewCCEventDisconnect(nil, rtime, ""))
+    }
+    if uaR == self.uaA {
+        // Complete what has been postponed by aDisc()
+        self.aDisc(rtime, "", 0, nil)
+    }
+}
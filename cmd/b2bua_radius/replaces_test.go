@@ -0,0 +1,110 @@
+package main
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+// replacesAlice builds the INVITE with Replaces for the dialog with
+// alice sent by carol through the via host.
+func (self *test_call) replacesAlice(call_id, via_host string) []string {
+    to_tag := strings.SplitN(self.a_to, ";tag=", 2)[1]
+    return append([]string{
+        "INVITE sip:bob@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP " + via_host + ":5060;branch=z9hG4bK" + call_id,
+        "Max-Forwards: 70",
+        "From: <sip:carol@example.com>;tag=" + call_id + "c",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:carol@1.1.1.1:5060>",
+        "Call-ID: " + call_id,
+        "CSeq: 1 INVITE",
+        "Replaces: " + self.call_id + ";to-tag=" + to_tag + ";from-tag=" + self.call_id + "a",
+    }, testSdp("30000")...)
+}
+
+func TestReplacesSplice(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    call := newTestCall(t, cmap, tfactory, "rpl-1")
+    tfactory.feed(call.replacesAlice("rpl-1-new", "1.1.1.1"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 100 ", "INVITE sip:bob@2.2.2.2")
+    if msgs[1].GetCallId().CallId != call.o_invite.GetCallId().CallId {
+        t.Fatal("The re-INVITE is not within the dialog with bob")
+    }
+    tfactory.reply(config, msgs[1], 200, "OK", append([]string{
+        "Contact: <sip:bob@2.2.2.2:5060>",
+    }, testSdp("20002")...)...)
+    msgs = tfactory.expect(t, config, "SIP/2.0 200 ")
+    if msgs[0].GetCallId().CallId != "rpl-1-new" {
+        t.Fatal("The 200 OK has not been sent to carol")
+    }
+    // The ACK to bob waits for the one from carol
+    tfactory.feed([]string{
+        "ACK sip:b2bua@127.0.0.1 SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bKrpl-1-newACK",
+        "Max-Forwards: 70",
+        "From: <sip:carol@example.com>;tag=rpl-1-newc",
+        msgs[0].GetTo().String(),
+        "Call-ID: rpl-1-new",
+        "CSeq: 1 ACK",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    tfactory.expect(t, config, "ACK sip:bob@2.2.2.2", "BYE sip:alice@1.1.1.1")
+    call.cc.lock.Lock()
+    defer call.cc.lock.Unlock()
+    if call.cc.uaA.GetCallId().CallId != "rpl-1-new" || call.cc.uaN != nil {
+        t.Fatal("The caller's leg has not been replaced")
+    }
+    if call.cc.uaO.GetState() != sippy_types.UA_STATE_CONNECTED {
+        t.Fatal("The callee has been disconnected")
+    }
+}
+
+func TestReplacesAbort(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    call := newTestCall(t, cmap, tfactory, "rpl-2")
+    tfactory.feed(call.replacesAlice("rpl-2-new", "1.1.1.1"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 100 ", "INVITE sip:bob@2.2.2.2")
+    tfactory.reply(config, msgs[1], 488, "Not Acceptable Here")
+    msgs = tfactory.expect(t, config, "ACK sip:bob@2.2.2.2", "SIP/2.0 488 ")
+    if msgs[1].GetCallId().CallId != "rpl-2-new" {
+        t.Fatal("The 488 has not been sent to carol")
+    }
+    call.cc.lock.Lock()
+    defer call.cc.lock.Unlock()
+    if call.cc.uaA.GetCallId().CallId != "rpl-2" || call.cc.uaN != nil {
+        t.Fatal("The call has been changed")
+    }
+    if call.cc.uaA.GetState() != sippy_types.UA_STATE_CONNECTED || call.cc.uaO.GetState() != sippy_types.UA_STATE_CONNECTED {
+        t.Fatal("The call has been disconnected")
+    }
+}
+
+func TestReplacesRejected(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    call := newTestCall(t, cmap, tfactory, "rpl-3")
+    // Not the caller's account
+    tfactory.feedFrom(call.replacesAlice("rpl-3-new", "3.3.3.3"), "3.3.3.3")
+    tfactory.expect(t, config, "SIP/2.0 100 ", "SIP/2.0 403 ")
+    // Unknown dialog
+    inp := call.replacesAlice("rpl-3-unknown", "1.1.1.1")
+    inp[8] = "Replaces: unknown;to-tag=1;from-tag=2"
+    tfactory.feed(inp)
+    tfactory.expect(t, config, "SIP/2.0 481 ")
+    cmap.drain_lock.Lock()
+    cmap.draining = true
+    cmap.drain_lock.Unlock()
+    tfactory.feed(call.replacesAlice("rpl-3-drain", "1.1.1.1"))
+    tfactory.expect(t, config, "SIP/2.0 503 ")
+    call.cc.lock.Lock()
+    defer call.cc.lock.Unlock()
+    if call.cc.uaN != nil || call.cc.uaA.GetState() != sippy_types.UA_STATE_CONNECTED {
+        t.Fatal("The call has been changed")
+    }
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+type blacklist_entry struct {
+    failures        int
+    until           time.Time
+    last_failure    time.Time
+}
+
+// expired tells if the entry can be forgotten: either its blacklisting
+// period is over or the failures have not accumulated in time.
+func (self *blacklist_entry) expired(now time.Time, duration time.Duration) bool {
+    if ! self.until.IsZero() {
+        return now.After(self.until)
+    }
+    return now.After(self.last_failure.Add(duration))
+}
+
+// TargetBlacklist keeps track of the next hop addresses that have
+// failed repeatedly, so that the calls skip them for a while.
+type TargetBlacklist struct {
+    lock            sync.Mutex
+    entries         map[string]*blacklist_entry
+    max_failures    int
+    duration        time.Duration
+    next_prune      time.Time
+}
+
+func NewTargetBlacklist(max_failures int, duration time.Duration) *TargetBlacklist {
+    if max_failures < 1 {
+        max_failures = 1
+    }
+    return &TargetBlacklist{
+        entries         : make(map[string]*blacklist_entry),
+        max_failures    : max_failures,
+        duration        : duration,
+    }
+}
+
+func (self *TargetBlacklist) IsBlacklisted(target string) bool {
+    if self == nil {
+        return false
+    }
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    entry, ok := self.entries[target]
+    if ! ok || entry.until.IsZero() {
+        return false
+    }
+    if time.Now().After(entry.until) {
+        delete(self.entries, target)
+        return false
+    }
+    return true
+}
+
+func (self *TargetBlacklist) Failure(target string) {
+    if self == nil || self.duration <= 0 {
+        return
+    }
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    now := time.Now()
+    if now.After(self.next_prune) {
+        self.prune(now)
+    }
+    entry, ok := self.entries[target]
+    if ! ok || entry.expired(now, self.duration) {
+        entry = &blacklist_entry{}
+        self.entries[target] = entry
+    }
+    entry.failures++
+    entry.last_failure = now
+    if entry.failures >= self.max_failures {
+        entry.failures = 0
+        entry.until = now.Add(self.duration)
+    }
+}
+
+// prune drops the expired entries so that the targets failed once
+// do not stay in the map forever.
+func (self *TargetBlacklist) prune(now time.Time) {
+    for target, entry := range self.entries {
+        if entry.expired(now, self.duration) {
+            delete(self.entries, target)
+        }
+    }
+    self.next_prune = now.Add(self.duration)
+}
+
+func (self *TargetBlacklist) Success(target string) {
+    if self == nil {
+        return
+    }
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    delete(self.entries, target)
+}
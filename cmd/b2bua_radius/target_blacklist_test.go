@@ -0,0 +1,59 @@
+package main
+
+import (
+    "net"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+func Test_TargetBlacklist(t *testing.T) {
+    bl := NewTargetBlacklist(2, time.Hour)
+    route := &B2BRoute{
+        ainfo : []*ainfo_item{
+            { net.ParseIP("192.0.2.1"), "5060", "" },
+            { net.ParseIP("192.0.2.2"), "5060", "" },
+        },
+    }
+    bl.Failure("192.0.2.1:5060")
+    if bl.IsBlacklisted("192.0.2.1:5060") {
+        t.Fatal("Target blacklisted after the first failure")
+    }
+    bl.Failure("192.0.2.1:5060")
+    if ! bl.IsBlacklisted("192.0.2.1:5060") {
+        t.Fatal("Target is not blacklisted after two failures")
+    }
+    targets := route.getNHTargets(sippy_net.NewHostPort("192.0.2.100", "5060"), bl)
+    if len(targets) != 1 || targets[0].String() != "192.0.2.2:5060" {
+        t.Fatalf("Unexpected targets: %v", targets)
+    }
+    bl.Failure("192.0.2.2:5060")
+    bl.Failure("192.0.2.2:5060")
+    if targets = route.getNHTargets(sippy_net.NewHostPort("192.0.2.100", "5060"), bl); len(targets) != 2 {
+        t.Fatal("All targets should be returned when all of them are blacklisted")
+    }
+    bl.Success("192.0.2.1:5060")
+    if bl.IsBlacklisted("192.0.2.1:5060") {
+        t.Fatal("Target is still blacklisted after success")
+    }
+}
+
+func Test_TargetBlacklistPrune(t *testing.T) {
+    bl := NewTargetBlacklist(2, 10 * time.Millisecond)
+    bl.Failure("192.0.2.1:5060")
+    time.Sleep(20 * time.Millisecond)
+    // The earlier failure is too old to count
+    bl.Failure("192.0.2.2:5060")
+    if _, ok := bl.entries["192.0.2.1:5060"]; ok {
+        t.Fatal("The stale entry has not been pruned")
+    }
+    bl.Failure("192.0.2.2:5060")
+    if ! bl.IsBlacklisted("192.0.2.2:5060") {
+        t.Fatal("Target is not blacklisted after two failures")
+    }
+    time.Sleep(20 * time.Millisecond)
+    if bl.IsBlacklisted("192.0.2.2:5060") || len(bl.entries) != 0 {
+        t.Fatal("The blacklisting has not expired")
+    }
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "strings"
+
+    "github.com/sippy/go-b2bua/sippy"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+// transferRequested returns the transfer target if inreq is REFER or BYE
+// with Also received on the ua leg and the transfer is to be handled by
+// us instead of disconnecting the call.
+func (self *callController) transferRequested(ua sippy_types.UA, inreq sippy_types.SipRequest) *sippy_header.SipURL {
+    uaP := self.peerOf(ua)
+    if inreq == nil || self.state != CCStateConnected || self.replacing || uaP == nil ||
+      uaP.GetState() != sippy_types.UA_STATE_CONNECTED {
+        return nil
+    }
+    var also *sippy_header.SipAddress
+    var err error
+    switch {
+    case inreq.GetMethod() == "REFER" && inreq.GetReferTo() != nil:
+        also, err = inreq.GetReferTo().GetBody(self.global_config)
+    case inreq.GetMethod() == "BYE" && len(inreq.GetAlso()) > 0:
+        also, err = inreq.GetAlso()[0].GetBody(self.global_config)
+    default:
+        return nil
+    }
+    if err != nil {
+        return nil
+    }
+    // The same checks as in transferReplaces() and startTransfer()
+    self.xfer_lookup = self.referReplaces(also.GetUrl())
+    if rl := self.xfer_lookup; rl != nil && (rl.replaces != nil || rl.busy) {
+        return also.GetUrl()
+    }
+    if self.global_config.Handle_transfer && also.GetUrl().Username != "" {
+        return also.GetUrl()
+    }
+    return nil
+}
+
+// recvTransfer handles the transfer requested by the ua leg with the
+// CCEventDisconnect carrying the Refer-To or Also. The leg that has sent
+// REFER is kept until the transferor has been notified of the outcome,
+// so its accounting is stopped here instead of aDisc()/oDisc().
+func (self *callController) recvTransfer(event *sippy.CCEventDisconnect, ua sippy_types.UA) bool {
+    if ! self.transferReplaces(event, ua) && ! self.startTransfer(event, ua) {
+        return false
+    }
+    if ua.GetState() != sippy_types.UA_STATE_CONNECTED {
+        return true
+    }
+    var acct Accounting = self.acctA
+    if ua == self.uaO {
+        if self.acctO == nil {
+            return true
+        }
+        acct = self.acctO
+    }
+    acct.SetTransfer("to", event.GetRedirectURL().GetUrl().Username)
+    acct.Disc(ua, event.GetRtime(), event.GetOrigin(), 0)
+    return true
+}
+
+// startTransfer places the call from the remaining party to the target
+// of the REFER or BYE with Also received on the ua leg. The new call leg
+// goes through the same authorisation and routing as the original call
+// and takes the place of ua once it is answered.
+func (self *callController) startTransfer(event *sippy.CCEventDisconnect, ua sippy_types.UA) bool {
+    also := event.GetRedirectURL()
+    uaP := self.peerOf(ua)
+    if ! self.global_config.Handle_transfer || also == nil || also.GetUrl().Username == "" || self.replacing ||
+      uaP == nil || uaP.GetState() != sippy_types.UA_STATE_CONNECTED {
+        return false
+    }
+    self.uaR = ua
+    self.uaR_gone = true
+    self.replacing = true
+    self.refer_sub = event.GetReferSubscription()
+    self.xfer_cld = also.GetUrl().Username
+    if ua == self.uaO {
+        self.xfer_cli = self.cli
+    } else {
+        self.xfer_cli = self.cld
+    }
+    if ! self.global_config.Auth_enable {
+        self.rDoneTransfer_nolock(NewRadiusResult())
+        return true
+    }
+    self.auth_proc = self.cmap.radius_auth.Do_auth(self.remote_ip.String(), self.xfer_cli, self.xfer_cld, self.cGUID,
+      self.cId, self.remote_ip, self.rDoneTransfer, "", "", "", "")
+    return true
+}
+
+func (self *callController) rDoneTransfer(results *RadiusResult) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if ! self.replacing || self.uaN != nil {
+        // The transfer has been aborted meanwhile
+        return
+    }
+    self.auth_proc = nil
+    self.rDoneTransfer_nolock(results)
+}
+
+func (self *callController) rDoneTransfer_nolock(results *RadiusResult) {
+    if results == nil || results.Rcode != 0 {
+        self.abortReplaces(403, "Auth Failed", nil)
+        return
+    }
+    routes, reason := self.getRoutes(results, self.xfer_cli, self.xfer_cld)
+    if reason != "" {
+        self.abortReplaces(500, reason, nil)
+        return
+    }
+    self.xfer_routes = routes[1:]
+    self.placeTransfer(routes[0])
+}
+
+// nextTransferRoute places the call to the transfer target using the
+// next route after the failed attempt. Returns false if there is no
+// route left to try.
+func (self *callController) nextTransferRoute(event sippy_types.CCEvent) bool {
+    if len(self.xfer_routes) == 0 {
+        return false
+    }
+    if ev_fail, ok := event.(*sippy.CCEventFail); ok {
+        for _, c := range self.xfer_huntstop {
+            if c == ev_fail.GetScode() {
+                return false
+            }
+        }
+    }
+    oroute := self.xfer_routes[0]
+    self.xfer_routes = self.xfer_routes[1:]
+    self.placeTransfer(oroute)
+    return true
+}
+
+func (self *callController) placeTransfer(oroute *B2BRoute) {
+    cld := oroute.cld
+    if self.global_config.Static_tr_out != "" {
+        var err error
+        cld, err = re_replace(self.global_config.Static_tr_out, cld)
+        if err != nil {
+            self.abortReplaces(500, "Internal Server Error (7)", nil)
+            return
+        }
+    }
+    var nh_address *sippy_net.HostPort
+    host := oroute.hostonly
+    transport := oroute.transport
+    if oroute.hostport == "sip-ua" {
+        host = self.source.Host.String()
+        nh_address = self.source
+    } else {
+        nh_target := oroute.getNHTargets(self.source, self.cmap.blacklist)[0]
+        nh_address = nh_target.HostPort()
+        if transport == "" && nh_target.proto != "" && nh_target.proto != "UDP" {
+            transport = strings.ToLower(nh_target.proto)
+        }
+    }
+    self.xfer_huntstop = oroute.huntstop_scodes
+    self.uaN = self.newReplacingLeg(self.uaR, nh_address, nh_address)
+    self.uaN.SetUsername(oroute.user)
+    self.uaN.SetPassword(oroute.passw)
+    self.uaN.SetRTargetTransport(transport)
+    if oroute.credit_time > 0 {
+        self.uaN.SetCreditTime(oroute.credit_time)
+    }
+    if oroute.expires > 0 {
+        self.uaN.SetExpireTime(oroute.expires)
+    }
+    self.uaN.SetNoProgressTime(oroute.no_progress_expires)
+    extra_headers := []sippy_header.SipHeader{ self.cGUID, self.cGUID.AsH323ConfId() }
+    extra_headers = append(extra_headers, oroute.extra_headers...)
+    self.uaN.SetExtraHeaders(extra_headers)
+    if oroute.outbound_proxy != nil && self.source.String() != oroute.outbound_proxy.String() {
+        self.uaN.SetOutboundProxy(oroute.outbound_proxy)
+    }
+    self.xfer_acct = nil
+    if ! oroute.forward_on_fail && self.global_config.Acct_enable {
+        self.xfer_acct = self.newTransferAcct(self.uaN, self.newOriginateAcct(oroute, host))
+    }
+    caller_name := oroute.caller_name
+    if caller_name == "" && self.uaR == self.uaO {
+        caller_name = self.caller_name
+    }
+    // The INVITE is sent without SDP, see recvReplacesEvent()
+    event, _ := sippy.NewCCEventTry(nil, oroute.cli, cld, nil, nil, caller_name, nil, "")
+    self.uaN.RecvEvent(event)
+}
+
+// newTransferAcct makes acct the accounting of the call leg placed to
+// the transfer target.
+func (self *callController) newTransferAcct(ua sippy_types.UA, acct *RadiusAccounting) *RadiusAccounting {
+    if self.uaR == self.uaO {
+        acct.SetTransfer("from", self.cld)
+    } else {
+        acct.SetTransfer("from", self.cli)
+    }
+    ua.SetConnCb(func(rtime *sippy_time.MonoTime, origin string) { acct.Conn(ua, rtime, origin) })
+    ua.SetDiscCb(func(rtime *sippy_time.MonoTime, origin string, scode int, req sippy_types.SipRequest) { acct.Disc(ua, rtime, origin, scode) })
+    ua.SetFailCb(func(rtime *sippy_time.MonoTime, origin string, scode int) { acct.Disc(ua, rtime, origin, scode) })
+    return acct
+}
+
+// setTransferAcct makes the call leg placed to the transfer target
+// take over the accounting and the callbacks of the leg it replaces.
+func (self *callController) setTransferAcct(ua sippy_types.UA) {
+    if self.uaR == self.uaA {
+        if self.xfer_acct != nil {
+            self.acctA = self.xfer_acct
+        } else {
+            self.acctA = NewFakeAccounting()
+        }
+        ua.SetDiscCb(self.aDisc)
+        ua.SetFailCb(self.aFail)
+        ua.SetDeadCb(self.aDead)
+        if self.xfer_cld != "" {
+            self.cli = self.xfer_cld
+        }
+    } else {
+        self.acctO = self.xfer_acct
+        if self.acctO != nil {
+            ua.SetDiscCb(self.oDisc)
+            ua.SetFailCb(self.oFail)
+        }
+        ua.SetDeadCb(self.oDead)
+        if self.xfer_cld != "" {
+            self.cld = self.xfer_cld
+        }
+    }
+    self.xfer_cli = ""
+    self.xfer_cld = ""
+}
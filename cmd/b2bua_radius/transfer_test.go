@@ -0,0 +1,225 @@
+package main
+
+import (
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+func expectSipfrag(t *testing.T, msg sippy_types.SipMsg, sipfrag string) {
+    if msg.GetBody() == nil || ! strings.HasPrefix(msg.GetBody().String(), sipfrag) {
+        t.Fatalf("Expected %q sipfrag in NOTIFY", sipfrag)
+    }
+}
+
+func TestReferPassThrough(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    call := newTestCall(t, cmap, tfactory, "xfer-1")
+    tfactory.feed(call.fromBob("REFER", "Refer-To: <sip:carol@example.com>"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 202 ", "NOTIFY sip:bob@2.2.2.2", "REFER sip:alice@1.1.1.1")
+    expectSipfrag(t, msgs[1], "SIP/2.0 100 Trying")
+    tfactory.reply(config, msgs[1], 200, "OK")
+    call.cc.lock.Lock()
+    if call.cc.uaO.GetState() != sippy_types.UA_STATE_CONNECTED {
+        t.Fatal("The transferor has been disconnected ahead of the final NOTIFY")
+    }
+    call.cc.lock.Unlock()
+    // The response to the REFER passed on to alice is the outcome
+    tfactory.reply(config, msgs[2], 202, "Accepted")
+    msgs = tfactory.expect(t, config, "NOTIFY sip:bob@2.2.2.2", "BYE sip:alice@1.1.1.1")
+    expectSipfrag(t, msgs[0], "SIP/2.0 202 Accepted")
+    if ss := msgs[0].GetFirstHF("subscription-state"); ss == nil || ! strings.HasPrefix(ss.StringBody(), "terminated") {
+        t.Fatal("The refer subscription has not been terminated")
+    }
+    tfactory.reply(config, msgs[1], 200, "OK")
+    tfactory.reply(config, msgs[0], 200, "OK")
+    tfactory.expect(t, config, "BYE sip:bob@2.2.2.2")
+}
+
+// testAcct enables the accounting with the records queued to the
+// channel returned instead of being sent to the RADIUS client.
+func testAcct(cmap *CallMap) chan *Work_item {
+    work_ch := make(chan *Work_item, 100)
+    cmap.radius_client = &RadiusClient{ external_command : &ExternalCommand{ work_ch : work_ch } }
+    cmap.global_config.Acct_enable = true
+    return work_ch
+}
+
+// expectAcct reads the accounting record of the typ and returns it
+// as a single string.
+func expectAcct(t *testing.T, work_ch chan *Work_item, typ string) string {
+    select {
+    case wi := <-work_ch:
+        rec := strings.Join(wi.data, "\n")
+        if ! strings.Contains(rec, "Acct-Status-Type=\"" + typ + "\"") {
+            t.Fatalf("Expected the Acct %s record, got:\n%s", typ, rec)
+        }
+        return rec
+    case <-time.After(2 * time.Second):
+        t.Fatalf("Timeout waiting for the Acct %s record", typ)
+    }
+    return ""
+}
+
+func TestBlindTransferByCallee(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    config.Handle_transfer = true
+    work_ch := testAcct(cmap)
+    call := newTestCall(t, cmap, tfactory, "xfer-2")
+    call.cc.lock.Lock()
+    uaO, acctO := call.cc.uaO, call.cc.acctO
+    call.cc.lock.Unlock()
+
+    tfactory.feed(call.fromBob("REFER", "Refer-To: <sip:carol@example.com>"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 202 ", "NOTIFY sip:bob@2.2.2.2", "INVITE sip:bob@2.2.2.2")
+    expectSipfrag(t, msgs[1], "SIP/2.0 100 Trying")
+    if msgs[2].GetCallId().CallId == call.o_invite.GetCallId().CallId || msgs[2].GetBody() != nil {
+        t.Fatal("The transfer target is not called in a new dialog without SDP")
+    }
+    // The transferor's leg is accounted as transferred
+    if rec := expectAcct(t, work_ch, "Stop"); ! strings.Contains(rec, "transfer-to:carol") {
+        t.Fatal("The transferor's record has no transfer-to:carol")
+    }
+    tfactory.reply(config, msgs[1], 200, "OK")
+    tfactory.reply(config, msgs[2], 200, "OK", append([]string{
+        "Contact: <sip:carol@3.3.3.3:5060>",
+    }, testSdp("30000")...)...)
+    // The offer from carol is passed to alice
+    msgs = tfactory.expect(t, config, "NOTIFY sip:bob@2.2.2.2", "INVITE sip:alice@1.1.1.1")
+    expectSipfrag(t, msgs[0], "SIP/2.0 200 OK")
+    if msgs[1].GetBody() == nil {
+        t.Fatal("The offer from the transfer target has not been passed on")
+    }
+    tfactory.reply(config, msgs[1], 200, "OK", append([]string{
+        "Contact: <sip:alice@1.1.1.1:5060>",
+    }, testSdp("10002")...)...)
+    tfactory.reply(config, msgs[0], 200, "OK")
+    msgs = tfactory.expect(t, config, "ACK sip:alice@1.1.1.1", "ACK sip:carol@3.3.3.3", "BYE sip:bob@2.2.2.2")
+    if msgs[1].GetBody() == nil {
+        t.Fatal("The answer from alice has not been passed on")
+    }
+    tfactory.reply(config, msgs[2], 200, "OK")
+
+    call.cc.lock.Lock()
+    if call.cc.uaO == uaO || call.cc.uaO.GetState() != sippy_types.UA_STATE_CONNECTED || call.cc.replacing {
+        t.Fatal("The transferor's leg has not been replaced")
+    }
+    if call.cc.acctO == acctO || call.cc.cld != "carol" {
+        t.Fatal("The accounting has not been taken over by the new leg")
+    }
+    if uaO.GetDiscCb() != nil || uaO.GetDeadCb() != nil {
+        t.Fatal("The callbacks have been left on the transferor's leg")
+    }
+    call.cc.lock.Unlock()
+
+    // Both legs are accounted once the call ends
+    tfactory.feed(call.fromAlice("BYE"))
+    msgs = tfactory.expect(t, config, "SIP/2.0 200 ", "BYE sip:carol@3.3.3.3")
+    tfactory.reply(config, msgs[1], 200, "OK")
+    recs := expectAcct(t, work_ch, "Stop") + expectAcct(t, work_ch, "Stop")
+    if ! strings.Contains(recs, "transfer-from:bob") {
+        t.Fatal("The record of the transfer target's leg has no transfer-from:bob")
+    }
+}
+
+func TestBlindTransferByCaller(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    config.Handle_transfer = true
+    work_ch := testAcct(cmap)
+    call := newTestCall(t, cmap, tfactory, "xfer-3")
+    call.cc.lock.Lock()
+    uaA, acctA := call.cc.uaA, call.cc.acctA
+    call.cc.lock.Unlock()
+
+    tfactory.feed(call.fromAlice("BYE", "Also: <sip:carol@example.com>"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 200 ", "INVITE sip:bob@2.2.2.2")
+    if rec := expectAcct(t, work_ch, "Stop"); ! strings.Contains(rec, "transfer-to:carol") {
+        t.Fatal("The transferor's record has no transfer-to:carol")
+    }
+    tfactory.reply(config, msgs[1], 200, "OK", append([]string{
+        "Contact: <sip:carol@3.3.3.3:5060>",
+    }, testSdp("30000")...)...)
+    reinvite := tfactory.expect(t, config, "INVITE sip:bob@2.2.2.2")[0]
+    if reinvite.GetCallId().CallId != call.o_invite.GetCallId().CallId {
+        t.Fatal("The offer has not been sent to bob")
+    }
+    tfactory.reply(config, reinvite, 200, "OK", append([]string{
+        "Contact: <sip:bob@2.2.2.2:5060>",
+    }, testSdp("20002")...)...)
+    tfactory.expect(t, config, "ACK sip:bob@2.2.2.2", "ACK sip:carol@3.3.3.3")
+
+    call.cc.lock.Lock()
+    defer call.cc.lock.Unlock()
+    if call.cc.uaA == uaA || call.cc.uaA.GetState() != sippy_types.UA_STATE_CONNECTED || call.cc.replacing {
+        t.Fatal("The transferor's leg has not been replaced")
+    }
+    if call.cc.acctA == Accounting(acctA) || call.cc.cli != "carol" {
+        t.Fatal("The accounting has not been taken over by the new leg")
+    }
+}
+
+func TestTransferNotHandled(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    work_ch := testAcct(cmap)
+    call := newTestCall(t, cmap, tfactory, "xfer-4")
+    // Neither Handle_transfer is set nor the dialog to be replaced is ours
+    tfactory.feed(call.fromAlice("BYE", "Also: <sip:carol@example.com?Replaces=unknown%3Bto-tag%3D1%3Bfrom-tag%3D2>"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 200 ", "REFER sip:bob@2.2.2.2")
+    if rec := expectAcct(t, work_ch, "Stop"); strings.Contains(rec, "transfer-to:") {
+        t.Fatal("The call has been accounted as transferred")
+    }
+    tfactory.reply(config, msgs[1], 202, "Accepted")
+    tfactory.expect(t, config, "BYE sip:bob@2.2.2.2")
+}
+
+func TestTransferReplacesBusy(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    call1 := newTestCall(t, cmap, tfactory, "xfer-5")
+    call2 := newTestCall(t, cmap, tfactory, "xfer-6")
+    call1.cc.lock.Lock()
+    replaces, _, _ := call1.cc.peerReplaces(call1.cc.uaA)
+    // The dialog with bob of the first call is ours but the call is busy
+    tfactory.feed(call2.fromBob("REFER", "Refer-To: <sip:alice@example.com?Replaces=" + url.QueryEscape(replaces.StringBody()) + ">"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 202 ", "NOTIFY sip:bob@2.2.2.2", "NOTIFY sip:bob@2.2.2.2")
+    call1.cc.lock.Unlock()
+    expectSipfrag(t, msgs[1], "SIP/2.0 100 Trying")
+    expectSipfrag(t, msgs[2], "SIP/2.0 491 Request Pending")
+    tfactory.reply(config, msgs[1], 200, "OK")
+    tfactory.reply(config, msgs[2], 200, "OK")
+    tfactory.expect(t, config, "BYE sip:bob@2.2.2.2", "BYE sip:alice@1.1.1.1")
+    call1.cc.lock.Lock()
+    defer call1.cc.lock.Unlock()
+    if call1.cc.uaN != nil || call1.cc.uaO.GetState() != sippy_types.UA_STATE_CONNECTED {
+        t.Fatal("The busy call has been changed")
+    }
+}
+
+func TestTransferReplacesBusyAlso(t *testing.T) {
+    cmap, tfactory := newTestCallMap(t)
+    config := cmap.global_config
+    call1 := newTestCall(t, cmap, tfactory, "xfer-5")
+    call2 := newTestCall(t, cmap, tfactory, "xfer-6")
+    call1.cc.lock.Lock()
+    replaces, _, _ := call1.cc.peerReplaces(call1.cc.uaA)
+    // The dialog with bob of the first call is ours but the call is busy
+    tfactory.feed(call2.fromAlice("BYE", "Also: <sip:bob@example.com?Replaces=" + url.QueryEscape(replaces.StringBody()) + ">"))
+    msgs := tfactory.expect(t, config, "SIP/2.0 200 ", "BYE sip:bob@2.2.2.2")
+    call1.cc.lock.Unlock()
+    if msgs[1].GetCallId().CallId != call2.o_invite.GetCallId().CallId {
+        t.Fatal("The transfer has not been failed")
+    }
+    tfactory.reply(config, msgs[1], 200, "OK")
+    call1.cc.lock.Lock()
+    defer call1.cc.lock.Unlock()
+    if call1.cc.replacing || call1.cc.uaO.GetState() != sippy_types.UA_STATE_CONNECTED {
+        t.Fatal("The busy call has been changed")
+    }
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy"
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+type test_sip_transport_factory struct {
+    recv_cb     sippy_net.DataPacketReceiver
+    laddress    *sippy_net.HostPort
+    data_ch     chan []byte
+}
+
+func newTestSipTransportFactory() *test_sip_transport_factory {
+    return &test_sip_transport_factory{
+        laddress    : sippy_net.NewHostPort("0.0.0.0", "5060"),
+        data_ch     : make(chan []byte, 100),
+    }
+}
+
+func (self *test_sip_transport_factory) NewSipTransport(addr *sippy_net.HostPort, recv_cb sippy_net.DataPacketReceiver) (sippy_net.Transport, error) {
+    self.recv_cb = recv_cb
+    return self, nil
+}
+
+func (self *test_sip_transport_factory) GetLAddress() *sippy_net.HostPort {
+    return self.laddress
+}
+
+func (self *test_sip_transport_factory) SendTo(data []byte, dest *sippy_net.HostPort) {
+    self.data_ch <- data
+}
+
+func (self *test_sip_transport_factory) SendToWithCb(data []byte, dest *sippy_net.HostPort, cb func()) {
+    self.SendTo(data, dest)
+    if cb != nil {
+        cb()
+    }
+}
+
+func (self *test_sip_transport_factory) Shutdown() {
+}
+
+func (self *test_sip_transport_factory) feed(inp []string) {
+    self.feedFrom(inp, "1.1.1.1")
+}
+
+func (self *test_sip_transport_factory) feedFrom(inp []string, host string) {
+    s := strings.Join(inp, "\r\n")
+    rtime, _ := sippy_time.NewMonoTime()
+    self.recv_cb([]byte(s), sippy_net.NewHostPort(host, "5060"), self, rtime)
+}
+
+func (self *test_sip_transport_factory) get(t *testing.T) string {
+    select {
+    case data := <-self.data_ch:
+        return string(data)
+    case <-time.After(2 * time.Second):
+        t.Fatal("Timeout waiting for the SIP message")
+    }
+    return ""
+}
+
+type test_null_sip_logger struct {
+}
+
+func (self *test_null_sip_logger) Write(rtime *sippy_time.MonoTime, call_id string, msg string) {
+}
+
+// newTestCallMap creates the CallMap with the SIP stack running over
+// the test transport. The authentication is disabled.
+func newTestCallMap(t *testing.T) (*CallMap, *test_sip_transport_factory) {
+    global_config := NewMyConfigParser()
+    global_config.Config = sippy_conf.NewConfig(sippy_log.NewErrorLogger(), &test_null_sip_logger{})
+    global_config.SetSipAddress(global_config.GetMyAddress())
+    global_config.SetSipPort(global_config.GetMyPort())
+    global_config.Auth_enable = false
+    tfactory := newTestSipTransportFactory()
+    global_config.SetSipTransportFactory(tfactory)
+    cmap := &CallMap{
+        global_config   : global_config,
+        ccmap           : make(map[int64]*callController),
+        blacklist       : NewTargetBlacklist(0, 0),
+    }
+    sip_tm, err := sippy.NewSipTransactionManager(global_config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    cmap.Sip_tm = sip_tm
+    go sip_tm.Run()
+    t.Cleanup(sip_tm.Shutdown)
+    return cmap, tfactory
+}
+
+// expect reads the next len(sls) messages, these may come in any order.
+// The messages are returned parsed in the order of the start lines
+// expected.
+func (self *test_sip_transport_factory) expect(t *testing.T, config sippy_conf.Config, sls ...string) []sippy_types.SipMsg {
+    datas := make([]string, len(sls))
+    for range sls {
+        data := self.get(t)
+        i := 0
+        for ; i < len(sls); i++ {
+            if datas[i] == "" && strings.HasPrefix(data, sls[i]) {
+                break
+            }
+        }
+        if i == len(sls) {
+            t.Fatalf("Unexpected %q while expecting %q", strings.SplitN(data, "\r\n", 2)[0], sls)
+        }
+        datas[i] = data
+    }
+    // The messages are parsed once all of them have been received, the
+    // B2BUA may be running on a timer goroutine until the last one is sent
+    msgs := make([]sippy_types.SipMsg, len(sls))
+    for i, data := range datas {
+        rtime, _ := sippy_time.NewMonoTime()
+        var err error
+        if strings.HasPrefix(data, "SIP/2.0 ") {
+            msgs[i], err = sippy.ParseSipResponse([]byte(data), rtime, config)
+        } else {
+            msgs[i], err = sippy.ParseSipRequest([]byte(data), rtime, config)
+        }
+        if err != nil {
+            t.Fatal("Cannot parse SIP message: " + err.Error())
+        }
+    }
+    return msgs
+}
+
+// reply feeds the response to the request received from the B2BUA.
+// The To tag is added unless there is one.
+func (self *test_sip_transport_factory) reply(config sippy_conf.Config, msg sippy_types.SipMsg, scode int, reason string, hfs ...string) {
+    resp := msg.(sippy_types.SipRequest).GenResponse(scode, reason, nil, nil)
+    if to, err := resp.GetTo().GetBody(config); err == nil && to.GetTag() == "" {
+        to.SetTag(TEST_PEER_TAG)
+    }
+    lines := strings.Split(resp.LocalStr(nil, false), "\r\n")
+    // drop Content-Length and the empty lines
+    lines = append(lines[:len(lines) - 3], hfs...)
+    if ! strings.HasPrefix(lines[len(lines) - 1], "v=") {
+        lines = append(lines, "Content-Length: 0", "", "")
+    }
+    self.feed(lines)
+}
+
+func testSdp(port string) []string {
+    sdp := strings.Join([]string{
+        "v=0",
+        "o=user1 53655765 2353687637 IN IP4 1.1.1.1",
+        "s=-",
+        "c=IN IP4 1.1.1.1",
+        "t=0 0",
+        "m=audio " + port + " RTP/AVP 0",
+        "a=rtpmap:0 PCMU/8000",
+        "",
+    }, "\r\n")
+    return []string{
+        "Content-Type: application/sdp",
+        "Content-Length: " + strconv.Itoa(len(sdp)),
+        "",
+        sdp,
+    }
+}
+
+const TEST_PEER_TAG = "5f4e3d"
+
+// test_call is the call from alice to bob established through the
+// B2BUA with newTestCall().
+type test_call struct {
+    t           *testing.T
+    cmap        *CallMap
+    tfactory    *test_sip_transport_factory
+    cc          *callController
+    call_id     string
+    a_to        string
+    o_invite    sippy_types.SipRequest
+    cseq        int
+}
+
+// newTestCall sets the static route to bob and places the call through
+// it.
+func newTestCall(t *testing.T, cmap *CallMap, tfactory *test_sip_transport_factory, call_id string) *test_call {
+    var err error
+
+    // The routes without the credit time are not used
+    cmap.global_config.Max_credit_time = 3600
+    cmap.static_route, err = NewB2BRoute("bob@2.2.2.2", cmap.global_config)
+    if err != nil {
+        t.Fatal("Cannot create the static route: " + err.Error())
+    }
+    self := &test_call{ t : t, cmap : cmap, tfactory : tfactory, call_id : call_id, cseq : 1 }
+    tfactory.feed(append([]string{
+        "INVITE sip:bob@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK" + call_id,
+        "Max-Forwards: 70",
+        "From: <sip:alice@example.com>;tag=" + call_id + "a",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:alice@1.1.1.1:5060>",
+        "Call-ID: " + call_id,
+        "CSeq: 1 INVITE",
+    }, testSdp("10000")...))
+    msgs := tfactory.expect(t, cmap.global_config, "SIP/2.0 100 ", "INVITE sip:bob@2.2.2.2")
+    self.o_invite = msgs[1].(sippy_types.SipRequest)
+    tfactory.reply(cmap.global_config, self.o_invite, 200, "OK", append([]string{
+        "Contact: <sip:bob@2.2.2.2:5060>",
+    }, testSdp("20000")...)...)
+    self.a_to = tfactory.expect(t, cmap.global_config, "SIP/2.0 200 ")[0].GetTo().String()
+    // The ACK to bob waits for the one from alice
+    tfactory.feed(self.fromAlice("ACK"))
+    tfactory.expect(t, cmap.global_config, "ACK sip:bob@2.2.2.2")
+    cmap.ccmap_lock.Lock()
+    for _, cc := range cmap.ccmap {
+        if cc.cId != nil && cc.cId.CallId == call_id {
+            self.cc = cc
+        }
+    }
+    cmap.ccmap_lock.Unlock()
+    if self.cc == nil {
+        t.Fatal("The call has not been found")
+    }
+    self.cc.lock.Lock()
+    defer self.cc.lock.Unlock()
+    if self.cc.state != CCStateConnected {
+        t.Fatal("The call has not been connected")
+    }
+    return self
+}
+
+func (self *test_call) inDialog(method, from, to, call_id string, hfs []string) []string {
+    self.cseq++
+    cseq := strconv.Itoa(self.cseq)
+    if method == "ACK" {
+        cseq = "1"
+    }
+    return append(append([]string{
+        method + " sip:b2bua@127.0.0.1 SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK" + call_id + method + strconv.Itoa(self.cseq),
+        "Max-Forwards: 70",
+        from,
+        to,
+        "Call-ID: " + call_id,
+        "CSeq: " + cseq + " " + method,
+    }, hfs...), "Content-Length: 0", "", "")
+}
+
+// fromAlice builds the request within the dialog of the caller.
+func (self *test_call) fromAlice(method string, hfs ...string) []string {
+    return self.inDialog(method, "From: <sip:alice@example.com>;tag=" + self.call_id + "a", self.a_to, self.call_id,
+      append([]string{ "Contact: <sip:alice@1.1.1.1:5060>" }, hfs...))
+}
+
+// fromBob builds the request within the dialog of the callee.
+func (self *test_call) fromBob(method string, hfs ...string) []string {
+    from := "From: " + strings.SplitN(self.o_invite.GetTo().String(), ": ", 2)[1] + ";tag=" + TEST_PEER_TAG
+    to := "To: " + strings.SplitN(self.o_invite.GetFrom().String(), ": ", 2)[1]
+    return self.inDialog(method, from, to, self.o_invite.GetCallId().CallId,
+      append([]string{ "Contact: <sip:bob@2.2.2.2:5060>" }, hfs...))
+}
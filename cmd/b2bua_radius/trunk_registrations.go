@@ -0,0 +1,126 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy"
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+const DEFAULT_TRUNK_EXPIRES = 3600
+
+type trunkRegistration struct {
+    aor         *sippy_header.SipURL
+    user        string
+    passw       string
+    target      *sippy_net.HostPort
+    expires     int
+    agent       *sippy.SipRegistrationAgent
+}
+
+// Parses the comma-separated list of the trunk registrations in the
+// format "user[:password]@registrar[:port][/expires]".
+func parseTrunkRegistrations(s string, config sippy_conf.Config) ([]*trunkRegistration, error) {
+    rval := []*trunkRegistration{}
+    for _, entry := range strings.Split(s, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        expires := DEFAULT_TRUNK_EXPIRES
+        if idx := strings.LastIndex(entry, "/"); idx >= 0 {
+            var err error
+            expires, err = strconv.Atoi(entry[idx + 1:])
+            if err != nil || expires <= 0 {
+                return nil, errors.New("invalid expires in the trunk registration: " + entry)
+            }
+            entry = entry[:idx]
+        }
+        url, err := sippy_header.ParseSipURL("sip:" + entry, false, config)
+        if err != nil || url.Username == "" {
+            return nil, errors.New("invalid trunk registration: " + entry)
+        }
+        port := url.Port
+        if port == nil {
+            port = config.DefaultPort()
+        }
+        reg := &trunkRegistration{
+            user        : url.Username,
+            passw       : url.Password,
+            target      : sippy_net.NewHostPort(url.Host.String(), port.String()),
+            expires     : expires,
+        }
+        url.Password = ""
+        reg.aor = url
+        rval = append(rval, reg)
+    }
+    return rval, nil
+}
+
+func (self *trunkRegistration) start(config sippy_conf.Config, sip_tm sippy_types.SipTransactionManager) {
+    aor := self.aor.GetCopy()
+    passw := sippy_types.NullString{ String : self.passw, Valid : self.passw != "" }
+    user := sippy_types.NullString{ String : self.user, Valid : passw.Valid }
+    rfail_cb := func(reason string) {
+        config.ErrorLogger().Error("Registration of " + self.name() + " has failed: " + reason)
+    }
+    self.agent = sippy.NewSipRegistrationAgent(config, sip_tm, aor, /*contact_url*/ nil, user, passw, /*rok_cb*/ nil, rfail_cb, self.target, self.expires)
+    self.agent.Lock.Lock()
+    self.agent.DoRegister()
+    self.agent.Lock.Unlock()
+}
+
+func (self *trunkRegistration) name() string {
+    return self.aor.Username + "@" + self.target.String()
+}
+
+func (self *trunkRegistration) String() string {
+    status := self.agent.GetStatus()
+    now := time.Now()
+    res := fmt.Sprintf("%s: %s", self.name(), status.State.String())
+    if ! status.Expires.IsZero() && status.Expires.After(now) {
+        res += fmt.Sprintf(", expires in %ds", int(status.Expires.Sub(now).Seconds()))
+    }
+    if status.Failures > 0 {
+        res += fmt.Sprintf(", failures %d", status.Failures)
+    }
+    if ! status.NextAttempt.IsZero() && status.NextAttempt.After(now) {
+        res += fmt.Sprintf(", next attempt in %ds", int(status.NextAttempt.Sub(now).Seconds()))
+    }
+    if status.LastError != "" {
+        res += ", last error \"" + status.LastError + "\""
+    }
+    return res
+}
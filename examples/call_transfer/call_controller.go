@@ -45,6 +45,7 @@ type callController struct {
     cmap            *CallMap
     evTry           *sippy.CCEventTry
     transfer_is_in_progress bool
+    refer_sub       *sippy.ReferSubscription
 }
 
 func NewCallController(cmap *CallMap) *callController {
@@ -70,6 +71,10 @@ func (self *callController) handle_transfer(event sippy_types.CCEvent, ua sippy_
         }
         self.uaO.RecvEvent(event)
     case self.uaO:
+        if self.refer_sub != nil {
+            // Let the transferor know how the transfer goes.
+            self.refer_sub.RecvEvent(event)
+        }
         if _, ok := event.(*sippy.CCEventPreConnect); ok {
             //
             // Convert into CCEventUpdate.
@@ -89,6 +94,11 @@ func (self *callController) handle_transfer(event sippy_types.CCEvent, ua sippy_
 }
 
 func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.UA) {
+    if ua != self.uaA && ua != self.uaO {
+        // The transferor is kept until it has been notified of the
+        // outcome, nothing it sends matters any longer.
+        return
+    }
     if self.transfer_is_in_progress {
         self.handle_transfer(event, ua)
         return
@@ -126,6 +136,7 @@ func (self *callController) RecvEvent(event sippy_types.CCEvent, ua sippy_types.
                     self.evTry.GetCLI(), cld, nil /*body*/, nil /*auth*/, self.evTry.GetCallerName(),
                     ev_disc.GetRtime(), self.evTry.GetOrigin())
                 self.transfer_is_in_progress = true
+                self.refer_sub = ev_disc.GetReferSubscription()
                 self.uaO.RecvEvent(ev_try)
                 return
             }
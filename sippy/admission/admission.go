@@ -0,0 +1,359 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_admission
+
+import (
+    "bytes"
+    "net"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+type Verdict int
+
+const (
+    ADMIT   = Verdict(iota)
+    REJECT  // reply with 503 Service Unavailable
+    DROP    // discard silently
+)
+
+// The admission filter is consulted by the transaction manager for
+// every message received before the message is parsed. The method is
+// the first token of the request line or an empty string for the
+// responses.
+type Filter interface {
+    Admit(method string, address *sippy_net.HostPort, data []byte) Verdict
+}
+
+type TokenBucket struct {
+    rate    float64
+    burst   float64
+    tokens  float64
+    last    time.Time
+}
+
+func NewTokenBucket(rate, burst float64, now time.Time) *TokenBucket {
+    if burst < 1 {
+        burst = 1
+    }
+    return &TokenBucket{
+        rate    : rate,
+        burst   : burst,
+        tokens  : burst,
+        last    : now,
+    }
+}
+
+func (self *TokenBucket) refill(now time.Time) {
+    if now.After(self.last) {
+        self.tokens += now.Sub(self.last).Seconds() * self.rate
+        if self.tokens > self.burst {
+            self.tokens = self.burst
+        }
+    }
+    self.last = now
+}
+
+func (self *TokenBucket) Take(now time.Time) bool {
+    self.refill(now)
+    if self.tokens < 1 {
+        return false
+    }
+    self.tokens -= 1
+    return true
+}
+
+// The bucket is full and can be forgotten without any effect on the
+// rate limiting.
+func (self *TokenBucket) Idle(now time.Time) bool {
+    self.refill(now)
+    return self.tokens >= self.burst
+}
+
+type RateLimiterStats struct {
+    Admitted    uint64
+    Allowed     uint64 // admitted by the allow list
+    Rejected    uint64
+    Dropped     uint64
+    Limited     map[string]uint64 // limited messages per method, "*" for the per source limit
+    Sources     int
+}
+
+type rateLimit struct {
+    rate    float64
+    burst   float64
+}
+
+type rateLimiterSource struct {
+    all     *TokenBucket
+    methods map[string]*TokenBucket
+}
+
+// RateLimiter is the admission filter that limits the message rate
+// from each source IP address using the token buckets. The limit on all
+// messages applies to every message from the source while the per
+// method limits apply only to the out-of-dialog requests, the requests
+// within the existing dialogs and transactions (ACK, CANCEL) are exempt
+// from them.
+type RateLimiter struct {
+    lock        sync.Mutex
+    action      Verdict
+    source_limit *rateLimit
+    method_limits map[string]*rateLimit
+    allow       []*net.IPNet
+    sources     map[string]*rateLimiterSource
+    max_sources int
+    overflow    *rateLimiterSource
+    last_purge  time.Time
+    stats       RateLimiterStats
+}
+
+const (
+    RATE_LIMITER_PURGE_IVAL = 32 * time.Second
+    // When the number of the tracked sources reaches the limit the new
+    // sources share a single set of buckets.
+    RATE_LIMITER_MAX_SOURCES = 65536
+)
+
+func NewRateLimiter(action Verdict) *RateLimiter {
+    return &RateLimiter{
+        action      : action,
+        method_limits : make(map[string]*rateLimit),
+        sources     : make(map[string]*rateLimiterSource),
+        max_sources : RATE_LIMITER_MAX_SOURCES,
+        last_purge  : time.Now(),
+        stats       : RateLimiterStats{ Limited : make(map[string]uint64) },
+    }
+}
+
+// Limit the rate of all messages from a single source. The zero rate
+// removes the limit.
+func (self *RateLimiter) SetSourceLimit(rate, burst float64) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if rate <= 0 {
+        self.source_limit = nil
+    } else {
+        self.source_limit = &rateLimit{ rate : rate, burst : burst }
+    }
+    self.sources = make(map[string]*rateLimiterSource)
+    self.overflow = nil
+}
+
+// Limit the rate of the requests with the given method from a single
+// source. The zero rate removes the limit.
+func (self *RateLimiter) SetMethodLimit(method string, rate, burst float64) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if rate <= 0 {
+        delete(self.method_limits, method)
+    } else {
+        self.method_limits[method] = &rateLimit{ rate : rate, burst : burst }
+    }
+    self.sources = make(map[string]*rateLimiterSource)
+    self.overflow = nil
+}
+
+// The messages from the allowed networks bypass all limits.
+func (self *RateLimiter) AddAllowed(network *net.IPNet) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.allow = append(self.allow, network)
+}
+
+func (self *RateLimiter) isAllowed(host string) bool {
+    if len(self.allow) == 0 {
+        return false
+    }
+    ip := net.ParseIP(host)
+    if ip == nil {
+        return false
+    }
+    for _, network := range self.allow {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+func (self *RateLimiter) newSource(now time.Time) *rateLimiterSource {
+    src := &rateLimiterSource{ methods : make(map[string]*TokenBucket) }
+    if self.source_limit != nil {
+        src.all = NewTokenBucket(self.source_limit.rate, self.source_limit.burst, now)
+    }
+    return src
+}
+
+func (self *RateLimiter) getSource(host string, now time.Time) *rateLimiterSource {
+    if src, ok := self.sources[host]; ok {
+        return src
+    }
+    if len(self.sources) >= self.max_sources {
+        self.purge(now)
+    }
+    if len(self.sources) >= self.max_sources {
+        if self.overflow == nil {
+            self.overflow = self.newSource(now)
+        }
+        return self.overflow
+    }
+    src := self.newSource(now)
+    self.sources[host] = src
+    return src
+}
+
+func (self *RateLimiter) Admit(method string, address *sippy_net.HostPort, data []byte) Verdict {
+    now := time.Now()
+    host := address.Host.String()
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if self.isAllowed(host) {
+        self.stats.Allowed++
+        return ADMIT
+    }
+    if now.Sub(self.last_purge) > RATE_LIMITER_PURGE_IVAL {
+        self.purge(now)
+    }
+    src := self.getSource(host, now)
+    limited := ""
+    if src.all != nil && ! src.all.Take(now) {
+        limited = "*"
+    } else if lim, ok := self.method_limits[method]; ok && method != "ACK" && method != "CANCEL" && ! InDialog(data) {
+        bucket, ok := src.methods[method]
+        if ! ok {
+            bucket = NewTokenBucket(lim.rate, lim.burst, now)
+            src.methods[method] = bucket
+        }
+        if ! bucket.Take(now) {
+            limited = method
+        }
+    }
+    if limited == "" {
+        self.stats.Admitted++
+        return ADMIT
+    }
+    self.stats.Limited[limited]++
+    if self.action == REJECT {
+        self.stats.Rejected++
+        return REJECT
+    }
+    self.stats.Dropped++
+    return DROP
+}
+
+func (self *RateLimiter) purge(now time.Time) {
+    for host, src := range self.sources {
+        if src.all != nil && ! src.all.Idle(now) {
+            continue
+        }
+        idle := true
+        for _, bucket := range src.methods {
+            if ! bucket.Idle(now) {
+                idle = false
+                break
+            }
+        }
+        if idle {
+            delete(self.sources, host)
+        }
+    }
+    if self.overflow != nil && len(self.sources) < self.max_sources {
+        self.overflow = nil
+    }
+    self.last_purge = now
+}
+
+func (self *RateLimiter) GetStats() *RateLimiterStats {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    stats := self.stats
+    stats.Limited = make(map[string]uint64, len(self.stats.Limited))
+    for method, cnt := range self.stats.Limited {
+        stats.Limited[method] = cnt
+    }
+    stats.Sources = len(self.sources)
+    return &stats
+}
+
+// InDialog tells if the request has the tag in the To header, i.e. it
+// belongs to an existing dialog, without parsing the message.
+func InDialog(data []byte) bool {
+    for len(data) > 0 {
+        var line []byte
+        if i := bytes.IndexByte(data, '\n'); i >= 0 {
+            line, data = data[:i], data[i + 1:]
+        } else {
+            line, data = data, nil
+        }
+        line = bytes.TrimRight(line, "\r")
+        if len(line) == 0 {
+            // the end of the headers
+            break
+        }
+        i := bytes.IndexByte(line, ':')
+        if i < 0 {
+            continue
+        }
+        name := string(bytes.TrimSpace(line[:i]))
+        if ! strings.EqualFold(name, "To") && ! strings.EqualFold(name, "t") {
+            continue
+        }
+        value := line[i + 1:]
+        // The tag parameter follows the name-addr
+        if j := bytes.LastIndexByte(value, '>'); j >= 0 {
+            value = value[j + 1:]
+        }
+        value = bytes.ToLower(bytes.Map(func(r rune) rune {
+            if r == ' ' || r == '\t' {
+                return -1
+            }
+            return r
+        }, value))
+        return bytes.Contains(value, []byte(";tag="))
+    }
+    return false
+}
+
+// Extract the method from the request line without parsing the
+// message, returns an empty string for the responses.
+func GetMethod(data []byte) string {
+    if len(data) >= 7 && string(data[:7]) == "SIP/2.0" {
+        return ""
+    }
+    for i, c := range data {
+        if c == ' ' || c == '\r' || c == '\n' {
+            return string(data[:i])
+        }
+        if i >= 32 {
+            break
+        }
+    }
+    return ""
+}
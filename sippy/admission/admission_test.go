@@ -0,0 +1,121 @@
+package sippy_admission
+
+import (
+    "net"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+func Test_TokenBucket(t *testing.T) {
+    now := time.Now()
+    b := NewTokenBucket(10, 2, now)
+    if ! b.Take(now) || ! b.Take(now) {
+        t.Fatal("the burst has not been admitted")
+    }
+    if b.Take(now) {
+        t.Fatal("the bucket is expected to be empty")
+    }
+    if ! b.Take(now.Add(100 * time.Millisecond)) {
+        t.Fatal("the bucket has not been refilled")
+    }
+}
+
+func Test_RateLimiter(t *testing.T) {
+    rl := NewRateLimiter(REJECT)
+    rl.SetMethodLimit("INVITE", 1, 1)
+    _, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+    rl.AddAllowed(allowed)
+    src := sippy_net.NewHostPort("1.2.3.4", "5060")
+    if v := rl.Admit("INVITE", src, nil); v != ADMIT {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    if v := rl.Admit("INVITE", src, nil); v != REJECT {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    if v := rl.Admit("OPTIONS", src, nil); v != ADMIT {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    if v := rl.Admit("INVITE", sippy_net.NewHostPort("1.2.3.5", "5060"), nil); v != ADMIT {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    trusted := sippy_net.NewHostPort("10.1.1.1", "5060")
+    for i := 0; i < 10; i++ {
+        if v := rl.Admit("INVITE", trusted, nil); v != ADMIT {
+            t.Fatalf("unexpected verdict %d", v)
+        }
+    }
+    stats := rl.GetStats()
+    if stats.Admitted != 3 || stats.Allowed != 10 || stats.Rejected != 1 || stats.Limited["INVITE"] != 1 || stats.Sources != 2 {
+        t.Fatalf("unexpected stats %+v", stats)
+    }
+    if m := GetMethod([]byte("REGISTER sip:example.com SIP/2.0\r\n")); m != "REGISTER" {
+        t.Fatalf("unexpected method %s", m)
+    }
+    if m := GetMethod([]byte("SIP/2.0 200 OK\r\n")); m != "" {
+        t.Fatalf("unexpected method %s", m)
+    }
+}
+
+func Test_RateLimiterInDialog(t *testing.T) {
+    rl := NewRateLimiter(DROP)
+    rl.SetMethodLimit("INVITE", 1, 1)
+    src := sippy_net.NewHostPort("1.2.3.4", "5060")
+    invite := []byte("INVITE sip:bob@example.com SIP/2.0\r\nt: <sip:bob@example.com>\r\n\r\n")
+    reinvite := []byte("INVITE sip:bob@example.com SIP/2.0\r\nTo: <sip:bob@example.com> ; tag=abc\r\n\r\n")
+    if v := rl.Admit("INVITE", src, invite); v != ADMIT {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    if v := rl.Admit("INVITE", src, invite); v != DROP {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    // The per method limits do not apply within the dialogs
+    for _, msg := range []struct{ method string; data []byte }{
+            { "INVITE", reinvite },
+            { "ACK", nil },
+            { "CANCEL", nil },
+        } {
+        if v := rl.Admit(msg.method, src, msg.data); v != ADMIT {
+            t.Fatalf("%s: the message within the dialog has been limited", msg.method)
+        }
+    }
+}
+
+func Test_RateLimiterSource(t *testing.T) {
+    rl := NewRateLimiter(DROP)
+    rl.SetSourceLimit(1, 1)
+    rl.max_sources = 2
+    src := sippy_net.NewHostPort("1.2.3.4", "5060")
+    invite := []byte("INVITE sip:bob@example.com SIP/2.0\r\nt: <sip:bob@example.com>\r\n\r\n")
+    // Neither the To tag nor the method lets the flood past the limit
+    // on all messages
+    tagged := []byte("INVITE sip:bob@example.com SIP/2.0\r\nTo: <sip:bob@example.com>;tag=x\r\n\r\n")
+    if v := rl.Admit("INVITE", src, tagged); v != ADMIT {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    for _, msg := range []struct{ method string; data []byte }{
+            { "INVITE", tagged },
+            { "ACK", nil },
+            { "", []byte("SIP/2.0 200 OK\r\n\r\n") },
+        } {
+        if v := rl.Admit(msg.method, src, msg.data); v != DROP {
+            t.Fatalf("%s: the message over the source limit has been admitted", msg.method)
+        }
+    }
+    if stats := rl.GetStats(); stats.Limited["*"] != 3 {
+        t.Fatalf("unexpected stats %+v", stats)
+    }
+    // The sources above the limit share the buckets
+    for _, host := range []string{ "1.2.3.5", "1.2.3.6" } {
+        if v := rl.Admit("INVITE", sippy_net.NewHostPort(host, "5060"), invite); v != ADMIT {
+            t.Fatalf("%s: unexpected verdict %d", host, v)
+        }
+    }
+    if v := rl.Admit("INVITE", sippy_net.NewHostPort("1.2.3.7", "5060"), invite); v != DROP {
+        t.Fatalf("unexpected verdict %d", v)
+    }
+    if stats := rl.GetStats(); stats.Sources != 2 {
+        t.Fatalf("unexpected stats %+v", stats)
+    }
+}
@@ -33,6 +33,7 @@ import (
     "github.com/sippy/go-b2bua/sippy/headers"
     "github.com/sippy/go-b2bua/sippy/log"
     "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
 )
 
 type sip_transaction_state int
@@ -53,6 +54,7 @@ func (self sip_transaction_state) String() string {
     case COMPLETED:     return "COMPLETED"
     case CONFIRMED:     return "CONFIRMED"
     case TERMINATED:    return "TERMINATED"
+    case UACK:          return "UACK"
     default:            return "UNKNOWN"
     }
 }
@@ -69,6 +71,7 @@ type baseTransaction struct {
     tout            time.Duration
     data            []byte
     logger          sippy_log.ErrorLogger
+    ctime           time.Time
 }
 
 func newBaseTransaction(lock sync.Locker, tid *sippy_header.TID, userv sippy_net.Transport, sip_tm *sipTransactionManager, address *sippy_net.HostPort, data []byte, needack bool) *baseTransaction {
@@ -83,9 +86,29 @@ func newBaseTransaction(lock sync.Locker, tid *sippy_header.TID, userv sippy_net
         needack : needack,
         lock    : lock,
         logger  : sip_tm.config.ErrorLogger(),
+        ctime   : time.Now(),
     }
 }
 
+// getInfo must be called with the transaction locked.
+func (self *baseTransaction) getInfo(method string, server bool) *sippy_types.TransactionInfo {
+    if self.tid == nil {
+        // already terminated
+        return nil
+    }
+    info := &sippy_types.TransactionInfo{
+        TID         : *self.tid,
+        Method      : method,
+        State       : self.state.String(),
+        Server      : server,
+        Age         : time.Since(self.ctime),
+    }
+    if self.address != nil {
+        info.RAddress = self.address.GetCopy()
+    }
+    return info
+}
+
 func (self *baseTransaction) cleanup() {
     self.sip_tm = nil
     self.userv = nil
@@ -112,6 +135,7 @@ func (self *baseTransaction) timerA() {
     //print("timerA", t.GetTID())
     if sip_tm := self.sip_tm; sip_tm != nil {
         sip_tm.transmitData(self.userv, self.data, self.address, /*cachesum*/ "", /*call_id*/ self.tid.CallId, 0)
+        sip_tm.countRetransmit()
         self.tout *= 2
         self.teA = StartTimeout(self.timerA, self.lock, self.tout, 1, self.logger)
     }
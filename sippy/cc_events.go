@@ -248,6 +248,31 @@ func (self *CCEventUpdate) GetBody() sippy_types.MsgBody {
     return self.body
 }
 
+// CCEventUpdateAnswer reports the outcome of the offer/answer exchange
+// started by the CCEventUpdate in the early dialog, i.e. the response to
+// the UPDATE (RFC 3311). The CCEventConnect and CCEventFail cannot be used
+// here since in the early dialog they relate to the INVITE itself.
+type CCEventUpdateAnswer struct {
+    CCEventGeneric
+    scode           int
+    scode_reason    string
+    body            sippy_types.MsgBody
+}
+
+func NewCCEventUpdateAnswer(scode int, scode_reason string, body sippy_types.MsgBody, rtime *sippy_time.MonoTime, origin string, extra_headers ...sippy_header.SipHeader) *CCEventUpdateAnswer {
+    return &CCEventUpdateAnswer{
+        CCEventGeneric  : newCCEventGeneric(rtime, origin, extra_headers...),
+        scode           : scode,
+        scode_reason    : scode_reason,
+        body            : body,
+    }
+}
+
+func (self *CCEventUpdateAnswer) String() string { return "CCEventUpdateAnswer" }
+func (self *CCEventUpdateAnswer) GetScode() int { return self.scode }
+func (self *CCEventUpdateAnswer) GetScodeReason() string { return self.scode_reason }
+func (self *CCEventUpdateAnswer) GetBody() sippy_types.MsgBody { return self.body }
+
 type CCEventInfo struct {
     CCEventGeneric
     body    sippy_types.MsgBody
@@ -269,6 +294,7 @@ func (self *CCEventInfo) GetBody() sippy_types.MsgBody {
 type CCEventDisconnect struct {
     CCEventGeneric
     redirect_url *sippy_header.SipAddress
+    refer_sub    *ReferSubscription
 }
 
 func NewCCEventDisconnect(also *sippy_header.SipAddress, rtime *sippy_time.MonoTime, origin string, extra_headers ...sippy_header.SipHeader) *CCEventDisconnect {
@@ -284,6 +310,15 @@ func (self *CCEventDisconnect) GetRedirectURL() *sippy_header.SipAddress {
     return self.redirect_url
 }
 
+// GetReferSubscription returns the implicit subscription of the REFER
+// the event has been created for or nil if there is none. If the event
+// is passed on to another UA in the Connected state the final response
+// to the REFER or BYE with Also sent there is reported to the
+// transferor, both UAs must share the same session lock then.
+func (self *CCEventDisconnect) GetReferSubscription() *ReferSubscription {
+    return self.refer_sub
+}
+
 func (*CCEventDisconnect) GetBody() sippy_types.MsgBody {
     return nil
 }
@@ -294,6 +329,7 @@ type CCEventFail struct {
     scode           int
     scode_reason    string
     warning         *sippy_header.SipWarning
+    retry_after     sippy_header.SipHeader
 }
 
 func NewCCEventFail(scode int, scode_reason string, rtime *sippy_time.MonoTime, origin string, extra_headers ...sippy_header.SipHeader) *CCEventFail {
@@ -309,6 +345,8 @@ func (self *CCEventFail) String() string { return "CCEventFail" }
 func (self *CCEventFail) GetScode() int { return self.scode }
 func (self *CCEventFail) SetScode(scode int) { self.scode = scode }
 func (self *CCEventFail) GetScodeReason() string { return self.scode_reason }
+func (self *CCEventFail) GetRetryAfter() sippy_header.SipHeader { return self.retry_after }
+func (self *CCEventFail) SetRetryAfter(hf sippy_header.SipHeader) { self.retry_after = hf }
 func (self *CCEventFail) SetScodeReason(reason string) { self.scode_reason = reason }
 
 func (self *CCEventFail) GetExtraHeaders() []sippy_header.SipHeader {
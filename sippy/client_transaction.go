@@ -60,6 +60,10 @@ type clientTransaction struct {
     on_send_complete func()
     seen_rseqs      map[sippy_header.RTID]bool
     last_rseq       int
+    udp_userv       sippy_net.Transport
+    udp_data        []byte
+    laddress        *sippy_net.HostPort
+    auto_userv      bool
 }
 
 func NewClientTransactionObj(req sippy_types.SipRequest, tid *sippy_header.TID,
@@ -110,12 +114,57 @@ func NewClientTransactionObj(req sippy_types.SipRequest, tid *sippy_header.TID,
     return self, nil
 }
 
+// Re-render the request after it has been modified between the
+// transaction creation and the start.
+func (self *clientTransaction) updateRequest(req sippy_types.SipRequest) {
+    if self.udp_userv != nil {
+        if via0, err := req.GetVias()[0].GetBody(); err == nil {
+            via0.SetTransport("UDP")
+            self.udp_data = []byte(req.LocalStr(self.udp_userv.GetLAddress(), false /* compact */))
+            via0.SetTransport(sippy_net.GetTransportProto(self.userv))
+        }
+    }
+    self.data = []byte(req.LocalStr(self.userv.GetLAddress(), false /* compact */))
+}
+
+// retarget points the transaction that has not been started yet to
+// the address obtained by the RFC 3263 lookup. The transport is
+// changed as well when the lookup has selected another one.
+func (self *clientTransaction) retarget(req sippy_types.SipRequest, address *sippy_net.HostPort) {
+    self.address = address
+    for _, r := range []sippy_types.SipRequest{ req, self.ack, self.cancel } {
+        if r != nil {
+            r.SetTarget(address)
+        }
+    }
+    if ! self.auto_userv {
+        return
+    }
+    userv := self.sip_tm.selectTransport(req, self.laddress)
+    if userv == nil || userv == self.userv || userv == self.udp_userv {
+        return
+    }
+    self.userv, self.udp_userv, self.udp_data = userv, nil, nil
+    for _, r := range []sippy_types.SipRequest{ req, self.ack, self.cancel } {
+        if r == nil {
+            continue
+        }
+        if via0, err := r.GetVias()[0].GetBody(); err == nil {
+            via0.SetTransport(sippy_net.GetTransportProto(userv))
+        }
+    }
+    self.data = []byte(req.LocalStr(userv.GetLAddress(), false /* compact */))
+}
+
 func (self *clientTransaction) SetOnSendComplete(fn func()) {
     self.on_send_complete = fn
 }
 
 func (self *clientTransaction) StartTimers() {
-    self.startTeA()
+    if ! sippy_net.IsReliable(self.userv) {
+        // No retransmissions over the reliable transports (RFC 3261 17.1.1.2)
+        self.startTeA()
+    }
     self.startTeB(32 * time.Second)
 }
 
@@ -159,6 +208,15 @@ func (self *clientTransaction) timerB() {
     }
 }
 
+// Terminate the transaction without sending the request and deliver
+// the response to the receiver in the same way as the timeout.
+func (self *clientTransaction) failLocally(resp sippy_types.SipResponse) {
+    if self.resp_receiver != nil {
+        self.r408 = resp
+    }
+    self.startTeB(0)
+}
+
 func (self *clientTransaction) timerC() {
     if sip_tm := self.sip_tm; sip_tm != nil {
         sip_tm.tclient_del(self.tid)
@@ -356,7 +414,8 @@ func (self *clientTransaction) process_final_response(checksum string, resp sipp
 
 func (self *clientTransaction) Cancel(extra_headers ...sippy_header.SipHeader) {
     sip_tm := self.sip_tm
-    if sip_tm == nil {
+    if sip_tm == nil || self.cancel == nil {
+        // only the INVITE transaction can be cancelled
         return
     }
     // If we got at least one provisional reply then (state == RINGING)
@@ -411,8 +470,36 @@ func (self *clientTransaction) BeforeRequestSent(req sippy_types.SipRequest) {
 
 func (self *clientTransaction) TransmitData() {
     if sip_tm := self.sip_tm; sip_tm != nil {
-        sip_tm.transmitDataWithCb(self.userv, self.data, self.address, /*cachesum*/ "", /*call_id =*/ self.tid.CallId, 0, self.on_send_complete)
+        var on_error func()
+        if self.udp_userv != nil {
+            on_error = func() { go self.fallbackToUdp() }
+        }
+        sip_tm.transmitDataWithErrCb(self.userv, self.data, self.address, /*cachesum*/ "", /*call_id =*/ self.tid.CallId, 0, self.on_send_complete, on_error)
+    }
+}
+
+// fallbackToUdp re-sends the request that has been automatically
+// switched to TCP over the UDP when the TCP connection fails.
+func (self *clientTransaction) fallbackToUdp() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    sip_tm := self.sip_tm
+    if sip_tm == nil || self.udp_userv == nil || self.state != TRYING {
+        return
+    }
+    self.logger.Error("Cannot deliver request to " + self.address.String() + " over TCP, falling back to UDP")
+    self.userv, self.data = self.udp_userv, self.udp_data
+    self.udp_userv, self.udp_data = nil, nil
+    for _, req := range []sippy_types.SipRequest{ self.ack, self.cancel } {
+        if req == nil {
+            continue
+        }
+        if via0, err := req.GetVias()[0].GetBody(); err == nil {
+            via0.SetTransport("UDP")
+        }
     }
+    self.startTeA()
+    sip_tm.transmitDataWithCb(self.userv, self.data, self.address, /*cachesum*/ "", /*call_id =*/ self.tid.CallId, 0, self.on_send_complete)
 }
 
 func (self *clientTransaction) SetAckRparams(rAddr *sippy_net.HostPort, rTarget *sippy_header.SipURL, routes []*sippy_header.SipRoute) {
@@ -437,3 +524,22 @@ func (self *clientTransaction) SetTxnHeaders(hdrs []sippy_header.SipHeader) {
 func (self *clientTransaction) GetReqExtraHeaders() []sippy_header.SipHeader {
     return self.req_extra_hdrs
 }
+
+// GetFlow returns the flow the request has been sent over.
+func (self *clientTransaction) GetFlow() *sippy_net.Flow {
+    if self.userv == nil {
+        return nil
+    }
+    return sippy_net.NewFlow(self.userv, self.address)
+}
+
+func (self *clientTransaction) getInfo() *sippy_types.TransactionInfo {
+    if self.lock != nil {
+        self.lock.Lock()
+        defer self.lock.Unlock()
+    }
+    if self.tid == nil {
+        return nil
+    }
+    return self.baseTransaction.getInfo(self.tid.CSeqMethod, false)
+}
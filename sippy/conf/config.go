@@ -31,7 +31,10 @@ package sippy_conf
 import (
     "net"
     "os"
+    "sort"
+    "strings"
 
+    "github.com/sippy/go-b2bua/sippy/admission"
     "github.com/sippy/go-b2bua/sippy/log"
     "github.com/sippy/go-b2bua/sippy/net"
 )
@@ -59,6 +62,40 @@ type Config interface {
     SetAutoConvertTelUrl(bool)
     GetSipTransportFactory() sippy_net.SipTransportFactory
     SetSipTransportFactory(sippy_net.SipTransportFactory)
+    GetSipTransportFactoryByProto(string) sippy_net.SipTransportFactory
+    SetSipTransportFactoryByProto(string, sippy_net.SipTransportFactory)
+    GetSipTransportProtos() []string
+
+    GetTlsPort() *sippy_net.MyPort
+    SetTlsPort(*sippy_net.MyPort)
+    GetTlsCertFile() string
+    SetTlsCertFile(string)
+    GetTlsKeyFile() string
+    SetTlsKeyFile(string)
+    GetTlsCAFile() string
+    SetTlsCAFile(string)
+    GetTlsVerifyClient() bool
+    SetTlsVerifyClient(bool)
+
+    GetAutoTcpSwitch() bool
+    SetAutoTcpSwitch(bool)
+    GetTcpSwitchThreshold() int
+    SetTcpSwitchThreshold(int)
+
+    GetResolver() *sippy_net.Resolver
+    SetResolver(*sippy_net.Resolver)
+
+    GetHepCollector() *sippy_net.HostPort
+    SetHepCollector(*sippy_net.HostPort)
+    GetHepProto() string
+    SetHepProto(string)
+    GetHepAgentId() uint32
+    SetHepAgentId(uint32)
+    GetHepPassword() string
+    SetHepPassword(string)
+
+    GetAdmissionFilter() sippy_admission.Filter
+    SetAdmissionFilter(sippy_admission.Filter)
 }
 
 type config struct {
@@ -75,6 +112,20 @@ type config struct {
     allow_formats   []int
     autoconvert_tel_url bool
     tfactory        sippy_net.SipTransportFactory
+    tfactories      map[string]sippy_net.SipTransportFactory
+    tls_port        *sippy_net.MyPort
+    tls_cert_file   string
+    tls_key_file    string
+    tls_ca_file     string
+    tls_verify_client bool
+    auto_tcp_switch bool
+    tcp_switch_threshold int
+    resolver        *sippy_net.Resolver
+    hep_collector   *sippy_net.HostPort
+    hep_proto       string
+    hep_agent_id    uint32
+    hep_password    string
+    admission_filter sippy_admission.Filter
 }
 
 func NewConfig(error_logger sippy_log.ErrorLogger, sip_logger sippy_log.SipLogger) Config {
@@ -94,6 +145,11 @@ func NewConfig(error_logger sippy_log.ErrorLogger, sip_logger sippy_log.SipLogge
         allow_formats : make([]int, 0),
         autoconvert_tel_url : false,
         default_port    : sippy_net.NewSystemPort("5060"),
+        tfactories      : make(map[string]sippy_net.SipTransportFactory),
+        tls_port        : sippy_net.NewSystemPort("5061"),
+        auto_tcp_switch : true,
+        tcp_switch_threshold : 1300,
+        hep_proto       : "UDP",
     }
 }
 
@@ -182,3 +238,153 @@ func (self *config) SetSipTransportFactory(tfactory sippy_net.SipTransportFactor
 func (self *config) DefaultPort() *sippy_net.MyPort {
     return self.default_port
 }
+
+// The UDP transport factory is the one set by SetSipTransportFactory(),
+// the factories for the other protocols (TCP, TLS etc) are optional.
+func (self *config) GetSipTransportFactoryByProto(proto string) sippy_net.SipTransportFactory {
+    proto = strings.ToUpper(proto)
+    if proto == "UDP" {
+        return self.tfactory
+    }
+    return self.tfactories[proto]
+}
+
+func (self *config) SetSipTransportFactoryByProto(proto string, tfactory sippy_net.SipTransportFactory) {
+    proto = strings.ToUpper(proto)
+    if proto == "UDP" {
+        self.tfactory = tfactory
+    } else if tfactory == nil {
+        delete(self.tfactories, proto)
+    } else {
+        self.tfactories[proto] = tfactory
+    }
+}
+
+func (self *config) GetSipTransportProtos() []string {
+    protos := make([]string, 0, len(self.tfactories))
+    for proto := range self.tfactories {
+        protos = append(protos, proto)
+    }
+    sort.Strings(protos)
+    return append([]string{ "UDP" }, protos...)
+}
+
+func (self *config) GetTlsPort() *sippy_net.MyPort {
+    return self.tls_port
+}
+
+func (self *config) SetTlsPort(port *sippy_net.MyPort) {
+    self.tls_port = port
+}
+
+func (self *config) GetTlsCertFile() string {
+    return self.tls_cert_file
+}
+
+func (self *config) SetTlsCertFile(fname string) {
+    self.tls_cert_file = fname
+}
+
+func (self *config) GetTlsKeyFile() string {
+    return self.tls_key_file
+}
+
+func (self *config) SetTlsKeyFile(fname string) {
+    self.tls_key_file = fname
+}
+
+// The CA bundle is used to verify the peer certificates. The system
+// CA pool is used when no bundle is configured.
+func (self *config) GetTlsCAFile() string {
+    return self.tls_ca_file
+}
+
+func (self *config) SetTlsCAFile(fname string) {
+    self.tls_ca_file = fname
+}
+
+// When the client verification is enabled the TLS server requires the
+// clients to present a valid certificate (mutual TLS). The certificates
+// of the servers are verified regardless of this setting.
+func (self *config) GetTlsVerifyClient() bool {
+    return self.tls_verify_client
+}
+
+func (self *config) SetTlsVerifyClient(v bool) {
+    self.tls_verify_client = v
+}
+
+// When the auto TCP switch is enabled the requests larger than the
+// threshold are sent over TCP instead of UDP (RFC 3261 section 18.1.1).
+// It only has effect when the TCP transport factory is configured.
+func (self *config) GetAutoTcpSwitch() bool {
+    return self.auto_tcp_switch
+}
+
+func (self *config) SetAutoTcpSwitch(v bool) {
+    self.auto_tcp_switch = v
+}
+
+func (self *config) GetTcpSwitchThreshold() int {
+    return self.tcp_switch_threshold
+}
+
+func (self *config) SetTcpSwitchThreshold(v int) {
+    self.tcp_switch_threshold = v
+}
+
+// When the resolver is set the SIP URIs are resolved using the RFC 3263
+// procedures (NAPTR, SRV, A/AAAA), otherwise only the A/AAAA lookup is
+// done when the message is sent.
+func (self *config) GetResolver() *sippy_net.Resolver {
+    return self.resolver
+}
+
+func (self *config) SetResolver(resolver *sippy_net.Resolver) {
+    self.resolver = resolver
+}
+
+// When the HEP collector is set every SIP message sent or received by
+// the transaction manager is copied to it in the HEPv3 format.
+func (self *config) GetHepCollector() *sippy_net.HostPort {
+    return self.hep_collector
+}
+
+func (self *config) SetHepCollector(address *sippy_net.HostPort) {
+    self.hep_collector = address
+}
+
+// The transport used to reach the HEP collector, "UDP" or "TCP".
+func (self *config) GetHepProto() string {
+    return self.hep_proto
+}
+
+func (self *config) SetHepProto(proto string) {
+    self.hep_proto = proto
+}
+
+func (self *config) GetHepAgentId() uint32 {
+    return self.hep_agent_id
+}
+
+func (self *config) SetHepAgentId(id uint32) {
+    self.hep_agent_id = id
+}
+
+func (self *config) GetHepPassword() string {
+    return self.hep_password
+}
+
+func (self *config) SetHepPassword(password string) {
+    self.hep_password = password
+}
+
+// When the admission filter is set it is consulted for every message
+// received before the message is parsed.
+func (self *config) GetAdmissionFilter() sippy_admission.Filter {
+    return self.admission_filter
+}
+
+func (self *config) SetAdmissionFilter(filter sippy_admission.Filter) {
+    self.admission_filter = filter
+}
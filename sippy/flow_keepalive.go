@@ -0,0 +1,155 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "math/rand"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+const (
+    FLOW_KA_UDP_INTERVAL = 29 * time.Second
+    FLOW_KA_TCP_INTERVAL = 120 * time.Second
+    FLOW_KA_PONG_TIMEOUT = 10 * time.Second
+)
+
+// FlowKeepalive keeps the flow (NAT binding or connection) open outside
+// of any dialog by sending the CRLFCRLF pings and expecting the CRLF
+// pongs back (RFC 5626 section 4.4.1). When the pong does not arrive in
+// time the flow is considered failed and the failure callback is called.
+type FlowKeepalive struct {
+    flow        *sippy_net.Flow
+    sip_tm      sippy_types.SipTransactionManager
+    interval    time.Duration
+    on_failure  func()
+    logger      sippy_log.ErrorLogger
+    lock        sync.Mutex
+    ping_timer  *Timeout
+    pong_timer  *Timeout
+    running     bool
+}
+
+// NewFlowKeepalive creates the keep-alive for the flow. The interval is
+// the Flow-Timer value received from the registrar, zero selects the
+// default for the transport.
+func NewFlowKeepalive(sip_tm sippy_types.SipTransactionManager, flow *sippy_net.Flow, interval time.Duration, on_failure func(), logger sippy_log.ErrorLogger) *FlowKeepalive {
+    if interval <= 0 {
+        if flow.IsReliable() {
+            interval = FLOW_KA_TCP_INTERVAL
+        } else {
+            interval = FLOW_KA_UDP_INTERVAL
+        }
+    }
+    return &FlowKeepalive{
+        flow        : flow,
+        sip_tm      : sip_tm,
+        interval    : interval,
+        on_failure  : on_failure,
+        logger      : logger,
+    }
+}
+
+func (self *FlowKeepalive) GetFlow() *sippy_net.Flow {
+    return self.flow
+}
+
+func (self *FlowKeepalive) Start() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if self.running {
+        return
+    }
+    self.running = true
+    self.sip_tm.RegFlowConsumer(self.flow, self.pong)
+    self.schedule_ping()
+}
+
+func (self *FlowKeepalive) Stop() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if ! self.running {
+        return
+    }
+    self.running = false
+    self.sip_tm.UnregFlowConsumer(self.flow)
+    self.cancel_timers()
+}
+
+func (self *FlowKeepalive) cancel_timers() {
+    if self.ping_timer != nil {
+        self.ping_timer.Cancel()
+        self.ping_timer = nil
+    }
+    if self.pong_timer != nil {
+        self.pong_timer.Cancel()
+        self.pong_timer = nil
+    }
+}
+
+// schedule_ping picks the random interval between 80% and 100% of the
+// configured one to avoid synchronization of the keep-alives.
+func (self *FlowKeepalive) schedule_ping() {
+    ival := time.Duration(float64(self.interval) * (0.8 + 0.2 * rand.Float64()))
+    self.ping_timer = StartTimeout(self.ping, &self.lock, ival, 1, self.logger)
+}
+
+func (self *FlowKeepalive) ping() {
+    if ! self.running {
+        return
+    }
+    self.ping_timer = nil
+    self.flow.Transport.SendTo([]byte("\r\n\r\n"), self.flow.RAddress)
+    self.pong_timer = StartTimeout(self.pong_timeout, &self.lock, FLOW_KA_PONG_TIMEOUT, 1, self.logger)
+}
+
+func (self *FlowKeepalive) pong() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if ! self.running || self.pong_timer == nil {
+        return
+    }
+    self.pong_timer.Cancel()
+    self.pong_timer = nil
+    self.schedule_ping()
+}
+
+func (self *FlowKeepalive) pong_timeout() {
+    if ! self.running {
+        return
+    }
+    self.pong_timer = nil
+    self.running = false
+    self.sip_tm.UnregFlowConsumer(self.flow)
+    self.logger.Debugf("Flow %s has failed, no keep-alive response in %s", self.flow.String(), FLOW_KA_PONG_TIMEOUT)
+    if self.on_failure != nil {
+        go self.on_failure()
+    }
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "strings"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+type SipAllowEvents struct {
+    compactName
+    tagListHF
+}
+
+var _sip_allow_events_name compactName = newCompactName("Allow-Events", "u")
+
+func CreateSipAllowEvents(body string) []SipHeader {
+    return []SipHeader{
+        &SipAllowEvents{
+            compactName : _sip_allow_events_name,
+            tagListHF   : *createTagListHF(body),
+        },
+    }
+}
+
+func NewSipAllowEvents(packages ...string) *SipAllowEvents {
+    return CreateSipAllowEvents(strings.Join(packages, ", "))[0].(*SipAllowEvents)
+}
+
+func (self *SipAllowEvents) GetCopyAsIface() SipHeader {
+    return self.GetCopy()
+}
+
+func (self *SipAllowEvents) GetCopy() *SipAllowEvents {
+    tmp := *self
+    tmp.tagListHF = *self.tagListHF.getCopy()
+    return &tmp
+}
+
+func (self *SipAllowEvents) LocalStr(hostport *sippy_net.HostPort, compact bool) string {
+    if compact {
+        return self.CompactName() + ": " + self.StringBody()
+    }
+    return self.String()
+}
+
+func (self *SipAllowEvents) String() string {
+    return self.Name() + ": " + self.StringBody()
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "errors"
+    "strings"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+type SipEventBody struct {
+    Package     string
+    Id          string
+    otherparams []string
+}
+
+func (self *SipEventBody) String() string {
+    rval := self.Package
+    if self.Id != "" {
+        rval += ";id=" + self.Id
+    }
+    for _, param := range self.otherparams {
+        rval += ";" + param
+    }
+    return rval
+}
+
+func (self *SipEventBody) GetCopy() *SipEventBody {
+    rval := *self
+    rval.otherparams = make([]string, len(self.otherparams))
+    copy(rval.otherparams, self.otherparams)
+    return &rval
+}
+
+// Matches compares the event packages and ids as required to match the
+// NOTIFY to the subscription (RFC 6665 section 8.2.1).
+func (self *SipEventBody) Matches(other *SipEventBody) bool {
+    return strings.EqualFold(self.Package, other.Package) && self.Id == other.Id
+}
+
+type SipEvent struct {
+    compactName
+    string_body     string
+    body            *SipEventBody
+}
+
+var _sip_event_name compactName = newCompactName("Event", "o")
+
+func CreateSipEvent(body string) []SipHeader {
+    return []SipHeader{
+        &SipEvent{
+            compactName     : _sip_event_name,
+            string_body     : body,
+        },
+    }
+}
+
+func NewSipEvent(pkg, id string) *SipEvent {
+    return &SipEvent{
+        compactName : _sip_event_name,
+        body        : &SipEventBody{
+            Package     : pkg,
+            Id          : id,
+            otherparams : []string{},
+        },
+    }
+}
+
+func (self *SipEvent) parse() error {
+    params := strings.Split(self.string_body, ";")
+    body := &SipEventBody{
+        Package     : strings.TrimSpace(params[0]),
+        otherparams : []string{},
+    }
+    if body.Package == "" {
+        return errors.New("Error parsing Event: empty event package")
+    }
+    for _, param := range params[1:] {
+        param = strings.TrimSpace(param)
+        kv := strings.SplitN(param, "=", 2)
+        if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "id" {
+            body.Id = strings.TrimSpace(kv[1])
+        } else if param != "" {
+            body.otherparams = append(body.otherparams, param)
+        }
+    }
+    self.body = body
+    return nil
+}
+
+func (self *SipEvent) GetBody() (*SipEventBody, error) {
+    if self.body == nil {
+        if err := self.parse(); err != nil {
+            return nil, err
+        }
+    }
+    return self.body, nil
+}
+
+func (self *SipEvent) StringBody() string {
+    if self.body != nil {
+        return self.body.String()
+    }
+    return self.string_body
+}
+
+func (self *SipEvent) String() string {
+    return self.LocalStr(nil, false)
+}
+
+func (self *SipEvent) LocalStr(hostport *sippy_net.HostPort, compact bool) string {
+    if compact {
+        return self.CompactName() + ": " + self.StringBody()
+    }
+    return self.Name() + ": " + self.StringBody()
+}
+
+func (self *SipEvent) GetCopy() *SipEvent {
+    tmp := *self
+    if self.body != nil {
+        tmp.body = self.body.GetCopy()
+    }
+    return &tmp
+}
+
+func (self *SipEvent) GetCopyAsIface() SipHeader {
+    return self.GetCopy()
+}
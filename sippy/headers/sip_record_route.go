@@ -37,6 +37,13 @@ type SipRecordRoute struct {
 
 var _sip_record_route_name normalName = newNormalName("Record-Route")
 
+func NewSipRecordRoute(addr *SipAddress) *SipRecordRoute {
+    return &SipRecordRoute{
+        normalName   : _sip_record_route_name,
+        sipAddressHF : newSipAddressHF(addr),
+    }
+}
+
 func CreateSipRecordRoute(body string) []SipHeader {
     addresses := CreateSipAddressHFs(body)
     rval := make([]SipHeader, len(addresses))
@@ -27,54 +27,81 @@
 package sippy_header
 
 import (
+    "errors"
     "strings"
 
     "github.com/sippy/go-b2bua/sippy/net"
 )
 
-type sipReplacesBody struct {
-    call_id     string
-    from_tag    string
-    to_tag      string
-    early_only  bool
+type SipReplacesBody struct {
+    CallId      string
+    FromTag     string
+    ToTag       string
+    EarlyOnly   bool
     otherparams string
 }
 
 type SipReplaces struct {
     normalName
     string_body     string
-    body            *sipReplacesBody
+    body            *SipReplacesBody
 }
 
 var _sip_replaces_name normalName = newNormalName("Replaces")
 
+func NewSipReplaces(call_id, from_tag, to_tag string, early_only bool) *SipReplaces {
+    return &SipReplaces{
+        normalName  : _sip_replaces_name,
+        body        : &SipReplacesBody{
+            CallId      : call_id,
+            FromTag     : from_tag,
+            ToTag       : to_tag,
+            EarlyOnly   : early_only,
+        },
+    }
+}
+
 func CreateSipReplaces(body string) []SipHeader {
     return []SipHeader{
         &SipReplaces{
-            normalName : _sip_replaces_name,
+            normalName  : _sip_replaces_name,
+            string_body : body,
         },
     }
 }
 
-func (self *SipReplaces) parse() {
+func (self *SipReplaces) parse() error {
     params := strings.Split(self.string_body, ";")
-    body := &sipReplacesBody{
-        call_id     : params[0],
+    body := &SipReplacesBody{
+        CallId      : strings.TrimSpace(params[0]),
     }
     for _, param := range params[1:] {
-        kv := strings.SplitN(param, "=", 2)
-        switch kv[0] {
+        kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+        switch strings.ToLower(kv[0]) {
         case "from-tag":
-            if len(kv) == 2 { body.from_tag = kv[1] }
+            if len(kv) == 2 { body.FromTag = kv[1] }
         case "to-tag":
-            if len(kv) == 2 { body.to_tag = kv[1] }
+            if len(kv) == 2 { body.ToTag = kv[1] }
         case "early-only":
-            body.early_only = true
+            body.EarlyOnly = true
         default:
             body.otherparams += ";" + param
         }
     }
+    if body.CallId == "" || body.FromTag == "" || body.ToTag == "" {
+        return errors.New("Malformed Replaces field")
+    }
     self.body = body
+    return nil
+}
+
+func (self *SipReplaces) GetBody() (*SipReplacesBody, error) {
+    if self.body == nil {
+        if err := self.parse(); err != nil {
+            return nil, err
+        }
+    }
+    return self.body, nil
 }
 
 func (self *SipReplaces) StringBody() string {
@@ -84,9 +111,9 @@ func (self *SipReplaces) StringBody() string {
     return self.string_body
 }
 
-func (self *sipReplacesBody) String() string {
-    res := self.call_id + ";from-tag=" + self.from_tag + ";to-tag=" + self.to_tag
-    if self.early_only {
+func (self *SipReplacesBody) String() string {
+    res := self.CallId + ";from-tag=" + self.FromTag + ";to-tag=" + self.ToTag
+    if self.EarlyOnly {
         res += ";early-only"
     }
     return res + self.otherparams
@@ -102,6 +129,10 @@ func (self *SipReplaces) LocalStr(hostport *sippy_net.HostPort, compact bool) st
 
 func (self *SipReplaces) GetCopy() *SipReplaces {
     tmp := *self
+    if self.body != nil {
+        body := *self.body
+        tmp.body = &body
+    }
     return &tmp
 }
 
@@ -0,0 +1,169 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+type SipRetryAfterBody struct {
+    Delta       int
+    Comment     string
+    Duration    int
+    otherparams []string
+}
+
+func (self *SipRetryAfterBody) String() string {
+    rval := strconv.Itoa(self.Delta)
+    if self.Comment != "" {
+        rval += " (" + self.Comment + ")"
+    }
+    if self.Duration >= 0 {
+        rval += ";duration=" + strconv.Itoa(self.Duration)
+    }
+    for _, param := range self.otherparams {
+        rval += ";" + param
+    }
+    return rval
+}
+
+func (self *SipRetryAfterBody) GetCopy() *SipRetryAfterBody {
+    rval := *self
+    rval.otherparams = make([]string, len(self.otherparams))
+    copy(rval.otherparams, self.otherparams)
+    return &rval
+}
+
+type SipRetryAfter struct {
+    normalName
+    string_body     string
+    body            *SipRetryAfterBody
+}
+
+var _sip_retry_after_name normalName = newNormalName("Retry-After")
+
+func CreateSipRetryAfter(body string) []SipHeader {
+    return []SipHeader{
+        &SipRetryAfter{
+            normalName      : _sip_retry_after_name,
+            string_body     : body,
+        },
+    }
+}
+
+func NewSipRetryAfter(delta int) *SipRetryAfter {
+    return &SipRetryAfter{
+        normalName  : _sip_retry_after_name,
+        body        : &SipRetryAfterBody{
+            Delta       : delta,
+            Duration    : -1,
+            otherparams : []string{},
+        },
+    }
+}
+
+// Retry-After = delta-seconds [ comment ] *( SEMI retry-param )
+func (self *SipRetryAfter) parse() error {
+    s := strings.TrimSpace(self.string_body)
+    i := 0
+    for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+        i++
+    }
+    delta, err := strconv.Atoi(s[:i])
+    if err != nil {
+        return errors.New("Error parsing Retry-After: " + err.Error())
+    }
+    body := &SipRetryAfterBody{
+        Delta       : delta,
+        Duration    : -1,
+        otherparams : []string{},
+    }
+    s = strings.TrimSpace(s[i:])
+    if strings.HasPrefix(s, "(") {
+        j := strings.IndexByte(s, ')')
+        if j < 0 {
+            return errors.New("Error parsing Retry-After: unterminated comment")
+        }
+        body.Comment = strings.TrimSpace(s[1:j])
+        s = strings.TrimSpace(s[j + 1:])
+    }
+    if s != "" && s[0] != ';' {
+        return errors.New("Error parsing Retry-After: garbage after delta-seconds")
+    }
+    for _, param := range strings.Split(s, ";")[1:] {
+        param = strings.TrimSpace(param)
+        kv := strings.SplitN(param, "=", 2)
+        if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "duration" {
+            if body.Duration, err = strconv.Atoi(strings.TrimSpace(kv[1])); err != nil {
+                return errors.New("Error parsing Retry-After: " + err.Error())
+            }
+        } else if param != "" {
+            body.otherparams = append(body.otherparams, param)
+        }
+    }
+    self.body = body
+    return nil
+}
+
+func (self *SipRetryAfter) GetBody() (*SipRetryAfterBody, error) {
+    if self.body == nil {
+        if err := self.parse(); err != nil {
+            return nil, err
+        }
+    }
+    return self.body, nil
+}
+
+func (self *SipRetryAfter) StringBody() string {
+    if self.body != nil {
+        return self.body.String()
+    }
+    return self.string_body
+}
+
+func (self *SipRetryAfter) String() string {
+    return self.LocalStr(nil, false)
+}
+
+func (self *SipRetryAfter) LocalStr(hostport *sippy_net.HostPort, compact bool) string {
+    return self.Name() + ": " + self.StringBody()
+}
+
+func (self *SipRetryAfter) GetCopy() *SipRetryAfter {
+    tmp := *self
+    if self.body != nil {
+        tmp.body = self.body.GetCopy()
+    }
+    return &tmp
+}
+
+func (self *SipRetryAfter) GetCopyAsIface() SipHeader {
+    return self.GetCopy()
+}
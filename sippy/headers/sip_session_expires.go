@@ -0,0 +1,157 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+const (
+    SESSION_REFRESHER_UAC = "uac"
+    SESSION_REFRESHER_UAS = "uas"
+)
+
+type SipSessionExpiresBody struct {
+    Delta       int
+    Refresher   string
+    otherparams []string
+}
+
+func (self *SipSessionExpiresBody) String() string {
+    rval := strconv.Itoa(self.Delta)
+    if self.Refresher != "" {
+        rval += ";refresher=" + self.Refresher
+    }
+    for _, param := range self.otherparams {
+        rval += ";" + param
+    }
+    return rval
+}
+
+func (self *SipSessionExpiresBody) GetCopy() *SipSessionExpiresBody {
+    rval := *self
+    rval.otherparams = make([]string, len(self.otherparams))
+    copy(rval.otherparams, self.otherparams)
+    return &rval
+}
+
+type SipSessionExpires struct {
+    compactName
+    string_body     string
+    body            *SipSessionExpiresBody
+}
+
+var _sip_session_expires_name compactName = newCompactName("Session-Expires", "x")
+
+func CreateSipSessionExpires(body string) []SipHeader {
+    return []SipHeader{
+        &SipSessionExpires{
+            compactName     : _sip_session_expires_name,
+            string_body     : body,
+        },
+    }
+}
+
+// NewSipSessionExpires creates the Session-Expires header (RFC 4028). The
+// refresher is either SESSION_REFRESHER_UAC, SESSION_REFRESHER_UAS or empty
+// to leave the choice to the UAS.
+func NewSipSessionExpires(delta int, refresher string) *SipSessionExpires {
+    return &SipSessionExpires{
+        compactName : _sip_session_expires_name,
+        body        : &SipSessionExpiresBody{
+            Delta       : delta,
+            Refresher   : refresher,
+            otherparams : []string{},
+        },
+    }
+}
+
+func (self *SipSessionExpires) parse() error {
+    var err error
+
+    params := strings.Split(self.string_body, ";")
+    body := &SipSessionExpiresBody{
+        otherparams : []string{},
+    }
+    body.Delta, err = strconv.Atoi(strings.TrimSpace(params[0]))
+    if err != nil {
+        return errors.New("Error parsing Session-Expires: " + err.Error())
+    }
+    for _, param := range params[1:] {
+        param = strings.TrimSpace(param)
+        kv := strings.SplitN(param, "=", 2)
+        if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "refresher" {
+            body.Refresher = strings.ToLower(strings.TrimSpace(kv[1]))
+        } else if param != "" {
+            body.otherparams = append(body.otherparams, param)
+        }
+    }
+    self.body = body
+    return nil
+}
+
+func (self *SipSessionExpires) GetBody() (*SipSessionExpiresBody, error) {
+    if self.body == nil {
+        if err := self.parse(); err != nil {
+            return nil, err
+        }
+    }
+    return self.body, nil
+}
+
+func (self *SipSessionExpires) StringBody() string {
+    if self.body != nil {
+        return self.body.String()
+    }
+    return self.string_body
+}
+
+func (self *SipSessionExpires) String() string {
+    return self.LocalStr(nil, false)
+}
+
+func (self *SipSessionExpires) LocalStr(hostport *sippy_net.HostPort, compact bool) string {
+    if compact {
+        return self.CompactName() + ": " + self.StringBody()
+    }
+    return self.Name() + ": " + self.StringBody()
+}
+
+func (self *SipSessionExpires) GetCopy() *SipSessionExpires {
+    tmp := *self
+    if self.body != nil {
+        tmp.body = self.body.GetCopy()
+    }
+    return &tmp
+}
+
+func (self *SipSessionExpires) GetCopyAsIface() SipHeader {
+    return self.GetCopy()
+}
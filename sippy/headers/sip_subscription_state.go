@@ -0,0 +1,182 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_header
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+const (
+    SUBSCRIPTION_STATE_ACTIVE = "active"
+    SUBSCRIPTION_STATE_PENDING = "pending"
+    SUBSCRIPTION_STATE_TERMINATED = "terminated"
+)
+
+type SipSubscriptionStateBody struct {
+    State       string
+    Expires     int
+    Reason      string
+    RetryAfter  int
+    otherparams []string
+}
+
+func (self *SipSubscriptionStateBody) String() string {
+    rval := self.State
+    if self.Reason != "" {
+        rval += ";reason=" + self.Reason
+    }
+    if self.Expires >= 0 {
+        rval += ";expires=" + strconv.Itoa(self.Expires)
+    }
+    if self.RetryAfter >= 0 {
+        rval += ";retry-after=" + strconv.Itoa(self.RetryAfter)
+    }
+    for _, param := range self.otherparams {
+        rval += ";" + param
+    }
+    return rval
+}
+
+func (self *SipSubscriptionStateBody) GetCopy() *SipSubscriptionStateBody {
+    rval := *self
+    rval.otherparams = make([]string, len(self.otherparams))
+    copy(rval.otherparams, self.otherparams)
+    return &rval
+}
+
+func (self *SipSubscriptionStateBody) IsTerminated() bool {
+    return self.State == SUBSCRIPTION_STATE_TERMINATED
+}
+
+type SipSubscriptionState struct {
+    normalName
+    string_body     string
+    body            *SipSubscriptionStateBody
+}
+
+var _sip_subscription_state_name normalName = newNormalName("Subscription-State")
+
+func CreateSipSubscriptionState(body string) []SipHeader {
+    return []SipHeader{
+        &SipSubscriptionState{
+            normalName      : _sip_subscription_state_name,
+            string_body     : body,
+        },
+    }
+}
+
+// The expires and retry_after are not included when negative.
+func NewSipSubscriptionState(state string, expires int, reason string, retry_after int) *SipSubscriptionState {
+    return &SipSubscriptionState{
+        normalName  : _sip_subscription_state_name,
+        body        : &SipSubscriptionStateBody{
+            State       : state,
+            Expires     : expires,
+            Reason      : reason,
+            RetryAfter  : retry_after,
+            otherparams : []string{},
+        },
+    }
+}
+
+func (self *SipSubscriptionState) parse() error {
+    params := strings.Split(self.string_body, ";")
+    body := &SipSubscriptionStateBody{
+        State       : strings.ToLower(strings.TrimSpace(params[0])),
+        Expires     : -1,
+        RetryAfter  : -1,
+        otherparams : []string{},
+    }
+    if body.State == "" {
+        return errors.New("Error parsing Subscription-State: empty state")
+    }
+    for _, param := range params[1:] {
+        param = strings.TrimSpace(param)
+        kv := strings.SplitN(param, "=", 2)
+        if len(kv) != 2 {
+            if param != "" {
+                body.otherparams = append(body.otherparams, param)
+            }
+            continue
+        }
+        var err error
+        switch strings.ToLower(strings.TrimSpace(kv[0])) {
+        case "expires":
+            body.Expires, err = strconv.Atoi(strings.TrimSpace(kv[1]))
+        case "retry-after":
+            body.RetryAfter, err = strconv.Atoi(strings.TrimSpace(kv[1]))
+        case "reason":
+            body.Reason = strings.ToLower(strings.TrimSpace(kv[1]))
+        default:
+            body.otherparams = append(body.otherparams, param)
+        }
+        if err != nil {
+            return errors.New("Error parsing Subscription-State: " + err.Error())
+        }
+    }
+    self.body = body
+    return nil
+}
+
+func (self *SipSubscriptionState) GetBody() (*SipSubscriptionStateBody, error) {
+    if self.body == nil {
+        if err := self.parse(); err != nil {
+            return nil, err
+        }
+    }
+    return self.body, nil
+}
+
+func (self *SipSubscriptionState) StringBody() string {
+    if self.body != nil {
+        return self.body.String()
+    }
+    return self.string_body
+}
+
+func (self *SipSubscriptionState) String() string {
+    return self.LocalStr(nil, false)
+}
+
+func (self *SipSubscriptionState) LocalStr(hostport *sippy_net.HostPort, compact bool) string {
+    return self.Name() + ": " + self.StringBody()
+}
+
+func (self *SipSubscriptionState) GetCopy() *SipSubscriptionState {
+    tmp := *self
+    if self.body != nil {
+        tmp.body = self.body.GetCopy()
+    }
+    return &tmp
+}
+
+func (self *SipSubscriptionState) GetCopyAsIface() SipHeader {
+    return self.GetCopy()
+}
@@ -358,14 +358,88 @@ func (self *SipURL) GetParams() []string {
     return ret
 }
 
+// IsOutbound tells if the URL has the "ob" parameter meaning that the
+// requests towards it have to go over the same flow (RFC 5626 section 5.4).
+func (self *SipURL) IsOutbound() bool {
+    for _, p := range self.Other {
+        if p == "ob" {
+            return true
+        }
+    }
+    return false
+}
+
 func (self *SipURL) GetCopy() *SipURL {
     ret := *self
     return &ret
 }
 
 func (self *SipURL) GetAddr(config sippy_conf.Config) *sippy_net.HostPort {
+    if self.Host.String() == "" || self.Host.ParseIP() != nil {
+        return self.getAddr(config)
+    }
+    ret := self.getAddr(config)
+    // The TLS peer is verified against the domain in the URL and not
+    // against the name of the SRV target (RFC 5922).
+    ret.ServerName = self.Host.String()
+    if resolver := config.GetResolver(); resolver != nil {
+        // Only the cached results are used here as the DNS lookup
+        // cannot be done with the session locks held.
+        return resolver.GetAddr(self.getLookup(), ret)
+    }
+    return ret
+}
+
+func (self *SipURL) getAddr(config sippy_conf.Config) *sippy_net.HostPort {
     if self.Port != nil {
         return sippy_net.NewHostPort(self.Host.String(), self.Port.String())
     }
+    if self.IsSecure() {
+        return sippy_net.NewHostPort(self.Host.String(), config.GetTlsPort().String())
+    }
     return sippy_net.NewHostPort(self.Host.String(), config.DefaultPort().String())
 }
+
+// Resolve returns the list of the next hop targets for the URL using
+// the RFC 3263 procedures.
+func (self *SipURL) Resolve(config sippy_conf.Config) ([]*sippy_net.SipTarget, error) {
+    resolver := config.GetResolver()
+    if resolver == nil {
+        resolver = sippy_net.NewResolver(nil)
+    }
+    lookup := self.getLookup()
+    return resolver.Resolve(lookup.Host, lookup.Port, lookup.Proto, lookup.Secure)
+}
+
+func (self *SipURL) getLookup() *sippy_net.SipLookup {
+    lookup := &sippy_net.SipLookup{
+        Host    : self.Host.String(),
+        Proto   : self.Transport,
+        Secure  : self.Scheme == "sips",
+    }
+    if self.Maddr != "" {
+        lookup.Host = self.Maddr
+    }
+    if self.Port != nil {
+        lookup.Port = self.Port.String()
+    }
+    return lookup
+}
+
+// IsSecure returns true when the URL demands the TLS delivery, i.e.
+// either it is sips: URL or has transport=tls parameter.
+func (self *SipURL) IsSecure() bool {
+    return self.Scheme == "sips" || strings.ToLower(self.Transport) == "tls"
+}
+
+// GetTransportProto returns the transport protocol that has to be used
+// to deliver requests to this URL.
+func (self *SipURL) GetTransportProto() string {
+    if self.IsSecure() {
+        return "TLS"
+    }
+    if self.Transport != "" {
+        return strings.ToUpper(self.Transport)
+    }
+    return "UDP"
+}
@@ -31,6 +31,7 @@ import (
     "encoding/hex"
     "errors"
     "net"
+    "strconv"
     "strings"
 
     "github.com/sippy/go-b2bua/sippy/conf"
@@ -50,6 +51,10 @@ type SipViaBody struct {
     maddr       *string
     branch      *string
     extension   *string
+    oc          *string
+    oc_algo     *string
+    oc_validity *string
+    oc_seq      *string
 
     received_exists    bool
     rport_exists       bool
@@ -57,6 +62,10 @@ type SipViaBody struct {
     maddr_exists       bool
     branch_exists      bool
     extension_exists   bool
+    oc_exists          bool
+    oc_algo_exists     bool
+    oc_validity_exists bool
+    oc_seq_exists      bool
 }
 
 type SipVia struct {
@@ -115,6 +124,18 @@ func (self *SipVia) parse() error {
         case "extension":
             via.extension = val
             via.extension_exists = true
+        case "oc":
+            via.oc = val
+            via.oc_exists = true
+        case "oc-algo":
+            via.oc_algo = val
+            via.oc_algo_exists = true
+        case "oc-validity":
+            via.oc_validity = val
+            via.oc_validity_exists = true
+        case "oc-seq":
+            via.oc_seq = val
+            via.oc_seq_exists = true
         default:
             via.extra_headers += ";" + sparam[0]
             if val != nil {
@@ -208,6 +229,10 @@ func (self *SipViaBody) localString(hostport *sippy_net.HostPort) string {
                             {"maddr", self.maddr, self.maddr_exists },
                             {"branch", self.branch, self.branch_exists },
                             {"extension", self.extension, self.extension_exists },
+                            {"oc", self.oc, self.oc_exists },
+                            {"oc-algo", self.oc_algo, self.oc_algo_exists },
+                            {"oc-validity", self.oc_validity, self.oc_validity_exists },
+                            {"oc-seq", self.oc_seq, self.oc_seq_exists },
                         } {
         if it.exists {
             s += ";" + it.key
@@ -235,6 +260,10 @@ func (self *SipViaBody) getCopy() *SipViaBody {
     if self.maddr != nil { tmp_s := *self.maddr; tmp.maddr = &tmp_s }
     if self.branch != nil { tmp_s := *self.branch; tmp.branch = &tmp_s }
     if self.extension != nil { tmp_s := *self.extension; tmp.extension = &tmp_s }
+    if self.oc != nil { tmp_s := *self.oc; tmp.oc = &tmp_s }
+    if self.oc_algo != nil { tmp_s := *self.oc_algo; tmp.oc_algo = &tmp_s }
+    if self.oc_validity != nil { tmp_s := *self.oc_validity; tmp.oc_validity = &tmp_s }
+    if self.oc_seq != nil { tmp_s := *self.oc_seq; tmp.oc_seq = &tmp_s }
     return &tmp
 }
 
@@ -259,6 +288,9 @@ func (self *SipViaBody) GetBranch() string {
 
 func (self *SipViaBody) GetAddr(config sippy_conf.Config) (string, string) {
     if self.port == nil {
+        if self.GetTransport() == "TLS" {
+            return self.host.String(), config.GetTlsPort().String()
+        }
         return self.host.String(), config.DefaultPort().String()
     } else {
         return self.host.String(), self.port.String()
@@ -294,3 +326,73 @@ func (self *SipViaBody) SetReceived(v string) {
 func (self *SipViaBody) HasRport() bool {
     return self.rport_exists
 }
+
+func (self *SipViaBody) GetTransport() string {
+    if idx := strings.LastIndex(self.sipver, "/"); idx >= 0 {
+        return strings.ToUpper(self.sipver[idx + 1:])
+    }
+    return "UDP"
+}
+
+func (self *SipViaBody) SetTransport(proto string) {
+    idx := strings.LastIndex(self.sipver, "/")
+    if idx < 0 {
+        self.sipver = "SIP/2.0/" + strings.ToUpper(proto)
+    } else {
+        self.sipver = self.sipver[:idx + 1] + strings.ToUpper(proto)
+    }
+}
+
+// RFC 7339 overload control. The client advertises the support by
+// adding the "oc" parameter without a value and the list of supported
+// algorithms to the Via of the request.
+func (self *SipViaBody) SetOCSupported(algos string) {
+    self.oc_exists = true
+    self.oc = nil
+    algos = "\"" + algos + "\""
+    self.oc_algo = &algos
+    self.oc_algo_exists = true
+}
+
+func (self *SipViaBody) HasOC() bool {
+    return self.oc_exists
+}
+
+// The server sets the overload control parameters in the topmost Via
+// of the response. The validity is in milliseconds.
+func (self *SipViaBody) SetOC(value int, algo string, validity int, seq string) {
+    oc := strconv.Itoa(value)
+    algo = "\"" + algo + "\""
+    oc_validity := strconv.Itoa(validity)
+    self.oc, self.oc_exists = &oc, true
+    self.oc_algo, self.oc_algo_exists = &algo, true
+    self.oc_validity, self.oc_validity_exists = &oc_validity, true
+    self.oc_seq, self.oc_seq_exists = &seq, true
+}
+
+// Returns the overload control parameters sent by the server. The ok
+// is false when the "oc" parameter is missing or has no value.
+func (self *SipViaBody) GetOC() (value int, algo string, validity int, seq string, ok bool) {
+    if ! self.oc_exists || self.oc == nil {
+        return
+    }
+    value, err := strconv.Atoi(*self.oc)
+    if err != nil || value < 0 || value > 100 {
+        return 0, "", 0, "", false
+    }
+    algo = "loss"
+    if self.oc_algo_exists && self.oc_algo != nil {
+        algo = strings.ToLower(strings.Trim(*self.oc_algo, "\""))
+    }
+    validity = 500
+    if self.oc_validity_exists && self.oc_validity != nil {
+        validity, err = strconv.Atoi(*self.oc_validity)
+        if err != nil || validity < 0 {
+            return 0, "", 0, "", false
+        }
+    }
+    if self.oc_seq_exists && self.oc_seq != nil {
+        seq = *self.oc_seq
+    }
+    return value, algo, validity, seq, true
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_hep
+
+import (
+    "encoding/binary"
+    "errors"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+)
+
+// HEPv3 chunk types, see the HEP3 Network Protocol Specification.
+const (
+    HEP_CHUNK_IP_FAMILY     = 0x0001
+    HEP_CHUNK_IP_PROTO      = 0x0002
+    HEP_CHUNK_IP4_SRC       = 0x0003
+    HEP_CHUNK_IP4_DST       = 0x0004
+    HEP_CHUNK_IP6_SRC       = 0x0005
+    HEP_CHUNK_IP6_DST       = 0x0006
+    HEP_CHUNK_SRC_PORT      = 0x0007
+    HEP_CHUNK_DST_PORT      = 0x0008
+    HEP_CHUNK_TS_SEC        = 0x0009
+    HEP_CHUNK_TS_USEC       = 0x000a
+    HEP_CHUNK_PROTO_TYPE    = 0x000b
+    HEP_CHUNK_AGENT_ID      = 0x000c
+    HEP_CHUNK_AUTH_KEY      = 0x000e
+    HEP_CHUNK_PAYLOAD       = 0x000f
+    HEP_CHUNK_CORRELATION   = 0x0011
+
+    HEP_PROTO_TYPE_SIP      = 0x01
+
+    HEP_QUEUE_LEN           = 1024
+    HEP_CONNECT_TIMEOUT     = 5 * time.Second
+)
+
+// HepPacket is a single captured SIP message.
+type HepPacket struct {
+    Src         *sippy_net.HostPort
+    Dst         *sippy_net.HostPort
+    Proto       string
+    Time        time.Time
+    AgentId     uint32
+    Password    string
+    CallId      string
+    Payload     []byte
+}
+
+type hepEncoder struct {
+    buf     []byte
+}
+
+func (self *hepEncoder) chunk(ctype uint16, data []byte) {
+    var hdr [6]byte
+    binary.BigEndian.PutUint16(hdr[2:4], ctype)
+    binary.BigEndian.PutUint16(hdr[4:6], uint16(len(data) + 6))
+    self.buf = append(self.buf, hdr[:]...)
+    self.buf = append(self.buf, data...)
+}
+
+func (self *hepEncoder) chunk8(ctype uint16, v uint8) {
+    self.chunk(ctype, []byte{ v })
+}
+
+func (self *hepEncoder) chunk16(ctype uint16, v uint16) {
+    var b [2]byte
+    binary.BigEndian.PutUint16(b[:], v)
+    self.chunk(ctype, b[:])
+}
+
+func (self *hepEncoder) chunk32(ctype uint16, v uint32) {
+    var b [4]byte
+    binary.BigEndian.PutUint32(b[:], v)
+    self.chunk(ctype, b[:])
+}
+
+func hepAddr(hp *sippy_net.HostPort) (net.IP, uint16) {
+    var ip net.IP
+    var port int
+
+    if hp == nil {
+        return net.IPv4zero, 0
+    }
+    if ip = hp.ParseIP(); ip == nil {
+        ip = net.IPv4zero
+    }
+    if hp.Port != nil {
+        port, _ = strconv.Atoi(hp.Port.String())
+    }
+    return ip, uint16(port)
+}
+
+// Encode serializes the packet into the HEPv3 wire format.
+func (self *HepPacket) Encode() []byte {
+    enc := &hepEncoder{ buf : []byte("HEP3\x00\x00") }
+    src_ip, src_port := hepAddr(self.Src)
+    dst_ip, dst_port := hepAddr(self.Dst)
+    if src_ip.To4() != nil && dst_ip.To4() != nil {
+        enc.chunk8(HEP_CHUNK_IP_FAMILY, 2) // AF_INET
+    } else {
+        enc.chunk8(HEP_CHUNK_IP_FAMILY, 10) // AF_INET6
+    }
+    if strings.ToUpper(self.Proto) == "UDP" {
+        enc.chunk8(HEP_CHUNK_IP_PROTO, 17)
+    } else {
+        enc.chunk8(HEP_CHUNK_IP_PROTO, 6)
+    }
+    if src_ip.To4() != nil && dst_ip.To4() != nil {
+        enc.chunk(HEP_CHUNK_IP4_SRC, src_ip.To4())
+        enc.chunk(HEP_CHUNK_IP4_DST, dst_ip.To4())
+    } else {
+        enc.chunk(HEP_CHUNK_IP6_SRC, src_ip.To16())
+        enc.chunk(HEP_CHUNK_IP6_DST, dst_ip.To16())
+    }
+    enc.chunk16(HEP_CHUNK_SRC_PORT, src_port)
+    enc.chunk16(HEP_CHUNK_DST_PORT, dst_port)
+    enc.chunk32(HEP_CHUNK_TS_SEC, uint32(self.Time.Unix()))
+    enc.chunk32(HEP_CHUNK_TS_USEC, uint32(self.Time.Nanosecond() / 1000))
+    enc.chunk8(HEP_CHUNK_PROTO_TYPE, HEP_PROTO_TYPE_SIP)
+    enc.chunk32(HEP_CHUNK_AGENT_ID, self.AgentId)
+    if self.Password != "" {
+        enc.chunk(HEP_CHUNK_AUTH_KEY, []byte(self.Password))
+    }
+    if self.CallId != "" {
+        enc.chunk(HEP_CHUNK_CORRELATION, []byte(self.CallId))
+    }
+    enc.chunk(HEP_CHUNK_PAYLOAD, self.Payload)
+    binary.BigEndian.PutUint16(enc.buf[4:6], uint16(len(enc.buf)))
+    return enc.buf
+}
+
+// HepCapture sends the copies of the SIP messages to the HEPv3 collector
+// (i.e. Homer) over UDP or TCP. The packets are queued and sent from the
+// separate goroutine, so that the capture never blocks the SIP
+// processing. The packets are dropped when the queue is full or the
+// collector is not reachable.
+type HepCapture struct {
+    collector   *sippy_net.HostPort
+    proto       string
+    agent_id    uint32
+    password    string
+    logger      sippy_log.ErrorLogger
+    queue       chan []byte
+    queue_lock  sync.Mutex
+    closed      bool
+    conn        net.Conn
+    failed      bool
+}
+
+func NewHepCapture(collector *sippy_net.HostPort, proto string, agent_id uint32, password string, logger sippy_log.ErrorLogger) (*HepCapture, error) {
+    proto = strings.ToLower(proto)
+    if proto == "" {
+        proto = "udp"
+    }
+    if proto != "udp" && proto != "tcp" {
+        return nil, errors.New("unsupported HEP collector protocol: " + proto)
+    }
+    self := &HepCapture{
+        collector   : collector,
+        proto       : proto,
+        agent_id    : agent_id,
+        password    : password,
+        logger      : logger,
+        queue       : make(chan []byte, HEP_QUEUE_LEN),
+    }
+    go self.run()
+    return self, nil
+}
+
+// Capture queues the message sent from src to dst over the proto
+// transport. The rtime is the receive time, nil means now.
+func (self *HepCapture) Capture(rtime *sippy_time.MonoTime, src, dst *sippy_net.HostPort, proto, call_id string, data []byte) {
+    pkt := &HepPacket{
+        Src         : src,
+        Dst         : dst,
+        Proto       : proto,
+        AgentId     : self.agent_id,
+        Password    : self.password,
+        CallId      : call_id,
+        Payload     : data,
+    }
+    if rtime != nil {
+        pkt.Time = rtime.Realt()
+    } else {
+        pkt.Time = time.Now()
+    }
+    buf := pkt.Encode()
+    self.queue_lock.Lock()
+    defer self.queue_lock.Unlock()
+    if self.closed {
+        return
+    }
+    select {
+    case self.queue <- buf:
+    default:
+        // the collector is too slow, drop the packet
+    }
+}
+
+func (self *HepCapture) Shutdown() {
+    self.queue_lock.Lock()
+    defer self.queue_lock.Unlock()
+    if ! self.closed {
+        self.closed = true
+        close(self.queue)
+    }
+}
+
+func (self *HepCapture) run() {
+    for data := range self.queue {
+        self.send(data)
+    }
+    if self.conn != nil {
+        self.conn.Close()
+    }
+}
+
+func (self *HepCapture) send(data []byte) {
+    var err error
+
+    if self.conn == nil {
+        self.conn, err = net.DialTimeout(self.proto, self.collector.String(), HEP_CONNECT_TIMEOUT)
+        if err != nil {
+            self.conn = nil
+            if ! self.failed {
+                self.logger.Error("Cannot connect to the HEP collector " + self.collector.String() + ": " + err.Error())
+                self.failed = true
+            }
+            return
+        }
+        self.failed = false
+    }
+    if _, err = self.conn.Write(data); err != nil {
+        self.logger.Error("Cannot send to the HEP collector " + self.collector.String() + ": " + err.Error())
+        self.conn.Close()
+        self.conn = nil
+    }
+}
@@ -0,0 +1,88 @@
+package sippy_hep
+
+import (
+    "encoding/binary"
+    "net"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+func parseChunks(t *testing.T, data []byte) map[uint16][]byte {
+    if string(data[:4]) != "HEP3" {
+        t.Fatalf("bad HEP magic: %q", data[:4])
+    }
+    if int(binary.BigEndian.Uint16(data[4:6])) != len(data) {
+        t.Fatalf("bad HEP length: %d", binary.BigEndian.Uint16(data[4:6]))
+    }
+    ret := make(map[uint16][]byte)
+    for off := 6; off < len(data); {
+        ctype := binary.BigEndian.Uint16(data[off + 2:off + 4])
+        clen := int(binary.BigEndian.Uint16(data[off + 4:off + 6]))
+        if clen < 6 || off + clen > len(data) {
+            t.Fatalf("bad chunk length %d at %d", clen, off)
+        }
+        ret[ctype] = data[off + 6:off + clen]
+        off += clen
+    }
+    return ret
+}
+
+func TestHepEncode(t *testing.T) {
+    pkt := &HepPacket{
+        Src         : sippy_net.NewHostPort("192.0.2.1", "5060"),
+        Dst         : sippy_net.NewHostPort("192.0.2.2", "5080"),
+        Proto       : "TCP",
+        Time        : time.Unix(1700000000, 123456000),
+        AgentId     : 42,
+        Password    : "secret",
+        CallId      : "abc@host",
+        Payload     : []byte("OPTIONS sip:x SIP/2.0\r\n\r\n"),
+    }
+    chunks := parseChunks(t, pkt.Encode())
+    if chunks[HEP_CHUNK_IP_FAMILY][0] != 2 || chunks[HEP_CHUNK_IP_PROTO][0] != 6 {
+        t.Fatal("bad family or protocol")
+    }
+    if !net.IP(chunks[HEP_CHUNK_IP4_SRC]).Equal(net.ParseIP("192.0.2.1")) || !net.IP(chunks[HEP_CHUNK_IP4_DST]).Equal(net.ParseIP("192.0.2.2")) {
+        t.Fatal("bad addresses")
+    }
+    if binary.BigEndian.Uint16(chunks[HEP_CHUNK_SRC_PORT]) != 5060 || binary.BigEndian.Uint16(chunks[HEP_CHUNK_DST_PORT]) != 5080 {
+        t.Fatal("bad ports")
+    }
+    if binary.BigEndian.Uint32(chunks[HEP_CHUNK_TS_SEC]) != 1700000000 || binary.BigEndian.Uint32(chunks[HEP_CHUNK_TS_USEC]) != 123456 {
+        t.Fatal("bad timestamp")
+    }
+    if binary.BigEndian.Uint32(chunks[HEP_CHUNK_AGENT_ID]) != 42 || string(chunks[HEP_CHUNK_AUTH_KEY]) != "secret" {
+        t.Fatal("bad agent id or password")
+    }
+    if string(chunks[HEP_CHUNK_CORRELATION]) != "abc@host" || string(chunks[HEP_CHUNK_PAYLOAD]) != string(pkt.Payload) {
+        t.Fatal("bad correlation id or payload")
+    }
+}
+
+func TestHepCapture(t *testing.T) {
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer conn.Close()
+    collector, _ := sippy_net.NewHostPortFromAddr(conn.LocalAddr())
+    hep, err := NewHepCapture(collector, "udp", 1, "", sippy_log.NewErrorLogger())
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer hep.Shutdown()
+    hep.Capture(nil, sippy_net.NewHostPort("::1", "5060"), sippy_net.NewHostPort("::1", "5061"), "UDP", "cid", []byte("SIP/2.0 200 OK\r\n\r\n"))
+    buf := make([]byte, 65535)
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    n, _, err := conn.ReadFrom(buf)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    chunks := parseChunks(t, buf[:n])
+    if chunks[HEP_CHUNK_IP_FAMILY][0] != 10 || len(chunks[HEP_CHUNK_IP6_SRC]) != 16 || chunks[HEP_CHUNK_IP_PROTO][0] != 17 {
+        t.Fatal("bad IPv6 capture")
+    }
+}
@@ -28,6 +28,7 @@ package sippy
 
 import (
     "net"
+    "strings"
     "sync"
 
     "github.com/sippy/go-b2bua/sippy/conf"
@@ -41,10 +42,17 @@ type local4remote struct {
     cache_l2s       map[string]sippy_net.Transport
     handleIncoming  sippy_net.DataPacketReceiver
     fixed           bool
-    tfactory        sippy_net.SipTransportFactory
+    tfactories      map[string]sippy_net.SipTransportFactory
     lock            sync.Mutex
 }
 
+func l2s_key(laddress *sippy_net.HostPort, proto string) string {
+    if proto == "UDP" {
+        return laddress.String()
+    }
+    return strings.ToLower(proto) + ":" + laddress.String()
+}
+
 func NewLocal4Remote(config sippy_conf.Config, handleIncoming sippy_net.DataPacketReceiver) (*local4remote, error) {
     self := &local4remote{
         config          : config,
@@ -53,10 +61,16 @@ func NewLocal4Remote(config sippy_conf.Config, handleIncoming sippy_net.DataPack
         cache_l2s       : make(map[string]sippy_net.Transport),
         handleIncoming  : handleIncoming,
         fixed           : false,
-        tfactory        : config.GetSipTransportFactory(),
+        tfactories      : make(map[string]sippy_net.SipTransportFactory),
     }
-    if self.tfactory == nil {
-        self.tfactory = NewDefaultSipTransportFactory(config)
+    for _, proto := range config.GetSipTransportProtos() {
+        tfactory := config.GetSipTransportFactoryByProto(proto)
+        if tfactory == nil && proto == "UDP" {
+            tfactory = NewDefaultSipTransportFactory(config)
+        }
+        if tfactory != nil {
+            self.tfactories[proto] = tfactory
+        }
     }
     laddresses := make([]*sippy_net.HostPort, 0)
     if config.SipAddress().IsSystemDefault() {
@@ -70,19 +84,21 @@ func NewLocal4Remote(config sippy_conf.Config, handleIncoming sippy_net.DataPack
     }
     var last_error error
     for _, laddress := range laddresses {
-        /*
-        sopts := NewUdpServerOpts(laddress, handleIncoming)
-        server, err := NewUdpServer(config, sopts)
-        */
-        server, err := self.tfactory.NewSipTransport(laddress, handleIncoming)
-        if err != nil {
-            if ! config.SipAddress().IsSystemDefault() {
-                return nil, err
+        for proto, tfactory := range self.tfactories {
+            /*
+            sopts := NewUdpServerOpts(laddress, handleIncoming)
+            server, err := NewUdpServer(config, sopts)
+            */
+            server, err := tfactory.NewSipTransport(laddress, handleIncoming)
+            if err != nil {
+                if ! config.SipAddress().IsSystemDefault() {
+                    return nil, err
+                } else {
+                    last_error = err
+                }
             } else {
-                last_error = err
+                self.cache_l2s[l2s_key(laddress, proto)] = server
             }
-        } else {
-            self.cache_l2s[laddress.String()] = server
         }
     }
     if len(self.cache_l2s) == 0 && last_error != nil {
@@ -91,16 +107,22 @@ func NewLocal4Remote(config sippy_conf.Config, handleIncoming sippy_net.DataPack
     return self, nil
 }
 
-func (self *local4remote) getServer(address *sippy_net.HostPort, is_local bool /*= false*/) sippy_net.Transport {
+func (self *local4remote) getServer(address *sippy_net.HostPort, is_local bool /*= false*/, proto string /*= "UDP"*/) sippy_net.Transport {
     var laddress *sippy_net.HostPort
     var ok bool
 
     self.lock.Lock()
     defer self.lock.Unlock()
 
+    tfactory, ok := self.tfactories[proto]
+    if ! ok {
+        return nil
+    }
     if self.fixed {
         for _, server := range self.cache_l2s {
-            return server
+            if sippy_net.GetTransportProto(server) == proto {
+                return server
+            }
         }
         return nil
     }
@@ -113,7 +135,7 @@ func (self *local4remote) getServer(address *sippy_net.HostPort, is_local bool /
             }
         }
         if ok {
-            server, ok := self.cache_l2s[laddress.String()]
+            server, ok := self.cache_l2s[l2s_key(laddress, proto)]
             if ! ok {
                 return nil
             } else {
@@ -149,24 +171,37 @@ func (self *local4remote) getServer(address *sippy_net.HostPort, is_local bool /
     } else {
         laddress = address
     }
-    server, ok := self.cache_l2s[laddress.String()]
+    server, ok := self.cache_l2s[l2s_key(laddress, proto)]
     if ! ok {
         var err error
         /*
         sopts := NewUdpServerOpts(laddress, self.handleIncoming)
         server, err = NewUdpServer(self.config, sopts)
         */
-        server, err = self.tfactory.NewSipTransport(laddress, self.handleIncoming)
+        server, err = tfactory.NewSipTransport(laddress, self.handleIncoming)
         if err != nil {
-            self.config.ErrorLogger().Errorf("Cannot bind %s: %s", laddress.String(), err.Error())
+            self.config.ErrorLogger().Errorf("Cannot bind %s/%s: %s", laddress.String(), proto, err.Error())
             return nil
         }
-        self.cache_l2s[laddress.String()] = server
+        self.cache_l2s[l2s_key(laddress, proto)] = server
     }
     //print 'local4remote-2: local address for %s is %s' % (address[0], laddress[0])
     return server
 }
 
+// getAnyServer returns any of the already created servers for the
+// protocol, it is used when the remote address is not known yet.
+func (self *local4remote) getAnyServer(proto string) sippy_net.Transport {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    for _, server := range self.cache_l2s {
+        if sippy_net.GetTransportProto(server) == proto {
+            return server
+        }
+    }
+    return nil
+}
+
 func (self *local4remote) rotateCache() {
     self.lock.Lock()
     defer self.lock.Unlock()
@@ -175,6 +210,44 @@ func (self *local4remote) rotateCache() {
     self.cache_r2l = make(map[string]*sippy_net.HostPort)
 }
 
+// Tells if the address is the one of the SIP transports. The transports
+// listening on the wildcard address match the configured own address.
+func (self *local4remote) isLocal(address *sippy_net.HostPort) bool {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    host, port := address.Host.String(), address.Port.String()
+    for _, server := range self.cache_l2s {
+        laddress := server.GetLAddress()
+        if laddress == nil || laddress.Port.String() != port {
+            continue
+        }
+        switch laddress.Host.String() {
+        case host:
+            return true
+        case "0.0.0.0", "[::]":
+            if host == self.config.GetMyAddress().String() || host == self.config.SipAddress().String() {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// The fill level of the fullest UDP send queue.
+func (self *local4remote) getQueueLoad() float64 {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    load := 0.0
+    for _, userv := range self.cache_l2s {
+        if userv, ok := userv.(*UdpServer); ok {
+            if l := userv.getQueueLoad(); l > load {
+                load = l
+            }
+        }
+    }
+    return load
+}
+
 func (self *local4remote) shutdown() {
     for _, userv := range self.cache_l2s {
         userv.Shutdown()
@@ -0,0 +1,219 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_log
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "runtime"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/fmt"
+    "github.com/sippy/go-b2bua/sippy/time"
+)
+
+// SipMsgLogger is implemented by the SIP loggers that want to receive
+// the SIP messages in the structured form rather than the pre-formatted
+// text.
+type SipMsgLogger interface {
+    SipLogger
+    WriteMsg(rtime *sippy_time.MonoTime, call_id, direction, address string, data []byte)
+}
+
+type jsonSipRecord struct {
+    Ts          string  `json:"ts"`
+    Date        string  `json:"date"`
+    Id          string  `json:"id"`
+    CallId      string  `json:"call_id"`
+    Direction   string  `json:"direction,omitempty"`
+    Address     string  `json:"address,omitempty"`
+    Method      string  `json:"method,omitempty"`
+    Status      int     `json:"status,omitempty"`
+    Reason      string  `json:"reason,omitempty"`
+    Msg         string  `json:"msg"`
+}
+
+// jsonSipLogger writes one JSON object per line for every logged SIP
+// message, so that the log could be ingested by the log collectors
+// without parsing the text format.
+type jsonSipLogger struct {
+    fname   string
+    id      string
+    fd      *os.File
+    lock    sync.Mutex
+}
+
+func NewJsonSipLogger(id, fname string) (*jsonSipLogger, error) {
+    self := &jsonSipLogger{
+        fname   : fname,
+        id      : id,
+    }
+    err := self.Reopen()
+    if err != nil {
+        return nil, err
+    }
+    return self, nil
+}
+
+func (self *jsonSipLogger) Write(rtime *sippy_time.MonoTime, call_id string, msg string) {
+    self.write(self.newRecord(rtime, call_id, strings.TrimRight(msg, "\n")))
+}
+
+func (self *jsonSipLogger) WriteMsg(rtime *sippy_time.MonoTime, call_id, direction, address string, data []byte) {
+    rec := self.newRecord(rtime, call_id, string(data))
+    rec.Direction = direction
+    rec.Address = address
+    line := data
+    if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+        line = data[:idx]
+    }
+    arr := strings.SplitN(strings.TrimSpace(string(line)), " ", 3)
+    if len(arr) > 1 && strings.HasPrefix(arr[0], "SIP/") {
+        rec.Status, _ = strconv.Atoi(arr[1])
+        if len(arr) > 2 {
+            rec.Reason = arr[2]
+        }
+    } else if len(arr) > 0 {
+        rec.Method = arr[0]
+    }
+    self.write(rec)
+}
+
+func (self *jsonSipLogger) newRecord(rtime *sippy_time.MonoTime, call_id, msg string) *jsonSipRecord {
+    var t time.Time
+    if rtime != nil {
+        t = rtime.Realt()
+    } else {
+        t = time.Now()
+    }
+    return &jsonSipRecord{
+        Ts          : t.UTC().Format(time.RFC3339Nano),
+        Date        : FormatDate(t),
+        Id          : self.id,
+        CallId      : call_id,
+        Msg         : msg,
+    }
+}
+
+func (self *jsonSipLogger) write(rec *jsonSipRecord) {
+    buf, err := json.Marshal(rec)
+    if err != nil {
+        return
+    }
+    buf = append(buf, '\n')
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    fileno := int(self.fd.Fd())
+    syscall.Flock(fileno, syscall.LOCK_EX)
+    defer syscall.Flock(fileno, syscall.LOCK_UN)
+    self.fd.Write(buf)
+}
+
+// Reopen reopens the log file, i.e. after it has been rotated.
+func (self *jsonSipLogger) Reopen() error {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    fd, err := os.OpenFile(self.fname, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    if self.fd != nil {
+        self.fd.Close()
+    }
+    self.fd = fd
+    return nil
+}
+
+type jsonErrorRecord struct {
+    Ts          string  `json:"ts"`
+    Date        string  `json:"date"`
+    Level       string  `json:"level"`
+    Msg         string  `json:"msg"`
+    Traceback   string  `json:"traceback,omitempty"`
+}
+
+// jsonErrorLogger is the ErrorLogger that writes the leveled JSON lines
+// to the stderr.
+type jsonErrorLogger struct {
+    lock    sync.Mutex
+    out     io.Writer
+}
+
+func NewJsonErrorLogger() *jsonErrorLogger {
+    return &jsonErrorLogger{
+        out     : os.Stderr,
+    }
+}
+
+func (self *jsonErrorLogger) ErrorAndTraceback(err interface{}) {
+    buf := make([]byte, 16384)
+    n := runtime.Stack(buf, false)
+    self.write("error", fmt.Sprint(err), string(buf[:n]))
+}
+
+func (self *jsonErrorLogger) Debug(params...interface{}) {
+    self.write("debug", fmt.Sprintln(params...), "")
+}
+
+func (self *jsonErrorLogger) Debugf(format string, params...interface{}) {
+    self.write("debug", sippy_fmt.Sprintf(format, params...), "")
+}
+
+func (self *jsonErrorLogger) Error(params...interface{}) {
+    self.write("error", fmt.Sprintln(params...), "")
+}
+
+func (self *jsonErrorLogger) Errorf(format string, params...interface{}) {
+    self.write("error", sippy_fmt.Sprintf(format, params...), "")
+}
+
+func (*jsonErrorLogger) Reopen() {
+}
+
+func (self *jsonErrorLogger) write(level, msg, traceback string) {
+    t := time.Now()
+    buf, err := json.Marshal(&jsonErrorRecord{
+        Ts          : t.UTC().Format(time.RFC3339Nano),
+        Date        : FormatDate(t),
+        Level       : level,
+        Msg         : strings.TrimRight(msg, "\n"),
+        Traceback   : traceback,
+    })
+    if err != nil {
+        return
+    }
+    buf = append(buf, '\n')
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.out.Write(buf)
+}
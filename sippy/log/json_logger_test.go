@@ -0,0 +1,63 @@
+package sippy_log
+
+import (
+    "bytes"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestJsonSipLogger(t *testing.T) {
+    fname := filepath.Join(t.TempDir(), "sip.log")
+    logger, err := NewJsonSipLogger("b2bua", fname)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    logger.WriteMsg(nil, "cid1", "RECEIVED", "192.0.2.1:5060", []byte("INVITE sip:bob@example.com SIP/2.0\r\nCall-ID: cid1\r\n\r\n"))
+    logger.WriteMsg(nil, "cid1", "SENDING", "192.0.2.1:5060", []byte("SIP/2.0 486 Busy Here\r\nCall-ID: cid1\r\n\r\n"))
+    if err = logger.Reopen(); err != nil {
+        t.Fatal(err.Error())
+    }
+    logger.Write(nil, "cid1", "some text\n")
+    data, err := os.ReadFile(fname)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+    if len(lines) != 3 {
+        t.Fatalf("3 lines expected, got %d", len(lines))
+    }
+    var recs [3]jsonSipRecord
+    for i, line := range lines {
+        if err = json.Unmarshal([]byte(line), &recs[i]); err != nil {
+            t.Fatal(err.Error())
+        }
+    }
+    if recs[0].Method != "INVITE" || recs[0].Direction != "RECEIVED" || recs[0].Address != "192.0.2.1:5060" || recs[0].CallId != "cid1" {
+        t.Fatalf("bad request record: %s", lines[0])
+    }
+    if recs[1].Status != 486 || recs[1].Reason != "Busy Here" || recs[1].Method != "" {
+        t.Fatalf("bad response record: %s", lines[1])
+    }
+    if recs[2].Msg != "some text" {
+        t.Fatalf("bad text record: %s", lines[2])
+    }
+}
+
+func TestJsonErrorLogger(t *testing.T) {
+    var buf bytes.Buffer
+    logger := NewJsonErrorLogger()
+    logger.out = &buf
+    logger.Errorf("bad %s", "thing")
+    logger.Debug("a", 1)
+    var rec jsonErrorRecord
+    lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil || rec.Level != "error" || rec.Msg != "bad thing" {
+        t.Fatalf("bad error record: %s", lines[0])
+    }
+    if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil || rec.Level != "debug" || rec.Msg != "a 1" {
+        t.Fatalf("bad debug record: %s", lines[1])
+    }
+}
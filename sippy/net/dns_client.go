@@ -0,0 +1,289 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_net
+
+import (
+    "bufio"
+    "context"
+    "encoding/binary"
+    "errors"
+    "io"
+    "math/rand"
+    "net"
+    "os"
+    "strings"
+    "time"
+)
+
+const (
+    DNS_TYPE_NAPTR  = 35
+    DNS_CLASS_IN    = 1
+    DNS_TIMEOUT     = 2 * time.Second
+)
+
+type NAPTR struct {
+    Order       uint16
+    Preference  uint16
+    Flags       string
+    Service     string
+    Regexp      string
+    Replacement string
+}
+
+// DnsClient is the interface to the DNS used by the Resolver. It can be
+// replaced to use a custom DNS library or a stub in tests.
+type DnsClient interface {
+    LookupNAPTR(name string) ([]*NAPTR, error)
+    LookupSRV(name string) ([]*net.SRV, error)
+    LookupIP(host string) ([]net.IP, error)
+}
+
+type defaultDnsClient struct {
+    nameservers []string
+}
+
+// NewDefaultDnsClient returns the DNS client that uses the system
+// resolver for SRV and A/AAAA lookups and queries the nameservers from
+// the /etc/resolv.conf directly for NAPTR records, which are not
+// supported by the standard library.
+func NewDefaultDnsClient() DnsClient {
+    return &defaultDnsClient{
+        nameservers : readNameservers("/etc/resolv.conf"),
+    }
+}
+
+func readNameservers(fname string) []string {
+    ret := []string{}
+    fd, err := os.Open(fname)
+    if err != nil {
+        return []string{ "127.0.0.1:53" }
+    }
+    defer fd.Close()
+    scanner := bufio.NewScanner(fd)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) >= 2 && fields[0] == "nameserver" {
+            ret = append(ret, net.JoinHostPort(fields[1], "53"))
+        }
+    }
+    if len(ret) == 0 {
+        ret = append(ret, "127.0.0.1:53")
+    }
+    return ret
+}
+
+func (self *defaultDnsClient) LookupSRV(name string) ([]*net.SRV, error) {
+    _, addrs, err := net.DefaultResolver.LookupSRV(context.Background(), "", "", name)
+    if err != nil {
+        if dnserr, ok := err.(*net.DNSError); ok && dnserr.IsNotFound {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return addrs, nil
+}
+
+func (self *defaultDnsClient) LookupIP(host string) ([]net.IP, error) {
+    return net.LookupIP(host)
+}
+
+func (self *defaultDnsClient) LookupNAPTR(name string) ([]*NAPTR, error) {
+    var last_err error
+    query := buildDnsQuery(uint16(rand.Intn(65536)), name, DNS_TYPE_NAPTR)
+    for _, ns := range self.nameservers {
+        resp, err := dnsExchange(ns, query)
+        if err == nil && len(resp) > 2 && resp[2] & 0x02 != 0 {
+            // The answer does not fit into UDP datagram (TC bit),
+            // repeat the query over TCP (RFC 7766).
+            resp, err = dnsExchangeTCP(ns, query)
+        }
+        if err != nil {
+            last_err = err
+            continue
+        }
+        return parseNAPTRResponse(resp, binary.BigEndian.Uint16(query))
+    }
+    return nil, last_err
+}
+
+func dnsExchange(nameserver string, query []byte) ([]byte, error) {
+    conn, err := net.DialTimeout("udp", nameserver, DNS_TIMEOUT)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(DNS_TIMEOUT))
+    if _, err = conn.Write(query); err != nil {
+        return nil, err
+    }
+    buf := make([]byte, 65535)
+    n, err := conn.Read(buf)
+    if err != nil {
+        return nil, err
+    }
+    return buf[:n], nil
+}
+
+// Over TCP the DNS messages are prefixed with the two bytes length.
+func dnsExchangeTCP(nameserver string, query []byte) ([]byte, error) {
+    conn, err := net.DialTimeout("tcp", nameserver, DNS_TIMEOUT)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(DNS_TIMEOUT))
+    msg := make([]byte, 2, 2 + len(query))
+    binary.BigEndian.PutUint16(msg, uint16(len(query)))
+    if _, err = conn.Write(append(msg, query...)); err != nil {
+        return nil, err
+    }
+    if _, err = io.ReadFull(conn, msg); err != nil {
+        return nil, err
+    }
+    buf := make([]byte, binary.BigEndian.Uint16(msg))
+    if _, err = io.ReadFull(conn, buf); err != nil {
+        return nil, err
+    }
+    return buf, nil
+}
+
+func buildDnsQuery(id uint16, name string, qtype uint16) []byte {
+    msg := make([]byte, 12)
+    binary.BigEndian.PutUint16(msg[0:], id)
+    binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+    binary.BigEndian.PutUint16(msg[4:], 1)      // QDCOUNT
+    for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+        msg = append(msg, byte(len(label)))
+        msg = append(msg, label...)
+    }
+    msg = append(msg, 0, byte(qtype >> 8), byte(qtype), 0, DNS_CLASS_IN)
+    return msg
+}
+
+var errDnsShort = errors.New("truncated DNS message")
+
+func readDnsName(msg []byte, off int) (string, int, error) {
+    labels := []string{}
+    next := -1
+    for hops := 0; hops < 64; hops++ {
+        if off >= len(msg) {
+            return "", 0, errDnsShort
+        }
+        l := int(msg[off])
+        switch {
+        case l == 0:
+            if next < 0 {
+                next = off + 1
+            }
+            return strings.Join(labels, "."), next, nil
+        case l & 0xc0 == 0xc0:
+            if off + 1 >= len(msg) {
+                return "", 0, errDnsShort
+            }
+            if next < 0 {
+                next = off + 2
+            }
+            off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+        default:
+            if off + 1 + l > len(msg) {
+                return "", 0, errDnsShort
+            }
+            labels = append(labels, string(msg[off + 1:off + 1 + l]))
+            off += 1 + l
+        }
+    }
+    return "", 0, errors.New("too many DNS compression pointers")
+}
+
+func readDnsString(msg []byte, off int) (string, int, error) {
+    if off >= len(msg) || off + 1 + int(msg[off]) > len(msg) {
+        return "", 0, errDnsShort
+    }
+    l := int(msg[off])
+    return string(msg[off + 1:off + 1 + l]), off + 1 + l, nil
+}
+
+func parseNAPTRResponse(msg []byte, id uint16) ([]*NAPTR, error) {
+    var err error
+
+    if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+        return nil, errors.New("bad DNS response")
+    }
+    switch rcode := msg[3] & 0x0f; rcode {
+    case 0:
+    case 3: // NXDOMAIN
+        return nil, nil
+    default:
+        return nil, errors.New("DNS server failure")
+    }
+    qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+    ancount := int(binary.BigEndian.Uint16(msg[6:]))
+    off := 12
+    for i := 0; i < qdcount; i++ {
+        if _, off, err = readDnsName(msg, off); err != nil {
+            return nil, err
+        }
+        off += 4
+    }
+    ret := []*NAPTR{}
+    for i := 0; i < ancount; i++ {
+        if _, off, err = readDnsName(msg, off); err != nil {
+            return nil, err
+        }
+        if off + 10 > len(msg) {
+            return nil, errDnsShort
+        }
+        rtype := binary.BigEndian.Uint16(msg[off:])
+        rdlen := int(binary.BigEndian.Uint16(msg[off + 8:]))
+        off += 10
+        if off + rdlen > len(msg) {
+            return nil, errDnsShort
+        }
+        next := off + rdlen
+        if rtype == DNS_TYPE_NAPTR && rdlen > 4 {
+            rr := &NAPTR{
+                Order       : binary.BigEndian.Uint16(msg[off:]),
+                Preference  : binary.BigEndian.Uint16(msg[off + 2:]),
+            }
+            off += 4
+            if rr.Flags, off, err = readDnsString(msg, off); err != nil {
+                return nil, err
+            }
+            if rr.Service, off, err = readDnsString(msg, off); err != nil {
+                return nil, err
+            }
+            if rr.Regexp, off, err = readDnsString(msg, off); err != nil {
+                return nil, err
+            }
+            if rr.Replacement, _, err = readDnsName(msg, off); err != nil {
+                return nil, err
+            }
+            ret = append(ret, rr)
+        }
+        off = next
+    }
+    return ret, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_net
+
+import (
+    "encoding/base64"
+)
+
+// Flow is the transport level association between the local transport
+// and the remote address (RFC 5626 section 3.1). For the connection
+// oriented transports it identifies the connection, for UDP the pair
+// of local and remote addresses (i.e. the NAT binding).
+type Flow struct {
+    Transport   Transport
+    RAddress    *HostPort
+}
+
+func NewFlow(transport Transport, raddress *HostPort) *Flow {
+    return &Flow{
+        Transport   : transport,
+        RAddress    : raddress,
+    }
+}
+
+func (self *Flow) String() string {
+    return GetTransportProto(self.Transport) + ":" + self.Transport.GetLAddress().String() + "->" + self.RAddress.String()
+}
+
+// Token returns the opaque flow token that can be placed into the URI
+// user part or parameter to identify the flow (RFC 5626 section 5.2).
+func (self *Flow) Token() string {
+    return base64.RawURLEncoding.EncodeToString([]byte(self.String()))
+}
+
+func (self *Flow) IsReliable() bool {
+    return IsReliable(self.Transport)
+}
@@ -125,6 +125,15 @@ func (self *MyPort) GetCopy() *MyPort {
 type HostPort struct {
     Host    *MyAddress
     Port    *MyPort
+    // The host name the address has been obtained from. It is used
+    // to verify the certificate of the TLS peer.
+    ServerName  string
+    // The transport selected for the address by the RFC 3263
+    // procedures, empty when it is to be taken from the URI.
+    Proto       string
+    // The RFC 3263 lookup that is still to be done for the address,
+    // see Resolver.GetAddr().
+    Lookup      *SipLookup
 }
 
 func NewHostPort(host, port string) *HostPort {
@@ -155,8 +164,11 @@ func (self *HostPort) String() string {
 
 func (self *HostPort) GetCopy() *HostPort {
     return &HostPort{
-        Host : self.Host.GetCopy(),
-        Port : self.Port.GetCopy(),
+        Host        : self.Host.GetCopy(),
+        Port        : self.Port.GetCopy(),
+        ServerName  : self.ServerName,
+        Proto       : self.Proto,
+        Lookup      : self.Lookup,
     }
 }
 
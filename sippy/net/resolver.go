@@ -0,0 +1,359 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy_net
+
+import (
+    "errors"
+    "math/rand"
+    "net"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    RESOLVER_CACHE_TTL      = 300 * time.Second
+    RESOLVER_NEG_CACHE_TTL  = 30 * time.Second
+)
+
+// SipTarget is a single next hop produced by the RFC 3263 procedures.
+type SipTarget struct {
+    Address     *HostPort
+    Proto       string
+}
+
+func (self *SipTarget) String() string {
+    return strings.ToLower(self.Proto) + ":" + self.Address.String()
+}
+
+type naptr_service struct {
+    proto       string
+    secure      bool
+    srv_prefix  string
+}
+
+var naptr_services = map[string]naptr_service{
+    "SIP+D2U"   : { "UDP", false, "_sip._udp." },
+    "SIP+D2T"   : { "TCP", false, "_sip._tcp." },
+    "SIPS+D2T"  : { "TLS", true, "_sips._tcp." },
+    "SIP+D2W"   : { "WS", false, "_sip._ws." },
+    "SIPS+D2W"  : { "WSS", true, "_sips._ws." },
+}
+
+// SipLookup is the RFC 3263 query for the host that has not been
+// resolved yet. Port and Proto are the values explicitly set in the
+// URI (empty if not set), Secure is true for sips: URIs.
+type SipLookup struct {
+    Host        string
+    Port        string
+    Proto       string
+    Secure      bool
+}
+
+func (self *SipLookup) key() string {
+    return self.Host + "|" + self.Port + "|" + strings.ToUpper(self.Proto) + "|" + strconv.FormatBool(self.Secure)
+}
+
+type resolver_cache_entry struct {
+    targets     []*SipTarget
+    err         error
+    expires     time.Time
+}
+
+// Resolver locates SIP servers as per RFC 3263: NAPTR -> SRV -> A/AAAA.
+// The results are cached so that the addresses could be obtained on
+// the hot paths without blocking on the DNS.
+type Resolver struct {
+    dns         DnsClient
+    protos      map[string]bool
+    ipv6        bool
+    cache       map[string]*resolver_cache_entry
+    lock        sync.Mutex
+}
+
+func NewResolver(dns DnsClient) *Resolver {
+    if dns == nil {
+        dns = NewDefaultDnsClient()
+    }
+    return &Resolver{
+        dns         : dns,
+        protos      : map[string]bool{ "UDP" : true },
+        ipv6        : true,
+        cache       : make(map[string]*resolver_cache_entry),
+    }
+}
+
+// SetTransports sets the transport protocols supported by the client,
+// only the targets using those are returned.
+func (self *Resolver) SetTransports(protos []string) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.protos = make(map[string]bool)
+    for _, proto := range protos {
+        self.protos[strings.ToUpper(proto)] = true
+    }
+    self.cache = make(map[string]*resolver_cache_entry)
+}
+
+func (self *Resolver) SetIPV6Enabled(v bool) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.ipv6 = v
+    self.cache = make(map[string]*resolver_cache_entry)
+}
+
+// GetAddr returns the address of the first target for the lookup when
+// it is in the cache. Otherwise the fallback address is returned with
+// the lookup attached to it so that the caller could complete it with
+// ResolveAddr() once it does not hold any locks.
+func (self *Resolver) GetAddr(lookup *SipLookup, fallback *HostPort) *HostPort {
+    self.lock.Lock()
+    entry, ok := self.cache[lookup.key()]
+    self.lock.Unlock()
+    if ! ok || time.Now().After(entry.expires) {
+        ret := fallback.GetCopy()
+        ret.Lookup = lookup
+        return ret
+    }
+    return targetAddr(entry.targets, entry.err, fallback)
+}
+
+// ResolveAddr completes the lookup attached to the address by GetAddr().
+// It blocks on the DNS so it should not be called with the locks held.
+func (self *Resolver) ResolveAddr(address *HostPort) *HostPort {
+    if address.Lookup == nil {
+        return address
+    }
+    fallback := address.GetCopy()
+    fallback.Lookup = nil
+    targets, err := self.Resolve(address.Lookup.Host, address.Lookup.Port, address.Lookup.Proto, address.Lookup.Secure)
+    return targetAddr(targets, err, fallback)
+}
+
+func targetAddr(targets []*SipTarget, err error, fallback *HostPort) *HostPort {
+    if err != nil || len(targets) == 0 {
+        return fallback
+    }
+    ret := targets[0].Address.GetCopy()
+    ret.Proto = targets[0].Proto
+    ret.ServerName = fallback.ServerName
+    return ret
+}
+
+func defaultSipPort(proto string) string {
+    switch proto {
+    case "TLS", "WSS":
+        return "5061"
+    }
+    return "5060"
+}
+
+// Resolve returns the ordered list of the targets for the host. Port
+// and proto are the values explicitly set in the URI (empty if not
+// set), secure is true for sips: URIs.
+func (self *Resolver) Resolve(host, port, proto string, secure bool) ([]*SipTarget, error) {
+    key := (&SipLookup{ Host : host, Port : port, Proto : proto, Secure : secure }).key()
+    self.lock.Lock()
+    entry, ok := self.cache[key]
+    protos, ipv6 := self.protos, self.ipv6
+    self.lock.Unlock()
+    if ok && time.Now().Before(entry.expires) {
+        return entry.targets, entry.err
+    }
+    targets, err := (&resolver_query{ dns : self.dns, protos : protos, ipv6 : ipv6 }).resolve(host, port, proto, secure)
+    ttl := RESOLVER_CACHE_TTL
+    if err != nil {
+        ttl = RESOLVER_NEG_CACHE_TTL
+    }
+    self.lock.Lock()
+    self.cache[key] = &resolver_cache_entry{ targets : targets, err : err, expires : time.Now().Add(ttl) }
+    self.lock.Unlock()
+    return targets, err
+}
+
+// resolver_query holds the settings of the resolver for the duration
+// of a single lookup.
+type resolver_query struct {
+    dns         DnsClient
+    protos      map[string]bool
+    ipv6        bool
+}
+
+func (self *resolver_query) resolve(host, port, proto string, secure bool) ([]*SipTarget, error) {
+    proto = strings.ToUpper(proto)
+    def_proto := "UDP"
+    if secure {
+        def_proto = "TLS"
+    }
+    host = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSuffix(host, "]"), "["), ".")
+    if ip := net.ParseIP(host); ip != nil || port != "" {
+        // Numeric IP address or explicit port - no NAPTR or SRV
+        if proto == "" {
+            proto = def_proto
+        }
+        if port == "" {
+            port = defaultSipPort(proto)
+        }
+        return self.resolveA(host, port, proto, nil)
+    }
+    // The failed NAPTR or SRV lookups are not fatal, the procedure
+    // just falls through to the next step.
+    if proto == "" {
+        if ret, err := self.resolveNAPTR(host, secure); err == nil && len(ret) > 0 {
+            return ret, nil
+        }
+        // No usable NAPTR records, try SRV for all supported protos
+        for _, p := range []string{ "TLS", "UDP", "TCP" } {
+            if (secure && p != "TLS") || ! self.protos[p] {
+                continue
+            }
+            if ret, err := self.resolveSRV(srvPrefix(p) + host, p); err == nil && len(ret) > 0 {
+                return ret, nil
+            }
+        }
+        proto = def_proto
+    } else if ret, err := self.resolveSRV(srvPrefix(proto) + host, proto); err == nil && len(ret) > 0 {
+        return ret, nil
+    }
+    return self.resolveA(host, defaultSipPort(proto), proto, nil)
+}
+
+func srvPrefix(proto string) string {
+    for _, svc := range naptr_services {
+        if svc.proto == proto {
+            return svc.srv_prefix
+        }
+    }
+    return "_sip._" + strings.ToLower(proto) + "."
+}
+
+func (self *resolver_query) resolveNAPTR(host string, secure bool) ([]*SipTarget, error) {
+    rrs, err := self.dns.LookupNAPTR(host)
+    if err != nil {
+        return nil, err
+    }
+    sort.SliceStable(rrs, func(i, j int) bool {
+        if rrs[i].Order != rrs[j].Order {
+            return rrs[i].Order < rrs[j].Order
+        }
+        return rrs[i].Preference < rrs[j].Preference
+    })
+    ret := []*SipTarget{}
+    for _, rr := range rrs {
+        svc, ok := naptr_services[strings.ToUpper(rr.Service)]
+        if ! ok || ! self.protos[svc.proto] || (secure && ! svc.secure) {
+            continue
+        }
+        if strings.ToLower(rr.Flags) != "s" {
+            continue
+        }
+        targets, err := self.resolveSRV(rr.Replacement, svc.proto)
+        if err != nil {
+            continue
+        }
+        ret = append(ret, targets...)
+    }
+    return ret, nil
+}
+
+func (self *resolver_query) resolveSRV(name, proto string) ([]*SipTarget, error) {
+    srvs, err := self.dns.LookupSRV(name)
+    if err != nil {
+        return nil, err
+    }
+    ret := []*SipTarget{}
+    for _, srv := range orderSRV(srvs) {
+        if srv.Target == "." {
+            continue
+        }
+        ret, _ = self.resolveA(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)), proto, ret)
+    }
+    return ret, nil
+}
+
+func (self *resolver_query) resolveA(host, port, proto string, ret []*SipTarget) ([]*SipTarget, error) {
+    var ips []net.IP
+
+    if ip := net.ParseIP(host); ip != nil {
+        ips = []net.IP{ ip }
+    } else {
+        var err error
+        ips, err = self.dns.LookupIP(host)
+        if err != nil {
+            return ret, err
+        }
+    }
+    for _, ip := range ips {
+        if ! self.ipv6 && ! IsIP4(ip) {
+            continue
+        }
+        ret = append(ret, &SipTarget{
+            Address : NewHostPort(ip.String(), port),
+            Proto   : proto,
+        })
+    }
+    if len(ret) == 0 {
+        return ret, errors.New("no usable addresses for " + host)
+    }
+    return ret, nil
+}
+
+// orderSRV orders the records by priority and then by the weighted
+// random selection within the same priority (RFC 2782).
+func orderSRV(srvs []*net.SRV) []*net.SRV {
+    sorted := make([]*net.SRV, len(srvs))
+    copy(sorted, srvs)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+    ret := make([]*net.SRV, 0, len(sorted))
+    for i := 0; i < len(sorted); {
+        j := i
+        total := 0
+        for j < len(sorted) && sorted[j].Priority == sorted[i].Priority {
+            total += int(sorted[j].Weight)
+            j++
+        }
+        group := sorted[i:j]
+        for len(group) > 0 {
+            k := 0
+            if total > 0 {
+                n := rand.Intn(total + 1)
+                for sum := 0; k < len(group) - 1; k++ {
+                    sum += int(group[k].Weight)
+                    if sum >= n {
+                        break
+                    }
+                }
+            }
+            ret = append(ret, group[k])
+            total -= int(group[k].Weight)
+            group = append(group[:k:k], group[k + 1:]...)
+        }
+        i = j
+    }
+    return ret
+}
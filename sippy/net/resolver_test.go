@@ -0,0 +1,196 @@
+package sippy_net
+
+import (
+    "encoding/binary"
+    "net"
+    "testing"
+)
+
+type stubDnsClient struct {
+    naptr   map[string][]*NAPTR
+    srv     map[string][]*net.SRV
+    a       map[string][]net.IP
+}
+
+func (self *stubDnsClient) LookupNAPTR(name string) ([]*NAPTR, error) {
+    return self.naptr[name], nil
+}
+
+func (self *stubDnsClient) LookupSRV(name string) ([]*net.SRV, error) {
+    return self.srv[name], nil
+}
+
+func (self *stubDnsClient) LookupIP(host string) ([]net.IP, error) {
+    if ips, ok := self.a[host]; ok {
+        return ips, nil
+    }
+    return nil, &net.DNSError{ Err : "no such host", Name : host, IsNotFound : true }
+}
+
+func newStubDnsClient() *stubDnsClient {
+    return &stubDnsClient{
+        naptr   : map[string][]*NAPTR{
+            "example.com" : {
+                { Order : 20, Preference : 10, Flags : "s", Service : "SIP+D2U", Replacement : "_sip._udp.example.com" },
+                { Order : 10, Preference : 10, Flags : "s", Service : "SIP+D2T", Replacement : "_sip._tcp.example.com" },
+                { Order : 30, Preference : 10, Flags : "s", Service : "SIPS+D2T", Replacement : "_sips._tcp.example.com" },
+            },
+        },
+        srv     : map[string][]*net.SRV{
+            "_sip._tcp.example.com" : { { Target : "tcp.example.com.", Port : 5070, Priority : 10, Weight : 1 } },
+            "_sip._udp.example.com" : {
+                { Target : "udp2.example.com.", Port : 5060, Priority : 20, Weight : 1 },
+                { Target : "udp1.example.com.", Port : 5060, Priority : 10, Weight : 1 },
+            },
+            "_sips._tcp.example.com" : { { Target : "tls.example.com.", Port : 5061, Priority : 10, Weight : 1 } },
+            "_sip._udp.example.net" : { { Target : "udp.example.net.", Port : 5080, Priority : 10, Weight : 1 } },
+        },
+        a       : map[string][]net.IP{
+            "tcp.example.com" : { net.ParseIP("192.0.2.1") },
+            "udp1.example.com" : { net.ParseIP("192.0.2.2") },
+            "udp2.example.com" : { net.ParseIP("192.0.2.3") },
+            "tls.example.com" : { net.ParseIP("192.0.2.4") },
+            "udp.example.net" : { net.ParseIP("192.0.2.5") },
+            "example.org" : { net.ParseIP("192.0.2.6") },
+        },
+    }
+}
+
+func checkTargets(t *testing.T, name string, targets []*SipTarget, err error, expected ...string) {
+    if err != nil {
+        t.Fatalf("%s: unexpected error: %s", name, err.Error())
+    }
+    if len(targets) != len(expected) {
+        t.Fatalf("%s: expected %d targets, got %d: %v", name, len(expected), len(targets), targets)
+    }
+    for i, target := range targets {
+        if target.String() != expected[i] {
+            t.Fatalf("%s: target #%d: expected %s, got %s", name, i, expected[i], target.String())
+        }
+    }
+}
+
+func TestResolver(t *testing.T) {
+    r := NewResolver(newStubDnsClient())
+    r.SetTransports([]string{ "UDP", "TCP", "TLS" })
+
+    targets, err := r.Resolve("example.com", "", "", false)
+    checkTargets(t, "NAPTR", targets, err, "tcp:192.0.2.1:5070", "udp:192.0.2.2:5060", "udp:192.0.2.3:5060", "tls:192.0.2.4:5061")
+
+    targets, err = r.Resolve("example.com", "", "", true)
+    checkTargets(t, "NAPTR sips", targets, err, "tls:192.0.2.4:5061")
+
+    targets, err = r.Resolve("example.net", "", "", false)
+    checkTargets(t, "SRV", targets, err, "udp:192.0.2.5:5080")
+
+    targets, err = r.Resolve("example.org", "", "", false)
+    checkTargets(t, "A", targets, err, "udp:192.0.2.6:5060")
+
+    targets, err = r.Resolve("example.com", "", "tcp", false)
+    checkTargets(t, "explicit transport", targets, err, "tcp:192.0.2.1:5070")
+
+    targets, err = r.Resolve("tls.example.com", "5062", "", true)
+    checkTargets(t, "explicit port", targets, err, "tls:192.0.2.4:5062")
+
+    targets, err = r.Resolve("[2001:db8::1]", "", "", false)
+    checkTargets(t, "IPv6", targets, err, "udp:[2001:db8::1]:5060")
+
+    r.SetTransports([]string{ "UDP" })
+    targets, err = r.Resolve("example.com", "", "", false)
+    checkTargets(t, "UDP only", targets, err, "udp:192.0.2.2:5060", "udp:192.0.2.3:5060")
+
+    if _, err = r.Resolve("nonexistent.example.com", "", "", false); err == nil {
+        t.Fatal("error expected for the nonexistent host")
+    }
+}
+
+func TestParseNAPTRResponse(t *testing.T) {
+    msg := buildDnsQuery(0x1234, "example.com", DNS_TYPE_NAPTR)
+    msg[2] |= 0x80 // QR
+    binary.BigEndian.PutUint16(msg[6:], 1)
+    rdata := []byte{ 0, 10, 0, 20, 1, 's', 7, 'S', 'I', 'P', '+', 'D', '2', 'T', 0 }
+    rdata = append(rdata, 4, '_', 's', 'i', 'p', 4, '_', 't', 'c', 'p', 0xc0, 12)
+    msg = append(msg, 0xc0, 12, 0, DNS_TYPE_NAPTR, 0, DNS_CLASS_IN, 0, 0, 0, 60, 0, byte(len(rdata)))
+    msg = append(msg, rdata...)
+    rrs, err := parseNAPTRResponse(msg, 0x1234)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(rrs) != 1 || rrs[0].Order != 10 || rrs[0].Preference != 20 || rrs[0].Flags != "s" ||
+            rrs[0].Service != "SIP+D2T" || rrs[0].Replacement != "_sip._tcp.example.com" {
+        t.Fatalf("unexpected result: %+v", rrs[0])
+    }
+}
+
+func TestResolverGetAddr(t *testing.T) {
+    r := NewResolver(newStubDnsClient())
+    r.SetTransports([]string{ "UDP", "TCP" })
+    lookup := &SipLookup{ Host : "example.com" }
+    fallback := NewHostPort("example.com", "5060")
+    fallback.ServerName = "example.com"
+    addr := r.GetAddr(lookup, fallback)
+    if addr.Lookup == nil || addr.String() != "example.com:5060" {
+        t.Fatalf("the fallback address with the pending lookup expected, got %s", addr.String())
+    }
+    addr = r.ResolveAddr(addr)
+    if addr.Lookup != nil || addr.String() != "192.0.2.1:5070" || addr.Proto != "TCP" || addr.ServerName != "example.com" {
+        t.Fatalf("unexpected resolved address: %s %s %s", addr.Proto, addr.String(), addr.ServerName)
+    }
+    // Now it comes from the cache
+    addr = r.GetAddr(lookup, fallback)
+    if addr.Lookup != nil || addr.String() != "192.0.2.1:5070" || addr.Proto != "TCP" {
+        t.Fatalf("unexpected cached address: %s %s", addr.Proto, addr.String())
+    }
+}
+
+func TestDnsTruncated(t *testing.T) {
+    pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer pc.Close()
+    ln, err := net.Listen("tcp", pc.LocalAddr().String())
+    if err != nil {
+        t.Skip("cannot listen on TCP: " + err.Error())
+    }
+    defer ln.Close()
+    answer := func(query []byte) []byte {
+        msg := append([]byte{}, query...)
+        msg[2] |= 0x80 // QR
+        binary.BigEndian.PutUint16(msg[6:], 1)
+        rdata := []byte{ 0, 10, 0, 20, 1, 's', 7, 'S', 'I', 'P', '+', 'D', '2', 'T', 0 }
+        rdata = append(rdata, 4, '_', 's', 'i', 'p', 4, '_', 't', 'c', 'p', 0xc0, 12)
+        msg = append(msg, 0xc0, 12, 0, DNS_TYPE_NAPTR, 0, DNS_CLASS_IN, 0, 0, 0, 60, 0, byte(len(rdata)))
+        return append(msg, rdata...)
+    }
+    go func() {
+        buf := make([]byte, 512)
+        n, addr, err := pc.ReadFrom(buf)
+        if err != nil {
+            return
+        }
+        // Empty truncated answer
+        msg := append([]byte{}, buf[:n]...)
+        msg[2] |= 0x82
+        pc.WriteTo(msg, addr)
+    }()
+    go func() {
+        conn, err := ln.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        buf := make([]byte, 514)
+        n, _ := conn.Read(buf)
+        msg := answer(buf[2:n])
+        conn.Write(append([]byte{ byte(len(msg) >> 8), byte(len(msg)) }, msg...))
+    }()
+    dns := &defaultDnsClient{ nameservers : []string{ pc.LocalAddr().String() } }
+    rrs, err := dns.LookupNAPTR("example.com")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(rrs) != 1 || rrs[0].Service != "SIP+D2T" {
+        t.Fatalf("the answer has not been retrieved over TCP: %v", rrs)
+    }
+}
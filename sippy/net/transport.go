@@ -43,3 +43,24 @@ type Transport interface {
     SendTo([]byte, *HostPort)
     SendToWithCb([]byte, *HostPort, func())
 }
+
+// ReliableTransport is implemented by the connection oriented
+// transports (TCP, TLS etc). The messages sent over such transports
+// are not subject to the retransmissions and the responses have to be
+// routed back over the connection the request has been received on.
+type ReliableTransport interface {
+    Transport
+    GetProto() string
+}
+
+func GetTransportProto(t Transport) string {
+    if rt, ok := t.(ReliableTransport); ok {
+        return rt.GetProto()
+    }
+    return "UDP"
+}
+
+func IsReliable(t Transport) bool {
+    _, ok := t.(ReliableTransport)
+    return ok
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "math/rand"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+// OverloadControl implements the loss-based RFC 7339 SIP overload
+// control. As a server it advertises the percentage of the requests
+// the clients should drop calculated from its own load. As a client it
+// honours the values sent by the downstream servers and throttles
+// outbound INVITEs to them.
+type OverloadControl struct {
+    lock            sync.Mutex
+    load_sources    []func() float64
+    threshold       float64
+    validity        time.Duration
+    reduction       int
+    seq_time        int64
+    seq_num         int
+    peers           map[string]*ocPeer
+}
+
+type ocPeer struct {
+    reduction   int
+    expires     time.Time
+    seq         string
+}
+
+func NewOverloadControl() *OverloadControl {
+    return &OverloadControl{
+        load_sources    : make([]func() float64, 0),
+        threshold       : 0.8,
+        validity        : 500 * time.Millisecond,
+        peers           : make(map[string]*ocPeer),
+    }
+}
+
+// The load source returns the current load in the range from 0 to 1.
+// The highest of the loads reported by all sources is used.
+func (self *OverloadControl) AddLoadSource(fn func() float64) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.load_sources = append(self.load_sources, fn)
+}
+
+// The load above which the clients are asked to reduce the traffic.
+// At the threshold the reduction is 0% and it grows linearly to 100%
+// at the full load.
+func (self *OverloadControl) SetThreshold(threshold float64) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.threshold = threshold
+}
+
+// The time the advertised reduction remains in effect at the client.
+func (self *OverloadControl) SetValidity(validity time.Duration) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.validity = validity
+}
+
+func (self *OverloadControl) getReduction() (int, string) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    load := 0.0
+    for _, fn := range self.load_sources {
+        if l := fn(); l > load {
+            load = l
+        }
+    }
+    reduction := 0
+    if load > self.threshold {
+        if self.threshold >= 1 {
+            reduction = 100
+        } else {
+            reduction = int((load - self.threshold) / (1 - self.threshold) * 100 + 0.5)
+        }
+        if reduction > 100 {
+            reduction = 100
+        }
+    }
+    if reduction != self.reduction || self.seq_time == 0 {
+        // The sequence has to increase every time the value changes
+        now := time.Now().Unix()
+        if now > self.seq_time {
+            self.seq_time, self.seq_num = now, 0
+        } else {
+            self.seq_num++
+        }
+        self.reduction = reduction
+    }
+    return self.reduction, strconv.FormatInt(self.seq_time, 10) + "." + strconv.Itoa(self.seq_num)
+}
+
+// Set the overload control parameters in the topmost Via of the
+// response if the client has indicated the support.
+func (self *OverloadControl) updateResponse(via0 *sippy_header.SipViaBody) {
+    if ! via0.HasOC() {
+        return
+    }
+    reduction, seq := self.getReduction()
+    validity := int(self.validity / time.Millisecond)
+    if reduction == 0 {
+        // Zero validity tells the client the overload is over
+        validity = 0
+    }
+    via0.SetOC(reduction, "loss", validity, seq)
+}
+
+func ocSeqNewer(seq, prev string) bool {
+    if prev == "" || seq == "" {
+        return true
+    }
+    a := strings.SplitN(seq, ".", 2)
+    b := strings.SplitN(prev, ".", 2)
+    at, _ := strconv.ParseInt(a[0], 10, 64)
+    bt, _ := strconv.ParseInt(b[0], 10, 64)
+    if at != bt {
+        return at > bt
+    }
+    var an, bn int
+    if len(a) == 2 { an, _ = strconv.Atoi(a[1]) }
+    if len(b) == 2 { bn, _ = strconv.Atoi(b[1]) }
+    return an >= bn
+}
+
+// Record the overload control parameters received from the server.
+func (self *OverloadControl) processResponse(address *sippy_net.HostPort, via0 *sippy_header.SipViaBody) {
+    value, algo, validity, seq, ok := via0.GetOC()
+    if ! ok || algo != "loss" {
+        return
+    }
+    key := address.String()
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    peer, exists := self.peers[key]
+    if exists && ! ocSeqNewer(seq, peer.seq) {
+        return
+    }
+    if validity == 0 || value == 0 {
+        delete(self.peers, key)
+        return
+    }
+    self.peers[key] = &ocPeer{
+        reduction   : value,
+        expires     : time.Now().Add(time.Duration(validity) * time.Millisecond),
+        seq         : seq,
+    }
+}
+
+// Returns true if the request to the server should be dropped to
+// satisfy the reduction requested by it.
+func (self *OverloadControl) Throttle(address *sippy_net.HostPort) bool {
+    key := address.String()
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    peer, ok := self.peers[key]
+    if ! ok {
+        return false
+    }
+    if time.Now().After(peer.expires) {
+        delete(self.peers, key)
+        return false
+    }
+    return rand.Intn(100) < peer.reduction
+}
+
+// Returns the number of seconds (rounded up) the reduction requested by
+// the server remains in effect.
+func (self *OverloadControl) retryAfter(address *sippy_net.HostPort) int {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    peer, ok := self.peers[address.String()]
+    if ! ok {
+        return 0
+    }
+    ival := time.Until(peer.expires)
+    if ival <= 0 {
+        return 0
+    }
+    return int((ival + time.Second - 1) / time.Second)
+}
+
+// Returns the reduction currently requested by the server.
+func (self *OverloadControl) GetPeerReduction(address *sippy_net.HostPort) int {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    peer, ok := self.peers[address.String()]
+    if ! ok || time.Now().After(peer.expires) {
+        return 0
+    }
+    return peer.reduction
+}
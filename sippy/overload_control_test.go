@@ -0,0 +1,57 @@
+package sippy
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+func Test_OverloadControl(t *testing.T) {
+    load := 0.9
+    server := NewOverloadControl()
+    server.AddLoadSource(func() float64 { return load })
+
+    // The client has advertised the support
+    req_via := sippy_header.CreateSipVia("SIP/2.0/UDP 1.2.3.4:5060;branch=z9hG4bK1;oc;oc-algo=\"loss\"")[0].(*sippy_header.SipVia)
+    via0, err := req_via.GetBody()
+    if err != nil {
+        t.Fatal(err)
+    }
+    server.updateResponse(via0)
+    s := req_via.StringBody()
+    if ! strings.Contains(s, ";oc=50;oc-algo=\"loss\";oc-validity=500;oc-seq=") {
+        t.Fatalf("unexpected Via: %s", s)
+    }
+
+    client := NewOverloadControl()
+    peer := sippy_net.NewHostPort("1.2.3.4", "5060")
+    resp_via := sippy_header.CreateSipVia(s)[0].(*sippy_header.SipVia)
+    via0, err = resp_via.GetBody()
+    if err != nil {
+        t.Fatal(err)
+    }
+    client.processResponse(peer, via0)
+    if r := client.GetPeerReduction(peer); r != 50 {
+        t.Fatalf("unexpected reduction %d", r)
+    }
+    if ra := client.retryAfter(peer); ra != 1 {
+        t.Fatalf("unexpected Retry-After %d", ra)
+    }
+
+    // The overload is over
+    load = 0.1
+    req_via = sippy_header.CreateSipVia("SIP/2.0/UDP 1.2.3.4:5060;branch=z9hG4bK2;oc")[0].(*sippy_header.SipVia)
+    via0, _ = req_via.GetBody()
+    server.updateResponse(via0)
+    resp_via = sippy_header.CreateSipVia(req_via.StringBody())[0].(*sippy_header.SipVia)
+    via0, _ = resp_via.GetBody()
+    client.processResponse(peer, via0)
+    if r := client.GetPeerReduction(peer); r != 0 {
+        t.Fatalf("unexpected reduction %d", r)
+    }
+    if client.Throttle(peer) {
+        t.Fatal("the request should not be throttled")
+    }
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+const (
+    REFER_SUBS_EXPIRES = 180
+)
+
+// ReferSubscription is the implicit subscription created by REFER
+// (RFC 3515). The call controller gets it from the CCEventDisconnect
+// carrying the Refer-To and reports the progress of the call placed to
+// the transfer target, the transferor is kept informed with the
+// message/sipfrag NOTIFYs. The subscription is terminated by the final
+// response or when it expires. The dialog is kept until the final NOTIFY
+// has been answered and then disconnected. All methods must be called
+// with the session lock of the UA held.
+type ReferSubscription struct {
+    ua          sippy_types.UA
+    config      sippy_conf.Config
+    event       *sippy_header.SipEvent
+    scode       int
+    reason      string
+    terminated  bool
+    expires     time.Time
+    timer       *Timeout
+    final_cseq  int
+}
+
+func newReferSubscription(ua sippy_types.UA, config sippy_conf.Config, req sippy_types.SipRequest) *ReferSubscription {
+    id := ""
+    if cseq, err := req.GetCSeq().GetBody(); err == nil {
+        id = strconv.Itoa(cseq.CSeq)
+    }
+    self := &ReferSubscription{
+        ua      : ua,
+        config  : config,
+        event   : sippy_header.NewSipEvent("refer", id),
+        scode   : 100,
+        reason  : "Trying",
+        expires : time.Now().Add(REFER_SUBS_EXPIRES * time.Second),
+        final_cseq : -1,
+    }
+    self.timer = StartTimeout(self.onExpire, ua.GetSessionLock(), REFER_SUBS_EXPIRES * time.Second, 1, config.ErrorLogger())
+    return self
+}
+
+// referSubRequested returns false if the transferor has asked for no
+// implicit subscription with "Refer-Sub: false" (RFC 4488).
+func referSubRequested(req sippy_types.SipRequest) bool {
+    hf := req.GetFirstHF("refer-sub")
+    if hf == nil {
+        return true
+    }
+    value := strings.SplitN(hf.StringBody(), ";", 2)[0]
+    return strings.ToLower(strings.TrimSpace(value)) != "false"
+}
+
+func (self *ReferSubscription) IsTerminated() bool {
+    return self.terminated
+}
+
+// Progress reports the status line of the response received on the
+// call to the transfer target.
+func (self *ReferSubscription) Progress(scode int, reason string) {
+    if self.terminated {
+        return
+    }
+    self.scode, self.reason = scode, reason
+    if scode >= 200 {
+        self.terminate("noresource")
+    } else {
+        self.notify(sippy_header.SUBSCRIPTION_STATE_ACTIVE, "")
+    }
+}
+
+// RecvEvent reports the progress based on the event received from the
+// call leg placed to the transfer target.
+func (self *ReferSubscription) RecvEvent(_event sippy_types.CCEvent) {
+    switch event := _event.(type) {
+    case *CCEventRing:
+        scode, reason := event.scode, event.scode_reason
+        if scode == 0 {
+            scode, reason = 180, "Ringing"
+        }
+        if scode > 100 {
+            self.Progress(scode, reason)
+        }
+    case *CCEventPreConnect:
+        self.Progress(event.scode, event.scode_reason)
+    case *CCEventConnect:
+        scode, reason := event.scode, event.scode_reason
+        if scode == 0 {
+            scode, reason = 200, "OK"
+        }
+        self.Progress(scode, reason)
+    case *CCEventRedirect:
+        self.Progress(event.scode, event.scode_reason)
+    case *CCEventFail:
+        scode, reason := event.scode, event.scode_reason
+        if scode == 0 {
+            scode, reason = 500, "Failed"
+        }
+        self.Progress(scode, reason)
+    case *CCEventDisconnect:
+        self.Progress(487, "Request Terminated")
+    }
+}
+
+func (self *ReferSubscription) terminate(reason string) {
+    self.terminated = true
+    if self.timer != nil {
+        self.timer.Cancel()
+        self.timer = nil
+    }
+    self.notify(sippy_header.SUBSCRIPTION_STATE_TERMINATED, reason)
+}
+
+func (self *ReferSubscription) notify(state, reason string) {
+    expires := -1
+    if state != sippy_header.SUBSCRIPTION_STATE_TERMINATED {
+        expires = int(time.Until(self.expires).Seconds() + 0.5)
+        if expires < 0 {
+            expires = 0
+        }
+    }
+    body := NewMsgBody("SIP/2.0 " + strconv.Itoa(self.scode) + " " + self.reason + "\r\n", "message/sipfrag;version=2.0")
+    req, err := self.ua.GenRequest("NOTIFY", body, nil, self.event.GetCopy(),
+                    sippy_header.NewSipSubscriptionState(state, expires, reason, -1))
+    if err != nil {
+        self.config.ErrorLogger().Error("ReferSubscription::notify: " + err.Error())
+        if state == sippy_header.SUBSCRIPTION_STATE_TERMINATED {
+            self.disconnect()
+        }
+        return
+    }
+    if state == sippy_header.SUBSCRIPTION_STATE_TERMINATED {
+        if cseq, err := req.GetCSeq().GetBody(); err == nil {
+            self.final_cseq = cseq.CSeq
+        }
+    }
+    self.ua.BeginNewClientTransaction(req, self)
+}
+
+// disconnect sends BYE once the transferor has been notified of the
+// outcome unless the dialog is already over.
+func (self *ReferSubscription) disconnect() {
+    if self.ua.HasActiveReferSubscription() {
+        return
+    }
+    switch self.ua.GetState() {
+    case sippy_types.UA_STATE_CONNECTED, sippy_types.UAC_STATE_UPDATING, sippy_types.UAS_STATE_UPDATING:
+        rtime, _ := sippy_time.NewMonoTime()
+        self.ua.RecvEvent(NewCCEventDisconnect(nil, rtime, self.ua.GetOrigin()))
+    }
+}
+
+func (self *ReferSubscription) onExpire() {
+    self.timer = nil
+    if ! self.terminated {
+        self.terminate("timeout")
+    }
+}
+
+func (self *ReferSubscription) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    scode := resp.GetSCodeNum()
+    if scode < 200 {
+        return
+    }
+    if self.terminated {
+        if cseq, err := resp.GetCSeq().GetBody(); err == nil && cseq.CSeq == self.final_cseq {
+            // The final NOTIFY has been answered
+            self.disconnect()
+        }
+        return
+    }
+    if scode < 300 || scode == 401 || scode == 407 {
+        return
+    }
+    // The transferor is gone, stop notifying
+    self.terminated = true
+    if self.timer != nil {
+        self.timer.Cancel()
+        self.timer = nil
+    }
+    self.disconnect()
+}
+
+// referOutcome reports the final response to the REFER or BYE with Also
+// the transfer has been passed on with to another party. Nothing more is
+// known of the outcome in this case.
+type referOutcome struct {
+    refer_sub   *ReferSubscription
+    next        sippy_types.ResponseReceiver
+}
+
+func (self *referOutcome) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    if resp.GetSCodeNum() >= 200 {
+        self.refer_sub.Progress(resp.GetSCodeNum(), resp.GetSCodeReason())
+    }
+    if self.next != nil {
+        self.next.RecvResponse(resp, tr)
+    }
+}
@@ -0,0 +1,122 @@
+package sippy
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+func expectSipfrag(t *testing.T, tfactory *test_sip_transport_factory, config sippy_conf.Config, sipfrag, state string) sippy_types.SipRequest {
+    notify := expectMsg(t, tfactory, config, "NOTIFY ")
+    if notify.GetBody() == nil || ! strings.HasPrefix(notify.GetBody().GetMtype(), "message/sipfrag") ||
+      ! strings.HasPrefix(notify.GetBody().String(), sipfrag) {
+        t.Fatalf("Expected %q sipfrag in NOTIFY", sipfrag)
+    }
+    ss := getSubscriptionState(t, notify)
+    if ss.State != state {
+        t.Fatalf("Unexpected Subscription-State %q", ss.State)
+    }
+    event := getEvent(notify)
+    if event == nil || event.Package != "refer" {
+        t.Fatal("No Event: refer in NOTIFY")
+    }
+    return notify.(sippy_types.SipRequest)
+}
+
+func Test_ReferSubscription(t *testing.T) {
+    var err error
+
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    cmap := &test_update_call_map{ test_call_map : test_call_map{ config : config } }
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    cmap.sip_tm, err = NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go cmap.sip_tm.Run()
+    defer cmap.sip_tm.Shutdown()
+
+    refer := func(call_id string, refer_sub ...string) *CCEventDisconnect {
+        tfactory.feed(append([]string{
+            "INVITE sip:bob@example.com SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK" + call_id,
+            "Max-Forwards: 70",
+            "From: <sip:alice@example.com>;tag=a1b2c3",
+            "To: <sip:bob@example.com>",
+            "Contact: <sip:alice@1.1.1.1:5060>",
+            "Call-ID: " + call_id + "@example.com",
+            "CSeq: 1 INVITE",
+        }, testSdp("11111")...))
+        expectMsg(t, tfactory, config, "SIP/2.0 100 ")
+        cmap.lock.Lock()
+        cmap.answer()
+        cmap.lock.Unlock()
+        resp := expectMsg(t, tfactory, config, "SIP/2.0 200 ")
+        tfactory.feed([]string{
+            "ACK sip:bob@1.1.1.1 SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK" + call_id + "ack",
+            "Max-Forwards: 70",
+            "From: <sip:alice@example.com>;tag=a1b2c3",
+            resp.GetTo().String(),
+            "Call-ID: " + call_id + "@example.com",
+            "CSeq: 1 ACK",
+            "Content-Length: 0",
+            "",
+            "",
+        })
+        tfactory.feed(append([]string{
+            "REFER sip:bob@1.1.1.1 SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK" + call_id + "ref",
+            "Max-Forwards: 70",
+            "From: <sip:alice@example.com>;tag=a1b2c3",
+            resp.GetTo().String(),
+            "Contact: <sip:alice@1.1.1.1:5060>",
+            "Call-ID: " + call_id + "@example.com",
+            "CSeq: 2 REFER",
+            "Refer-To: <sip:carol@example.com>",
+        }, append(refer_sub, "Content-Length: 0", "", "")...))
+        resp = expectMsg(t, tfactory, config, "SIP/2.0 202 ")
+        event, ok := cmap.event.(*CCEventDisconnect)
+        if ! ok || event.GetRedirectURL() == nil {
+            t.Fatal("No CCEventDisconnect with the Refer-To has been emitted")
+        }
+        if len(refer_sub) > 0 {
+            if hf := resp.GetFirstHF("refer-sub"); hf == nil || hf.StringBody() != "false" {
+                t.Fatal("No Refer-Sub: false in 202 response")
+            }
+            if event.GetReferSubscription() != nil {
+                t.Fatal("Unexpected refer subscription")
+            }
+        }
+        return event
+    }
+    // The progress of the transfer is reported with NOTIFYs
+    event := refer("6f5e4d")
+    expectSipfrag(t, tfactory, config, "SIP/2.0 100 Trying", sippy_header.SUBSCRIPTION_STATE_ACTIVE)
+    rtime := event.GetRtime()
+    cmap.lock.Lock()
+    event.GetReferSubscription().RecvEvent(NewCCEventRing(180, "Ringing", nil, rtime, "callee"))
+    cmap.lock.Unlock()
+    expectSipfrag(t, tfactory, config, "SIP/2.0 180 Ringing", sippy_header.SUBSCRIPTION_STATE_ACTIVE)
+    cmap.lock.Lock()
+    event.GetReferSubscription().RecvEvent(NewCCEventConnect(200, "OK", nil, rtime, "callee"))
+    if cmap.ua.HasActiveReferSubscription() {
+        t.Fatal("The refer subscription has not been terminated")
+    }
+    if cmap.ua.GetState() != sippy_types.UA_STATE_CONNECTED {
+        t.Fatal("The dialog has been disconnected ahead of the final NOTIFY")
+    }
+    cmap.lock.Unlock()
+    notify := expectSipfrag(t, tfactory, config, "SIP/2.0 200 OK", sippy_header.SUBSCRIPTION_STATE_TERMINATED)
+    // The BYE follows the answer to the final NOTIFY
+    tfactory.feed([]string{ notify.GenResponse(200, "OK", nil, nil).LocalStr(nil, false) })
+    expectMsg(t, tfactory, config, "BYE ")
+    // No implicit subscription
+    refer("7a6f5e", "Refer-Sub: false")
+    expectMsg(t, tfactory, config, "BYE ")
+}
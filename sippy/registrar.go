@@ -0,0 +1,363 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+const (
+    REG_DEFAULT_EXPIRES = 3600
+    REG_MIN_EXPIRES = 60
+    REG_MAX_EXPIRES = 7200
+)
+
+// The single Contact registered for the AOR.
+type RegBinding struct {
+    Contact     *sippy_header.SipAddress
+    Expires     time.Time
+    Q           float64
+    CallId      string
+    CSeq        int
+    Path        []*sippy_header.SipRoute
+    Source      *sippy_net.HostPort
+}
+
+func (self *RegBinding) isExpired(now time.Time) bool {
+    return ! now.Before(self.Expires)
+}
+
+// Registrar processes the incoming REGISTER requests (RFC 3261 section
+// 10.3) and keeps the bindings in memory. The call controllers use
+// Lookup() to find where to route the requests to the registered users.
+// The Registrar is the RequestReceiver, the CallMap returns it from the
+// OnNewDialog() for the REGISTER requests.
+type Registrar struct {
+    config          sippy_conf.Config
+    lock            sync.Mutex
+    bindings        map[string][]*RegBinding
+    realm           string
+    algorithm       string
+    auth_cb         func(username string, aor *sippy_header.SipURL) (string, bool)
+    min_expires     int
+    max_expires     int
+    default_expires int
+    service_route   []*sippy_header.SipURL
+}
+
+func NewRegistrar(config sippy_conf.Config) *Registrar {
+    return &Registrar{
+        config          : config,
+        bindings        : make(map[string][]*RegBinding),
+        min_expires     : REG_MIN_EXPIRES,
+        max_expires     : REG_MAX_EXPIRES,
+        default_expires : REG_DEFAULT_EXPIRES,
+    }
+}
+
+// Enable the digest authentication. The callback returns the password
+// of the user and whether the user is allowed to register the AOR.
+// The realm defaults to the host part of the Request-URI.
+func (self *Registrar) SetAuthCb(realm, algorithm string, auth_cb func(string, *sippy_header.SipURL) (string, bool)) {
+    self.realm = realm
+    self.algorithm = algorithm
+    self.auth_cb = auth_cb
+}
+
+func (self *Registrar) SetExpires(min_expires, max_expires, default_expires int) {
+    self.min_expires = min_expires
+    self.max_expires = max_expires
+    self.default_expires = default_expires
+}
+
+// The Service-Route (RFC 3608) returned to the registered UAs.
+func (self *Registrar) SetServiceRoute(service_route []*sippy_header.SipURL) {
+    self.service_route = service_route
+}
+
+func aorKey(url *sippy_header.SipURL) string {
+    scheme := url.Scheme
+    if scheme == "sips" {
+        scheme = "sip"
+    }
+    host := ""
+    if url.Host != nil {
+        host = strings.ToLower(url.Host.String())
+    }
+    return scheme + ":" + url.Username + "@" + host
+}
+
+func contactKey(url *sippy_header.SipURL) string {
+    s := *url
+    s.Other = nil
+    s.Userparams = nil
+    s.Headers = nil
+    s.Transport = strings.ToLower(url.Transport)
+    return s.String()
+}
+
+func (self *Registrar) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) *sippy_types.Ua_context {
+    if req.GetMethod() != "REGISTER" {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(405, "Method Not Allowed", nil, nil) }
+    }
+    resp := self.processRegister(req)
+    return &sippy_types.Ua_context{ Response : resp }
+}
+
+// Verifies the digest credentials of the request. Returns nil if the
+// request is authorized or the response to reject it with otherwise.
+// The callback returns the password of the user and whether the user
+// is allowed to access the url.
+func digestAuthorize(req sippy_types.SipRequest, realm, algorithm string, auth_cb func(string, *sippy_header.SipURL) (string, bool), url *sippy_header.SipURL) sippy_types.SipResponse {
+    if realm == "" {
+        realm = req.GetRURI().Host.String()
+    }
+    if auth := req.GetSipAuthorization(); auth != nil {
+        if body, err := auth.GetBody(); err == nil && body.GetRealm() == realm {
+            if passwd, ok := auth_cb(body.GetUsername(), url); ok {
+                if body.Verify(passwd, req.GetMethod(), "") {
+                    return nil
+                }
+            } else {
+                return req.GenResponse(403, "Forbidden", nil, nil)
+            }
+        }
+    }
+    resp := req.GenResponse(401, "Unauthorized", nil, nil)
+    resp.AppendHeader(sippy_header.NewSipWWWAuthenticateWithRealm(realm, algorithm, req.GetRtime().Monot()))
+    return resp
+}
+
+func (self *Registrar) processRegister(req sippy_types.SipRequest) sippy_types.SipResponse {
+    to, err := req.GetTo().GetBody(self.config)
+    if err != nil {
+        return req.GenResponse(400, "Bad Request", nil, nil)
+    }
+    aor := to.GetUrl()
+    if aor.Scheme != "sip" && aor.Scheme != "sips" {
+        return req.GenResponse(404, "Not Found", nil, nil)
+    }
+    if self.auth_cb != nil {
+        if resp := digestAuthorize(req, self.realm, self.algorithm, self.auth_cb, aor); resp != nil {
+            return resp
+        }
+    }
+    call_id := req.GetCallId().StringBody()
+    cseq, err := req.GetCSeq().GetBody()
+    if err != nil {
+        return req.GenResponse(400, "Bad Request", nil, nil)
+    }
+    expires := self.default_expires
+    if hf, ok := req.GetFirstHF("expires").(*sippy_header.SipExpires); ok {
+        if body, err := hf.GetBody(); err == nil {
+            expires = body.Number
+        }
+    }
+    path := []*sippy_header.SipRoute{}
+    for _, hf := range req.GetHFs("path") {
+        for _, r := range sippy_header.CreateSipRoute(hf.StringBody()) {
+            path = append(path, r.(*sippy_header.SipRoute))
+        }
+    }
+    now := time.Now()
+
+    self.lock.Lock()
+    defer self.lock.Unlock()
+
+    key := aorKey(aor)
+    bindings := self.purge(key, now)
+    contacts := req.GetContacts()
+    if req.HasContactWildcard() {
+        // Wildcard unregistration, section 10.3 step 6
+        if expires != 0 || len(contacts) > 0 {
+            return req.GenResponse(400, "Invalid Wildcard Contact", nil, nil)
+        }
+        for _, b := range bindings {
+            if b.CallId == call_id && b.CSeq >= cseq.CSeq {
+                return req.GenResponse(500, "Out Of Order Request", nil, nil)
+            }
+        }
+        bindings = nil
+    }
+    for _, contact := range contacts {
+        addr, err := contact.GetBody(self.config)
+        if err != nil {
+            return req.GenResponse(400, "Bad Contact", nil, nil)
+        }
+        cexpires := expires
+        if s := addr.GetParam("expires"); s != "" {
+            if cexpires, err = strconv.Atoi(s); err != nil {
+                return req.GenResponse(400, "Bad Contact", nil, nil)
+            }
+        }
+        if cexpires > 0 && cexpires < self.min_expires {
+            resp := req.GenResponse(423, "Interval Too Brief", nil, nil)
+            resp.AppendHeader(sippy_header.NewSipGenericHF("Min-Expires", strconv.Itoa(self.min_expires)))
+            return resp
+        }
+        if cexpires > self.max_expires {
+            cexpires = self.max_expires
+        }
+        ckey := contactKey(addr.GetUrl())
+        idx := -1
+        for i, b := range bindings {
+            if contactKey(b.Contact.GetUrl()) == ckey {
+                idx = i
+                break
+            }
+        }
+        if idx >= 0 {
+            if b := bindings[idx]; b.CallId == call_id && b.CSeq >= cseq.CSeq {
+                return req.GenResponse(500, "Out Of Order Request", nil, nil)
+            }
+            bindings = append(bindings[:idx:idx], bindings[idx + 1:]...)
+        }
+        if cexpires == 0 {
+            continue
+        }
+        baddr := addr.GetCopy()
+        baddr.SetParams(map[string]*string{})
+        bindings = append(bindings, &RegBinding{
+            Contact     : baddr,
+            Expires     : now.Add(time.Duration(cexpires) * time.Second),
+            Q           : addr.GetQ(),
+            CallId      : call_id,
+            CSeq        : cseq.CSeq,
+            Path        : path,
+            Source      : req.GetSource(),
+        })
+    }
+    if len(bindings) > 0 {
+        self.bindings[key] = bindings
+    } else {
+        delete(self.bindings, key)
+    }
+    resp := req.GenResponse(200, "OK", nil, nil)
+    for _, b := range bindings {
+        addr := b.Contact.GetCopy()
+        addr.SetParam("expires", strconv.Itoa(int(b.Expires.Sub(now).Seconds() + 0.5)))
+        if b.Q != 1.0 {
+            addr.SetParam("q", strconv.FormatFloat(b.Q, 'g', -1, 64))
+        }
+        resp.AppendHeader(sippy_header.NewSipContactFromAddress(addr))
+    }
+    for _, hf := range req.GetHFs("path") {
+        resp.AppendHeader(hf.GetCopyAsIface())
+    }
+    for _, url := range self.service_route {
+        resp.AppendHeader(sippy_header.NewSipGenericHF("Service-Route", "<" + url.String() + ">"))
+    }
+    resp.AppendHeader(sippy_header.NewSipDate(now))
+    return resp
+}
+
+// Removes the expired bindings of the AOR. Must be called with the lock
+// held.
+func (self *Registrar) purge(key string, now time.Time) []*RegBinding {
+    bindings, ok := self.bindings[key]
+    if ! ok {
+        return nil
+    }
+    alive := make([]*RegBinding, 0, len(bindings))
+    for _, b := range bindings {
+        if ! b.isExpired(now) {
+            alive = append(alive, b)
+        }
+    }
+    if len(alive) == 0 {
+        delete(self.bindings, key)
+    } else {
+        self.bindings[key] = alive
+    }
+    return alive
+}
+
+// Returns the active bindings of the AOR sorted by q-value in the
+// descending order.
+func (self *Registrar) Lookup(aor *sippy_header.SipURL) []*RegBinding {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    bindings := self.purge(aorKey(aor), time.Now())
+    rval := make([]*RegBinding, len(bindings))
+    copy(rval, bindings)
+    sort.SliceStable(rval, func(i, j int) bool { return rval[i].Q > rval[j].Q })
+    return rval
+}
+
+// The proxy targets for the registered contacts of the Request-URI, so
+// that the Registrar can be used as the stateful proxy target callback:
+//
+//    proxy.SetTargetsCb(registrar.ProxyTargets)
+func (self *Registrar) ProxyTargets(req sippy_types.SipRequest) []*ProxyTarget {
+    rval := []*ProxyTarget{}
+    for _, b := range self.Lookup(req.GetRURI()) {
+        target := &ProxyTarget{
+            RURI    : b.Contact.GetUrl().GetCopy(),
+            Q       : b.Q,
+        }
+        for _, r := range b.Path {
+            target.Routes = append(target.Routes, r.GetCopy())
+        }
+        rval = append(rval, target)
+    }
+    return rval
+}
+
+// Removes all bindings of the AOR.
+func (self *Registrar) Unregister(aor *sippy_header.SipURL) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    delete(self.bindings, aorKey(aor))
+}
+
+// Removes the expired bindings of all AORs.
+func (self *Registrar) Purge() {
+    now := time.Now()
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    for key := range self.bindings {
+        self.purge(key, now)
+    }
+}
+
+func (self *Registrar) NumBindings() int {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    rval := 0
+    for _, bindings := range self.bindings {
+        rval += len(bindings)
+    }
+    return rval
+}
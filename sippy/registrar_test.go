@@ -0,0 +1,63 @@
+package sippy
+
+import (
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/time"
+)
+
+func Test_Registrar(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    registrar := NewRegistrar(config)
+    cseq := 0
+    register := func(hdrs ...string) int {
+        cseq++
+        lines := []string{
+            "REGISTER sip:example.com SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK" + strings.Repeat("a", cseq),
+            "From: <sip:alice@example.com>;tag=as57b03f0f",
+            "To: <sip:alice@example.com>",
+            "Call-ID: 7e3e4c7d9d8c6a3a@example.com",
+            "CSeq: " + strconv.Itoa(cseq) + " REGISTER",
+        }
+        lines = append(lines, hdrs...)
+        lines = append(lines, "Content-Length: 0", "", "")
+        rtime, _ := sippy_time.NewMonoTime()
+        req, err := ParseSipRequest([]byte(strings.Join(lines, "\r\n")), rtime, config)
+        if err != nil {
+            t.Fatal("Cannot parse REGISTER: " + err.Error())
+        }
+        return registrar.RecvRequest(req, nil).Response.GetSCodeNum()
+    }
+    aor, _ := sippy_header.ParseSipURL("sip:alice@example.com", false, config)
+    if scode := register("Contact: <sip:alice@1.1.1.1:5060>;q=0.5", "Contact: <sip:alice@2.2.2.2:5060>;q=0.9"); scode != 200 {
+        t.Fatalf("Expected 200, got %d", scode)
+    }
+    bindings := registrar.Lookup(aor)
+    if len(bindings) != 2 || bindings[0].Contact.GetUrl().Host.String() != "2.2.2.2" {
+        t.Fatal("The bindings should be sorted by q-value")
+    }
+    if scode := register("Contact: <sip:alice@1.1.1.1:5060>;expires=10"); scode != 423 {
+        t.Fatalf("Expected 423, got %d", scode)
+    }
+    if scode := register("Contact: <sip:alice@1.1.1.1:5060>;expires=0"); scode != 200 || len(registrar.Lookup(aor)) != 1 {
+        t.Fatal("The binding has not been removed")
+    }
+    if scode := register("Contact: *"); scode != 400 {
+        t.Fatalf("Expected 400 for the wildcard without Expires: 0, got %d", scode)
+    }
+    if scode := register("Contact: *", "Expires: 0"); scode != 200 || len(registrar.Lookup(aor)) != 0 {
+        t.Fatal("The wildcard has not removed the bindings")
+    }
+    registrar.SetAuthCb("example.com", "", func(username string, aor *sippy_header.SipURL) (string, bool) {
+        return "secret", username == aor.Username
+    })
+    if scode := register("Contact: <sip:alice@1.1.1.1:5060>"); scode != 401 {
+        t.Fatalf("Expected 401, got %d", scode)
+    }
+}
@@ -0,0 +1,115 @@
+package sippy
+
+import (
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+type test_naptr_dns_client struct {
+}
+
+func (self *test_naptr_dns_client) LookupNAPTR(name string) ([]*sippy_net.NAPTR, error) {
+    if name != "example.com" {
+        return nil, nil
+    }
+    return []*sippy_net.NAPTR{
+        { Order : 10, Preference : 10, Flags : "s", Service : "SIP+D2T", Replacement : "_sip._tcp.example.com" },
+    }, nil
+}
+
+func (self *test_naptr_dns_client) LookupSRV(name string) ([]*net.SRV, error) {
+    if name != "_sip._tcp.example.com" {
+        return nil, nil
+    }
+    return []*net.SRV{ { Target : "tcp.example.com.", Port : 5070, Priority : 10, Weight : 1 } }, nil
+}
+
+func (self *test_naptr_dns_client) LookupIP(host string) ([]net.IP, error) {
+    if host != "tcp.example.com" {
+        return nil, &net.DNSError{ Err : "no such host", Name : host, IsNotFound : true }
+    }
+    return []net.IP{ net.ParseIP("192.0.2.1") }, nil
+}
+
+type test_tcp_transport struct {
+    laddress    *sippy_net.HostPort
+    dest_ch     chan *sippy_net.HostPort
+    data_ch     chan []byte
+}
+
+func (self *test_tcp_transport) NewSipTransport(addr *sippy_net.HostPort, recv_cb sippy_net.DataPacketReceiver) (sippy_net.Transport, error) {
+    return self, nil
+}
+
+func (self *test_tcp_transport) GetLAddress() *sippy_net.HostPort {
+    return self.laddress
+}
+
+func (self *test_tcp_transport) SendTo(data []byte, dest *sippy_net.HostPort) {
+    self.SendToWithCb(data, dest, nil)
+}
+
+func (self *test_tcp_transport) SendToWithCb(data []byte, dest *sippy_net.HostPort, cb func()) {
+    self.dest_ch <- dest
+    self.data_ch <- data
+}
+
+func (self *test_tcp_transport) Shutdown() {
+}
+
+func (self *test_tcp_transport) GetProto() string {
+    return "TCP"
+}
+
+func Test_Rfc3263Transport(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(config.GetMyAddress())
+    config.SetSipPort(config.GetMyPort())
+    config.SetSipTransportFactory(NewTestSipTransportFactory())
+    ttransport := &test_tcp_transport{
+        laddress    : sippy_net.NewHostPort("0.0.0.0", "5060"),
+        dest_ch     : make(chan *sippy_net.HostPort, 10),
+        data_ch     : make(chan []byte, 10),
+    }
+    config.SetSipTransportFactoryByProto("TCP", ttransport)
+    resolver := sippy_net.NewResolver(&test_naptr_dns_client{})
+    resolver.SetTransports([]string{ "UDP", "TCP" })
+    config.SetResolver(resolver)
+    sip_tm, err := NewSipTransactionManager(config, NewTestCallMap(config))
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+    ruri, _ := sippy_header.ParseSipURL("sip:bob@example.com", false, config)
+    req, err := NewSipRequest("OPTIONS", ruri, "", nil, nil, nil, 1, nil, nil, nil, nil, nil, nil, nil, nil, config)
+    if err != nil {
+        t.Fatal("Cannot create request: " + err.Error())
+    }
+    if req.GetTarget().Lookup == nil {
+        t.Fatal("The lookup should not be done synchronously")
+    }
+    lock := &sync.Mutex{}
+    lock.Lock()
+    sip_tm.BeginNewClientTransaction(req, nil, lock, nil, nil, nil)
+    lock.Unlock()
+    select {
+    case dest := <-ttransport.dest_ch:
+        if dest.String() != "192.0.2.1:5070" || dest.ServerName != "example.com" {
+            t.Fatalf("unexpected destination: %s (%s)", dest.String(), dest.ServerName)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("The request has not been sent over TCP")
+    }
+    if data := string(<-ttransport.data_ch); ! strings.Contains(data, "Via: SIP/2.0/TCP ") {
+        t.Fatalf("bad Via in the request: %q", data)
+    }
+}
@@ -371,9 +371,12 @@ func (self *serverTransaction) SendResponseWithLossEmul(resp sippy_types.SipResp
                     self.prov_inflight_lock.Unlock()
                 }
             }
-            // Install retransmit timer if necessary
-            self.tout = time.Duration(0.5 * float64(time.Second))
-            self.startTeA()
+            // Install retransmit timer if necessary, 2xx has to be
+            // retransmitted even over the reliable transports
+            if resp.GetSCodeNum() < 300 || ! sippy_net.IsReliable(self.userv) {
+                self.tout = time.Duration(0.5 * float64(time.Second))
+                self.startTeA()
+            }
         } else {
             // We have done with the transaction
             sip_tm.tserver_del(self.tid)
@@ -384,6 +387,7 @@ func (self *serverTransaction) SendResponseWithLossEmul(resp sippy_types.SipResp
         self.before_response_sent(resp)
     }
     sip_tm.transmitData(self.userv, self.data, self.address, self.checksum, self.tid.CallId, lossemul)
+    sip_tm.countResponse(resp.GetSCodeNum(), &sip_tm.resp_sent)
     if need_cleanup {
         self.cleanup()
     }
@@ -409,6 +413,12 @@ func (self *serverTransaction) Unlock() {
     }
 }
 
+func (self *serverTransaction) getInfo() *sippy_types.TransactionInfo {
+    self.Lock()
+    defer self.Unlock()
+    return self.baseTransaction.getInfo(self.method, true)
+}
+
 func (self *serverTransaction) UpgradeToSessionLock(session_lock sync.Locker) {
     // Must be called with the self.lock already locked!
     // Must be called once only!
@@ -442,6 +452,7 @@ func (self *serverTransaction) retrUasResponse(last_timeout time.Duration, losse
     if sip_tm := self.sip_tm; sip_tm != nil {
         if lossemul == 0 {
             sip_tm.transmitData(self.userv, self.data, self.address, "" /*checksum*/, self.tid.CallId, 0 /*lossemul*/)
+            sip_tm.countRetransmit()
         } else {
             lossemul -= 1
         }
@@ -0,0 +1,344 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+const (
+    SESSION_MIN_SE          = 90 * time.Second // the lowest Min-SE allowed by RFC 4028
+    SESSION_REFRESH_RETRY   = time.Second
+    SESSION_GLARE_RETRY     = 3 * time.Second
+)
+
+// sessionTimer implements the RFC 4028 session timers on behalf of the UA.
+// Depending on the negotiated refresher role it either refreshes the
+// session with re-INVITE (or UPDATE if the remote side allows it) every
+// half of the session interval or tears the dialog down when the remote
+// refresher fails to refresh the session in time.
+type sessionTimer struct {
+    ua              sippy_types.UA
+    session_expires time.Duration
+    min_se          time.Duration
+    interval        time.Duration
+    refresher       bool
+    pending         *sippy_header.SipSessionExpires
+    timer           *Timeout
+    tr              sippy_types.ClientTransaction
+    triedauth       bool
+    logger          sippy_log.ErrorLogger
+}
+
+func newSessionTimer(ua sippy_types.UA, session_expires, min_se time.Duration, logger sippy_log.ErrorLogger) *sessionTimer {
+    if session_expires <= 0 {
+        return nil
+    }
+    if min_se < SESSION_MIN_SE {
+        min_se = SESSION_MIN_SE
+    }
+    if session_expires < min_se {
+        session_expires = min_se
+    }
+    return &sessionTimer{
+        ua              : ua,
+        session_expires : session_expires,
+        min_se          : min_se,
+        logger          : logger,
+    }
+}
+
+func getSessionExpires(msg sippy_types.SipMsg) *sippy_header.SipSessionExpiresBody {
+    if hf, ok := msg.GetFirstHF("session-expires").(*sippy_header.SipSessionExpires); ok {
+        if body, err := hf.GetBody(); err == nil {
+            return body
+        }
+    }
+    return nil
+}
+
+func getMinSE(msg sippy_types.SipMsg) time.Duration {
+    if hf, ok := msg.GetFirstHF("min-se").(*sippy_header.SipMinSE); ok {
+        if body, err := hf.GetBody(); err == nil {
+            return time.Duration(body.Number) * time.Second
+        }
+    }
+    return 0
+}
+
+func supportsTimer(msg sippy_types.SipMsg) bool {
+    for _, supported := range msg.GetSipSupported() {
+        if supported.HasTag("timer") {
+            return true
+        }
+    }
+    return false
+}
+
+// updateRequest adds the session timer headers to an outgoing INVITE or
+// UPDATE. Once the session interval has been negotiated the request
+// confirms the current refresher, otherwise the choice is left to the UAS.
+func (self *sessionTimer) updateRequest(req sippy_types.SipRequest) {
+    delta, refresher := self.session_expires, ""
+    if self.interval > 0 {
+        delta = self.interval
+        if self.refresher {
+            refresher = sippy_header.SESSION_REFRESHER_UAC
+        } else {
+            refresher = sippy_header.SESSION_REFRESHER_UAS
+        }
+    }
+    req.AppendHeader(sippy_header.CreateSipSupported("timer")[0])
+    req.AppendHeader(sippy_header.NewSipSessionExpires(int(delta / time.Second), refresher))
+    req.AppendHeader(sippy_header.NewSipMinSE(int(self.min_se / time.Second)))
+}
+
+// recvRequest negotiates the session interval and the refresher for the
+// incoming INVITE or UPDATE. The result is applied when the 2xx response
+// is sent. The 422 response is returned if the requested interval is
+// below our Min-SE.
+func (self *sessionTimer) recvRequest(req sippy_types.SipRequest) sippy_types.SipResponse {
+    self.pending = nil
+    min_se := self.min_se
+    if req_min_se := getMinSE(req); req_min_se > min_se {
+        min_se = req_min_se
+    }
+    se := getSessionExpires(req)
+    if se == nil {
+        // The UAC does not ask for the session timer, we can still
+        // run it on our own as the refresher.
+        delta := self.session_expires
+        if delta < min_se {
+            delta = min_se
+        }
+        self.pending = sippy_header.NewSipSessionExpires(int(delta / time.Second), sippy_header.SESSION_REFRESHER_UAS)
+        return nil
+    }
+    if time.Duration(se.Delta) * time.Second < self.min_se {
+        resp := req.GenResponse(422, "Session Interval Too Small", nil, self.ua.GetLocalUA().AsSipServer())
+        resp.AppendHeader(sippy_header.NewSipMinSE(int(self.min_se / time.Second)))
+        return resp
+    }
+    delta := se.Delta
+    if self.session_expires < time.Duration(delta) * time.Second && self.session_expires >= min_se {
+        delta = int(self.session_expires / time.Second)
+    }
+    refresher := se.Refresher
+    if ! supportsTimer(req) {
+        // The Session-Expires has been inserted by a proxy, the UAC is
+        // not able to refresh.
+        refresher = sippy_header.SESSION_REFRESHER_UAS
+    } else if refresher != sippy_header.SESSION_REFRESHER_UAC && refresher != sippy_header.SESSION_REFRESHER_UAS {
+        refresher = sippy_header.SESSION_REFRESHER_UAC
+    }
+    self.pending = sippy_header.NewSipSessionExpires(delta, refresher)
+    return nil
+}
+
+// updateResponse completes the negotiation started by recvRequest.
+func (self *sessionTimer) updateResponse(resp sippy_types.SipResponse) {
+    pending := self.pending
+    self.pending = nil
+    if pending == nil || resp.GetSCodeNum() >= 300 {
+        return
+    }
+    se, err := pending.GetBody()
+    if err != nil {
+        return
+    }
+    resp.AppendHeader(pending)
+    if se.Refresher == sippy_header.SESSION_REFRESHER_UAC {
+        resp.AppendHeader(sippy_header.CreateSipRequire("timer")[0])
+    }
+    self.start(se.Delta, se.Refresher == sippy_header.SESSION_REFRESHER_UAS)
+}
+
+// recvResponse applies the session interval from the 2xx response to our
+// INVITE or UPDATE.
+func (self *sessionTimer) recvResponse(resp sippy_types.SipResponse) {
+    se := getSessionExpires(resp)
+    if se == nil {
+        // The UAS does not support the session timer, there is no
+        // session expiration.
+        self.cancelTimer()
+        self.interval = 0
+        return
+    }
+    self.start(se.Delta, se.Refresher != sippy_header.SESSION_REFRESHER_UAS)
+}
+
+// recv422 raises our session interval to the Min-SE of the 422 response.
+// Returns false if the request should not be retried.
+func (self *sessionTimer) recv422(resp sippy_types.SipResponse) bool {
+    min_se := getMinSE(resp)
+    if min_se <= self.session_expires {
+        return false
+    }
+    self.min_se = min_se
+    self.session_expires = min_se
+    if self.interval > 0 && self.interval < min_se {
+        self.interval = min_se
+    }
+    return true
+}
+
+func (self *sessionTimer) isOurs(tr sippy_types.ClientTransaction) bool {
+    return self.tr != nil && self.tr == tr
+}
+
+// RecvResponse handles the responses to the session refresh requests.
+func (self *sessionTimer) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    code := resp.GetSCodeNum()
+    if code < 200 {
+        return
+    }
+    self.tr = nil
+    cseq, err := resp.GetCSeq().GetBody()
+    if err != nil {
+        self.logger.Error("sessionTimer::RecvResponse: cannot parse CSeq: " + err.Error())
+        return
+    }
+    switch {
+    case code >= 200 && code < 300:
+        self.recvResponse(resp)
+    case (code == 401 || code == 407) && ! self.triedauth && self.ua.GetUsername() != "" && self.ua.GetPassword() != "":
+        var challenge sippy_types.Challenge
+        if code == 401 && len(resp.GetSipWWWAuthenticates()) > 0 {
+            challenge = resp.GetSipWWWAuthenticates()[0]
+        } else if code == 407 && len(resp.GetSipProxyAuthenticates()) > 0 {
+            challenge = resp.GetSipProxyAuthenticates()[0]
+        }
+        if challenge == nil {
+            self.expireLater()
+            return
+        }
+        self.triedauth = true
+        self.sendRefresh(cseq.Method, challenge)
+    case code == 422 && self.recv422(resp):
+        self.sendRefresh(cseq.Method, nil)
+    case code == 408 || code == 481:
+        // RFC 4028 section 10: the session is gone
+        self.ua.Disconnect(nil, "")
+    case code == 491:
+        self.timer = StartTimeoutWithSpread(self.refresh, self.ua.GetSessionLock(), SESSION_GLARE_RETRY, 1, self.logger, 0.3)
+    default:
+        self.expireLater()
+    }
+}
+
+func (self *sessionTimer) margin() time.Duration {
+    margin := self.interval / 3
+    if margin > 32 * time.Second {
+        margin = 32 * time.Second
+    }
+    return margin
+}
+
+func (self *sessionTimer) start(delta int, refresher bool) {
+    self.cancelTimer()
+    self.interval = time.Duration(delta) * time.Second
+    self.refresher = refresher
+    if refresher {
+        self.timer = StartTimeout(self.refresh, self.ua.GetSessionLock(), self.interval / 2, 1, self.logger)
+    } else {
+        self.timer = StartTimeout(self.expire, self.ua.GetSessionLock(), self.interval - self.margin(), 1, self.logger)
+    }
+}
+
+// expireLater schedules the BYE at the session expiration after the
+// failed refresh. The refresh is sent at the half of the interval.
+func (self *sessionTimer) expireLater() {
+    self.timer = StartTimeout(self.expire, self.ua.GetSessionLock(), self.interval / 2 - self.margin(), 1, self.logger)
+}
+
+func (self *sessionTimer) refresh() {
+    self.timer = nil
+    switch self.ua.GetState() {
+    case sippy_types.UA_STATE_CONNECTED:
+    case sippy_types.UA_STATE_DISCONNECTED, sippy_types.UA_STATE_FAILED, sippy_types.UA_STATE_DEAD:
+        return
+    default:
+        // Some other transaction is in progress, try again a bit later
+        self.timer = StartTimeout(self.refresh, self.ua.GetSessionLock(), SESSION_REFRESH_RETRY, 1, self.logger)
+        return
+    }
+    self.triedauth = false
+    if self.ua.RemoteAllows("UPDATE") {
+        self.sendRefresh("UPDATE", nil)
+    } else {
+        self.sendRefresh("INVITE", nil)
+    }
+}
+
+func (self *sessionTimer) sendRefresh(method string, challenge sippy_types.Challenge) {
+    var body sippy_types.MsgBody
+
+    if method == "INVITE" {
+        body = self.ua.GetLSDP()
+    }
+    req, err := self.ua.GenRequest(method, body, challenge)
+    if err != nil {
+        self.logger.Error("Cannot create " + method + ": " + err.Error())
+        return
+    }
+    self.tr, err = self.ua.PrepTr(req, nil)
+    if err != nil {
+        self.logger.Error("Cannot prepare " + method + " transaction: " + err.Error())
+        self.tr = nil
+        return
+    }
+    self.ua.BeginClientTransaction(req, self.tr)
+}
+
+func (self *sessionTimer) expire() {
+    self.timer = nil
+    switch self.ua.GetState() {
+    case sippy_types.UA_STATE_DISCONNECTED, sippy_types.UA_STATE_FAILED, sippy_types.UA_STATE_DEAD:
+        return
+    }
+    self.ua.Disconnect(nil, "")
+}
+
+func (self *sessionTimer) cancelTimer() {
+    if self.timer != nil {
+        self.timer.Cancel()
+        self.timer = nil
+    }
+}
+
+func (self *sessionTimer) Stop() {
+    self.cancelTimer()
+    if tr := self.tr; tr != nil {
+        tr.Cancel()
+        self.tr = nil
+    }
+    self.interval = 0
+    self.pending = nil
+}
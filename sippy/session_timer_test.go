@@ -0,0 +1,125 @@
+package sippy
+
+import (
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+type test_st_call_map struct {
+    test_call_map
+}
+
+func (self *test_st_call_map) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.ServerTransaction) (sippy_types.UA, sippy_types.RequestReceiver, sippy_types.SipResponse) {
+    self.ua = NewUA(self.sip_tm, self.config, sippy_net.NewHostPort("1.1.1.1", "5060"), self, &self.lock, nil)
+    self.ua.SetSessionTimer(600 * time.Second, 90 * time.Second)
+    self.msg_body = req.GetBody()
+    return self.ua, self.ua, nil
+}
+
+func getSessionExpiresHF(t *testing.T, msg sippy_types.SipMsg) *sippy_header.SipSessionExpiresBody {
+    se := getSessionExpires(msg)
+    if se == nil {
+        t.Fatal("No valid Session-Expires in the message")
+    }
+    return se
+}
+
+func Test_SessionTimer(t *testing.T) {
+    var err error
+
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    cmap := &test_st_call_map{ test_call_map{ config : config } }
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    cmap.sip_tm, err = NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go cmap.sip_tm.Run()
+    defer cmap.sip_tm.Shutdown()
+
+    invite := func(call_id, se string) {
+        tfactory.feed([]string{
+            "INVITE sip:bob@example.com SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK" + call_id,
+            "Max-Forwards: 70",
+            "From: <sip:alice@example.com>;tag=a1b2c3",
+            "To: <sip:bob@example.com>",
+            "Contact: <sip:alice@1.1.1.1:5060>",
+            "Call-ID: " + call_id + "@example.com",
+            "CSeq: 1 INVITE",
+            "Supported: timer",
+            "Session-Expires: " + se,
+            "Min-SE: 90",
+            "Allow: INVITE, ACK, CANCEL, BYE, UPDATE",
+            "Content-Length: 0",
+            "",
+            "",
+        })
+    }
+    // The session interval is lowered to ours, the UAC does the refreshes
+    invite("3c2b1a", "1800")
+    expectMsg(t, tfactory, config, "SIP/2.0 100 ")
+    cmap.lock.Lock()
+    cmap.answer()
+    cmap.lock.Unlock()
+    resp := expectMsg(t, tfactory, config, "SIP/2.0 200 ")
+    se := getSessionExpiresHF(t, resp)
+    if se.Delta != 600 || se.Refresher != sippy_header.SESSION_REFRESHER_UAC {
+        t.Fatalf("Unexpected Session-Expires in 200 OK: %s", se.String())
+    }
+    if len(resp.GetSipRequire()) == 0 || ! resp.GetSipRequire()[0].HasTag("timer") {
+        t.Fatal("No Require: timer in 200 OK")
+    }
+    tfactory.feed([]string{
+        "ACK sip:bob@1.1.1.1 SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK3c2b1aack",
+        "Max-Forwards: 70",
+        "From: <sip:alice@example.com>;tag=a1b2c3",
+        resp.GetTo().String(),
+        "Call-ID: 3c2b1a@example.com",
+        "CSeq: 1 ACK",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    // The refresh UPDATE hands the refresher role over to us
+    tfactory.feed([]string{
+        "UPDATE sip:bob@1.1.1.1 SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK3c2b1aupd",
+        "Max-Forwards: 70",
+        "From: <sip:alice@example.com>;tag=a1b2c3",
+        resp.GetTo().String(),
+        "Contact: <sip:alice@1.1.1.1:5060>",
+        "Call-ID: 3c2b1a@example.com",
+        "CSeq: 2 UPDATE",
+        "Supported: timer",
+        "Session-Expires: 600;refresher=uas",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    resp = expectMsg(t, tfactory, config, "SIP/2.0 200 ")
+    se = getSessionExpiresHF(t, resp)
+    if se.Delta != 600 || se.Refresher != sippy_header.SESSION_REFRESHER_UAS {
+        t.Fatalf("Unexpected Session-Expires in 200 OK to UPDATE: %s", se.String())
+    }
+    cmap.lock.Lock()
+    st := cmap.ua.(*Ua).session_timer
+    if ! st.refresher || st.interval != 600 * time.Second {
+        t.Fatal("The refresher role has not been taken over")
+    }
+    cmap.lock.Unlock()
+    // Too small session interval
+    invite("4d3c2b", "60")
+    resp = expectMsg(t, tfactory, config, "SIP/2.0 422 ")
+    if getMinSE(resp) != 90 * time.Second {
+        t.Fatal("No valid Min-SE in 422 response")
+    }
+}
@@ -77,6 +77,15 @@ var sip_header_name_map = map[string]func(body string) ([]sippy_header.SipHeader
     "require"           : sippy_header.CreateSipRequire,
     "supported"         : sippy_header.CreateSipSupported,
     "date"              : sippy_header.CreateSipDate,
+    "event"             : sippy_header.CreateSipEvent,
+    "o"                 : sippy_header.CreateSipEvent,
+    "allow-events"      : sippy_header.CreateSipAllowEvents,
+    "u"                 : sippy_header.CreateSipAllowEvents,
+    "subscription-state": sippy_header.CreateSipSubscriptionState,
+    "session-expires"   : sippy_header.CreateSipSessionExpires,
+    "x"                 : sippy_header.CreateSipSessionExpires,
+    "min-se"            : sippy_header.CreateSipMinSE,
+    "retry-after"       : sippy_header.CreateSipRetryAfter,
 }
 
 func ParseSipHeader(s string) ([]sippy_header.SipHeader, sippy_types.SipHandlingError) {
@@ -47,6 +47,7 @@ type sipMsg struct {
     startline           string
     vias                []*sippy_header.SipVia
     contacts            []*sippy_header.SipContact
+    contact_wildcard    bool
     to                  *sippy_header.SipTo
     from                *sippy_header.SipFrom
     cseq                *sippy_header.SipCSeq
@@ -56,6 +57,7 @@ type sipMsg struct {
     content_type        *sippy_header.SipContentType
     call_id             *sippy_header.SipCallId
     refer_to            *sippy_header.SipReferTo
+    replaces            *sippy_header.SipReplaces
     maxforwards         *sippy_header.SipMaxForwards
     also                []*sippy_header.SipAlso
     rtime               *sippy_time.MonoTime
@@ -141,6 +143,7 @@ func ParseSipMsg(_buf []byte, rtime *sippy_time.MonoTime, config sippy_conf.Conf
         for _, header := range headers {
             if contact, ok := header.(*sippy_header.SipContact); ok {
                 if contact.Asterisk {
+                    self.contact_wildcard = true
                     continue
                 }
             }
@@ -233,6 +236,7 @@ func (self *sipMsg) AppendHeader(hdr sippy_header.SipHeader) {
         self.sip_authorization = nil
         return
     case *sippy_header.SipReplaces:
+        self.replaces = t
     case *sippy_header.SipReason:
         self.reason_hf  = t
     case *sippy_header.SipWarning:
@@ -572,6 +576,10 @@ func (self *sipMsg) GetReferTo() *sippy_header.SipReferTo {
     return self.refer_to
 }
 
+func (self *sipMsg) GetReplaces() *sippy_header.SipReplaces {
+    return self.replaces
+}
+
 func (self *sipMsg) GetRtime() *sippy_time.MonoTime {
     return self.rtime
 }
@@ -584,10 +592,32 @@ func (self *sipMsg) GetContacts() []*sippy_header.SipContact {
     return self.contacts
 }
 
+// Returns true if the message has the "Contact: *" header, it is not
+// present in GetContacts().
+func (self *sipMsg) HasContactWildcard() bool {
+    return self.contact_wildcard
+}
+
 func (self *sipMsg) GetRecordRoutes() []*sippy_header.SipRecordRoute {
     return self.record_routes
 }
 
+// Insert the Record-Route above all existing ones.
+func (self *sipMsg) InsertFirstRecordRoute(rr *sippy_header.SipRecordRoute) {
+    self.record_routes = append([]*sippy_header.SipRecordRoute{ rr }, self.record_routes...)
+    for i, hf := range self.headers {
+        if _, ok := hf.(*sippy_header.SipRecordRoute); ok {
+            self.headers = append(self.headers[:i], append([]sippy_header.SipHeader{ rr }, self.headers[i:]...)...)
+            return
+        }
+    }
+    self.headers = append(self.headers, rr)
+}
+
+func (self *sipMsg) GetRoutes() []*sippy_header.SipRoute {
+    return self.routes
+}
+
 func (self *sipMsg) GetCGUID() *sippy_header.SipCiscoGUID {
     return self.sip_cisco_guid
 }
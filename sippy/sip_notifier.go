@@ -0,0 +1,364 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "errors"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+// SipNotifier is the notifier side of the SUBSCRIBE/NOTIFY dialog usage
+// (RFC 6665). The CallMap returns the new notifier from OnNewDialog()
+// for the initial SUBSCRIBE. The subscribe callback is invoked for the
+// initial and for every refreshing SUBSCRIBE, it returns the response
+// code (200 or 202 to accept, any final code to reject) and is expected
+// to send the current state with Notify(). When it does not the
+// notifier sends the NOTIFY with the last body itself, as the NOTIFY is
+// mandatory after each SUBSCRIBE. All methods must be called with the
+// Lock held, the callbacks are invoked with the Lock held.
+type SipNotifier struct {
+    Lock            sync.Mutex
+    config          sippy_conf.Config
+    sip_tm          sippy_types.SipTransactionManager
+    smap            *SubscriptionMap
+    dlg             *sipDialog
+    event           *sippy_header.SipEvent
+    state           string
+    last_body       sippy_types.MsgBody
+    notified        bool
+    held            []sippy_types.SipRequest
+    hold            bool
+    terminated      bool
+    expires         time.Time
+    timer           *Timeout
+    subscribe_cb    func(*SipNotifier, sippy_types.SipRequest) (int, string)
+    term_cb         func(string)
+    min_expires     int
+    max_expires     int
+    default_expires int
+    realm           string
+    algorithm       string
+    auth_cb         func(string, *sippy_header.SipURL) (string, bool)
+}
+
+func NewSipNotifier(config sippy_conf.Config, sip_tm sippy_types.SipTransactionManager, smap *SubscriptionMap, subscribe_cb func(*SipNotifier, sippy_types.SipRequest) (int, string)) *SipNotifier {
+    return &SipNotifier{
+        config          : config,
+        sip_tm          : sip_tm,
+        smap            : smap,
+        subscribe_cb    : subscribe_cb,
+        min_expires     : SUBS_MIN_EXPIRES,
+        max_expires     : SUBS_MAX_EXPIRES,
+        default_expires : SUBS_DEFAULT_EXPIRES,
+    }
+}
+
+// SetTermCb sets the callback invoked once the subscription has been
+// terminated by either side.
+func (self *SipNotifier) SetTermCb(term_cb func(string)) {
+    self.term_cb = term_cb
+}
+
+func (self *SipNotifier) SetExpires(min_expires, max_expires, default_expires int) {
+    self.min_expires = min_expires
+    self.max_expires = max_expires
+    self.default_expires = default_expires
+}
+
+// Enable the digest authentication of the SUBSCRIBE requests. The
+// callback returns the password of the user and whether the user is
+// allowed to subscribe to the Request-URI.
+func (self *SipNotifier) SetAuthCb(realm, algorithm string, auth_cb func(string, *sippy_header.SipURL) (string, bool)) {
+    self.realm = realm
+    self.algorithm = algorithm
+    self.auth_cb = auth_cb
+}
+
+// GetEvent returns the Event of the subscription.
+func (self *SipNotifier) GetEvent() *sippy_header.SipEvent {
+    return self.event
+}
+
+func (self *SipNotifier) GetState() string {
+    return self.state
+}
+
+func (self *SipNotifier) IsTerminated() bool {
+    return self.terminated
+}
+
+// GetRemote returns the address of the subscriber.
+func (self *SipNotifier) GetRemote() *sippy_header.SipAddress {
+    if self.dlg == nil {
+        return nil
+    }
+    return self.dlg.remote
+}
+
+// Notify sends the NOTIFY with the given Subscription-State. The reason
+// is only included into the "terminated" state, the subscription is
+// terminated as soon as it has been sent.
+func (self *SipNotifier) Notify(state string, body sippy_types.MsgBody, reason string) error {
+    if self.dlg == nil || self.terminated {
+        return errors.New("the subscription is not active")
+    }
+    expires := -1
+    if state == sippy_header.SUBSCRIPTION_STATE_TERMINATED {
+        if reason == "" {
+            reason = "noresource"
+        }
+    } else {
+        reason = ""
+        expires = int(time.Until(self.expires).Seconds() + 0.5)
+        if expires < 0 {
+            expires = 0
+        }
+    }
+    var req_body sippy_types.MsgBody
+    if body != nil {
+        req_body = body.GetCopy()
+    }
+    req, err := self.dlg.genRequest("NOTIFY", req_body, nil, self.event.GetCopy(),
+                    sippy_header.NewSipSubscriptionState(state, expires, reason, -1))
+    if err != nil {
+        return err
+    }
+    if self.hold {
+        // The initial SUBSCRIBE has not been answered yet
+        self.held = append(self.held, req)
+    } else {
+        self.sip_tm.BeginNewClientTransaction(req, self, &self.Lock, nil, nil, nil)
+    }
+    self.state = state
+    self.last_body = body
+    self.notified = true
+    if state == sippy_header.SUBSCRIPTION_STATE_TERMINATED {
+        self.terminate(reason)
+    }
+    return nil
+}
+
+// Terminate ends the subscription with the final NOTIFY.
+func (self *SipNotifier) Terminate(reason string) {
+    if ! self.terminated {
+        self.Notify(sippy_header.SUBSCRIPTION_STATE_TERMINATED, self.last_body, reason)
+    }
+}
+
+func (self *SipNotifier) terminate(reason string) {
+    if self.terminated {
+        return
+    }
+    self.terminated = true
+    self.state = sippy_header.SUBSCRIPTION_STATE_TERMINATED
+    if self.timer != nil {
+        self.timer.Cancel()
+        self.timer = nil
+    }
+    if self.dlg != nil {
+        self.smap.remove(self.dlg.key())
+    }
+    if self.term_cb != nil {
+        self.term_cb(reason)
+    }
+}
+
+func (self *SipNotifier) setExpires(expires int) {
+    if self.timer != nil {
+        self.timer.Cancel()
+    }
+    self.expires = time.Now().Add(time.Duration(expires) * time.Second)
+    self.timer = StartTimeout(self.onExpire, &self.Lock, time.Duration(expires) * time.Second, 1, self.config.ErrorLogger())
+}
+
+func (self *SipNotifier) onExpire() {
+    self.timer = nil
+    self.Terminate("timeout")
+}
+
+// getExpires returns the expiration interval granted to the SUBSCRIBE
+// or the response to reject it with.
+func (self *SipNotifier) getExpires(req sippy_types.SipRequest) (int, sippy_types.SipResponse) {
+    expires := getExpires(req, self.default_expires)
+    if expires > 0 && expires < self.min_expires {
+        resp := req.GenResponse(423, "Interval Too Brief", nil, nil)
+        resp.AppendHeader(sippy_header.NewSipGenericHF("Min-Expires", strconv.Itoa(self.min_expires)))
+        return 0, resp
+    }
+    if expires > self.max_expires {
+        expires = self.max_expires
+    }
+    return expires, nil
+}
+
+func (self *SipNotifier) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) *sippy_types.Ua_context {
+    t.UpgradeToSessionLock(&self.Lock)
+    if self.dlg == nil {
+        return self.recvInitial(req, t)
+    }
+    if req.GetMethod() != "SUBSCRIBE" {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(405, "Method Not Allowed", nil, nil) }
+    }
+    if self.terminated {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(481, "Subscription Does Not Exist", nil, nil) }
+    }
+    if event := getEvent(req); event == nil || ! self.getEventBody().Matches(event) {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(489, "Bad Event", nil, nil) }
+    }
+    if ! self.dlg.checkCSeq(req) {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(500, "Out Of Order Request", nil, nil) }
+    }
+    expires, resp := self.getExpires(req)
+    if resp != nil {
+        return &sippy_types.Ua_context{ Response : resp }
+    }
+    resp = req.GenResponse(200, "OK", nil, nil)
+    resp.AppendHeader(newSipExpires(expires))
+    t.SendResponse(resp, false, nil)
+    if expires == 0 {
+        // Unsubscribe
+        self.Terminate("timeout")
+        return nil
+    }
+    self.setExpires(expires)
+    self.notified = false
+    self.subscribe_cb(self, req)
+    if ! self.notified && ! self.terminated {
+        self.Notify(self.state, self.last_body, "")
+    }
+    return nil
+}
+
+func (self *SipNotifier) getEventBody() *sippy_header.SipEventBody {
+    event, _ := self.event.GetBody()
+    return event
+}
+
+func (self *SipNotifier) recvInitial(req sippy_types.SipRequest, t sippy_types.ServerTransaction) *sippy_types.Ua_context {
+    if req.GetMethod() != "SUBSCRIBE" {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(405, "Method Not Allowed", nil, nil) }
+    }
+    event := getEvent(req)
+    if event == nil {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(489, "Bad Event", nil, nil) }
+    }
+    if self.auth_cb != nil {
+        if resp := digestAuthorize(req, self.realm, self.algorithm, self.auth_cb, req.GetRURI()); resp != nil {
+            return &sippy_types.Ua_context{ Response : resp }
+        }
+    }
+    expires, resp := self.getExpires(req)
+    if resp != nil {
+        return &sippy_types.Ua_context{ Response : resp }
+    }
+    to, err := req.GetTo().GetBody(self.config)
+    if err != nil {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Bad To", nil, nil) }
+    }
+    from, err := req.GetFrom().GetBody(self.config)
+    if err != nil {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Bad From", nil, nil) }
+    }
+    if len(req.GetContacts()) == 0 {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Missing Contact", nil, nil) }
+    }
+    cseq, err := req.GetCSeq().GetBody()
+    if err != nil {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Bad CSeq", nil, nil) }
+    }
+    local := to.GetCopy()
+    local.GenTag()
+    self.event = sippy_header.NewSipEvent(event.Package, event.Id)
+    self.dlg = &sipDialog{
+        config      : self.config,
+        call_id     : req.GetCallId().GetCopy(),
+        local       : local,
+        remote      : from.GetCopy(),
+        lcseq       : 1,
+        rcseq       : cseq.CSeq,
+        lcontact    : sippy_header.NewSipContact(self.config),
+    }
+    self.dlg.setRouting(req, /*reverse_routes*/ false)
+    self.expires = time.Now().Add(time.Duration(expires) * time.Second)
+    self.smap.add(self.dlg.key(), self)
+    self.hold = true
+    scode, reason := self.subscribe_cb(self, req)
+    self.hold = false
+    held := self.held
+    self.held = nil
+    if scode < 200 || scode >= 300 {
+        self.smap.remove(self.dlg.key())
+        self.dlg = nil
+        self.terminated = false
+        self.state = ""
+        return &sippy_types.Ua_context{ Response : req.GenResponse(scode, reason, nil, nil) }
+    }
+    if self.state == "" {
+        if scode == 202 {
+            self.state = sippy_header.SUBSCRIPTION_STATE_PENDING
+        } else {
+            self.state = sippy_header.SUBSCRIPTION_STATE_ACTIVE
+        }
+    }
+    resp = req.GenResponse(scode, reason, nil, nil)
+    if resp_to, err := resp.GetTo().GetBody(self.config); err == nil {
+        resp_to.SetTag(local.GetTag())
+    }
+    resp.AppendHeader(newSipExpires(expires))
+    resp.AppendHeader(self.dlg.lcontact.GetCopy())
+    t.SendResponse(resp, false, nil)
+    for _, notify := range held {
+        self.sip_tm.BeginNewClientTransaction(notify, self, &self.Lock, nil, nil, nil)
+    }
+    if expires == 0 {
+        // Fetch, RFC 6665 section 4.4.3
+        self.Terminate("timeout")
+        return nil
+    }
+    if self.terminated {
+        return nil
+    }
+    self.setExpires(expires)
+    if ! self.notified {
+        self.Notify(self.state, self.last_body, "")
+    }
+    return nil
+}
+
+func (self *SipNotifier) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    scode := resp.GetSCodeNum()
+    if scode < 300 || scode == 401 || scode == 407 {
+        return
+    }
+    // The subscriber is gone (RFC 6665 section 4.2.2)
+    self.terminate(resp.GetSL())
+}
@@ -4,6 +4,7 @@ import (
 	"sync"
 	"time"
 	"strconv"
+	"strings"
 
 	"github.com/sippy/go-b2bua/sippy/headers"
 	"github.com/sippy/go-b2bua/sippy/net"
@@ -11,6 +12,45 @@ import (
 	"github.com/sippy/go-b2bua/sippy/conf"
 )
 
+type SipRegistrationState int
+
+const (
+	REG_STATE_IDLE = SipRegistrationState(iota)
+	REG_STATE_REGISTERING
+	REG_STATE_REGISTERED
+	REG_STATE_FAILED
+	REG_STATE_UNREGISTERED
+)
+
+const (
+	REG_RETRY_MIN = 30 * time.Second
+	REG_RETRY_MAX = 30 * time.Minute
+)
+
+func (self SipRegistrationState) String() string {
+	switch self {
+	case REG_STATE_IDLE:
+			return "IDLE"
+	case REG_STATE_REGISTERING:
+			return "REGISTERING"
+	case REG_STATE_REGISTERED:
+			return "REGISTERED"
+	case REG_STATE_FAILED:
+			return "FAILED"
+	case REG_STATE_UNREGISTERED:
+			return "UNREGISTERED"
+	}
+	return "UNKNOWN"
+}
+
+type SipRegistrationStatus struct {
+	State           SipRegistrationState
+	Expires         time.Time
+	NextAttempt     time.Time
+	LastError       string
+	Failures        int
+}
+
 type SipRegistrationAgent struct {
 	dead            bool
 	Rmsg            sippy_types.SipRequest
@@ -24,6 +64,17 @@ type SipRegistrationAgent struct {
 	Lock            sync.Mutex
 	atries          int
 	AuthProvider   sippy_types.AuthProvider
+	reg_id          int
+	flow_ka         *FlowKeepalive
+	expires_param   int
+	state           SipRegistrationState
+	expires         time.Time
+	next_attempt    time.Time
+	last_error      string
+	failures        int
+	timer           *Timeout
+	unregistering   bool
+	status_cb       func(SipRegistrationStatus)
 }
 
 
@@ -36,6 +87,8 @@ func NewSipRegistrationAgent(global_config sippy_conf.Config, sip_tm sippy_types
 			rok_cb          : rok_cb,
 			Rfail_cb        : rfail_cb,
 			sip_tm          : sip_tm,
+			expires_param   : expires_param,
+			state           : REG_STATE_IDLE,
 	}
 	ruri := aor.GetCopy()
 	ruri.Username = ""
@@ -71,10 +124,66 @@ func NewSipRegistrationAgent(global_config sippy_conf.Config, sip_tm sippy_types
 	return self
 }
 
+// AddContact registers one more contact within the same registration.
+func (self *SipRegistrationAgent) AddContact(contact_url *sippy_header.SipURL) {
+	contact_addr := sippy_header.NewSipAddress("", contact_url)
+	contact_addr.SetParam("expires", strconv.Itoa(self.expires_param))
+	self.Rmsg.AppendHeader(sippy_header.NewSipContactFromAddress(contact_addr))
+}
+
+// SetStatusCb sets the callback invoked with the agent's lock held
+// every time the registration status changes.
+func (self *SipRegistrationAgent) SetStatusCb(status_cb func(SipRegistrationStatus)) {
+	self.status_cb = status_cb
+}
+
+func (self *SipRegistrationAgent) GetStatus() SipRegistrationStatus {
+	self.Lock.Lock()
+	defer self.Lock.Unlock()
+	return self.getStatus()
+}
+
+func (self *SipRegistrationAgent) getStatus() SipRegistrationStatus {
+	return SipRegistrationStatus{
+			State       : self.state,
+			Expires     : self.expires,
+			NextAttempt : self.next_attempt,
+			LastError   : self.last_error,
+			Failures    : self.failures,
+	}
+}
+
+func (self *SipRegistrationAgent) setState(state SipRegistrationState) {
+	self.state = state
+	if self.status_cb != nil {
+			self.status_cb(self.getStatus())
+	}
+}
+
+// SetOutbound enables SIP Outbound (RFC 5626) for the registration. The
+// instance is the URN identifying the UA instance (i.e. urn:uuid:...),
+// the reg_id distinguishes the flows of the same instance. Once the
+// registrar confirms the outbound support the flow is kept alive with
+// CRLF pings and the registration is refreshed as soon as it fails.
+func (self *SipRegistrationAgent) SetOutbound(instance string, reg_id int) {
+	contact_addr, err := self.Rmsg.GetContacts()[0].GetBody(self.global_config)
+	if err != nil {
+			return
+	}
+	contact_addr.SetParam("+sip.instance", "\"<" + instance + ">\"")
+	contact_addr.SetParam("reg-id", strconv.Itoa(reg_id))
+	self.Rmsg.AppendHeader(sippy_header.CreateSipSupported("outbound")[0])
+	self.reg_id = reg_id
+}
+
 func (self *SipRegistrationAgent) DoRegister() {
 	if self.dead {
 			return
 	}
+	self.cancelTimer()
+	if self.state != REG_STATE_REGISTERED {
+			self.setState(REG_STATE_REGISTERING)
+	}
 	self.sip_tm.BeginNewClientTransaction(self.Rmsg, self, &self.Lock, /*laddress*/ self.source_address, nil, nil)
 	via_hdr, err := self.Rmsg.GetVias()[0].GetBody()
 	if err == nil {
@@ -89,6 +198,95 @@ func (self *SipRegistrationAgent) DoRegister() {
 func (self *SipRegistrationAgent) StopRegister() {
 	self.dead = true
 	self.Rmsg = nil
+	self.cancelTimer()
+	self.stopFlowKeepalive()
+}
+
+// Unregister removes the bindings from the registrar and stops the
+// agent once the registrar has confirmed it.
+func (self *SipRegistrationAgent) Unregister() {
+	if self.dead {
+			return
+	}
+	self.unregistering = true
+	self.setExpiresParam(0)
+	self.DoRegister()
+}
+
+func (self *SipRegistrationAgent) setExpiresParam(expires_param int) {
+	self.expires_param = expires_param
+	for _, contact := range self.Rmsg.GetContacts() {
+			if contact_addr, err := contact.GetBody(self.global_config); err == nil {
+					contact_addr.SetParam("expires", strconv.Itoa(expires_param))
+			}
+	}
+}
+
+func (self *SipRegistrationAgent) cancelTimer() {
+	if self.timer != nil {
+			self.timer.Cancel()
+			self.timer = nil
+	}
+}
+
+func (self *SipRegistrationAgent) schedule(delay time.Duration) {
+	self.cancelTimer()
+	self.next_attempt = time.Now().Add(delay)
+	self.timer = StartTimeout(self.onTimer, &self.Lock, delay, 1, self.global_config.ErrorLogger())
+}
+
+func (self *SipRegistrationAgent) onTimer() {
+	self.timer = nil
+	self.DoRegister()
+}
+
+func (self *SipRegistrationAgent) startFlowKeepalive(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+	outbound := false
+	for _, require := range resp.GetSipRequire() {
+			if require.HasTag("outbound") {
+					outbound = true
+					break
+			}
+	}
+	flow := tr.GetFlow()
+	if ! outbound || flow == nil {
+			// The registrar does not support outbound, nothing to keep alive
+			self.stopFlowKeepalive()
+			return
+	}
+	var interval time.Duration
+	if hf := resp.GetFirstHF("flow-timer"); hf != nil {
+			if tout, err := strconv.Atoi(strings.TrimSpace(hf.StringBody())); err == nil && tout > 0 {
+					interval = time.Duration(tout) * time.Second
+			}
+	}
+	if self.flow_ka != nil {
+			if self.flow_ka.GetFlow().Token() == flow.Token() {
+					return
+			}
+			self.flow_ka.Stop()
+	}
+	self.flow_ka = NewFlowKeepalive(self.sip_tm, flow, interval, self.flowFailed, self.global_config.ErrorLogger())
+	self.flow_ka.Start()
+}
+
+func (self *SipRegistrationAgent) stopFlowKeepalive() {
+	if self.flow_ka != nil {
+			self.flow_ka.Stop()
+			self.flow_ka = nil
+	}
+}
+
+// flowFailed re-registers as soon as the flow is detected to be dead
+// so that the registrar learns the new one (RFC 5626 section 4.4.1).
+func (self *SipRegistrationAgent) flowFailed() {
+	self.Lock.Lock()
+	defer self.Lock.Unlock()
+	if self.dead {
+			return
+	}
+	self.flow_ka = nil
+	self.DoRegister()
 }
 
 func (self *SipRegistrationAgent) HandleAuth(challenges []sippy_types.Challenge) {
@@ -105,57 +303,155 @@ func (self *SipRegistrationAgent) AuthDone(auth sippy_header.SipHeader) {
 	self.DoRegister()
 }
 
-func (self *SipRegistrationAgent) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
-	var err error
+// getExpires returns the expiration interval the registrar has granted
+// to our contact(s). The registrar returns all bindings of the AOR, so
+// the other contacts are skipped. The nil contact is returned if none
+// of ours is among the bindings.
+func (self *SipRegistrationAgent) getExpires(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) (int, *sippy_header.SipAddress) {
+	var laddr *sippy_net.HostPort
+	if ct, ok := tr.(*clientTransaction); ok && ct.userv != nil {
+			laddr = ct.userv.GetLAddress()
+	}
+	ours := make(map[string]bool)
+	for _, contact := range self.Rmsg.GetContacts() {
+			if contact_addr, err := contact.GetBody(self.global_config); err == nil {
+					url := contact_addr.GetUrl().GetCopy()
+					if laddr != nil {
+							// The system default host and port have been substituted
+							// with the local address in the request sent
+							if url.Host.IsSystemDefault() {
+									url.Host = sippy_net.NewMyAddress(laddr.Host.String())
+							}
+							if url.Port != nil && url.Port.IsSystemDefault() {
+									url.Port = sippy_net.NewMyPort(laddr.Port.String())
+							}
+					}
+					ours[contactKey(url)] = true
+			}
+	}
+	tout := -1
+	var contact *sippy_header.SipAddress
+	for _, hf := range resp.GetContacts() {
+			contact_addr, err := hf.GetBody(self.global_config)
+			if err != nil || ! ours[contactKey(contact_addr.GetUrl())] {
+					continue
+			}
+			t, err := strconv.Atoi(contact_addr.GetParam("expires"))
+			if err != nil {
+					t = -1
+			}
+			if contact == nil || (t != -1 && (tout == -1 || t < tout)) {
+					tout = t
+					contact = contact_addr
+			}
+	}
+	if tout == -1 {
+			hf := resp.GetFirstHF("expires")
+			if expires_hf, ok := hf.(*sippy_header.SipExpires); ok {
+					tout = expires_hf.Number
+			}
+	}
+	if tout == -1 {
+			tout = 180
+	}
+	return tout, contact
+}
+
+// retryDelay returns the Retry-After if the registrar has provided one
+// or the exponential backoff since the first failure otherwise.
+func (self *SipRegistrationAgent) retryDelay(resp sippy_types.SipResponse) time.Duration {
+	if hf, ok := resp.GetFirstHF("retry-after").(*sippy_header.SipRetryAfter); ok {
+			if retry_after, err := hf.GetBody(); err == nil && retry_after.Delta > 0 {
+					return time.Duration(retry_after.Delta) * time.Second
+			}
+	}
+	delay := REG_RETRY_MIN
+	for i := 1; i < self.failures && delay < REG_RETRY_MAX; i++ {
+			delay *= 2
+	}
+	if delay > REG_RETRY_MAX {
+			delay = REG_RETRY_MAX
+	}
+	return delay
+}
 
+func (self *SipRegistrationAgent) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
 	if self.dead {
 			return
 	}
-	if resp.GetSCodeNum() < 200 {
+	scode := resp.GetSCodeNum()
+	if scode < 200 {
 			return
 	}
-	if resp.GetSCodeNum() >= 200 && resp.GetSCodeNum() < 300 && resp.GetSCodeReason() != "Auth Failed" {
-			tout := -1
-			var contact *sippy_header.SipAddress
-
-			if len(resp.GetContacts()) > 0 {
-					contact, err = resp.GetContacts()[0].GetBody(self.global_config)
-					if err != nil {
-							tout = -1
-					} else {
-							tout, err = strconv.Atoi(contact.GetParam("expires"))
-							if err != nil {
-									tout = -1
-							}
-					}
-			}
-			if tout == -1 {
-					hf := resp.GetFirstHF("expires")
-					if expires_hf, ok := hf.(*sippy_header.SipExpires); ok {
-							tout = expires_hf.Number
-					}
+	if scode >= 200 && scode < 300 && resp.GetSCodeReason() != "Auth Failed" {
+			if self.unregistering {
+					self.atries = 0
+					self.failures = 0
+					self.last_error = ""
+					self.expires = time.Time{}
+					self.next_attempt = time.Time{}
+					self.setState(REG_STATE_UNREGISTERED)
+					self.StopRegister()
+					return
 			}
-			if tout == -1 {
-					tout = 180
+			tout, contact := self.getExpires(resp, tr)
+			if contact == nil {
+					self.failed(resp, "Contact is missing in " + resp.GetSL())
+					return
 			}
+			self.atries = 0
+			self.failures = 0
+			self.last_error = ""
 			expires := time.Duration(tout) * time.Second
-			StartTimeout(self.DoRegister, &self.Lock, expires, 1, self.global_config.ErrorLogger())
+			if self.reg_id > 0 {
+					self.startFlowKeepalive(resp, tr)
+			}
+			self.expires = time.Now().Add(expires)
+			self.schedule(refreshInterval(expires))
+			self.setState(REG_STATE_REGISTERED)
 			if self.rok_cb != nil {
-					self.rok_cb(time.Now().Add(expires), contact)
+					self.rok_cb(self.expires, contact)
 			}
-			self.atries = 0
 			return
 	}
-	if (resp.GetSCodeNum() == 401 || resp.GetSCodeNum() == 407) && self.user.Valid && self.passw.Valid && self.atries < 3 {
+	if (scode == 401 || scode == 407) && self.user.Valid && self.passw.Valid && self.atries < 3 {
 			challenges := resp.GetChallenges()
 			if len(challenges) > 0 {
 					self.AuthProvider.HandleAuth(challenges)
 					return
 			}
 	}
-	if self.Rfail_cb != nil {
-			self.Rfail_cb(resp.GetSL())
+	if scode == 423 && ! self.unregistering {
+			// Interval Too Brief, retry with the Min-Expires
+			if hf := resp.GetFirstHF("min-expires"); hf != nil {
+					if min_expires, err := strconv.Atoi(strings.TrimSpace(hf.StringBody())); err == nil && min_expires > self.expires_param {
+							self.setExpiresParam(min_expires)
+							self.DoRegister()
+							return
+					}
+			}
 	}
-	StartTimeout(self.DoRegister, &self.Lock, 60 * time.Second, 1, self.global_config.ErrorLogger())
+	self.failed(resp, resp.GetSL())
+}
+
+func (self *SipRegistrationAgent) failed(resp sippy_types.SipResponse, reason string) {
 	self.atries = 0
+	self.failures++
+	self.last_error = reason
+	if self.Rfail_cb != nil {
+			self.Rfail_cb(reason)
+	}
+	if self.dead {
+			return
+	}
+	if self.unregistering {
+			self.setState(REG_STATE_FAILED)
+			self.StopRegister()
+			return
+	}
+	if ! self.expires.IsZero() && time.Now().After(self.expires) {
+			self.expires = time.Time{}
+	}
+	self.schedule(self.retryDelay(resp))
+	self.setState(REG_STATE_FAILED)
 }
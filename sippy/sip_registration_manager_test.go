@@ -0,0 +1,111 @@
+package sippy
+
+import (
+    "testing"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+type test_reg_call_map struct {
+}
+
+func (self *test_reg_call_map) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.ServerTransaction) (sippy_types.UA, sippy_types.RequestReceiver, sippy_types.SipResponse) {
+    return nil, nil, req.GenResponse(501, "Not Implemented", nil, nil)
+}
+
+func Test_SipRegistrationAgent(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    sip_tm, err := NewSipTransactionManager(config, &test_reg_call_map{})
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+    aor, _ := sippy_header.ParseSipURL("sip:alice@example.com", false, config)
+    ra := NewSipRegistrationAgent(config, sip_tm, aor, nil, sippy_types.NullString{}, sippy_types.NullString{}, nil, nil, sippy_net.NewHostPort("2.2.2.2", "5060"), 300)
+    ra.Lock.Lock()
+    ra.DoRegister()
+    ra.Lock.Unlock()
+    rtime, _ := sippy_time.NewMonoTime()
+    next_register := func(expires string) *sipRequest {
+        req, err := ParseSipRequest(tfactory.get(), rtime, config)
+        if err != nil {
+            t.Fatal("Cannot parse REGISTER: " + err.Error())
+        }
+        contact, _ := req.GetContacts()[0].GetBody(config)
+        if contact.GetParam("expires") != expires {
+            t.Fatalf("Expected expires=%s, got %s", expires, contact.GetParam("expires"))
+        }
+        return req
+    }
+    req := next_register("300")
+    resp := req.GenResponse(423, "Interval Too Brief", nil, nil)
+    resp.AppendHeader(sippy_header.NewSipGenericHF("Min-Expires", "600"))
+    tfactory.feed([]string{ resp.LocalStr(nil, false) })
+    req = next_register("600")
+    resp = req.GenResponse(200, "OK", nil, nil)
+    other, _ := sippy_header.ParseSipAddress("<sip:alice@3.3.3.3>;expires=30", false, config)
+    resp.AppendHeader(sippy_header.NewSipContactFromAddress(other))
+    ours, _ := req.GetContacts()[0].GetBody(config)
+    ours = ours.GetCopy()
+    ours.SetParam("expires", "500")
+    resp.AppendHeader(sippy_header.NewSipContactFromAddress(ours))
+    tfactory.feed([]string{ resp.LocalStr(nil, false) })
+    for i := 0; i < 100; i++ {
+        if ra.GetStatus().State == REG_STATE_REGISTERED {
+            break
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    status := ra.GetStatus()
+    if status.State != REG_STATE_REGISTERED {
+        t.Fatal("Expected REGISTERED, got " + status.State.String())
+    }
+    if d := time.Until(status.Expires); d < 490 * time.Second || d > 500 * time.Second {
+        t.Fatal("The expires of our contact has not been honoured: " + d.String())
+    }
+    wait_failures := func(failures int) SipRegistrationStatus {
+        for i := 0; i < 100; i++ {
+            if status := ra.GetStatus(); status.Failures == failures {
+                return status
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+        t.Fatalf("Expected %d failures", failures)
+        return SipRegistrationStatus{}
+    }
+    // The registrar has not kept our binding
+    ra.Lock.Lock()
+    ra.DoRegister()
+    ra.Lock.Unlock()
+    req = next_register("600")
+    resp = req.GenResponse(200, "OK", nil, nil)
+    resp.AppendHeader(sippy_header.NewSipContactFromAddress(other))
+    tfactory.feed([]string{ resp.LocalStr(nil, false) })
+    status = wait_failures(1)
+    if status.State != REG_STATE_FAILED {
+        t.Fatal("Expected FAILED, got " + status.State.String())
+    }
+    ra.Lock.Lock()
+    ra.DoRegister()
+    ra.Lock.Unlock()
+    req = next_register("600")
+    resp = req.GenResponse(503, "Service Unavailable", nil, nil)
+    resp.AppendHeader(sippy_header.NewSipGenericHF("Retry-After", "120 (Maintenance);duration=3600"))
+    tfactory.feed([]string{ resp.LocalStr(nil, false) })
+    status = wait_failures(2)
+    if d := time.Until(status.NextAttempt); d < 110 * time.Second || d > 120 * time.Second {
+        t.Fatal("The Retry-After has not been honoured: " + d.String())
+    }
+    ra.Lock.Lock()
+    ra.StopRegister()
+    ra.Lock.Unlock()
+}
@@ -46,6 +46,7 @@ type sipRequest struct {
     expires *sippy_header.SipExpires
     user_agent *sippy_header.SipUserAgent
     nated   bool
+    flow    *sippy_net.Flow
 }
 
 func ParseSipRequest(buf []byte, rtime *sippy_time.MonoTime, config sippy_conf.Config) (*sipRequest, sippy_types.SipHandlingError) {
@@ -228,6 +229,20 @@ func (self *sipRequest) GenCANCEL() (sippy_types.SipRequest, error) {
                       /*expires*/ nil, self.config)
 }
 
+func (self *sipRequest) GetCopy() sippy_types.SipRequest {
+    rval := &sipRequest{
+        method  : self.method,
+        sipver  : self.sipver,
+        ruri    : self.ruri.GetCopy(),
+        expires : self.expires,
+        user_agent : self.user_agent,
+        nated   : self.nated,
+        flow    : self.flow,
+    }
+    rval.sipMsg = self.sipMsg.getCopy()
+    return rval
+}
+
 func (self *sipRequest) GetExpires() *sippy_header.SipExpires {
     return self.expires
 }
@@ -236,6 +251,12 @@ func (self *sipRequest) GetNated() bool {
     return self.nated
 }
 
+// GetFlow returns the flow the request has been received over, nil for
+// the locally generated requests.
+func (self *sipRequest) GetFlow() *sippy_net.Flow {
+    return self.flow
+}
+
 func (self *sipRequest) GetRTId() (*sippy_header.RTID, error) {
     if self.rack == nil {
         return nil, errors.New("No RAck field present")
@@ -0,0 +1,299 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+// SipSubscriber is the subscriber side of the SUBSCRIBE/NOTIFY dialog
+// usage (RFC 6665). It sends the SUBSCRIBE, refreshes it ahead of the
+// expiration and passes the NOTIFYs received to the application. All
+// methods must be called with the Lock held, the callbacks are invoked
+// with the Lock held.
+type SipSubscriber struct {
+    Lock            sync.Mutex
+    AuthProvider    sippy_types.AuthProvider
+    config          sippy_conf.Config
+    sip_tm          sippy_types.SipTransactionManager
+    smap            *SubscriptionMap
+    dlg             *sipDialog
+    event           *sippy_header.SipEvent
+    expires         int
+    extra_headers   []sippy_header.SipHeader
+    state           string
+    established     bool
+    unsubscribing   bool
+    terminated      bool
+    timer           *Timeout
+    notify_cb       func(sippy_types.SipRequest, *sippy_header.SipSubscriptionStateBody)
+    term_cb         func(string)
+    atries          int
+    has_credentials bool
+}
+
+func NewSipSubscriber(config sippy_conf.Config, sip_tm sippy_types.SipTransactionManager, smap *SubscriptionMap, ruri *sippy_header.SipURL, from, to *sippy_header.SipAddress, event *sippy_header.SipEvent, expires int, target *sippy_net.HostPort) *SipSubscriber {
+    self := &SipSubscriber{
+        config          : config,
+        sip_tm          : sip_tm,
+        smap            : smap,
+        event           : event,
+        expires         : expires,
+        extra_headers   : []sippy_header.SipHeader{},
+    }
+    local := from.GetCopy()
+    local.GenTag()
+    remote := to.GetCopy()
+    remote.SetTag("")
+    self.dlg = &sipDialog{
+        config      : config,
+        call_id     : sippy_header.GenerateSipCallId(config),
+        local       : local,
+        remote      : remote,
+        lcseq       : 1,
+        rcseq       : -1,
+        rtarget     : ruri.GetCopy(),
+        routes      : []*sippy_header.SipRoute{},
+        raddr       : target,
+        lcontact    : sippy_header.NewSipContact(config),
+    }
+    if target == nil {
+        self.dlg.raddr = ruri.GetAddr(config)
+    }
+    self.AuthProvider = self
+    return self
+}
+
+func (self *SipSubscriber) SetCredentials(username, password string) {
+    self.dlg.username = username
+    self.dlg.password = password
+    self.has_credentials = true
+}
+
+// SetNotifyCb sets the callback invoked for every NOTIFY received. The
+// NOTIFY is answered with 200 OK.
+func (self *SipSubscriber) SetNotifyCb(notify_cb func(sippy_types.SipRequest, *sippy_header.SipSubscriptionStateBody)) {
+    self.notify_cb = notify_cb
+}
+
+// SetTermCb sets the callback invoked once the subscription has been
+// terminated by either side.
+func (self *SipSubscriber) SetTermCb(term_cb func(string)) {
+    self.term_cb = term_cb
+}
+
+// AppendHeader adds the header (i.e. Accept) to all SUBSCRIBE requests.
+func (self *SipSubscriber) AppendHeader(hf sippy_header.SipHeader) {
+    self.extra_headers = append(self.extra_headers, hf)
+}
+
+// GetState returns the Subscription-State of the last NOTIFY or "" if
+// none has been received yet.
+func (self *SipSubscriber) GetState() string {
+    return self.state
+}
+
+func (self *SipSubscriber) IsTerminated() bool {
+    return self.terminated
+}
+
+func (self *SipSubscriber) Subscribe() {
+    if self.terminated {
+        return
+    }
+    self.smap.add(self.dlg.key(), self)
+    self.sendSubscribe(nil)
+}
+
+// Unsubscribe sends SUBSCRIBE with Expires: 0. The subscription is
+// terminated when the final NOTIFY arrives.
+func (self *SipSubscriber) Unsubscribe() {
+    if self.terminated || self.unsubscribing {
+        return
+    }
+    self.unsubscribing = true
+    self.sendSubscribe(nil)
+    self.schedule(SUBS_NOTIFY_WAIT, func() { self.terminate("timeout") })
+}
+
+func (self *SipSubscriber) sendSubscribe(challenge sippy_types.Challenge) {
+    expires := self.expires
+    if self.unsubscribing {
+        expires = 0
+    }
+    headers := []sippy_header.SipHeader{ self.event.GetCopy(), newSipExpires(expires) }
+    for _, hf := range self.extra_headers {
+        headers = append(headers, hf.GetCopyAsIface())
+    }
+    req, err := self.dlg.genRequest("SUBSCRIBE", nil, challenge, headers...)
+    if err != nil {
+        self.config.ErrorLogger().Error("SipSubscriber: cannot create SUBSCRIBE: " + err.Error())
+        return
+    }
+    self.sip_tm.BeginNewClientTransaction(req, self, &self.Lock, nil, nil, nil)
+}
+
+func (self *SipSubscriber) HandleAuth(challenges []sippy_types.Challenge) {
+    self.atries++
+    self.sendSubscribe(challenges[0])
+}
+
+func (self *SipSubscriber) cancelTimer() {
+    if self.timer != nil {
+        self.timer.Cancel()
+        self.timer = nil
+    }
+}
+
+func (self *SipSubscriber) schedule(delay time.Duration, cb func()) {
+    self.cancelTimer()
+    self.timer = StartTimeout(func() { self.timer = nil; cb() }, &self.Lock, delay, 1, self.config.ErrorLogger())
+}
+
+func (self *SipSubscriber) refresh() {
+    if ! self.terminated && ! self.unsubscribing {
+        self.sendSubscribe(nil)
+    }
+}
+
+func (self *SipSubscriber) terminate(reason string) {
+    if self.terminated {
+        return
+    }
+    self.terminated = true
+    self.state = sippy_header.SUBSCRIPTION_STATE_TERMINATED
+    self.cancelTimer()
+    self.smap.remove(self.dlg.key())
+    if self.term_cb != nil {
+        self.term_cb(reason)
+    }
+}
+
+func (self *SipSubscriber) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    scode := resp.GetSCodeNum()
+    if self.terminated || scode < 200 {
+        return
+    }
+    if scode < 300 {
+        self.atries = 0
+        if ! self.established {
+            to, err := resp.GetTo().GetBody(self.config)
+            if err != nil {
+                self.terminate("bad response")
+                return
+            }
+            self.dlg.remote.SetTag(to.GetTag())
+            self.dlg.setRouting(resp, /*reverse_routes*/ true)
+            self.established = true
+        }
+        if self.unsubscribing {
+            return
+        }
+        expires := getExpires(resp, self.expires)
+        if expires <= 0 {
+            // The notifier terminates the subscription with NOTIFY
+            return
+        }
+        self.schedule(refreshInterval(time.Duration(expires) * time.Second), self.refresh)
+        return
+    }
+    if (scode == 401 || scode == 407) && self.has_credentials && self.atries < 3 {
+        challenges := resp.GetChallenges()
+        if len(challenges) > 0 {
+            self.AuthProvider.HandleAuth(challenges)
+            return
+        }
+    }
+    if scode == 423 && ! self.unsubscribing {
+        if hf := resp.GetFirstHF("min-expires"); hf != nil {
+            if min_expires, err := strconv.Atoi(strings.TrimSpace(hf.StringBody())); err == nil && min_expires > self.expires {
+                self.expires = min_expires
+                self.sendSubscribe(nil)
+                return
+            }
+        }
+    }
+    self.terminate(resp.GetSL())
+}
+
+func (self *SipSubscriber) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) *sippy_types.Ua_context {
+    t.UpgradeToSessionLock(&self.Lock)
+    if req.GetMethod() != "NOTIFY" {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(405, "Method Not Allowed", nil, nil) }
+    }
+    if self.terminated {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(481, "Subscription Does Not Exist", nil, nil) }
+    }
+    event := getEvent(req)
+    if event == nil {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Missing Event", nil, nil) }
+    }
+    if ev, err := self.event.GetBody(); err != nil || ! ev.Matches(event) {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(489, "Bad Event", nil, nil) }
+    }
+    hf, ok := req.GetFirstHF("subscription-state").(*sippy_header.SipSubscriptionState)
+    if ! ok {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Missing Subscription-State", nil, nil) }
+    }
+    ss, err := hf.GetBody()
+    if err != nil {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Bad Subscription-State", nil, nil) }
+    }
+    from, err := req.GetFrom().GetBody(self.config)
+    if err != nil {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(400, "Bad From", nil, nil) }
+    }
+    if ! self.established {
+        // The NOTIFY may arrive ahead of the 2xx to the SUBSCRIBE
+        self.dlg.remote.SetTag(from.GetTag())
+        self.dlg.setRouting(req, /*reverse_routes*/ false)
+        self.established = true
+    } else if from.GetTag() != self.dlg.remote.GetTag() {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(481, "Subscription Does Not Exist", nil, nil) }
+    }
+    if ! self.dlg.checkCSeq(req) {
+        return &sippy_types.Ua_context{ Response : req.GenResponse(500, "Out Of Order Request", nil, nil) }
+    }
+    self.state = ss.State
+    if self.notify_cb != nil {
+        self.notify_cb(req, ss)
+    }
+    resp := req.GenResponse(200, "OK", nil, nil)
+    if ss.IsTerminated() {
+        self.terminate(ss.Reason)
+    } else if ss.Expires > 0 && ! self.unsubscribing {
+        self.schedule(refreshInterval(time.Duration(ss.Expires) * time.Second), self.refresh)
+    }
+    return &sippy_types.Ua_context{ Response : resp }
+}
@@ -31,11 +31,16 @@ import (
     "encoding/hex"
     "errors"
     "net"
+    "strconv"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
 
+    "github.com/sippy/go-b2bua/sippy/admission"
     "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/hep"
+    "github.com/sippy/go-b2bua/sippy/log"
     "github.com/sippy/go-b2bua/sippy/headers"
     "github.com/sippy/go-b2bua/sippy/net"
     "github.com/sippy/go-b2bua/sippy/types"
@@ -62,6 +67,15 @@ type sipTransactionManager struct {
     before_response_sent func(sippy_types.SipResponse)
     rtid2tid        map[sippy_header.RTID]*sippy_header.TID
     rtid2tid_lock   sync.Mutex
+    flow_consumers  map[string]func()
+    flow_lock       sync.Mutex
+    hep             *sippy_hep.HepCapture
+    overload        *OverloadControl
+    ack_consumers   []UnmatchedAckConsumer
+    retrans_sent    uint64
+    retrans_rcvd    uint64
+    resp_sent       [7]uint64
+    resp_rcvd       [7]uint64
 }
 
 type sipTMRetransmitO struct {
@@ -85,6 +99,7 @@ func NewSipTransactionManager(config sippy_conf.Config, call_map sippy_types.Cal
         tserver         : make(map[sippy_header.TID]sippy_types.ServerTransaction),
         nat_traversal   : false,
         req_consumers   : make(map[string][]sippy_types.UA),
+        flow_consumers  : make(map[string]func()),
         pass_t_to_cb    : false,
         rtid2tid        : make(map[sippy_header.RTID]*sippy_header.TID),
     }
@@ -92,6 +107,12 @@ func NewSipTransactionManager(config sippy_conf.Config, call_map sippy_types.Cal
     // early for us to start processing it
     self.rcache_lock.Lock()
     defer self.rcache_lock.Unlock()
+    if collector := config.GetHepCollector(); collector != nil {
+        self.hep, err = sippy_hep.NewHepCapture(collector, config.GetHepProto(), config.GetHepAgentId(), config.GetHepPassword(), config.ErrorLogger())
+        if err != nil {
+            return nil, err
+        }
+    }
     self.l4r, err = NewLocal4Remote(config, self.handleIncoming)
     if err != nil {
         return nil, err
@@ -112,6 +133,9 @@ func NewSipTransactionManager(config sippy_conf.Config, call_map sippy_types.Cal
 func (self *sipTransactionManager) Run() {
     <-self.shutdown_chan
     self.l4r.shutdown()
+    if self.hep != nil {
+        self.hep.Shutdown()
+    }
 }
 
 func (self *sipTransactionManager) rCachePurge() {
@@ -182,30 +206,64 @@ func (self *sipTransactionManager) rcache_set_call_id(checksum, call_id string)
 }
 
 func (self *sipTransactionManager) logMsg(rtime *sippy_time.MonoTime, call_id string,
-  direction string, address *sippy_net.HostPort, data []byte) {
-    var ft string
-    if direction == "SENDING" {
-        ft = " message to "
+  direction string, address *sippy_net.HostPort, data []byte, userv sippy_net.Transport) {
+    if ml, ok := self.config.SipLogger().(sippy_log.SipMsgLogger); ok {
+        ml.WriteMsg(rtime, call_id, direction, address.String(), data)
     } else {
-        ft = " message from "
+        var ft string
+        if direction == "SENDING" {
+            ft = " message to "
+        } else {
+            ft = " message from "
+        }
+        msg := direction + ft + address.String() + ":\n" + string(data) + "\n"
+        self.config.SipLogger().Write(rtime, call_id, msg)
+    }
+    if self.hep != nil && userv != nil {
+        switch direction {
+        case "SENDING":
+            self.hep.Capture(rtime, userv.GetLAddress(), address, sippy_net.GetTransportProto(userv), call_id, data)
+        case "RECEIVED":
+            self.hep.Capture(rtime, address, userv.GetLAddress(), sippy_net.GetTransportProto(userv), call_id, data)
+        }
     }
-    msg := direction + ft + address.String() + ":\n" + string(data) + "\n"
-    self.config.SipLogger().Write(rtime, call_id, msg)
 }
 
 func (self *sipTransactionManager) handleIncoming(data []byte, address *sippy_net.HostPort, server sippy_net.Transport, rtime *sippy_time.MonoTime) {
+    switch string(data) {
+    case "\r\n\r\n":
+        // RFC 5626 keep-alive ping, answer with the pong. The stream
+        // transports answer pings themselves.
+        if ! sippy_net.IsReliable(server) {
+            server.SendTo([]byte("\r\n"), address)
+        }
+        return
+    case "\r\n":
+        self.flowPong(sippy_net.NewFlow(server, address))
+        return
+    }
     if len(data) < 32 {
         //self.logMsg(rtime, retrans.call_id, "RECEIVED", address, data)
         //self.logError("The message is too short from " + address.String() + ":\n" + string(data))
         return
     }
+    if filter := self.config.GetAdmissionFilter(); filter != nil {
+        switch filter.Admit(sippy_admission.GetMethod(data), address, data) {
+        case sippy_admission.DROP:
+            return
+        case sippy_admission.REJECT:
+            self.rejectRequest(rtime, data, address, server)
+            return
+        }
+    }
     sum := md5.Sum(data)
     checksum := hex.EncodeToString(sum[:])
     self.rcache_lock.Lock()
     retrans, ok := self.rcache_get_no_lock(checksum)
     if ok {
         self.rcache_lock.Unlock()
-        self.logMsg(rtime, retrans.call_id, "RECEIVED", address, data)
+        atomic.AddUint64(&self.retrans_rcvd, 1)
+        self.logMsg(rtime, retrans.call_id, "RECEIVED", address, data, server)
         if retrans.data == nil {
             return
         }
@@ -225,6 +283,18 @@ func (self *sipTransactionManager) handleIncoming(data []byte, address *sippy_ne
     }
 }
 
+// Reply with 503 to the request that has not been admitted. No
+// transaction is created for it.
+func (self *sipTransactionManager) rejectRequest(rtime *sippy_time.MonoTime, data []byte, address *sippy_net.HostPort, server sippy_net.Transport) {
+    req, perr := ParseSipRequest(data, rtime, self.config)
+    if perr != nil {
+        return
+    }
+    self.logMsg(rtime, req.GetCallId().CallId, "RECEIVED", address, data, server)
+    resp := req.GenResponse(503, "Service Unavailable", nil, nil)
+    self.transmitMsg(server, resp, address, "", req.GetCallId().CallId)
+}
+
 func (self *sipTransactionManager) process_response(rtime *sippy_time.MonoTime, data []byte, checksum string, address *sippy_net.HostPort, server sippy_net.Transport) {
     var resp *sipResponse
     var err error
@@ -233,26 +303,32 @@ func (self *sipTransactionManager) process_response(rtime *sippy_time.MonoTime,
 
     resp, err = ParseSipResponse(data, rtime, self.config)
     if err != nil {
-        self.logMsg(rtime, "", "RECEIVED", address, data)
+        self.logMsg(rtime, "", "RECEIVED", address, data, server)
         self.logBadMessage("can't parse SIP response from " + address.String() + ":" + err.Error(), data)
         return
     }
     tid, err = resp.GetTId(true /*wCSM*/, true/*wBRN*/, false /*wTTG*/)
     if err != nil {
-        self.logMsg(rtime, "", "RECEIVED", address, data)
+        self.logMsg(rtime, "", "RECEIVED", address, data, server)
         self.logBadMessage("can't parse SIP response from " + address.String() + ":" + err.Error(), data)
         return
     }
-    self.logMsg(rtime, tid.CallId, "RECEIVED", address, data)
+    self.logMsg(rtime, tid.CallId, "RECEIVED", address, data, server)
 
     if resp.scode < 100 || resp.scode > 999 {
         self.logBadMessage("invalid status code in SIP response" + address.String() + ":\n" + string(data), data)
         self.rcache_set_call_id(checksum, tid.CallId)
         return
     }
+    self.countResponse(resp.scode, &self.resp_rcvd)
     self.tclient_lock.Lock()
     t, ok := self.tclient[*tid]
     self.tclient_lock.Unlock()
+    if ct, is_ct := t.(*clientTransaction); ok && is_ct && self.overload != nil {
+        if via0, err := resp.vias[0].GetBody(); err == nil {
+            self.overload.processResponse(ct.address, via0)
+        }
+    }
     if !ok {
         //print 'no transaction with tid of %s in progress' % str(tid)
         if len(resp.vias) > 1 {
@@ -323,17 +399,17 @@ func (self *sipTransactionManager) process_request(rtime *sippy_time.MonoTime, d
             sip_response := perr.GetResponse(req)
             self.transmitMsg(server, sip_response, address, checksum, sip_response.GetCallId().CallId)
         }
-        self.logMsg(rtime, "", "RECEIVED", address, data)
+        self.logMsg(rtime, "", "RECEIVED", address, data, server)
         self.logBadMessage("can't parse SIP request from " + address.String() + ": " + perr.Error(), data)
         return
     }
     tids, err = req.getTIds()
     if err != nil {
-        self.logMsg(rtime, "", "RECEIVED", address, data)
+        self.logMsg(rtime, "", "RECEIVED", address, data, server)
         self.logBadMessage(err.Error(), data)
         return
     }
-    self.logMsg(rtime, tids[0].CallId, "RECEIVED", address, data)
+    self.logMsg(rtime, tids[0].CallId, "RECEIVED", address, data, server)
     via0, err = req.vias[0].GetBody()
     if err != nil {
         self.logBadMessage(err.Error(), data)
@@ -347,7 +423,10 @@ func (self *sipTransactionManager) process_request(rtime *sippy_time.MonoTime, d
     if ahost != rhost {
         via0.SetReceived(rhost)
     }
-    if via0.HasRport() || req.nated {
+    if via0.HasRport() || req.nated || sippy_net.IsReliable(server) {
+        // Responses to the requests received over the connection
+        // oriented transport have to be sent back over the same
+        // connection, so remember its address.
         via0.SetRport(&rport)
     }
     if self.nat_traversal && len(req.contacts) > 0 && !req.contacts[0].Asterisk && len(req.vias) == 1 {
@@ -366,8 +445,24 @@ func (self *sipTransactionManager) process_request(rtime *sippy_time.MonoTime, d
             req.nated = true
         }
     }
+    if proto := sippy_net.GetTransportProto(server); (proto == "WS" || proto == "WSS") && len(req.contacts) > 0 && !req.contacts[0].Asterisk && len(req.vias) == 1 {
+        var contact *sippy_header.SipAddress
+
+        // The WebSocket clients cannot accept connections, so any
+        // request towards them has to go over the same socket (RFC 7118).
+        contact, err = req.contacts[0].GetBody(self.config)
+        if err != nil {
+            self.logBadMessage("Bad Contact: " + err.Error(), data)
+            return
+        }
+        curl := contact.GetUrl()
+        curl.Host = sippy_net.NewMyAddress(address.Host.String())
+        curl.Port = sippy_net.NewMyPort(address.Port.String())
+        curl.Transport = strings.ToLower(proto)
+    }
     host, port := address.Host.String(), address.Port.String()
     req.source = sippy_net.NewHostPort(host, port)
+    req.flow = sippy_net.NewFlow(server, req.source)
     self.incomingRequest(req, checksum, tids, server, data)
 }
 
@@ -384,21 +479,19 @@ func (self *sipTransactionManager) CreateClientTransaction(req sippy_types.SipRe
         return nil, errors.New("BUG: Attempt to initiate transaction from terminated dialog!!!")
     }
     target := req.GetTarget()
+    auto_select := userv == nil
     if userv == nil {
-        var uv sippy_net.Transport
-        if laddress != nil {
-            uv = self.l4r.getServer(laddress, /*is_local =*/ true)
-        }
-        if uv == nil {
-            uv = self.l4r.getServer(target, /*is_local =*/ false)
-        }
-        if uv != nil {
-            userv = uv
-        }
+        userv = self.selectTransport(req, laddress)
     }
     if userv == nil {
         return nil, errors.New("BUG: cannot get userv from local4remote!!!")
     }
+    if via0, err := req.GetVias()[0].GetBody(); err == nil {
+        via0.SetTransport(sippy_net.GetTransportProto(userv))
+        if self.overload != nil {
+            via0.SetOCSupported("loss")
+        }
+    }
     tid, err = req.GetTId(true /*wCSM*/, true/*wBRN*/, false /*wTTG*/)
     if err != nil {
         return nil, err
@@ -409,16 +502,121 @@ func (self *sipTransactionManager) CreateClientTransaction(req sippy_types.SipRe
         return nil, errors.New("BUG: Attempt to initiate transaction with the same TID as existing one!!!")
     }
     data := []byte(req.LocalStr(userv.GetLAddress(), false /* compact */))
+    var udp_userv sippy_net.Transport
+    var udp_data []byte
+    if auto_select {
+        if tuserv, tdata := self.switchToTcp(userv, req, data); tuserv != nil {
+            // Keep UDP version as a fallback
+            udp_userv, udp_data = userv, data
+            userv, data = tuserv, tdata
+        }
+    }
     t, err = NewClientTransactionObj(req, tid, userv, data, self, resp_receiver, session_lock, target, eh, req_out_cb)
     if err != nil {
         return nil, err
     }
+    t.udp_userv, t.udp_data = udp_userv, udp_data
+    t.laddress, t.auto_userv = laddress, auto_select
     self.tclient[*tid] = t
     self.tclient_lock.Unlock()
     return t, nil
 }
 
+// selectTransport picks the transport to send the request to its
+// target.
+func (self *sipTransactionManager) selectTransport(req sippy_types.SipRequest, laddress *sippy_net.HostPort) sippy_net.Transport {
+    var userv sippy_net.Transport
+
+    target := req.GetTarget()
+    proto := self.getTargetProto(req)
+    if laddress != nil {
+        userv = self.l4r.getServer(laddress, /*is_local =*/ true, proto)
+    }
+    if userv == nil && target.Lookup != nil {
+        // Any transport would do until the lookup is complete, see
+        // clientTransaction.retarget()
+        userv = self.l4r.getAnyServer(proto)
+    }
+    if userv == nil {
+        userv = self.l4r.getServer(target, /*is_local =*/ false, proto)
+    }
+    if userv == nil && proto != "UDP" {
+        self.logError("No " + proto + " transport available for " + target.String() + ", falling back to UDP")
+        if laddress != nil {
+            userv = self.l4r.getServer(laddress, /*is_local =*/ true, "UDP")
+        }
+        if userv == nil {
+            userv = self.l4r.getServer(target, /*is_local =*/ false, "UDP")
+        }
+    }
+    return userv
+}
+
+// switchToTcp returns the TCP transport and the request re-rendered
+// for it when the request is too large to be sent over UDP (RFC 3261
+// section 18.1.1). Nil is returned when no switch is needed.
+func (self *sipTransactionManager) switchToTcp(userv sippy_net.Transport, req sippy_types.SipRequest, data []byte) (sippy_net.Transport, []byte) {
+    if sippy_net.IsReliable(userv) || ! self.config.GetAutoTcpSwitch() || len(data) <= self.config.GetTcpSwitchThreshold() {
+        return nil, nil
+    }
+    tuserv := self.l4r.getServer(userv.GetLAddress(), /*is_local =*/ true, "TCP")
+    if tuserv == nil {
+        return nil, nil
+    }
+    if via0, err := req.GetVias()[0].GetBody(); err == nil {
+        via0.SetTransport("TCP")
+    }
+    return tuserv, []byte(req.LocalStr(tuserv.GetLAddress(), false /* compact */))
+}
+
+func (self *sipTransactionManager) getTargetProto(req sippy_types.SipRequest) string {
+    if target := req.GetTarget(); target != nil && target.Proto != "" {
+        // The transport has been selected by the RFC 3263 lookup
+        return target.Proto
+    }
+    url := req.GetRURI()
+    if route, ok := req.GetFirstHF("route").(*sippy_header.SipRoute); ok {
+        // The request goes to the first Route, so does its transport
+        if r0, err := route.GetBody(self.config); err == nil {
+            url = r0.GetUrl()
+        }
+    }
+    if url == nil {
+        return "UDP"
+    }
+    return url.GetTransportProto()
+}
+
 func (self *sipTransactionManager) BeginClientTransaction(req sippy_types.SipRequest, tr sippy_types.ClientTransaction) {
+    if t, ok := tr.(*clientTransaction); ok && t.address != nil && t.address.Lookup != nil && self.config.GetResolver() != nil {
+        // The next hop is not in the resolver cache, look it up
+        // without holding the session lock and start afterwards.
+        address := t.address
+        go func() {
+            address = self.config.GetResolver().ResolveAddr(address)
+            t.lock.Lock()
+            defer t.lock.Unlock()
+            if t.sip_tm == nil {
+                return
+            }
+            t.retarget(req, address)
+            self.beginClientTransaction(req, t)
+        }()
+        return
+    }
+    self.beginClientTransaction(req, tr)
+}
+
+func (self *sipTransactionManager) beginClientTransaction(req sippy_types.SipRequest, tr sippy_types.ClientTransaction) {
+    if t, ok := tr.(*clientTransaction); ok && self.overload != nil && req.GetMethod() == "INVITE" && self.overload.Throttle(t.address) {
+        // The server has asked us to reduce the traffic, fail the
+        // request locally as if it has been rejected with 503. The
+        // Retry-After tells that the server itself is not down.
+        resp := req.GenResponse(503, "Service Unavailable", /*body*/ nil, /*server*/ nil)
+        resp.AppendHeader(sippy_header.NewSipGenericHF("Retry-After", strconv.Itoa(self.overload.retryAfter(t.address))))
+        t.failLocally(resp)
+        return
+    }
     tr.StartTimers()
     tr.BeforeRequestSent(req)
     tr.TransmitData()
@@ -494,6 +692,16 @@ func (self *sipTransactionManager) incomingRequest(req *sipRequest, checksum str
         // to seeing this anyway.
         //println("unmatched ACK transaction - ignoring")
         self.rcache_set_call_id(checksum, tid.CallId)
+        self.consumers_lock.Lock()
+        ack_consumers := self.ack_consumers
+        self.consumers_lock.Unlock()
+        for _, consumer := range ack_consumers {
+            taken := false
+            sippy_utils.SafeCall(func() { taken = consumer.RecvUnmatchedAck(req) }, nil, self.config.ErrorLogger())
+            if taken {
+                break
+            }
+        }
     case "PRACK":
         // Some ACK that doesn't match any existing transaction.
         // Drop and forget it - upper layer is unlikely to be interested
@@ -537,7 +745,7 @@ func (self *sipTransactionManager) new_server_transaction(server sippy_net.Trans
     if server.GetLAddress().Host.String() == "0.0.0.0" || server.GetLAddress().Host.String() == "[::]" {
         // For messages received on the wildcard interface find
         // or create more specific server.
-        userv = self.l4r.getServer(req.GetSource(), /*is_local*/ false)
+        userv = self.l4r.getServer(req.GetSource(), /*is_local*/ false, sippy_net.GetTransportProto(server))
         if userv == nil {
             self.logError("BUG! cannot create more specific server for transaction")
             userv = server
@@ -606,6 +814,29 @@ func (self *sipTransactionManager) new_server_transaction(server sippy_net.Trans
     }
 }
 
+// RegFlowConsumer registers the callback to be called on every
+// keep-alive pong received over the flow (RFC 5626 section 4.4.1).
+func (self *sipTransactionManager) RegFlowConsumer(flow *sippy_net.Flow, cb func()) {
+    self.flow_lock.Lock()
+    self.flow_consumers[flow.Token()] = cb
+    self.flow_lock.Unlock()
+}
+
+func (self *sipTransactionManager) UnregFlowConsumer(flow *sippy_net.Flow) {
+    self.flow_lock.Lock()
+    delete(self.flow_consumers, flow.Token())
+    self.flow_lock.Unlock()
+}
+
+func (self *sipTransactionManager) flowPong(flow *sippy_net.Flow) {
+    self.flow_lock.Lock()
+    cb, ok := self.flow_consumers[flow.Token()]
+    self.flow_lock.Unlock()
+    if ok {
+        cb()
+    }
+}
+
 func (self *sipTransactionManager) RegConsumer(consumer sippy_types.UA, call_id string) {
     self.consumers_lock.Lock()
     defer self.consumers_lock.Unlock()
@@ -667,8 +898,18 @@ func (self *sipTransactionManager) SendResponseWithLossEmul(resp sippy_types.Sip
 }
 
 func (self *sipTransactionManager) transmitMsg(userv sippy_net.Transport, msg sippy_types.SipMsg, address *sippy_net.HostPort, cachesum string, call_id string) {
+    if address.Lookup != nil && self.config.GetResolver() != nil {
+        // The caller may hold the session lock, so do not block on DNS
+        go func() {
+            self.transmitMsg(userv, msg, self.config.GetResolver().ResolveAddr(address), cachesum, call_id)
+        }()
+        return
+    }
     data := msg.LocalStr(userv.GetLAddress(), false /*compact*/)
     self.transmitData(userv, []byte(data), address, cachesum, call_id, 0)
+    if resp, ok := msg.(sippy_types.SipResponse); ok {
+        self.countResponse(resp.GetSCodeNum(), &self.resp_sent)
+    }
 }
 
 func (self *sipTransactionManager) transmitData(userv sippy_net.Transport, data []byte, address *sippy_net.HostPort, cachesum, call_id string, lossemul int /*=0*/) {
@@ -676,13 +917,23 @@ func (self *sipTransactionManager) transmitData(userv sippy_net.Transport, data
 }
 
 func (self *sipTransactionManager) transmitDataWithCb(userv sippy_net.Transport, data []byte, address *sippy_net.HostPort, cachesum, call_id string, lossemul int /*=0*/, on_complete func()) {
+    self.transmitDataWithErrCb(userv, data, address, cachesum, call_id, lossemul, on_complete, nil)
+}
+
+// transmitDataWithErrCb is the same as transmitDataWithCb but also
+// calls on_error when the stream transport cannot deliver the data.
+func (self *sipTransactionManager) transmitDataWithErrCb(userv sippy_net.Transport, data []byte, address *sippy_net.HostPort, cachesum, call_id string, lossemul int /*=0*/, on_complete func(), on_error func()) {
     logop := "SENDING"
     if lossemul == 0 {
-        userv.SendToWithCb(data, address, on_complete)
+        if tuserv, ok := userv.(*TcpServer); ok && on_error != nil {
+            tuserv.sendToWithErrCb(data, address, on_complete, on_error)
+        } else {
+            userv.SendToWithCb(data, address, on_complete)
+        }
     } else {
         logop = "DISCARDING"
     }
-    self.logMsg(nil, call_id, logop, address, data)
+    self.logMsg(nil, call_id, logop, address, data, userv)
     if len(cachesum) > 0 {
         if lossemul > 0 {
             lossemul--
@@ -730,16 +981,150 @@ func (self *sipTransactionManager) tserver_replace(old_tid, new_tid *sippy_heade
     self.tserver[*new_tid] = t
 }
 
+func (self *sipTransactionManager) countRetransmit() {
+    atomic.AddUint64(&self.retrans_sent, 1)
+}
+
+func (self *sipTransactionManager) countResponse(scode int, counters *[7]uint64) {
+    if class := scode / 100; class > 0 && class < len(counters) {
+        atomic.AddUint64(&counters[class], 1)
+    }
+}
+
+func (self *sipTransactionManager) GetStats() *sippy_types.SipTMStats {
+    stats := &sippy_types.SipTMStats{
+        RetransmitsSent     : atomic.LoadUint64(&self.retrans_sent),
+        RetransmitsReceived : atomic.LoadUint64(&self.retrans_rcvd),
+    }
+    for i := range stats.ResponsesSent {
+        stats.ResponsesSent[i] = atomic.LoadUint64(&self.resp_sent[i])
+        stats.ResponsesReceived[i] = atomic.LoadUint64(&self.resp_rcvd[i])
+    }
+    self.tclient_lock.Lock()
+    stats.ClientTransactions = len(self.tclient)
+    self.tclient_lock.Unlock()
+    self.tserver_lock.Lock()
+    stats.ServerTransactions = len(self.tserver)
+    self.tserver_lock.Unlock()
+    return stats
+}
+
+// GetTransactions returns the snapshot of all the transactions in
+// progress. The transactions are not locked while the lists are copied,
+// so that the call is safe to be made from any goroutine.
+func (self *sipTransactionManager) GetTransactions() []*sippy_types.TransactionInfo {
+    var trs []interface{ getInfo() *sippy_types.TransactionInfo }
+
+    self.tserver_lock.Lock()
+    for _, t := range self.tserver {
+        if st, ok := t.(*serverTransaction); ok {
+            trs = append(trs, st)
+        }
+    }
+    self.tserver_lock.Unlock()
+    self.tclient_lock.Lock()
+    for _, t := range self.tclient {
+        if ct, ok := t.(*clientTransaction); ok {
+            trs = append(trs, ct)
+        }
+    }
+    self.tclient_lock.Unlock()
+    ret := make([]*sippy_types.TransactionInfo, 0, len(trs))
+    for _, t := range trs {
+        if info := t.getInfo(); info != nil {
+            ret = append(ret, info)
+        }
+    }
+    return ret
+}
+
 func (self *sipTransactionManager) Shutdown() {
     close(self.shutdown_chan)
 }
 
 func (self *sipTransactionManager) beforeResponseSent(resp sippy_types.SipResponse) {
+    if self.overload != nil {
+        if via0, err := resp.GetVias()[0].GetBody(); err == nil {
+            self.overload.updateResponse(via0)
+        }
+    }
     if self.before_response_sent != nil {
         self.before_response_sent(resp)
     }
 }
 
+// Enable the RFC 7339 overload control. The fill level of the UDP send
+// queues is added to the load sources of the controller.
+func (self *sipTransactionManager) SetOverloadControl(oc *OverloadControl) {
+    oc.AddLoadSource(self.l4r.getQueueLoad)
+    self.overload = oc
+}
+
+// UnmatchedAckConsumer receives the ACKs that do not match any
+// transaction, i.e. the ACKs to 2xx passing through the proxy. The ACK
+// is offered to the consumers in the order of registration until one
+// of them takes it.
+type UnmatchedAckConsumer interface {
+    RecvUnmatchedAck(req sippy_types.SipRequest) bool
+}
+
+func (self *sipTransactionManager) RegUnmatchedAckConsumer(consumer UnmatchedAckConsumer) {
+    self.consumers_lock.Lock()
+    defer self.consumers_lock.Unlock()
+    for _, c := range self.ack_consumers {
+        if c == consumer {
+            return
+        }
+    }
+    // Copy on write, the list is iterated without the lock
+    ack_consumers := make([]UnmatchedAckConsumer, 0, len(self.ack_consumers) + 1)
+    self.ack_consumers = append(append(ack_consumers, self.ack_consumers...), consumer)
+}
+
+func (self *sipTransactionManager) UnregUnmatchedAckConsumer(consumer UnmatchedAckConsumer) {
+    self.consumers_lock.Lock()
+    defer self.consumers_lock.Unlock()
+    ack_consumers := make([]UnmatchedAckConsumer, 0, len(self.ack_consumers))
+    for _, c := range self.ack_consumers {
+        if c != consumer {
+            ack_consumers = append(ack_consumers, c)
+        }
+    }
+    self.ack_consumers = ack_consumers
+}
+
+// Send the request without creating the client transaction.
+func (self *sipTransactionManager) sendStateless(req sippy_types.SipRequest, address *sippy_net.HostPort) {
+    if address.Lookup != nil && self.config.GetResolver() != nil {
+        go func() {
+            self.sendStateless(req, self.config.GetResolver().ResolveAddr(address))
+        }()
+        return
+    }
+    req.SetTarget(address)
+    userv := self.l4r.getServer(address, /*is_local =*/ false, self.getTargetProto(req))
+    if userv == nil {
+        userv = self.l4r.getServer(address, /*is_local =*/ false, "UDP")
+    }
+    if userv == nil {
+        self.logError("Cannot get transport to send request to " + address.String())
+        return
+    }
+    if via0, err := req.GetVias()[0].GetBody(); err == nil {
+        via0.SetTransport(sippy_net.GetTransportProto(userv))
+    }
+    data := []byte(req.LocalStr(userv.GetLAddress(), false /*compact*/))
+    call_id := req.GetCallId().CallId
+    if tuserv, tdata := self.switchToTcp(userv, req, data); tuserv != nil {
+        self.transmitDataWithErrCb(tuserv, tdata, address, "", call_id, 0, nil, func() {
+            self.logError("Cannot deliver request to " + address.String() + " over TCP, falling back to UDP")
+            self.transmitData(userv, data, address, "", call_id, 0)
+        })
+        return
+    }
+    self.transmitData(userv, data, address, "", call_id, 0)
+}
+
 func (self *sipTransactionManager) SetBeforeResponseSent(cb func(sippy_types.SipResponse)) {
     self.before_response_sent = cb
 }
@@ -27,18 +27,39 @@
 package sippy
 
 import (
+    "sort"
+    "strings"
     "sync"
+    "time"
 
     "github.com/sippy/go-b2bua/sippy/conf"
     "github.com/sippy/go-b2bua/sippy/headers"
     "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
     "github.com/sippy/go-b2bua/sippy/types"
 )
 
+// ProxyTarget is the destination the request is forked to. When the
+// Address is nil the request is sent to the address of the RURI, when
+// the RURI is nil the Request-URI of the request is not changed. The
+// Routes (i.e. the Path of the registered contact) are put on top of
+// the route set of the request.
+type ProxyTarget struct {
+    Address     *sippy_net.HostPort
+    RURI        *sippy_header.SipURL
+    Q           float64
+    Routes      []*sippy_header.SipRoute
+}
+
 type statefulProxy struct {
     sip_tm      sippy_types.SipTransactionManager
     destination *sippy_net.HostPort
     config      sippy_conf.Config
+    targets     []*ProxyTarget
+    targets_cb  func(sippy_types.SipRequest) []*ProxyTarget
+    parallel    bool
+    serial_timeout time.Duration
+    record_route bool
 }
 
 func NewStatefulProxy(sip_tm sippy_types.SipTransactionManager, destination *sippy_net.HostPort, config sippy_conf.Config) *statefulProxy {
@@ -46,21 +67,482 @@ func NewStatefulProxy(sip_tm sippy_types.SipTransactionManager, destination *sip
         sip_tm      : sip_tm,
         destination : destination,
         config      : config,
+        parallel    : true,
     }
 }
 
-func (self *statefulProxy) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) *sippy_types.Ua_context {
+// Fork the requests to the fixed set of targets instead of the single
+// destination.
+func (self *statefulProxy) SetTargets(targets []*ProxyTarget) {
+    self.targets = targets
+}
+
+// The callback selects the targets for each request. The request is
+// rejected with 480 if the callback returns no targets.
+func (self *statefulProxy) SetTargetsCb(cb func(sippy_types.SipRequest) []*ProxyTarget) {
+    self.targets_cb = cb
+}
+
+// In the parallel mode (the default) the request is sent to all targets
+// at once. Otherwise the targets are tried one after another in the
+// order of decreasing q-value, the targets with equal q-values are
+// tried in parallel (RFC 3261 section 16.6).
+func (self *statefulProxy) SetParallel(parallel bool) {
+    self.parallel = parallel
+}
+
+// In the serial mode move on to the next target if the current one has
+// not produced the final response within the timeout (zero to wait for
+// the final response).
+func (self *statefulProxy) SetSerialTimeout(timeout time.Duration) {
+    self.serial_timeout = timeout
+}
+
+// Insert Record-Route into the dialog forming requests so that the
+// in-dialog requests are sent through the proxy. The ACKs to 2xx do
+// not match any transaction, so the proxy registers itself as the
+// consumer of such ACKs in the transaction manager.
+func (self *statefulProxy) SetRecordRoute(record_route bool) {
+    self.record_route = record_route
+    if sip_tm, ok := self.sip_tm.(*sipTransactionManager); ok {
+        if record_route {
+            sip_tm.RegUnmatchedAckConsumer(self)
+        } else {
+            sip_tm.UnregUnmatchedAckConsumer(self)
+        }
+    }
+}
+
+func (self *statefulProxy) isOurs(url *sippy_header.SipURL) bool {
+    sip_tm, ok := self.sip_tm.(*sipTransactionManager)
+    if ! ok || url == nil || url.Host == nil {
+        return false
+    }
+    port := url.Port
+    if port == nil {
+        if url.IsSecure() {
+            port = self.config.GetTlsPort()
+        } else {
+            port = self.config.DefaultPort()
+        }
+    }
+    return sip_tm.l4r.isLocal(sippy_net.NewHostPort(url.Host.String(), port.String()))
+}
+
+// Process the Route headers addressed to the proxy (RFC 3261 section
+// 16.4). Returns true if the request has to be forwarded according to
+// its route set and Request-URI rather than to the proxy targets, i.e.
+// the foreign Routes remain or the request is within a dialog.
+func (self *statefulProxy) processRoutes(req sippy_types.SipRequest) bool {
+    routes := req.GetRoutes()
+    if ruri := req.GetRURI(); len(routes) > 0 && ruri.Username == "" && self.isOurs(ruri) {
+        // The previous hop is a strict router, the Request-URI is
+        // the one we have put into Record-Route. Restore the original
+        // one from the last Route.
+        if r, err := routes[len(routes) - 1].GetBody(self.config); err == nil {
+            req.SetRURI(r.GetUrl().GetCopy())
+            routes = routes[:len(routes) - 1]
+        }
+    }
+    for len(routes) > 0 {
+        r, err := routes[0].GetBody(self.config)
+        if err != nil || ! self.isOurs(r.GetUrl()) {
+            break
+        }
+        routes = routes[1:]
+    }
+    req.SetRoutes(routes)
+    if len(routes) > 0 {
+        return true
+    }
+    to, err := req.GetTo().GetBody(self.config)
+    return err == nil && to.GetTag() != ""
+}
+
+// Returns the address to send the request to according to its route
+// set. When the next hop is a strict router its URI is moved into the
+// Request-URI (RFC 3261 section 16.6 step 6).
+func (self *statefulProxy) nextHop(req sippy_types.SipRequest) *sippy_net.HostPort {
+    routes := req.GetRoutes()
+    if len(routes) > 0 {
+        if r0, err := routes[0].GetBody(self.config); err == nil {
+            url := r0.GetUrl()
+            if ! url.Lr {
+                new_routes := make([]*sippy_header.SipRoute, 0, len(routes))
+                new_routes = append(new_routes, routes[1:]...)
+                new_routes = append(new_routes, sippy_header.NewSipRoute(sippy_header.NewSipAddress("", req.GetRURI())))
+                req.SetRURI(url.GetCopy())
+                req.SetRoutes(new_routes)
+            }
+            return url.GetAddr(self.config)
+        }
+    }
+    return req.GetRURI().GetAddr(self.config)
+}
+
+func (self *statefulProxy) newRecordRoute(userv sippy_net.Transport) *sippy_header.SipRecordRoute {
+    laddress := userv.GetLAddress()
+    host := sippy_net.NewMyAddress(laddress.Host.String())
+    if host.String() == "0.0.0.0" || host.String() == "[::]" {
+        host = self.config.GetMyAddress()
+    }
+    url := sippy_header.NewSipURL("", host, sippy_net.NewMyPort(laddress.Port.String()), /*lr*/ true)
+    if proto := sippy_net.GetTransportProto(userv); proto != "UDP" {
+        url.Transport = strings.ToLower(proto)
+    }
+    return sippy_header.NewSipRecordRoute(sippy_header.NewSipAddress("", url))
+}
+
+func (self *statefulProxy) RecvUnmatchedAck(req sippy_types.SipRequest) bool {
+    sip_tm, ok := self.sip_tm.(*sipTransactionManager)
+    if ! ok || ! self.processRoutes(req) {
+        return false
+    }
+    max_forwards := self.maxForwards(req)
+    if max_forwards < 0 {
+        // Consumed, ACK has no response
+        return true
+    }
+    req.SetMaxForwards(sippy_header.NewSipMaxForwards(max_forwards))
     via0 := sippy_header.NewSipVia(self.config)
     via0_body, _ := via0.GetBody()
     via0_body.GenBranch()
     req.InsertFirstVia(via0)
-    req.SetTarget(self.destination)
-    //print req
-    self.sip_tm.BeginNewClientTransaction(req, self, new(sync.Mutex), nil, nil, nil)
-    return &sippy_types.Ua_context{}
+    sip_tm.sendStateless(req, self.nextHop(req))
+    return true
+}
+
+func (self *statefulProxy) getTargets(req sippy_types.SipRequest) [][]*ProxyTarget {
+    var targets []*ProxyTarget
+
+    if self.targets_cb != nil {
+        targets = self.targets_cb(req)
+    } else if len(self.targets) > 0 {
+        targets = self.targets
+    } else {
+        targets = []*ProxyTarget{ &ProxyTarget{ Address : self.destination } }
+    }
+    if len(targets) == 0 {
+        return nil
+    }
+    if self.parallel {
+        return [][]*ProxyTarget{ targets }
+    }
+    sorted := make([]*ProxyTarget, len(targets))
+    copy(sorted, targets)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Q > sorted[j].Q })
+    groups := [][]*ProxyTarget{}
+    for i, target := range sorted {
+        if i == 0 || target.Q != sorted[i - 1].Q {
+            groups = append(groups, []*ProxyTarget{})
+        }
+        groups[len(groups) - 1] = append(groups[len(groups) - 1], target)
+    }
+    return groups
+}
+
+// Returns the Max-Forwards to be put into the forwarded copy of the
+// request or -1 if the request is not to be forwarded any further (RFC
+// 3261 sections 16.3 step 3 and 16.6 step 3).
+func (self *statefulProxy) maxForwards(req sippy_types.SipRequest) int {
+    mf := req.GetMaxForwards()
+    if mf == nil {
+        return 70
+    }
+    mf_body, err := mf.GetBody()
+    if err != nil || mf_body.Number <= 0 {
+        return -1
+    }
+    return mf_body.Number - 1
+}
+
+func (self *statefulProxy) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) *sippy_types.Ua_context {
+    var groups [][]*ProxyTarget
+
+    max_forwards := self.maxForwards(req)
+    if max_forwards < 0 {
+        return &sippy_types.Ua_context{
+            Response : req.GenResponse(483, "Too Many Hops", /*body*/ nil, /*server*/ nil),
+        }
+    }
+    if self.processRoutes(req) {
+        // In-dialog request or the request with the pre-loaded route
+        // set, no forking.
+        groups = [][]*ProxyTarget{ []*ProxyTarget{ &ProxyTarget{} } }
+    } else {
+        groups = self.getTargets(req)
+    }
+    if len(groups) == 0 {
+        return &sippy_types.Ua_context{
+            Response : req.GenResponse(480, "Temporarily Unavailable", /*body*/ nil, /*server*/ nil),
+        }
+    }
+    ctx := &proxyContext{
+        proxy       : self,
+        req         : req,
+        st          : t,
+        groups      : groups,
+        branches    : make([]*proxyBranch, 0),
+        max_forwards : max_forwards,
+    }
+    if st, ok := t.(*serverTransaction); ok {
+        ctx.userv = st.userv
+    }
+    if self.record_route {
+        switch req.GetMethod() {
+        case "INVITE", "SUBSCRIBE", "REFER":
+            if to, err := req.GetTo().GetBody(self.config); err == nil && to.GetTag() == "" {
+                ctx.record_route = true
+            }
+        }
+    }
+    t.UpgradeToSessionLock(&ctx.lock)
+    ctx.nextGroup()
+    return &sippy_types.Ua_context{ CancelCB : ctx.recvCancel }
+}
+
+// proxyContext is the response context of the request being forked
+// (RFC 3261 section 16.7). All its methods are called with the lock
+// held since it is the session lock of the server transaction and all
+// client transactions of the branches.
+type proxyContext struct {
+    proxy       *statefulProxy
+    lock        sync.Mutex
+    req         sippy_types.SipRequest
+    st          sippy_types.ServerTransaction
+    userv       sippy_net.Transport
+    groups      [][]*ProxyTarget
+    branches    []*proxyBranch
+    best        sippy_types.SipResponse
+    auth_resps  []sippy_types.SipResponse
+    final_sent  bool
+    serial_timer *Timeout
+    record_route bool
+    max_forwards int
 }
 
-func (self *statefulProxy) RecvResponse(resp sippy_types.SipResponse, t sippy_types.ClientTransaction) {
+type proxyBranch struct {
+    ctx         *proxyContext
+    tr          sippy_types.ClientTransaction
+    done        bool
+    cancelled   bool
+}
+
+func (self *proxyBranch) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.ClientTransaction) {
+    self.ctx.recvResponse(self, resp)
+}
+
+func (self *proxyContext) nextGroup() {
+    for len(self.groups) > 0 {
+        group := self.groups[0]
+        self.groups = self.groups[1:]
+        started := false
+        for _, target := range group {
+            if self.startBranch(target) {
+                started = true
+            }
+        }
+        if started {
+            if len(self.groups) > 0 && self.proxy.serial_timeout > 0 {
+                self.serial_timer = StartTimeout(self.serialTimeout, &self.lock, self.proxy.serial_timeout, 1, self.proxy.config.ErrorLogger())
+            }
+            return
+        }
+    }
+    self.sendBest()
+}
+
+func (self *proxyContext) startBranch(target *ProxyTarget) bool {
+    req := self.req.GetCopy()
+    via0 := sippy_header.NewSipVia(self.proxy.config)
+    via0_body, _ := via0.GetBody()
+    via0_body.GenBranch()
+    req.InsertFirstVia(via0)
+    req.SetMaxForwards(sippy_header.NewSipMaxForwards(self.max_forwards))
+    if target.RURI != nil {
+        req.SetRURI(target.RURI.GetCopy())
+    }
+    if len(target.Routes) > 0 {
+        routes := make([]*sippy_header.SipRoute, 0, len(target.Routes) + len(req.GetRoutes()))
+        for _, r := range target.Routes {
+            routes = append(routes, r.GetCopy())
+        }
+        req.SetRoutes(append(routes, req.GetRoutes()...))
+    }
+    address := target.Address
+    if address == nil {
+        address = self.proxy.nextHop(req)
+    }
+    req.SetTarget(address)
+    br := &proxyBranch{ ctx : self }
+    tr, err := self.proxy.sip_tm.CreateClientTransaction(req, br, &self.lock, /*laddress*/ nil, /*userv*/ nil, /*eh*/ nil, /*req_out_cb*/ nil)
+    if err != nil {
+        self.proxy.config.ErrorLogger().Error("Cannot fork request to " + address.String() + ": " + err.Error())
+        return false
+    }
+    br.tr = tr
+    if self.record_route {
+        self.recordRoute(req, tr)
+    }
+    self.branches = append(self.branches, br)
+    self.proxy.sip_tm.BeginClientTransaction(req, tr)
+    return true
+}
+
+// Insert Record-Route pointing to the outgoing interface. When the
+// request leaves over the other interface or transport than it has
+// arrived on, the second one pointing to the incoming interface is
+// inserted as well (RFC 5658).
+func (self *proxyContext) recordRoute(req sippy_types.SipRequest, tr sippy_types.ClientTransaction) {
+    ct, ok := tr.(*clientTransaction)
+    if ! ok {
+        return
+    }
+    if self.userv != nil && (sippy_net.GetTransportProto(self.userv) != sippy_net.GetTransportProto(ct.userv) ||
+      self.userv.GetLAddress().String() != ct.userv.GetLAddress().String()) {
+        req.InsertFirstRecordRoute(self.proxy.newRecordRoute(self.userv))
+    }
+    req.InsertFirstRecordRoute(self.proxy.newRecordRoute(ct.userv))
+    ct.updateRequest(req)
+}
+
+func (self *proxyContext) cancelSerialTimer() {
+    if self.serial_timer != nil {
+        self.serial_timer.Cancel()
+        self.serial_timer = nil
+    }
+}
+
+func (self *proxyContext) serialTimeout() {
+    self.serial_timer = nil
+    if self.final_sent || len(self.groups) == 0 {
+        return
+    }
+    self.cancelBranches()
+    self.nextGroup()
+}
+
+func (self *proxyContext) cancelBranches() {
+    for _, br := range self.branches {
+        if br.done || br.cancelled {
+            continue
+        }
+        br.cancelled = true
+        if self.req.GetMethod() == "INVITE" {
+            br.tr.Cancel()
+        }
+    }
+}
+
+func (self *proxyContext) recvCancel(rtime *sippy_time.MonoTime, req sippy_types.SipRequest) {
+    // The server transaction has already replied 487
+    self.final_sent = true
+    self.groups = nil
+    self.cancelSerialTimer()
+    self.cancelBranches()
+}
+
+func (self *proxyContext) recvResponse(br *proxyBranch, resp sippy_types.SipResponse) {
+    code := resp.GetSCodeNum()
+    if code < 200 {
+        if code > 100 && ! self.final_sent {
+            resp.RemoveFirstVia()
+            self.st.SendResponse(resp, /*retrans*/ false, nil)
+        }
+        return
+    }
+    br.done = true
+    if code < 300 {
+        resp.RemoveFirstVia()
+        if ! self.final_sent {
+            self.final_sent = true
+            self.st.SendResponse(resp, /*retrans*/ false, nil)
+        } else if self.req.GetMethod() == "INVITE" {
+            // All 2xx responses to INVITE are forwarded
+            self.forwardStateless(resp)
+        }
+        self.groups = nil
+        self.cancelSerialTimer()
+        self.cancelBranches()
+        return
+    }
     resp.RemoveFirstVia()
-    self.sip_tm.SendResponse(resp, /*lock*/true, nil)
+    if code == 401 || code == 407 {
+        self.auth_resps = append(self.auth_resps, resp)
+    }
+    if ! br.cancelled || self.best == nil {
+        self.updateBest(resp)
+    }
+    if code >= 600 {
+        // 6xx stops the forking
+        self.groups = nil
+        self.cancelSerialTimer()
+        self.cancelBranches()
+    }
+    for _, br := range self.branches {
+        if ! br.done {
+            return
+        }
+    }
+    self.cancelSerialTimer()
+    if len(self.groups) > 0 && ! self.final_sent {
+        self.nextGroup()
+        return
+    }
+    self.sendBest()
+}
+
+func responseRank(code int) int {
+    if code >= 600 {
+        return 0
+    }
+    return code / 100
+}
+
+func (self *proxyContext) updateBest(resp sippy_types.SipResponse) {
+    if self.best == nil || responseRank(resp.GetSCodeNum()) < responseRank(self.best.GetSCodeNum()) {
+        self.best = resp
+    }
+}
+
+func (self *proxyContext) sendBest() {
+    if self.final_sent {
+        return
+    }
+    self.final_sent = true
+    resp := self.best
+    if resp == nil {
+        // None of the branches could be started
+        resp = self.req.GenResponse(500, "Server Internal Error", /*body*/ nil, /*server*/ nil)
+    }
+    switch resp.GetSCodeNum() {
+    case 503:
+        resp.SetSCode(500, "Server Internal Error")
+    case 401, 407:
+        // Collect the challenges from all branches
+        for _, r := range self.auth_resps {
+            if r == resp {
+                continue
+            }
+            for _, hf := range r.GetSipWWWAuthenticates() {
+                resp.AppendHeader(hf)
+            }
+            for _, hf := range r.GetSipProxyAuthenticates() {
+                resp.AppendHeader(hf)
+            }
+        }
+    }
+    self.st.SendResponse(resp, /*retrans*/ false, nil)
+}
+
+func (self *proxyContext) forwardStateless(resp sippy_types.SipResponse) {
+    sip_tm, ok := self.proxy.sip_tm.(*sipTransactionManager)
+    if ! ok || self.userv == nil {
+        return
+    }
+    via0, err := resp.GetVias()[0].GetBody()
+    if err != nil {
+        return
+    }
+    sip_tm.transmitMsg(self.userv, resp, via0.GetTAddr(self.proxy.config), "", resp.GetCallId().CallId)
 }
@@ -0,0 +1,321 @@
+package sippy
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+type test_proxy_call_map struct {
+    proxy   *statefulProxy
+}
+
+func (self *test_proxy_call_map) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.ServerTransaction) (sippy_types.UA, sippy_types.RequestReceiver, sippy_types.SipResponse) {
+    return nil, self.proxy, nil
+}
+
+func Test_ForkingProxy(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(config.GetMyAddress())
+    config.SetSipPort(config.GetMyPort())
+    cmap := &test_proxy_call_map{}
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+    cmap.proxy = NewStatefulProxy(sip_tm, nil, config)
+    cmap.proxy.SetTargets([]*ProxyTarget{
+        &ProxyTarget{ Address : sippy_net.NewHostPort("2.2.2.2", "5060") },
+        &ProxyTarget{ Address : sippy_net.NewHostPort("3.3.3.3", "5060") },
+    })
+    tfactory.feed([]string{
+        "INVITE sip:bob@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK0b5aac35",
+        "Max-Forwards: 70",
+        "From: <sip:alice@example.com>;tag=as57b03f0f",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:alice@1.1.1.1:5060>",
+        "Call-ID: 5c1c2a5b7b6a4e1e@example.com",
+        "CSeq: 102 INVITE",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    rtime, _ := sippy_time.NewMonoTime()
+    forks := []*sipRequest{}
+    for len(forks) < 2 {
+        data := tfactory.get()
+        if strings.HasPrefix(string(data), "SIP/2.0") {
+            continue
+        }
+        req, perr := ParseSipRequest(data, rtime, config)
+        if perr != nil {
+            t.Fatal("Cannot parse forked INVITE: " + perr.Error())
+        }
+        if len(req.GetVias()) != 2 {
+            t.Fatal("The forked INVITE should have 2 Vias")
+        }
+        forks = append(forks, req)
+    }
+    via0, _ := forks[0].GetVias()[0].GetBody()
+    via1, _ := forks[1].GetVias()[0].GetBody()
+    if via0.GetBranch() == via1.GetBranch() {
+        t.Fatal("The branches should have different Via branch")
+    }
+    respond := func(req *sipRequest, scode int, reason string) {
+        resp := req.GenResponse(scode, reason, nil, nil)
+        to, _ := resp.GetTo().GetBody(config)
+        to.GenTag()
+        tfactory.feed([]string{ resp.LocalStr(nil, false) })
+    }
+    expect := func(sl string) []byte {
+        for {
+            data := tfactory.get()
+            if strings.HasPrefix(string(data), "SIP/2.0 100 ") {
+                continue
+            }
+            if ! strings.HasPrefix(string(data), sl) {
+                t.Fatalf("Got %q while expecting %q", strings.SplitN(string(data), "\r\n", 2)[0], sl)
+            }
+            return data
+        }
+    }
+    respond(forks[0], 180, "Ringing")
+    expect("SIP/2.0 180 Ringing")
+    respond(forks[1], 200, "OK")
+    data := expect("SIP/2.0 200 OK")
+    resp, err := ParseSipResponse(data, rtime, config)
+    if err != nil {
+        t.Fatal("Cannot parse 200 OK: " + err.Error())
+    }
+    if len(resp.GetVias()) != 1 {
+        t.Fatal("The forwarded response should have 1 Via")
+    }
+    // The ringing branch is cancelled
+    expect("CANCEL sip:bob@example.com SIP/2.0")
+}
+
+func Test_ProxyLoop(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(config.GetMyAddress())
+    config.SetSipPort(config.GetMyPort())
+    cmap := &test_proxy_call_map{}
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+    // The target points back at the proxy
+    cmap.proxy = NewStatefulProxy(sip_tm, sippy_net.NewHostPort("2.2.2.2", "5060"), config)
+    tfactory.feed([]string{
+        "INVITE sip:bob@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bKloop",
+        "Max-Forwards: 3",
+        "From: <sip:alice@example.com>;tag=as57b03f0f",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:alice@1.1.1.1:5060>",
+        "Call-ID: 6d2d3b6c8c7b5f2f@example.com",
+        "CSeq: 102 INVITE",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    hops := 0
+    for {
+        data := string(tfactory.get())
+        if strings.HasPrefix(data, "SIP/2.0 100 ") {
+            continue
+        }
+        if strings.HasPrefix(data, "INVITE ") {
+            hops++
+            if hops == 1 && ! strings.Contains(data, "\r\nMax-Forwards: 2\r\n") {
+                t.Fatal("Max-Forwards has not been decremented")
+            }
+            if hops > 3 {
+                t.Fatal("The request keeps looping")
+            }
+        }
+        if strings.HasPrefix(data, "SIP/2.0 ") && strings.Contains(data, "branch=z9hG4bKloop\r\n") {
+            if ! strings.HasPrefix(data, "SIP/2.0 483 ") {
+                t.Fatalf("Got %q while expecting 483", strings.SplitN(data, "\r\n", 2)[0])
+            }
+            break
+        }
+        // Deliver the message back to the proxy
+        tfactory.feed([]string{ data })
+    }
+}
+
+func Test_RecordRouteProxy(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(config.GetMyAddress())
+    config.SetSipPort(config.GetMyPort())
+    cmap := &test_proxy_call_map{}
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+    cmap.proxy = NewStatefulProxy(sip_tm, nil, config)
+    cmap.proxy.SetTargets([]*ProxyTarget{
+        &ProxyTarget{ Address : sippy_net.NewHostPort("2.2.2.2", "5060") },
+    })
+    cmap.proxy.SetRecordRoute(true)
+    myaddr := config.GetMyAddress().String() + ":" + config.GetMyPort().String()
+    tfactory.feed([]string{
+        "INVITE sip:bob@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK0b5aac36",
+        "Max-Forwards: 70",
+        "Route: <sip:" + myaddr + ";lr>, <sip:4.4.4.4;lr>",
+        "From: <sip:alice@example.com>;tag=as57b03f10",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:alice@1.1.1.1:5060>",
+        "Call-ID: 6d2d3b6c8c7b5f2f@example.com",
+        "CSeq: 102 INVITE",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    rtime, _ := sippy_time.NewMonoTime()
+    var req *sipRequest
+    for req == nil {
+        data := tfactory.get()
+        if strings.HasPrefix(string(data), "SIP/2.0") {
+            continue
+        }
+        req, err = ParseSipRequest(data, rtime, config)
+        if err != nil {
+            t.Fatal("Cannot parse forwarded INVITE: " + err.Error())
+        }
+    }
+    routes := req.GetRoutes()
+    if len(routes) != 1 {
+        t.Fatalf("The forwarded INVITE should have 1 Route, got %d", len(routes))
+    }
+    if r0, _ := routes[0].GetBody(config); r0.GetUrl().Host.String() != "4.4.4.4" {
+        t.Fatal("Our Route has not been removed")
+    }
+    rrs := req.GetRecordRoutes()
+    if len(rrs) != 1 {
+        t.Fatalf("The forwarded INVITE should have 1 Record-Route, got %d", len(rrs))
+    }
+    rr0, _ := rrs[0].GetBody(config)
+    if ! rr0.GetUrl().Lr || ! cmap.proxy.isOurs(rr0.GetUrl()) {
+        t.Fatal("Bad Record-Route: " + rr0.String())
+    }
+}
+
+type test_ack_consumer struct {
+    acks    int
+}
+
+func (self *test_ack_consumer) RecvUnmatchedAck(req sippy_types.SipRequest) bool {
+    self.acks++
+    return false
+}
+
+func Test_ProxyOwnRoute(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(config.GetMyAddress())
+    config.SetSipPort(config.GetMyPort())
+    cmap := &test_proxy_call_map{}
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go sip_tm.Run()
+    defer sip_tm.Shutdown()
+    other := &test_ack_consumer{}
+    sip_tm.RegUnmatchedAckConsumer(other)
+    cmap.proxy = NewStatefulProxy(sip_tm, nil, config)
+    target, _ := sippy_header.ParseSipURL("sip:bob@2.2.2.2", false, config)
+    cmap.proxy.SetTargetsCb(func(sippy_types.SipRequest) []*ProxyTarget {
+        return []*ProxyTarget{ &ProxyTarget{ RURI : target } }
+    })
+    cmap.proxy.SetRecordRoute(true)
+    myaddr := config.GetMyAddress().String() + ":" + config.GetMyPort().String()
+    next_request := func() string {
+        for {
+            data := string(tfactory.get())
+            if ! strings.HasPrefix(data, "SIP/2.0") {
+                return strings.SplitN(data, "\r\n", 2)[0]
+            }
+        }
+    }
+    // The initial request routed to us only is forwarded to the targets
+    tfactory.feed([]string{
+        "INVITE sip:bob@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK0b5aac37",
+        "Max-Forwards: 70",
+        "Route: <sip:" + myaddr + ";lr>",
+        "From: <sip:alice@example.com>;tag=as57b03f11",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:alice@1.1.1.1:5060>",
+        "Call-ID: 7e3e4c7d9d8c6a3a@example.com",
+        "CSeq: 102 INVITE",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    if rl := next_request(); rl != "INVITE sip:bob@2.2.2.2 SIP/2.0" {
+        t.Fatalf("The INVITE has not been sent to the target: %q", rl)
+    }
+    // The ACK to 2xx is offered to all consumers
+    tfactory.feed([]string{
+        "ACK sip:bob@2.2.2.2 SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK0b5aac38",
+        "Max-Forwards: 70",
+        "Route: <sip:" + myaddr + ";lr>",
+        "From: <sip:alice@example.com>;tag=as57b03f11",
+        "To: <sip:bob@example.com>;tag=bob1",
+        "Call-ID: 7e3e4c7d9d8c6a3a@example.com",
+        "CSeq: 102 ACK",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    if rl := next_request(); rl != "ACK sip:bob@2.2.2.2 SIP/2.0" {
+        t.Fatalf("The ACK has not been forwarded: %q", rl)
+    }
+    if other.acks != 1 {
+        t.Fatal("The ACK has not been offered to the other consumer")
+    }
+    // The looping ACK is dropped, the next one is forwarded with
+    // Max-Forwards decremented
+    for _, mf := range []string{ "0", "70" } {
+        tfactory.feed([]string{
+            "ACK sip:bob@2.2.2.2 SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK0b5aac39" + mf,
+            "Max-Forwards: " + mf,
+            "Route: <sip:" + myaddr + ";lr>",
+            "From: <sip:alice@example.com>;tag=as57b03f11",
+            "To: <sip:bob@example.com>;tag=bob1",
+            "Call-ID: 7e3e4c7d9d8c6a3a@example.com",
+            "CSeq: 102 ACK",
+            "Content-Length: 0",
+            "",
+            "",
+        })
+    }
+    if data := string(tfactory.get()); ! strings.Contains(data, "\r\nMax-Forwards: 69\r\n") {
+        t.Fatalf("Expected the ACK with Max-Forwards decremented, got:\n%s", data)
+    }
+}
@@ -33,4 +33,46 @@ func Test_SipTransactionManager(t *testing.T) {
     if numGoroutinesBefore != numGoroutinesAfter {
         t.Fatalf("numGoroutinesBefore = %d, numGoroutinesAfter = %d\n", numGoroutinesBefore, numGoroutinesAfter)
     }
-}
\ No newline at end of file
+}
+
+func Test_GetTransactions(t *testing.T) {
+    var err error
+
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    config.SetSipAddress(config.GetMyAddress())
+    config.SetSipPort(config.GetMyPort())
+    cmap := NewTestCallMap(config)
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    cmap.sip_tm, err = NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go cmap.sip_tm.Run()
+    defer cmap.sip_tm.Shutdown()
+    tfactory.feed([]string{
+        "INVITE sip:905399232076@10.20.30.40 SIP/2.0",
+        "Via: SIP/2.0/UDP 10.164.244.209:5060;branch=z9hG4bK0b5aac35",
+        "Max-Forwards: 70",
+        "From: <sip:testcli@sip.test.com>;tag=as57b03f0f",
+        "To: <sip:905399232076@sip-carriers.local>",
+        "Contact: <sip:908502729000@10.164.244.209:5060>",
+        "Call-ID: 0b2b1c0d6d8a4e0e@sip.test.com",
+        "CSeq: 102 INVITE",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    tfactory.get() // 100 Trying
+    trs := cmap.sip_tm.GetTransactions()
+    if len(trs) != 1 {
+        t.Fatalf("1 transaction expected, got %d", len(trs))
+    }
+    tr := trs[0]
+    if ! tr.Server || tr.Method != "INVITE" || tr.State != "RINGING" || tr.TID.CallId != "0b2b1c0d6d8a4e0e@sip.test.com" {
+        t.Fatalf("unexpected transaction: %+v", *tr)
+    }
+    if tr.RAddress == nil || tr.RAddress.String() != "1.1.1.1:5060" {
+        t.Fatal("unexpected remote address")
+    }
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "sync"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+const (
+    SUBS_DEFAULT_EXPIRES = 3600
+    SUBS_MIN_EXPIRES = 60
+    SUBS_MAX_EXPIRES = 7200
+    SUBS_REFRESH_MARGIN = 30 * time.Second
+    SUBS_NOTIFY_WAIT = 32 * time.Second
+)
+
+// SubscriptionMap keeps the subscription dialogs (RFC 6665) of both
+// the subscribers and the notifiers. The in-dialog requests do not
+// match any UA, so the CallMap has to look them up in its
+// OnNewDialog():
+//
+//    if rr := smap.Lookup(req); rr != nil {
+//        return nil, rr, nil
+//    }
+type SubscriptionMap struct {
+    config      sippy_conf.Config
+    lock        sync.Mutex
+    dialogs     map[string]sippy_types.RequestReceiver
+}
+
+func NewSubscriptionMap(config sippy_conf.Config) *SubscriptionMap {
+    return &SubscriptionMap{
+        config  : config,
+        dialogs : make(map[string]sippy_types.RequestReceiver),
+    }
+}
+
+func subscriptionKey(call_id, local_tag string) string {
+    return call_id + ";" + local_tag
+}
+
+// Lookup returns the subscriber or the notifier the in-dialog request
+// belongs to or nil.
+func (self *SubscriptionMap) Lookup(req sippy_types.SipRequest) sippy_types.RequestReceiver {
+    to, err := req.GetTo().GetBody(self.config)
+    if err != nil || to.GetTag() == "" {
+        return nil
+    }
+    key := subscriptionKey(req.GetCallId().StringBody(), to.GetTag())
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return self.dialogs[key]
+}
+
+func (self *SubscriptionMap) Len() int {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return len(self.dialogs)
+}
+
+func (self *SubscriptionMap) add(key string, rr sippy_types.RequestReceiver) {
+    self.lock.Lock()
+    self.dialogs[key] = rr
+    self.lock.Unlock()
+}
+
+func (self *SubscriptionMap) remove(key string) {
+    self.lock.Lock()
+    delete(self.dialogs, key)
+    self.lock.Unlock()
+}
+
+// The dialog state shared by the subscriber and the notifier.
+type sipDialog struct {
+    config      sippy_conf.Config
+    call_id     *sippy_header.SipCallId
+    local       *sippy_header.SipAddress
+    remote      *sippy_header.SipAddress
+    lcseq       int
+    rcseq       int
+    rtarget     *sippy_header.SipURL
+    routes      []*sippy_header.SipRoute
+    raddr       *sippy_net.HostPort
+    lcontact    *sippy_header.SipContact
+    username    string
+    password    string
+}
+
+func (self *sipDialog) key() string {
+    return subscriptionKey(self.call_id.StringBody(), self.local.GetTag())
+}
+
+// setRouting establishes the route set and the remote target. The
+// Record-Route of the response is reversed at UAC, the one of the
+// request is used as is at UAS (RFC 3261 section 12.1).
+func (self *sipDialog) setRouting(msg sippy_types.SipMsg, reverse_routes bool) {
+    if len(msg.GetContacts()) > 0 {
+        if contact, err := msg.GetContacts()[0].GetBody(self.config); err == nil {
+            self.rtarget = contact.GetUrl().GetCopy()
+        }
+    }
+    rrs := msg.GetRecordRoutes()
+    self.routes = make([]*sippy_header.SipRoute, len(rrs))
+    for i, r := range rrs {
+        if reverse_routes {
+            self.routes[len(rrs) - i - 1] = r.AsSipRoute()
+        } else {
+            self.routes[i] = r.AsSipRoute()
+        }
+    }
+    self.raddr = self.rtarget.GetAddr(self.config)
+    if len(self.routes) > 0 {
+        r0, err := self.routes[0].GetBody(self.config)
+        if err != nil {
+            return
+        }
+        if ! r0.GetUrl().Lr {
+            self.routes = append(self.routes[1:], sippy_header.NewSipRoute(sippy_header.NewSipAddress("", self.rtarget)))
+            self.rtarget = r0.GetUrl().GetCopy()
+        }
+        self.raddr = r0.GetUrl().GetAddr(self.config)
+    }
+}
+
+// checkCSeq verifies that the in-dialog requests arrive in order.
+func (self *sipDialog) checkCSeq(req sippy_types.SipRequest) bool {
+    cseq, err := req.GetCSeq().GetBody()
+    if err != nil || (self.rcseq >= 0 && cseq.CSeq <= self.rcseq) {
+        return false
+    }
+    self.rcseq = cseq.CSeq
+    return true
+}
+
+func (self *sipDialog) genRequest(method string, body sippy_types.MsgBody, challenge sippy_types.Challenge, extra_headers ...sippy_header.SipHeader) (sippy_types.SipRequest, error) {
+    routes := make([]*sippy_header.SipRoute, len(self.routes))
+    for i, r := range self.routes {
+        routes[i] = r.GetCopy()
+    }
+    req, err := NewSipRequest(method, /*ruri*/ self.rtarget.GetCopy(), /*sipver*/ "",
+                    /*to*/ sippy_header.NewSipTo(self.remote.GetCopy(), self.config),
+                    /*fr0m*/ sippy_header.NewSipFrom(self.local.GetCopy(), self.config),
+                    /*via*/ nil, self.lcseq, self.call_id.GetCopy(), /*maxforwars*/ nil, body,
+                    self.lcontact.GetCopy(), routes, self.raddr, /*user_agent*/ nil,
+                    /*expires*/ nil, self.config)
+    if err != nil {
+        return nil, err
+    }
+    if challenge != nil {
+        entity_body := ""
+        if body != nil {
+            entity_body = body.String()
+        }
+        auth, err := challenge.GenAuthHF(self.username, self.password, method, self.rtarget.String(), entity_body)
+        if err == nil {
+            req.AppendHeader(auth)
+        }
+    }
+    for _, hf := range extra_headers {
+        req.AppendHeader(hf)
+    }
+    self.lcseq++
+    return req, nil
+}
+
+// refreshInterval returns when the subscription or the registration
+// has to be refreshed so that it never lapses.
+func refreshInterval(expires time.Duration) time.Duration {
+    if expires > 2 * SUBS_REFRESH_MARGIN {
+        return expires - SUBS_REFRESH_MARGIN
+    }
+    return expires / 2
+}
+
+func getEvent(msg sippy_types.SipMsg) *sippy_header.SipEventBody {
+    if hf, ok := msg.GetFirstHF("event").(*sippy_header.SipEvent); ok {
+        if event, err := hf.GetBody(); err == nil {
+            return event
+        }
+    }
+    return nil
+}
+
+func getExpires(msg sippy_types.SipMsg, dflt int) int {
+    if hf, ok := msg.GetFirstHF("expires").(*sippy_header.SipExpires); ok {
+        if body, err := hf.GetBody(); err == nil {
+            return body.Number
+        }
+    }
+    return dflt
+}
+
+func newSipExpires(expires int) *sippy_header.SipExpires {
+    hf := sippy_header.NewSipExpires()
+    hf.Number = expires
+    return hf
+}
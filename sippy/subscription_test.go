@@ -0,0 +1,182 @@
+package sippy
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+type test_subs_call_map struct {
+    config      sippy_conf.Config
+    sip_tm      sippy_types.SipTransactionManager
+    smap        *SubscriptionMap
+}
+
+func (self *test_subs_call_map) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.ServerTransaction) (sippy_types.UA, sippy_types.RequestReceiver, sippy_types.SipResponse) {
+    if rr := self.smap.Lookup(req); rr != nil {
+        return nil, rr, nil
+    }
+    if req.GetMethod() == "SUBSCRIBE" {
+        return nil, NewSipNotifier(self.config, self.sip_tm, self.smap, func(n *SipNotifier, req sippy_types.SipRequest) (int, string) {
+            n.Notify(sippy_header.SUBSCRIPTION_STATE_ACTIVE, NewMsgBody("test", "text/plain"), "")
+            return 200, "OK"
+        }), nil
+    }
+    return nil, nil, req.GenResponse(481, "Call Leg/Transaction Does Not Exist", nil, nil)
+}
+
+func newSubsTestEnv(t *testing.T) (*test_subs_call_map, *test_sip_transport_factory, func()) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    cmap := &test_subs_call_map{ config : config, smap : NewSubscriptionMap(config) }
+    sip_tm, err := NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    cmap.sip_tm = sip_tm
+    go sip_tm.Run()
+    return cmap, tfactory, sip_tm.Shutdown
+}
+
+func expectMsg(t *testing.T, tfactory *test_sip_transport_factory, config sippy_conf.Config, sl string) sippy_types.SipMsg {
+    data := tfactory.get()
+    if ! strings.HasPrefix(string(data), sl) {
+        t.Fatalf("Got %q while expecting %q", strings.SplitN(string(data), "\r\n", 2)[0], sl)
+    }
+    rtime, _ := sippy_time.NewMonoTime()
+    if strings.HasPrefix(sl, "SIP/2.0") {
+        resp, err := ParseSipResponse(data, rtime, config)
+        if err != nil {
+            t.Fatal("Cannot parse response: " + err.Error())
+        }
+        return resp
+    }
+    req, err := ParseSipRequest(data, rtime, config)
+    if err != nil {
+        t.Fatal("Cannot parse request: " + err.Error())
+    }
+    return req
+}
+
+func getSubscriptionState(t *testing.T, msg sippy_types.SipMsg) *sippy_header.SipSubscriptionStateBody {
+    hf, ok := msg.GetFirstHF("subscription-state").(*sippy_header.SipSubscriptionState)
+    if ! ok {
+        t.Fatal("No Subscription-State in NOTIFY")
+    }
+    ss, err := hf.GetBody()
+    if err != nil {
+        t.Fatal("Cannot parse Subscription-State: " + err.Error())
+    }
+    return ss
+}
+
+func Test_SipNotifier(t *testing.T) {
+    cmap, tfactory, shutdown := newSubsTestEnv(t)
+    defer shutdown()
+    subscribe := func(to_tag, expires string, cseq string) {
+        tfactory.feed([]string{
+            "SUBSCRIBE sip:bob@example.com SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK5d6e" + cseq,
+            "Max-Forwards: 70",
+            "From: <sip:alice@example.com>;tag=8a7b6c",
+            "To: <sip:bob@example.com>" + to_tag,
+            "Contact: <sip:alice@1.1.1.1:5060>",
+            "Call-ID: 9f4f5d8eae9d7b4b@example.com",
+            "CSeq: " + cseq + " SUBSCRIBE",
+            "Event: test;id=1",
+            "Expires: " + expires,
+            "Content-Length: 0",
+            "",
+            "",
+        })
+    }
+    subscribe("", "600", "1")
+    resp := expectMsg(t, tfactory, cmap.config, "SIP/2.0 200 OK").(sippy_types.SipResponse)
+    to, _ := resp.GetTo().GetBody(cmap.config)
+    if to.GetTag() == "" {
+        t.Fatal("No To tag in 200 OK")
+    }
+    notify := expectMsg(t, tfactory, cmap.config, "NOTIFY sip:alice@1.1.1.1:5060 SIP/2.0")
+    if ss := getSubscriptionState(t, notify); ss.State != "active" || ss.Expires <= 0 || ss.Expires > 600 {
+        t.Fatal("Bad Subscription-State: " + ss.String())
+    }
+    if event := getEvent(notify); event == nil || event.Id != "1" {
+        t.Fatal("Bad Event in NOTIFY")
+    }
+    if cmap.smap.Len() != 1 {
+        t.Fatal("The subscription has not been registered")
+    }
+    subscribe(";tag=" + to.GetTag(), "0", "2")
+    expectMsg(t, tfactory, cmap.config, "SIP/2.0 200 OK")
+    notify = expectMsg(t, tfactory, cmap.config, "NOTIFY sip:alice@1.1.1.1:5060 SIP/2.0")
+    if ss := getSubscriptionState(t, notify); ! ss.IsTerminated() || ss.Reason != "timeout" {
+        t.Fatal("Bad Subscription-State: " + ss.String())
+    }
+    if cmap.smap.Len() != 0 {
+        t.Fatal("The subscription has not been removed")
+    }
+}
+
+func Test_SipSubscriber(t *testing.T) {
+    cmap, tfactory, shutdown := newSubsTestEnv(t)
+    defer shutdown()
+    config := cmap.config
+    ruri, _ := sippy_header.ParseSipURL("sip:bob@2.2.2.2", false, config)
+    from, _ := sippy_header.ParseSipAddress("<sip:alice@example.com>", false, config)
+    to, _ := sippy_header.ParseSipAddress("<sip:bob@example.com>", false, config)
+    subs := NewSipSubscriber(config, cmap.sip_tm, cmap.smap, ruri, from, to, sippy_header.NewSipEvent("test", ""), 600, nil)
+    states := []string{}
+    term_reason := ""
+    subs.SetNotifyCb(func(req sippy_types.SipRequest, ss *sippy_header.SipSubscriptionStateBody) {
+        states = append(states, ss.State)
+    })
+    subs.SetTermCb(func(reason string) { term_reason = reason })
+    subs.Lock.Lock()
+    subs.Subscribe()
+    subs.Lock.Unlock()
+    req := expectMsg(t, tfactory, config, "SUBSCRIBE sip:bob@2.2.2.2 SIP/2.0").(sippy_types.SipRequest)
+    req_from, _ := req.GetFrom().GetBody(config)
+    notify := func(state string, cseq string) {
+        tfactory.feed([]string{
+            "NOTIFY sip:alice@1.1.1.1:5060 SIP/2.0",
+            "Via: SIP/2.0/UDP 2.2.2.2:5060;branch=z9hG4bK7f8a" + cseq,
+            "Max-Forwards: 70",
+            "From: <sip:bob@example.com>;tag=1c2d3e",
+            "To: <sip:alice@example.com>;tag=" + req_from.GetTag(),
+            "Contact: <sip:bob@2.2.2.2:5060>",
+            "Call-ID: " + req.GetCallId().StringBody(),
+            "CSeq: " + cseq + " NOTIFY",
+            "Event: test",
+            "Subscription-State: " + state,
+            "Content-Length: 0",
+            "",
+            "",
+        })
+    }
+    // NOTIFY ahead of 200 OK
+    notify("active;expires=600", "1")
+    expectMsg(t, tfactory, config, "SIP/2.0 200 OK")
+    resp := req.GenResponse(200, "OK", nil, nil)
+    resp_to, _ := resp.GetTo().GetBody(config)
+    resp_to.SetTag("1c2d3e")
+    tfactory.feed([]string{ resp.LocalStr(nil, false) })
+    notify("terminated;reason=deactivated", "2")
+    expectMsg(t, tfactory, config, "SIP/2.0 200 OK")
+    subs.Lock.Lock()
+    defer subs.Lock.Unlock()
+    if len(states) != 2 || states[0] != "active" || states[1] != "terminated" {
+        t.Fatalf("Unexpected states %v", states)
+    }
+    if ! subs.IsTerminated() || term_reason != "deactivated" {
+        t.Fatal("The subscription has not been terminated")
+    }
+    if cmap.smap.Len() != 0 {
+        t.Fatal("The subscription has not been removed")
+    }
+}
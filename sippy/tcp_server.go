@@ -0,0 +1,599 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+    "github.com/sippy/go-b2bua/sippy/utils"
+)
+
+const (
+    TCP_IDLE_TIMEOUT    = 180 * time.Second
+    TCP_CONNECT_TIMEOUT = 5 * time.Second
+    TCP_MAX_MSG_SIZE    = 65536
+)
+
+type streamDialer func(laddress, raddress *sippy_net.HostPort) (net.Conn, error)
+
+// streamPeer is the connection the framer reads from. It allows the
+// framer to answer the transport level control messages (pings etc).
+type streamPeer interface {
+    reply(data []byte)
+    // pongExpected reports if we have sent the CRLF keep-alive ping
+    // that has not been answered yet and marks it answered.
+    pongExpected() bool
+}
+
+// streamFramer splits the byte stream into SIP messages and wraps the
+// outgoing messages into the transport specific frames.
+type streamFramer interface {
+    readMessage(rd *bufio.Reader, peer streamPeer) ([]byte, error)
+    frameMessage(data []byte) []byte
+    // handshake is performed on the accepted connection before any
+    // SIP message is exchanged over it.
+    handshake(conn net.Conn, rd *bufio.Reader) error
+}
+
+type sipStreamFramer struct {
+}
+
+// readMessage handles the RFC 5626 section 3.5.1 keep-alives. A lone
+// CRLF received while our ping is outstanding is the pong and is passed
+// up so that the flow keep-alive could be refreshed. Otherwise the CRLF
+// is the start of the double CRLF ping, which is answered with a single
+// CRLF, or an empty line before the message, which is ignored.
+func (self sipStreamFramer) readMessage(rd *bufio.Reader, peer streamPeer) ([]byte, error) {
+    for {
+        b, err := rd.Peek(2)
+        if err != nil {
+            return nil, err
+        }
+        if string(b) != "\r\n" {
+            return readStreamMessage(rd)
+        }
+        rd.Discard(2)
+        if peer.pongExpected() {
+            return []byte("\r\n"), nil
+        }
+        b, err = rd.Peek(2)
+        if err != nil {
+            return nil, err
+        }
+        if string(b) == "\r\n" {
+            rd.Discard(2)
+            peer.reply([]byte("\r\n"))
+        }
+    }
+}
+
+func (self sipStreamFramer) frameMessage(data []byte) []byte {
+    return data
+}
+
+func (self sipStreamFramer) handshake(net.Conn, *bufio.Reader) error {
+    return nil
+}
+
+type tcpConnection struct {
+    conn        net.Conn
+    raddress    *sippy_net.HostPort
+    server      *TcpServer
+    pool        *tcpConnPool
+    wi          chan *write_req
+    done        chan struct{}
+    close_once  sync.Once
+    lock        sync.Mutex
+    last_active time.Time
+    ping_sent   bool
+}
+
+func (self *write_req) failed() {
+    if self.on_error != nil {
+        self.on_error()
+    }
+}
+
+func (self *tcpConnection) touch() {
+    self.lock.Lock()
+    self.last_active = time.Now()
+    self.lock.Unlock()
+}
+
+func (self *tcpConnection) idleFor() time.Duration {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    return time.Since(self.last_active)
+}
+
+func (self *tcpConnection) send(wi *write_req) bool {
+    select {
+    case <-self.done:
+        return false
+    default:
+    }
+    select {
+    case self.wi <- wi:
+        return true
+    case <-self.done:
+        return false
+    }
+}
+
+// setConn attaches the freshly dialed connection unless close() has
+// already been called in which case the connection is closed at once.
+func (self *tcpConnection) setConn(conn net.Conn) bool {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    select {
+    case <-self.done:
+        conn.Close()
+        return false
+    default:
+    }
+    self.conn = conn
+    return true
+}
+
+func (self *tcpConnection) close() {
+    self.close_once.Do(func() {
+        close(self.done)
+        self.lock.Lock()
+        conn := self.conn
+        self.lock.Unlock()
+        if conn != nil {
+            conn.Close()
+        }
+        self.pool.remove(self)
+        // Report the messages that have never been sent
+        for drained := false; ! drained; {
+            select {
+            case wi := <-self.wi:
+                wi.failed()
+            default:
+                drained = true
+            }
+        }
+    })
+}
+
+func (self *tcpConnection) run_writer() {
+    self.lock.Lock()
+    conn := self.conn
+    self.lock.Unlock()
+    if conn == nil {
+        var err error
+        conn, err = self.pool.dial(self.server.topts.LAddress, self.raddress)
+        if err != nil {
+            self.pool.logger.Errorf("%s_server: cannot connect to %s: %s", self.server.proto, self.raddress.String(), err.Error())
+            self.close()
+            return
+        }
+        if ! self.setConn(conn) {
+            return
+        }
+        go self.run_reader(bufio.NewReader(conn))
+    }
+    for {
+        select {
+        case <-self.done:
+            return
+        case wi := <-self.wi:
+            if string(wi.data) == "\r\n\r\n" {
+                self.lock.Lock()
+                self.ping_sent = true
+                self.lock.Unlock()
+            }
+            if _, err := conn.Write(wi.data); err != nil {
+                self.pool.logger.Errorf("%s_server: cannot send to %s: %s", self.server.proto, self.raddress.String(), err.Error())
+                wi.failed()
+                self.close()
+                return
+            }
+            self.touch()
+            if wi.on_complete != nil {
+                wi.on_complete()
+            }
+        }
+    }
+}
+
+func (self *tcpConnection) reply(data []byte) {
+    self.send(&write_req{ data : data })
+}
+
+func (self *tcpConnection) pongExpected() bool {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    ret := self.ping_sent
+    self.ping_sent = false
+    return ret
+}
+
+func (self *tcpConnection) run_reader(rd *bufio.Reader) {
+    for {
+        msg, err := self.pool.framer.readMessage(rd, self)
+        if err != nil {
+            if err != io.EOF && ! errors.Is(err, net.ErrClosed) {
+                self.pool.logger.Debugf("%s_server: connection to %s closed: %s", self.server.proto, self.raddress.String(), err.Error())
+            }
+            break
+        }
+        self.touch()
+        rtime, err := sippy_time.NewMonoTime()
+        if err != nil {
+            self.pool.logger.Error("Cannot create MonoTime object")
+            continue
+        }
+        sippy_utils.SafeCall(func() { self.server.handle_read(msg, self.raddress, rtime) }, nil, self.pool.logger)
+    }
+    self.close()
+}
+
+// readStreamMessage extracts the next SIP message from the stream using
+// the Content-Length header to find the message boundary. Empty lines
+// between the messages (CRLF keep-alives) are skipped.
+func readStreamMessage(rd *bufio.Reader) ([]byte, error) {
+    var buf bytes.Buffer
+    clen := 0
+    for {
+        line, err := rd.ReadString('\n')
+        if err != nil {
+            return nil, err
+        }
+        tline := strings.TrimRight(line, "\r\n")
+        if buf.Len() == 0 && tline == "" {
+            continue
+        }
+        buf.WriteString(line)
+        if buf.Len() > TCP_MAX_MSG_SIZE {
+            return nil, errors.New("SIP message headers are too long")
+        }
+        if tline == "" {
+            break
+        }
+        arr := strings.SplitN(tline, ":", 2)
+        if len(arr) != 2 {
+            continue
+        }
+        switch strings.ToLower(strings.TrimSpace(arr[0])) {
+        case "content-length", "l":
+            clen, err = strconv.Atoi(strings.TrimSpace(arr[1]))
+            if err != nil || clen < 0 {
+                return nil, errors.New("bad Content-Length: " + arr[1])
+            }
+        }
+    }
+    if clen > TCP_MAX_MSG_SIZE {
+        return nil, errors.New("SIP message body is too long")
+    }
+    body := make([]byte, clen)
+    if _, err := io.ReadFull(rd, body); err != nil {
+        return nil, err
+    }
+    buf.Write(body)
+    return buf.Bytes(), nil
+}
+
+// tcpConnPool keeps track of all established connections so that any
+// server created by the same factory can reuse them.
+type tcpConnPool struct {
+    conns           map[string]*tcpConnection
+    lock            sync.Mutex
+    idle_timeout    time.Duration
+    logger          sippy_log.ErrorLogger
+    dial            streamDialer
+    framer          streamFramer
+    nusers          int
+    shutdown_chan   chan struct{}
+}
+
+func newTcpConnPool(logger sippy_log.ErrorLogger, idle_timeout time.Duration, dial streamDialer) *tcpConnPool {
+    return newStreamConnPool(logger, idle_timeout, dial, sipStreamFramer{})
+}
+
+func newStreamConnPool(logger sippy_log.ErrorLogger, idle_timeout time.Duration, dial streamDialer, framer streamFramer) *tcpConnPool {
+    if dial == nil {
+        dial = tcpDial
+    }
+    return &tcpConnPool{
+        conns           : make(map[string]*tcpConnection),
+        idle_timeout    : idle_timeout,
+        logger          : logger,
+        dial            : dial,
+        framer          : framer,
+    }
+}
+
+func tcpDial(laddress, raddress *sippy_net.HostPort) (net.Conn, error) {
+    dialer := &net.Dialer{ Timeout : TCP_CONNECT_TIMEOUT }
+    if laddress != nil {
+        if ip := laddress.ParseIP(); ip != nil && ! ip.IsUnspecified() {
+            dialer.LocalAddr = &net.TCPAddr{ IP : ip }
+        }
+    }
+    return dialer.Dial("tcp", raddress.String())
+}
+
+func (self *tcpConnPool) attach() {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    self.nusers++
+    if self.nusers == 1 {
+        self.shutdown_chan = make(chan struct{})
+        // Zero idle timeout keeps the connections open until the peer
+        // closes them
+        if self.idle_timeout > 0 {
+            go self.run_reaper(self.shutdown_chan)
+        }
+    }
+}
+
+func (self *tcpConnPool) detach() {
+    self.lock.Lock()
+    self.nusers--
+    if self.nusers > 0 {
+        self.lock.Unlock()
+        return
+    }
+    close(self.shutdown_chan)
+    conns := make([]*tcpConnection, 0, len(self.conns))
+    for _, c := range self.conns {
+        conns = append(conns, c)
+    }
+    self.lock.Unlock()
+    for _, c := range conns {
+        c.close()
+    }
+}
+
+func (self *tcpConnPool) run_reaper(shutdown_chan chan struct{}) {
+    ival := self.idle_timeout / 4
+    if ival < time.Second {
+        ival = time.Second
+    }
+    for {
+        select {
+        case <-shutdown_chan:
+            return
+        case <-time.After(ival):
+        }
+        idle := []*tcpConnection{}
+        self.lock.Lock()
+        for _, c := range self.conns {
+            if c.idleFor() > self.idle_timeout {
+                idle = append(idle, c)
+            }
+        }
+        self.lock.Unlock()
+        for _, c := range idle {
+            c.close()
+        }
+    }
+}
+
+func (self *tcpConnPool) newConnection(conn net.Conn, raddress *sippy_net.HostPort, server *TcpServer) *tcpConnection {
+    return &tcpConnection{
+        conn        : conn,
+        raddress    : raddress,
+        server      : server,
+        pool        : self,
+        wi          : make(chan *write_req, 1000),
+        done        : make(chan struct{}),
+        last_active : time.Now(),
+    }
+}
+
+func (self *tcpConnPool) getConnection(raddress *sippy_net.HostPort, server *TcpServer) *tcpConnection {
+    key := raddress.String()
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    if c, ok := self.conns[key]; ok {
+        return c
+    }
+    c := self.newConnection(nil, raddress.GetCopy(), server)
+    self.conns[key] = c
+    go c.run_writer()
+    return c
+}
+
+func (self *tcpConnPool) register(conn net.Conn, server *TcpServer) {
+    raddress, err := sippy_net.NewHostPortFromAddr(conn.RemoteAddr())
+    if err != nil {
+        conn.Close()
+        return
+    }
+    rd := bufio.NewReader(conn)
+    conn.SetDeadline(time.Now().Add(TCP_CONNECT_TIMEOUT))
+    if err = self.framer.handshake(conn, rd); err != nil {
+        self.logger.Errorf("%s_server: handshake with %s failed: %s", server.proto, raddress.String(), err.Error())
+        conn.Close()
+        return
+    }
+    conn.SetDeadline(time.Time{})
+    c := self.newConnection(conn, raddress, server)
+    self.lock.Lock()
+    if old_c, ok := self.conns[raddress.String()]; ok {
+        defer old_c.close()
+    }
+    self.conns[raddress.String()] = c
+    self.lock.Unlock()
+    go c.run_writer()
+    c.run_reader(rd)
+}
+
+func (self *tcpConnPool) remove(c *tcpConnection) {
+    self.lock.Lock()
+    defer self.lock.Unlock()
+    key := c.raddress.String()
+    if self.conns[key] == c {
+        delete(self.conns, key)
+    }
+}
+
+type TcpServerOpts struct {
+    LAddress        *sippy_net.HostPort
+    data_callback   sippy_net.DataPacketReceiver
+    IdleTimeout     time.Duration
+    pool            *tcpConnPool
+}
+
+func NewTcpServerOpts(laddress *sippy_net.HostPort, data_callback sippy_net.DataPacketReceiver) *TcpServerOpts {
+    return &TcpServerOpts{
+        LAddress        : laddress,
+        data_callback   : data_callback,
+        IdleTimeout     : TCP_IDLE_TIMEOUT,
+    }
+}
+
+type TcpServer struct {
+    topts           TcpServerOpts
+    listener        net.Listener
+    pool            *tcpConnPool
+    proto           string
+    logger          sippy_log.ErrorLogger
+    sem             chan int
+}
+
+func listenStream(laddress *sippy_net.HostPort) (net.Listener, error) {
+    network := "tcp4"
+    if ip := laddress.ParseIP(); ip != nil && ! sippy_net.IsIP4(ip) {
+        network = "tcp6"
+    }
+    lc := net.ListenConfig{
+        Control : func(network, address string, c syscall.RawConn) error {
+            var err error
+            cerr := c.Control(func(fd uintptr) { err = setSockReuse(int(fd)) })
+            if cerr != nil {
+                return cerr
+            }
+            return err
+        },
+    }
+    return lc.Listen(context.Background(), network, laddress.String())
+}
+
+func NewTcpServer(config sippy_conf.Config, topts *TcpServerOpts) (*TcpServer, error) {
+    listener, err := listenStream(topts.LAddress)
+    if err != nil {
+        return nil, err
+    }
+    return newTcpServer(config, topts, listener, "TCP", nil), nil
+}
+
+func newTcpServer(config sippy_conf.Config, topts *TcpServerOpts, listener net.Listener, proto string, dial streamDialer) *TcpServer {
+    pool := topts.pool
+    if pool == nil {
+        pool = newTcpConnPool(config.ErrorLogger(), topts.IdleTimeout, dial)
+    }
+    self := &TcpServer{
+        topts       : *topts,
+        listener    : listener,
+        pool        : pool,
+        proto       : proto,
+        logger      : config.ErrorLogger(),
+        sem         : make(chan int, 1),
+    }
+    pool.attach()
+    go self.run_acceptor()
+    return self
+}
+
+func (self *TcpServer) run_acceptor() {
+    for {
+        conn, err := self.listener.Accept()
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                break
+            }
+            self.logger.Errorf("%s_server: accept() failed: %s", self.proto, err.Error())
+            time.Sleep(10 * time.Millisecond)
+            continue
+        }
+        go self.pool.register(conn, self)
+    }
+    self.sem <- 1
+}
+
+func (self *TcpServer) SendTo(data []byte, hostport *sippy_net.HostPort) {
+    self.SendToWithCb(data, hostport, nil)
+}
+
+func (self *TcpServer) SendToWithCb(data []byte, hostport *sippy_net.HostPort, on_complete func()) {
+    self.sendToWithErrCb(data, hostport, on_complete, nil)
+}
+
+// sendToWithErrCb is the same as SendToWithCb but also reports the
+// message that could not be delivered, i.e. the connection cannot be
+// established.
+func (self *TcpServer) sendToWithErrCb(data []byte, hostport *sippy_net.HostPort, on_complete func(), on_error func()) {
+    wi := &write_req{
+        data        : self.pool.framer.frameMessage(data),
+        on_complete : on_complete,
+        on_error    : on_error,
+    }
+    if ! self.pool.getConnection(hostport, self).send(wi) {
+        // The connection has just gone away, try a fresh one
+        if ! self.pool.getConnection(hostport, self).send(wi) {
+            self.logger.Errorf("%s_server: cannot send to %s: connection is closed", self.proto, hostport.String())
+            wi.failed()
+        }
+    }
+}
+
+func (self *TcpServer) handle_read(data []byte, address *sippy_net.HostPort, rtime *sippy_time.MonoTime) {
+    if len(data) > 0 {
+        self.topts.data_callback(data, address, self, rtime)
+    }
+}
+
+func (self *TcpServer) Shutdown() {
+    self.listener.Close()
+    <-self.sem
+    self.pool.detach()
+}
+
+func (self *TcpServer) GetLAddress() *sippy_net.HostPort {
+    return self.topts.LAddress
+}
+
+func (self *TcpServer) GetProto() string {
+    return self.proto
+}
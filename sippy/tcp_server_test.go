@@ -0,0 +1,332 @@
+package sippy
+
+import (
+    "bufio"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "math/big"
+    "net"
+    "net/http"
+    "strings"
+    "testing"
+    "testing/iotest"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/time"
+)
+
+const tcp_test_msg = "OPTIONS sip:1.2.3.4 SIP/2.0\r\n" +
+    "Via: SIP/2.0/TCP 127.0.0.1:5060;branch=z9hG4bK1\r\n" +
+    "l: 4\r\n" +
+    "\r\n" +
+    "test"
+
+func Test_ReadStreamMessage(t *testing.T) {
+    rd := bufio.NewReader(strings.NewReader("\r\n\r\n" + tcp_test_msg + "\r\n\r\n" + tcp_test_msg))
+    for i := 0; i < 2; i++ {
+        msg, err := readStreamMessage(rd)
+        if err != nil {
+            t.Fatal("readStreamMessage: " + err.Error())
+        }
+        if string(msg) != tcp_test_msg {
+            t.Fatalf("message #%d mismatch: %q", i, string(msg))
+        }
+    }
+    if _, err := readStreamMessage(rd); err == nil {
+        t.Fatal("readStreamMessage: EOF expected")
+    }
+}
+
+type test_stream_peer struct {
+    replies     []string
+    ping_sent   bool
+}
+
+func (self *test_stream_peer) reply(data []byte) {
+    self.replies = append(self.replies, string(data))
+}
+
+func (self *test_stream_peer) pongExpected() bool {
+    ret := self.ping_sent
+    self.ping_sent = false
+    return ret
+}
+
+func Test_StreamKeepalive(t *testing.T) {
+    // The ping split across the reads is answered once
+    peer := &test_stream_peer{}
+    rd := bufio.NewReader(iotest.OneByteReader(strings.NewReader("\r\n\r\n" + tcp_test_msg)))
+    msg, err := sipStreamFramer{}.readMessage(rd, peer)
+    if err != nil {
+        t.Fatal("readMessage: " + err.Error())
+    }
+    if string(msg) != tcp_test_msg {
+        t.Fatalf("message mismatch: %q", string(msg))
+    }
+    if len(peer.replies) != 1 || peer.replies[0] != "\r\n" {
+        t.Fatalf("the ping has not been answered: %q", peer.replies)
+    }
+    // The pong followed by the message in the same segment
+    peer = &test_stream_peer{ ping_sent : true }
+    rd = bufio.NewReader(strings.NewReader("\r\n" + tcp_test_msg))
+    msg, err = sipStreamFramer{}.readMessage(rd, peer)
+    if err != nil {
+        t.Fatal("readMessage: " + err.Error())
+    }
+    if string(msg) != "\r\n" || len(peer.replies) != 0 {
+        t.Fatalf("the pong has not been passed up: %q", string(msg))
+    }
+    msg, err = sipStreamFramer{}.readMessage(rd, peer)
+    if err != nil {
+        t.Fatal("readMessage: " + err.Error())
+    }
+    if string(msg) != tcp_test_msg {
+        t.Fatalf("the message after the pong has been lost: %q", string(msg))
+    }
+}
+
+func Test_TcpServer(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    listener, err := listenStream(sippy_net.NewHostPort("127.0.0.1", "0"))
+    if err != nil {
+        t.Fatal("Cannot listen: " + err.Error())
+    }
+    test_stream_server(t, config, listener, "TCP", func() (net.Conn, error) {
+        return net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+    })
+}
+
+func Test_TcpConnClose(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    peer, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal("Cannot listen: " + err.Error())
+    }
+    defer peer.Close()
+    raddress, _ := sippy_net.NewHostPortFromAddr(peer.Addr())
+    listener, err := listenStream(sippy_net.NewHostPort("127.0.0.1", "0"))
+    if err != nil {
+        t.Fatal("Cannot listen: " + err.Error())
+    }
+    server := newTcpServer(config, NewTcpServerOpts(sippy_net.NewHostPort("127.0.0.1", "0"), nil), listener, "TCP", nil)
+    defer server.Shutdown()
+    for i := 0; i < 10; i++ {
+        server.SendTo([]byte(tcp_test_msg), raddress)
+        c := server.pool.getConnection(raddress, server)
+        if i % 2 == 0 {
+            // Close the connection while it is being established
+            c.close()
+            continue
+        }
+        conn, err := peer.Accept()
+        if err != nil {
+            t.Fatal("Cannot accept: " + err.Error())
+        }
+        conn.Close()
+        c.close()
+    }
+}
+
+func Test_TlsServer(t *testing.T) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber    : big.NewInt(1),
+        Subject         : pkix.Name{ CommonName : "127.0.0.1" },
+        IPAddresses     : []net.IP{ net.ParseIP("127.0.0.1") },
+        NotBefore       : time.Now().Add(-time.Hour),
+        NotAfter        : time.Now().Add(time.Hour),
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    tls_config := &tls.Config{
+        Certificates    : []tls.Certificate{ { Certificate : [][]byte{ der }, PrivateKey : key } },
+    }
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    listener, err := listenStream(sippy_net.NewHostPort("127.0.0.1", "0"))
+    if err != nil {
+        t.Fatal("Cannot listen: " + err.Error())
+    }
+    test_stream_server(t, config, tls.NewListener(listener, tls_config), "TLS", func() (net.Conn, error) {
+        return tls.Dial("tcp", listener.Addr().String(), &tls.Config{ InsecureSkipVerify : true })
+    })
+}
+
+func Test_TlsDialerVerify(t *testing.T) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber            : big.NewInt(1),
+        Subject                 : pkix.Name{ CommonName : "sip.example.com" },
+        DNSNames                : []string{ "sip.example.com" },
+        NotBefore               : time.Now().Add(-time.Hour),
+        NotAfter                : time.Now().Add(time.Hour),
+        IsCA                    : true,
+        BasicConstraintsValid   : true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+        Certificates    : []tls.Certificate{ { Certificate : [][]byte{ der }, PrivateKey : key } },
+    })
+    if err != nil {
+        t.Fatal("Cannot listen: " + err.Error())
+    }
+    defer listener.Close()
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                conn.(*tls.Conn).Handshake()
+                conn.Close()
+            }()
+        }
+    }()
+    pool := x509.NewCertPool()
+    pool.AddCert(cert)
+    dial := newTlsDialer(&tls.Config{ RootCAs : pool })
+    raddress, _ := sippy_net.NewHostPortFromAddr(listener.Addr())
+    // The certificate does not cover the IP address
+    if conn, err := dial(nil, raddress); err == nil {
+        conn.Close()
+        t.Fatal("the certificate has not been verified")
+    }
+    raddress.ServerName = "sip.example.com"
+    conn, err := dial(nil, raddress)
+    if err != nil {
+        t.Fatal("Cannot connect: " + err.Error())
+    }
+    conn.Close()
+    // Self-signed certificate is rejected without the CA bundle
+    if conn, err = newTlsDialer(&tls.Config{})(nil, raddress); err == nil {
+        conn.Close()
+        t.Fatal("untrusted certificate has been accepted")
+    }
+}
+
+func test_stream_server(t *testing.T, config sippy_conf.Config, listener net.Listener, proto string, dial func() (net.Conn, error)) {
+    received := make(chan []byte, 1)
+    topts := NewTcpServerOpts(sippy_net.NewHostPort("127.0.0.1", "0"), func(data []byte, addr *sippy_net.HostPort, srv sippy_net.Transport, rtime *sippy_time.MonoTime) {
+        // Echo the message back over the same connection
+        srv.SendTo(data, addr)
+        received <- data
+    })
+    server := newTcpServer(config, topts, listener, proto, nil)
+    defer server.Shutdown()
+    conn, err := dial()
+    if err != nil {
+        t.Fatal("Cannot connect: " + err.Error())
+    }
+    defer conn.Close()
+    conn.Write([]byte(tcp_test_msg))
+    select {
+    case data := <-received:
+        if string(data) != tcp_test_msg {
+            t.Fatalf("received message mismatch: %q", string(data))
+        }
+    case <-time.After(time.Second):
+        t.Fatal("Timeout waiting for the message")
+    }
+    conn.SetReadDeadline(time.Now().Add(time.Second))
+    msg, err := readStreamMessage(bufio.NewReader(conn))
+    if err != nil {
+        t.Fatal("Cannot read the response: " + err.Error())
+    }
+    if string(msg) != tcp_test_msg {
+        t.Fatalf("response mismatch: %q", string(msg))
+    }
+}
+
+func Test_WsServer(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    topts := NewTcpServerOpts(sippy_net.NewHostPort("127.0.0.1", "0"), func(data []byte, addr *sippy_net.HostPort, srv sippy_net.Transport, rtime *sippy_time.MonoTime) {
+        srv.SendTo(data, addr)
+    })
+    listener, err := listenStream(topts.LAddress)
+    if err != nil {
+        t.Fatal("Cannot listen: " + err.Error())
+    }
+    topts.pool = newStreamConnPool(config.ErrorLogger(), topts.IdleTimeout, wsDial, wsFramer{})
+    server := newTcpServer(config, topts, listener, "WS", nil)
+    defer server.Shutdown()
+    conn, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+    if err != nil {
+        t.Fatal("Cannot connect: " + err.Error())
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(time.Second))
+    conn.Write([]byte("GET / HTTP/1.1\r\nHost: 127.0.0.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+        "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Protocol: sip\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+    rd := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(rd, nil)
+    if err != nil {
+        t.Fatal("Cannot read the handshake response: " + err.Error())
+    }
+    if resp.StatusCode != 101 || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+        t.Fatalf("bad handshake response: %d %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+    }
+    // Client frames are always masked
+    frame := wsFrame(ws_op_text, []byte(tcp_test_msg))
+    frame[1] |= 0x80
+    mask := []byte{ 1, 2, 3, 4 }
+    payload := frame[2:]
+    for i := range payload {
+        payload[i] ^= mask[i % 4]
+    }
+    conn.Write(append(append(frame[:2:2], mask...), payload...))
+    msg, err := wsFramer{}.readMessage(rd, &test_stream_peer{})
+    if err != nil {
+        t.Fatal("Cannot read the response: " + err.Error())
+    }
+    if string(msg) != tcp_test_msg {
+        t.Fatalf("response mismatch: %q", string(msg))
+    }
+}
+
+func Test_WsIdleConnection(t *testing.T) {
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    ws_pool := NewWsSipTransportFactory(config, sippy_net.NewMyPort("5066")).pool
+    tcp_pool := newTcpConnPool(config.ErrorLogger(), time.Millisecond, nil)
+    conns := []*tcpConnection{}
+    for _, pool := range []*tcpConnPool{ ws_pool, tcp_pool } {
+        raddress := sippy_net.NewHostPort("127.0.0.1", "5060")
+        c := pool.newConnection(nil, raddress, nil)
+        c.last_active = time.Now().Add(-time.Hour)
+        pool.conns[raddress.String()] = c
+        conns = append(conns, c)
+        pool.attach()
+        defer pool.detach()
+    }
+    select {
+    case <-conns[1].done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("The idle TCP connection has not been reaped")
+    }
+    select {
+    case <-conns[0].done:
+        t.Fatal("The idle WebSocket connection has been reaped")
+    case <-time.After(500 * time.Millisecond):
+    }
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "net"
+    "os"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+// NewTlsConfig builds the TLS configuration out of the certificate
+// settings in the sippy_conf.Config.
+func NewTlsConfig(config sippy_conf.Config) (*tls.Config, error) {
+    tls_config := &tls.Config{
+        MinVersion  : tls.VersionTLS12,
+    }
+    if config.GetTlsCertFile() == "" || config.GetTlsKeyFile() == "" {
+        return nil, errors.New("TLS certificate and key files have to be configured")
+    }
+    cert, err := tls.LoadX509KeyPair(config.GetTlsCertFile(), config.GetTlsKeyFile())
+    if err != nil {
+        return nil, err
+    }
+    tls_config.Certificates = []tls.Certificate{ cert }
+    if config.GetTlsCAFile() != "" {
+        pem, err := os.ReadFile(config.GetTlsCAFile())
+        if err != nil {
+            return nil, err
+        }
+        pool := x509.NewCertPool()
+        if ! pool.AppendCertsFromPEM(pem) {
+            return nil, errors.New("no certificates found in " + config.GetTlsCAFile())
+        }
+        tls_config.RootCAs = pool
+        tls_config.ClientCAs = pool
+    }
+    // The certificates of the servers we connect to are always
+    // verified, the clients are only asked for theirs in the mutual
+    // TLS mode.
+    if config.GetTlsVerifyClient() {
+        tls_config.ClientAuth = tls.RequireAndVerifyClientCert
+    } else {
+        tls_config.ClientAuth = tls.NoClientCert
+    }
+    return tls_config, nil
+}
+
+func newTlsDialer(tls_config *tls.Config) streamDialer {
+    return func(laddress, raddress *sippy_net.HostPort) (net.Conn, error) {
+        conn, err := tcpDial(laddress, raddress)
+        if err != nil {
+            return nil, err
+        }
+        cfg := tls_config.Clone()
+        if cfg.ServerName == "" {
+            cfg.ServerName = tlsServerName(raddress)
+        }
+        tconn := tls.Client(conn, cfg)
+        conn.SetDeadline(time.Now().Add(TCP_CONNECT_TIMEOUT))
+        if err = tconn.Handshake(); err != nil {
+            tconn.Close()
+            return nil, err
+        }
+        conn.SetDeadline(time.Time{})
+        return tconn, nil
+    }
+}
+
+// tlsServerName returns the name to verify the server certificate
+// against: the host name the address has been resolved from or the
+// address itself when it was given numerically.
+func tlsServerName(raddress *sippy_net.HostPort) string {
+    if raddress.ServerName != "" {
+        return raddress.ServerName
+    }
+    if ip := raddress.ParseIP(); ip != nil {
+        return ip.String()
+    }
+    return raddress.Host.String()
+}
+
+func NewTlsServer(config sippy_conf.Config, topts *TcpServerOpts, tls_config *tls.Config) (*TcpServer, error) {
+    listener, err := listenStream(topts.LAddress)
+    if err != nil {
+        return nil, err
+    }
+    return newTcpServer(config, topts, tls.NewListener(listener, tls_config), "TLS", newTlsDialer(tls_config)), nil
+}
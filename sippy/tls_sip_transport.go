@@ -0,0 +1,63 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "crypto/tls"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+type tls_sip_transport_factory struct {
+    config      sippy_conf.Config
+    tls_config  *tls.Config
+    pool        *tcpConnPool
+}
+
+func NewTlsSipTransportFactory(config sippy_conf.Config) (*tls_sip_transport_factory, error) {
+    tls_config, err := NewTlsConfig(config)
+    if err != nil {
+        return nil, err
+    }
+    return &tls_sip_transport_factory{
+        config      : config,
+        tls_config  : tls_config,
+        pool        : newTcpConnPool(config.ErrorLogger(), TCP_IDLE_TIMEOUT, newTlsDialer(tls_config)),
+    }, nil
+}
+
+func (self *tls_sip_transport_factory) SetIdleTimeout(idle_timeout time.Duration) {
+    self.pool.idle_timeout = idle_timeout
+}
+
+func (self *tls_sip_transport_factory) NewSipTransport(laddress *sippy_net.HostPort, handler sippy_net.DataPacketReceiver) (sippy_net.Transport, error) {
+    // TLS listens on its own port (5061 by default)
+    topts := NewTcpServerOpts(sippy_net.NewHostPort(laddress.Host.String(), self.config.GetTlsPort().String()), handler)
+    topts.pool = self.pool
+    return NewTlsServer(self.config, topts, self.tls_config)
+}
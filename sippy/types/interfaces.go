@@ -75,7 +75,10 @@ type SipMsg interface {
     GetBody() MsgBody
     SetBody(MsgBody)
     GetContacts() []*sippy_header.SipContact
+    HasContactWildcard() bool
     GetRecordRoutes() []*sippy_header.SipRecordRoute
+    InsertFirstRecordRoute(*sippy_header.SipRecordRoute)
+    GetRoutes() []*sippy_header.SipRoute
     GetCGUID() *sippy_header.SipCiscoGUID
     GetH323ConfId() *sippy_header.SipH323ConfId
     GetSource() *sippy_net.HostPort
@@ -103,7 +106,10 @@ type SipRequest interface {
     GetRURI() *sippy_header.SipURL
     SetRURI(ruri *sippy_header.SipURL)
     GetReferTo() *sippy_header.SipReferTo
+    GetReplaces() *sippy_header.SipReplaces
     GetNated() bool
+    GetFlow() *sippy_net.Flow
+    GetCopy() SipRequest
 }
 
 type SipResponse interface {
@@ -172,6 +178,10 @@ type UA interface {
     GetRAddr0() *sippy_net.HostPort
     SetRAddr0(addr *sippy_net.HostPort)
     GetRTarget() *sippy_header.SipURL
+    GetRTargetTransport() string
+    SetRTargetTransport(string)
+    GetFlow() *sippy_net.Flow
+    SetFlow(*sippy_net.Flow)
     SetRUri(*sippy_header.SipTo)
     GetRUri() *sippy_header.SipTo
     GetUsername() string
@@ -225,16 +235,25 @@ type UA interface {
     Enqueue(CCEvent)
     GetUasResp() SipResponse
     SetUasResp(SipResponse)
+    GetUpdateResp() SipResponse
+    SetUpdateResp(SipResponse)
+    SendUpdateResponse(int, string, MsgBody, ...sippy_header.SipHeader)
+    RemoteAllows(string) bool
+    AcceptRefer(SipRequest, ServerTransaction) bool
+    HasActiveReferSubscription() bool
     CancelCreditTimer()
     StartCreditTimer(*sippy_time.MonoTime)
     SetCreditTime(time.Duration)
     ResetCreditTime(*sippy_time.MonoTime, map[int64]*sippy_time.MonoTime)
     ShouldUseRefer() bool
+    ShouldUseUpdate() bool
+    SetUseUpdate(bool)
     GetState() UaStateID
     GetStateName() string
     Disconnect(*sippy_time.MonoTime, string)
     SetKaInterval(time.Duration)
     GetKaInterval() time.Duration
+    SetSessionTimer(time.Duration, time.Duration)
     OnDead()
     OnUacSetupComplete()
     OnReinvite(SipRequest, CCEvent)
@@ -320,6 +339,7 @@ type ClientTransaction interface {
     CheckRSeq(*sippy_header.SipRSeq) bool
     SetTxnHeaders([]sippy_header.SipHeader)
     GetReqExtraHeaders() []sippy_header.SipHeader
+    GetFlow() *sippy_net.Flow
 }
 
 type ServerTransaction interface {
@@ -342,6 +362,8 @@ type ServerTransaction interface {
 type SipTransactionManager interface {
     RegConsumer(UA, string)
     UnregConsumer(UA, string)
+    RegFlowConsumer(*sippy_net.Flow, func())
+    UnregFlowConsumer(*sippy_net.Flow)
     BeginNewClientTransaction(SipRequest, ResponseReceiver, sync.Locker, *sippy_net.HostPort, sippy_net.Transport, func(SipRequest))
     CreateClientTransaction(SipRequest, ResponseReceiver, sync.Locker, *sippy_net.HostPort,
         sippy_net.Transport, []sippy_header.SipHeader, func(SipRequest)) (ClientTransaction, error)
@@ -350,6 +372,8 @@ type SipTransactionManager interface {
     SendResponseWithLossEmul(resp SipResponse, lock bool, ack_cb func(SipRequest), lossemul int)
     Run()
     Shutdown()
+    GetStats() *SipTMStats
+    GetTransactions() []*TransactionInfo
 }
 
 type UaState interface {
@@ -25,6 +25,10 @@
 package sippy_types
 
 import (
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/net"
     "github.com/sippy/go-b2bua/sippy/time"
 )
 
@@ -38,3 +42,26 @@ type NullString struct {
     String string
     Valid  bool
 }
+
+// SipTMStats is the snapshot of the transaction manager counters. The
+// responses are counted by the status class, i.e. Responses*[2] is the
+// number of 2xx responses.
+type SipTMStats struct {
+    ClientTransactions  int
+    ServerTransactions  int
+    RetransmitsSent     uint64
+    RetransmitsReceived uint64
+    ResponsesSent       [7]uint64
+    ResponsesReceived   [7]uint64
+}
+
+// TransactionInfo is the snapshot of the transaction state returned by
+// the SipTransactionManager.GetTransactions().
+type TransactionInfo struct {
+    TID         sippy_header.TID
+    Method      string
+    State       string
+    Server      bool
+    Age         time.Duration
+    RAddress    *sippy_net.HostPort
+}
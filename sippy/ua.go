@@ -28,6 +28,8 @@ package sippy
 
 import (
     "errors"
+    "math/rand"
+    "strconv"
     "strings"
     "sync"
     "time"
@@ -40,6 +42,9 @@ import (
     "github.com/sippy/go-b2bua/sippy/utils"
 )
 
+// UA_ALLOW lists the methods accepted within the dialog.
+const UA_ALLOW = "INVITE, ACK, CANCEL, BYE, OPTIONS, INFO, PRACK, UPDATE, REFER, NOTIFY"
+
 type Ua struct {
     sip_tm          sippy_types.SipTransactionManager
     sip_tm_lock     sync.RWMutex
@@ -57,6 +62,8 @@ type Ua struct {
     on_remote_sdp_change sippy_types.OnRemoteSdpChange
     cId             *sippy_header.SipCallId
     rTarget         *sippy_header.SipURL
+    rtarget_transport string
+    flow            *sippy_net.Flow
     rAddr0          *sippy_net.HostPort
     rUri            *sippy_header.SipTo
     lUri            *sippy_header.SipFrom
@@ -94,7 +101,12 @@ type Ua struct {
     credit_timer    *Timeout
     uasResp         sippy_types.SipResponse
     useRefer        bool
+    use_update      bool
+    remote_allow    map[string]bool
+    update_resp     sippy_types.SipResponse
+    refer_subs      []*ReferSubscription
     kaInterval      time.Duration
+    session_timer   *sessionTimer
     godead_timeout  time.Duration
     last_scode      int
     _np_mtime       *sippy_time.MonoTime
@@ -180,6 +192,24 @@ func (self *Ua) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTran
         }
     }
     self.rCSeq = cseq_body.CSeq
+    self.update_allow(req)
+    // the session timer is not negotiated by UPDATE within an early dialog
+    if self.session_timer != nil && (req.GetMethod() == "INVITE" || (req.GetMethod() == "UPDATE" && self.isConnected())) {
+        if resp := self.session_timer.recvRequest(req); resp != nil {
+            return &sippy_types.Ua_context{
+                Response : resp,
+                CancelCB : nil,
+                NoAckCB  : nil,
+            }
+        }
+        if req.GetMethod() == "UPDATE" && req.GetBody() == nil {
+            // session refresh
+            resp := req.GenResponse(200, "OK", nil, self.local_ua.AsSipServer())
+            self.session_timer.updateResponse(resp)
+            t.SendResponse(resp, false, nil)
+            return nil
+        }
+    }
     if self.state == nil {
         if req.GetMethod() == "INVITE" {
             if req.GetBody() == nil {
@@ -212,11 +242,16 @@ REQ_LOOP:
             return nil
         }
     }
+    update_resp := self.update_resp
     newstate, cb := self.state.RecvRequest(req, t)
     if newstate != nil {
         self.me().ChangeState(newstate, cb)
     }
     self.emitPendingEvents()
+    if self.update_resp != nil && self.update_resp != update_resp {
+        // keep the UPDATE transaction until the answer is available
+        return &sippy_types.Ua_context{}
+    }
     if newstate != nil && req.GetMethod() == "INVITE" {
         disc_fn := func(rtime *sippy_time.MonoTime) { self.me().Disconnect(rtime, "") }
         if self.pr_rel {
@@ -245,8 +280,16 @@ func (self *Ua) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.Client
         return
     }
     self.update_ua(resp)
+    self.update_allow(resp)
     code, _ := resp.GetSCode()
     orig_req, cseq_found := self.reqs[cseq_body.CSeq]
+    if self.session_timer != nil && self.session_timer.isOurs(tr) {
+        if code >= 200 && cseq_found {
+            delete(self.reqs, cseq_body.CSeq)
+        }
+        self.session_timer.RecvResponse(resp, tr)
+        return
+    }
     if cseq_body.Method == "INVITE" && !self.pass_auth && cseq_found {
         if code == 401 && self.processWWWChallenge(resp, cseq_body.CSeq, orig_req, tr.GetReqExtraHeaders()) {
             return
@@ -254,6 +297,13 @@ func (self *Ua) RecvResponse(resp sippy_types.SipResponse, tr sippy_types.Client
             return
         }
     }
+    if self.session_timer != nil && (cseq_body.Method == "INVITE" || (cseq_body.Method == "UPDATE" && self.isConnected())) {
+        if code == 422 && cseq_found && self.processMinSE(resp, cseq_body.CSeq, tr.GetReqExtraHeaders()) {
+            return
+        } else if code >= 200 && code < 300 {
+            self.session_timer.recvResponse(resp)
+        }
+    }
     if code >= 200 && cseq_found {
         delete(self.reqs, cseq_body.CSeq)
     }
@@ -269,7 +319,13 @@ func (self *Ua) PrepTr(req sippy_types.SipRequest, eh []sippy_header.SipHeader)
     if sip_tm == nil {
         return nil, errors.New("UA already dead")
     }
-    tr, err := sip_tm.CreateClientTransaction(req, self.me(), self.session_lock, /*laddress*/ self.source_address, /*udp_server*/ nil, eh, self.me().BeforeRequestSent)
+    var userv sippy_net.Transport
+    rAddr := self.rAddr
+    if self.flow != nil && self.outbound_proxy == nil {
+        userv, rAddr = self.flow.Transport, self.flow.RAddress
+        req.SetTarget(rAddr)
+    }
+    tr, err := sip_tm.CreateClientTransaction(req, self.me(), self.session_lock, /*laddress*/ self.source_address, /*udp_server*/ userv, eh, self.me().BeforeRequestSent)
     if err != nil {
         return nil, err
     }
@@ -278,7 +334,7 @@ func (self *Ua) PrepTr(req sippy_types.SipRequest, eh []sippy_header.SipHeader)
         routes := make([]*sippy_header.SipRoute, len(self.routes))
         copy(routes, self.routes)
         if self.outbound_proxy == nil {
-            tr.SetAckRparams(self.rAddr, self.rTarget, routes)
+            tr.SetAckRparams(rAddr, self.rTarget, routes)
         } else {
             tr.SetAckRparams(self.outbound_proxy, self.rTarget, routes)
         }
@@ -346,6 +402,17 @@ func (self *Ua) ChangeState(newstate sippy_types.UaState, cb func()) {
         self.state.OnDeactivate()
     }
     self.state = newstate //.Newstate(self, self.config)
+    if newstate != nil {
+        switch newstate.ID() {
+        case sippy_types.UA_STATE_DISCONNECTED, sippy_types.UA_STATE_FAILED, sippy_types.UA_STATE_DEAD:
+            if self.session_timer != nil {
+                self.session_timer.Stop()
+            }
+            if self.update_resp != nil {
+                self.SendUpdateResponse(487, "Request Terminated", nil)
+            }
+        }
+    }
     if newstate != nil {
         newstate.OnActivation()
         if cb != nil {
@@ -410,6 +477,12 @@ func (self *Ua) GenRequest(method string, body sippy_types.MsgBody, challenge si
     if self.dlg_headers != nil {
         req.appendHeaders(self.dlg_headers)
     }
+    if self.use_update && method == "INVITE" {
+        req.AppendHeader(sippy_header.NewSipGenericHF("Allow", UA_ALLOW))
+    }
+    if self.session_timer != nil && (method == "INVITE" || (method == "UPDATE" && self.isConnected())) {
+        self.session_timer.updateRequest(req)
+    }
     self.reqs[self.lCSeq] = req
     self.lCSeq++
     return req, nil
@@ -435,6 +508,17 @@ func (self *Ua) SendUasResponse(t sippy_types.ServerTransaction, scode int, reas
     for _, eh := range extra_headers {
         uasResp.AppendHeader(eh)
     }
+    if self.use_update && scode > 100 && scode < 300 {
+        uasResp.AppendHeader(sippy_header.NewSipGenericHF("Allow", UA_ALLOW))
+    }
+    if self.session_timer != nil && scode >= 200 {
+        self.session_timer.updateResponse(uasResp)
+    }
+    if self.update_resp != nil && scode >= 200 {
+        // The early dialog is over while the offer received in UPDATE is
+        // still unanswered, let the UAC retry it later.
+        self.SendUpdateResponse(500, "Server Internal Error", nil, newSipRetryAfter())
+    }
     var ack_cb func(sippy_types.SipRequest)
     if ack_wait {
         ack_cb = self.me().RecvACK
@@ -449,6 +533,96 @@ func (self *Ua) SendUasResponse(t sippy_types.ServerTransaction, scode int, reas
     }
 }
 
+func (self *Ua) GetUpdateResp() sippy_types.SipResponse {
+    return self.update_resp
+}
+
+// SetUpdateResp stores the response template for the UPDATE carrying the
+// SDP offer until the answer is available.
+func (self *Ua) SetUpdateResp(resp sippy_types.SipResponse) {
+    self.update_resp = resp
+}
+
+// SendUpdateResponse sends the final response to the pending UPDATE.
+func (self *Ua) SendUpdateResponse(scode int, reason string, body sippy_types.MsgBody, extra_headers ...sippy_header.SipHeader) {
+    if self.update_resp == nil {
+        return
+    }
+    resp := self.update_resp
+    self.update_resp = nil
+    resp.SetSCode(scode, reason)
+    resp.SetBody(body)
+    for _, eh := range extra_headers {
+        resp.AppendHeader(eh)
+    }
+    if self.session_timer != nil && self.isConnected() {
+        self.session_timer.updateResponse(resp)
+    }
+    // the lock on the server transaction is already aquired so find it but do not try to lock
+    if sip_tm := self.get_sip_tm(); sip_tm != nil {
+        sip_tm.SendResponseWithLossEmul(resp, /*lock*/ false, nil, self.uas_lossemul)
+    }
+}
+
+// newSipRetryAfter generates the Retry-After with the random value between
+// 0 and 10 seconds as required by RFC 3311 section 5.2.
+func newSipRetryAfter() sippy_header.SipHeader {
+    return sippy_header.NewSipGenericHF("Retry-After", strconv.Itoa(rand.Intn(11)))
+}
+
+// offerPending generates the response to the UPDATE carrying an offer
+// while the offer we have received earlier is not answered yet.
+func offerPending(ua sippy_types.UA, req sippy_types.SipRequest) sippy_types.SipResponse {
+    resp := req.GenResponse(500, "Server Internal Error", nil, ua.GetLocalUA().AsSipServer())
+    resp.AppendHeader(newSipRetryAfter())
+    return resp
+}
+
+// AcceptRefer answers the REFER received within the dialog and passes
+// the Refer-To to the call controller with CCEventDisconnect. Unless the
+// transferor has opted out with "Refer-Sub: false" (RFC 4488) the event
+// carries the implicit subscription to report the transfer progress.
+func (self *Ua) AcceptRefer(req sippy_types.SipRequest, t sippy_types.ServerTransaction) bool {
+    if req.GetReferTo() == nil {
+        t.SendResponseWithLossEmul(req.GenResponse(400, "Bad Request", nil, self.local_ua.AsSipServer()), false, nil, self.uas_lossemul)
+        return false
+    }
+    refer_to, err := req.GetReferTo().GetBody(self.config)
+    if err != nil {
+        self.logError("UA::AcceptRefer: #1: " + err.Error())
+        t.SendResponseWithLossEmul(req.GenResponse(400, "Bad Refer-To", nil, self.local_ua.AsSipServer()), false, nil, self.uas_lossemul)
+        return false
+    }
+    event := NewCCEventDisconnect(refer_to.GetCopy(), req.GetRtime(), self.origin)
+    resp := req.GenResponse(202, "Accepted", nil, self.local_ua.AsSipServer())
+    if referSubRequested(req) {
+        event.refer_sub = newReferSubscription(self.me(), self.config, req)
+        self.refer_subs = append(self.refer_subs, event.refer_sub)
+    } else {
+        resp.AppendHeader(sippy_header.NewSipGenericHF("Refer-Sub", "false"))
+    }
+    t.SendResponseWithLossEmul(resp, false, nil, self.uas_lossemul)
+    if event.refer_sub != nil {
+        // the initial NOTIFY is mandatory
+        event.refer_sub.notify(sippy_header.SUBSCRIPTION_STATE_ACTIVE, "")
+    }
+    self.me().Enqueue(event)
+    return true
+}
+
+// HasActiveReferSubscription returns true while the transferor is still
+// to be notified of the transfer outcome.
+func (self *Ua) HasActiveReferSubscription() bool {
+    active := []*ReferSubscription{}
+    for _, refer_sub := range self.refer_subs {
+        if ! refer_sub.IsTerminated() {
+            active = append(active, refer_sub)
+        }
+    }
+    self.refer_subs = active
+    return len(active) > 0
+}
+
 func (self *Ua) RecvACK(req sippy_types.SipRequest) {
     if !self.isConnected() {
         return
@@ -549,6 +723,25 @@ func (self *Ua) GetDelayedLocalSdpUpdate(event sippy_types.CCEvent) sippy_types.
     return odc_cb
 }
 
+func (self *Ua) update_allow(msg sippy_types.SipMsg) {
+    hfs := msg.GetHFs("allow")
+    if len(hfs) == 0 {
+        return
+    }
+    self.remote_allow = make(map[string]bool)
+    for _, hf := range hfs {
+        for _, method := range strings.Split(hf.StringBody(), ",") {
+            self.remote_allow[strings.ToUpper(strings.TrimSpace(method))] = true
+        }
+    }
+}
+
+// RemoteAllows reports if the method is listed in the Allow header
+// received from the remote side.
+func (self *Ua) RemoteAllows(method string) bool {
+    return self.remote_allow[method]
+}
+
 func (self *Ua) update_ua(msg sippy_types.SipMsg) {
     if msg.GetSipUserAgent() != nil {
         self.remote_ua = msg.GetSipUserAgent().UserAgent
@@ -676,6 +869,26 @@ func (self *Ua) GetRTarget() *sippy_header.SipURL {
     return self.rTarget
 }
 
+func (self *Ua) GetRTargetTransport() string {
+    return self.rtarget_transport
+}
+
+// SetRTargetTransport sets the transport parameter of the Request-URI
+// of the outgoing INVITE, i.e. "tls" to demand the secure delivery.
+func (self *Ua) SetRTargetTransport(transport string) {
+    self.rtarget_transport = transport
+}
+
+func (self *Ua) GetFlow() *sippy_net.Flow {
+    return self.flow
+}
+
+// SetFlow makes all the in-dialog requests to be sent over the flow
+// the dialog has been established over (RFC 5626 section 5.3).
+func (self *Ua) SetFlow(flow *sippy_net.Flow) {
+    self.flow = flow
+}
+
 func (self *Ua) SetRUri(ruri *sippy_header.SipTo) {
     self.rUri = ruri
 }
@@ -915,6 +1128,19 @@ func (self *Ua) OnRemoteSdpChange(body sippy_types.MsgBody, f sippy_types.OnDela
     return nil
 }
 
+func (self *Ua) ShouldUseUpdate() bool {
+    return self.use_update
+}
+
+// SetUseUpdate makes the CCEventUpdate to be sent as UPDATE (RFC 3311)
+// instead of re-INVITE in the confirmed dialog provided the remote side
+// allows it. The early dialog is always updated with UPDATE. The methods
+// supported are advertised in the Allow header of the INVITE and of the
+// responses to it.
+func (self *Ua) SetUseUpdate(use_update bool) {
+    self.use_update = use_update
+}
+
 func (self *Ua) ShouldUseRefer() bool {
     return self.useRefer
 }
@@ -957,6 +1183,17 @@ func (self *Ua) SetKaInterval(ka time.Duration) {
     self.kaInterval = ka
 }
 
+// SetSessionTimer enables the RFC 4028 session timers with the desired
+// session interval and the minimum interval we accept from the remote
+// side. The Min-SE cannot go below 90 seconds. Zero session_expires
+// disables the session timers.
+func (self *Ua) SetSessionTimer(session_expires, min_se time.Duration) {
+    if self.session_timer != nil {
+        self.session_timer.Stop()
+    }
+    self.session_timer = newSessionTimer(self.me(), session_expires, min_se, self.config.ErrorLogger())
+}
+
 func (self *Ua) ResetOnLocalSdpChange() {
     self.on_local_sdp_change = nil
 }
@@ -1331,6 +1568,33 @@ func (self *Ua) processChallenge(challenges []sippy_types.Challenge, cseq int, e
     return true
 }
 
+// processMinSE retries the initial INVITE rejected with 422 Session Interval
+// Too Small with the session interval raised to the Min-SE of the response.
+func (self *Ua) processMinSE(resp sippy_types.SipResponse, cseq int, eh []sippy_header.SipHeader) bool {
+    switch self.GetState() {
+    case sippy_types.UAC_STATE_TRYING, sippy_types.UAC_STATE_RINGING:
+    default:
+        return false
+    }
+    if ! self.session_timer.recv422(resp) {
+        return false
+    }
+    req, err := self.GenRequest("INVITE", self.lSDP, nil, eh...)
+    if err != nil {
+        self.logError("UA::processMinSE: cannot create INVITE: " + err.Error())
+        return false
+    }
+    self.tr, err = self.me().PrepTr(req, eh)
+    if err != nil {
+        self.logError("UA::processMinSE: cannot prepare client transaction: " + err.Error())
+        return false
+    }
+    self.tr.SetTxnHeaders(self.dlg_headers)
+    self.BeginClientTransaction(req, self.tr)
+    delete(self.reqs, cseq)
+    return true
+}
+
 func (self *Ua) PassAuth() bool {
     return self.pass_auth
 }
@@ -1354,7 +1618,12 @@ func (self *Ua) BeginNewClientTransaction(req sippy_types.SipRequest, resp_recei
     if sip_tm == nil {
         return
     }
-    sip_tm.BeginNewClientTransaction(req, resp_receiver, self.session_lock, self.source_address, nil /*userv*/, self.me().BeforeRequestSent)
+    var userv sippy_net.Transport
+    if self.flow != nil && self.outbound_proxy == nil {
+        userv = self.flow.Transport
+        req.SetTarget(self.flow.RAddress)
+    }
+    sip_tm.BeginNewClientTransaction(req, resp_receiver, self.session_lock, self.source_address, userv, self.me().BeforeRequestSent)
 }
 
 func (self *Ua) RegConsumer(consumer sippy_types.UA, call_id string) {
@@ -59,17 +59,14 @@ func (self *UaStateConnected) String() string {
 
 func (self *UaStateConnected) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) (sippy_types.UaState, func()) {
     if req.GetMethod() == "REFER" {
-        if req.GetReferTo() == nil {
-            t.SendResponse(req.GenResponse(400, "Bad Request", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
+        if ! self.ua.AcceptRefer(req, t) {
             return nil, nil
         }
-        t.SendResponse(req.GenResponse(202, "Accepted", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
-        refer_to, err := req.GetReferTo().GetBody(self.config)
-        if err != nil {
-            self.config.ErrorLogger().Error("UaStateConnected::RecvRequest: #1: " + err.Error())
+        if self.ua.HasActiveReferSubscription() {
+            // The BYE is sent by the ReferSubscription once the
+            // transferor has been notified of the outcome.
             return nil, nil
         }
-        self.ua.Enqueue(NewCCEventDisconnect(refer_to.GetCopy(), req.GetRtime(), self.ua.GetOrigin()))
         self.ua.RecvEvent(NewCCEventDisconnect(nil, req.GetRtime(), self.ua.GetOrigin()))
         return nil, nil
     }
@@ -123,6 +120,28 @@ func (self *UaStateConnected) RecvRequest(req sippy_types.SipRequest, t sippy_ty
         self.ua.Enqueue(event)
         return nil, nil
     }
+    if req.GetMethod() == "UPDATE" && req.GetBody() != nil {
+        body := req.GetBody()
+        if self.ua.GetUpdateResp() != nil {
+            t.SendResponse(offerPending(self.ua, req), false, nil)
+            return nil, nil
+        }
+        self.ua.SetUpdateResp(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()))
+        rsdp := self.ua.GetRSDP()
+        if rsdp != nil && rsdp.String() == body.String() {
+            self.ua.SendUpdateResponse(200, "OK", self.ua.GetLSDP())
+            return nil, nil
+        }
+        event := NewCCEventUpdate(req.GetRtime(), self.ua.GetOrigin(), req.GetReason(), req.GetMaxForwards(), body)
+        if self.ua.HasOnRemoteSdpChange() {
+            self.ua.OnRemoteSdpChange(body, func (x sippy_types.MsgBody, ex sippy_types.SipHandlingError) { self.ua.DelayedRemoteSdpUpdate(event, x, ex) })
+            return NewUasStateUpdating(self.ua, self.config), nil
+        } else {
+            self.ua.SetRSDP(body.GetCopy())
+        }
+        self.ua.Enqueue(event)
+        return NewUasStateUpdating(self.ua, self.config), nil
+    }
     if req.GetMethod() == "OPTIONS" || req.GetMethod() == "UPDATE" {
         t.SendResponse(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
         return nil, nil
@@ -138,10 +157,12 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
     eh := event.GetExtraHeaders()
     ok := false
     var redirect *sippy_header.SipAddress = nil
+    var refer_sub *ReferSubscription
 
     switch ev := event.(type) {
     case *CCEventDisconnect:
         redirect = ev.GetRedirectURL()
+        refer_sub = ev.GetReferSubscription()
         ok = true
     case *CCEventRedirect:
         redirect = ev.GetRedirectURL()
@@ -166,7 +187,11 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
             }
             rby := sippy_header.NewSipReferredBy(sippy_header.NewSipAddress("", lUri.GetUrl()))
             req.AppendHeader(rby)
-            self.ua.BeginNewClientTransaction(req, newRedirectController(self.ua))
+            var resp_receiver sippy_types.ResponseReceiver = newRedirectController(self.ua)
+            if refer_sub != nil {
+                resp_receiver = &referOutcome{ refer_sub : refer_sub, next : resp_receiver }
+            }
+            self.ua.BeginNewClientTransaction(req, resp_receiver)
         } else {
             req, err = self.ua.GenRequest("BYE", nil, nil, eh...)
             if err != nil {
@@ -176,7 +201,11 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
                 also := sippy_header.NewSipAlso(redirect)
                 req.AppendHeader(also)
             }
-            self.ua.BeginNewClientTransaction(req, nil)
+            if redirect != nil && refer_sub != nil {
+                self.ua.BeginNewClientTransaction(req, &referOutcome{ refer_sub : refer_sub })
+            } else {
+                self.ua.BeginNewClientTransaction(req, nil)
+            }
         }
         self.ua.CancelCreditTimer()
         self.ua.SetDisconnectTs(event.GetRtime())
@@ -220,7 +249,11 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
             }
             eh2 = append(eh2, sippy_header.NewSipMaxForwards(max_forwards.Number - 1))
         }
-        req, err = self.ua.GenRequest("INVITE", body, nil, eh2...)
+        method := "INVITE"
+        if body != nil && self.ua.ShouldUseUpdate() && self.ua.RemoteAllows("UPDATE") {
+            method = "UPDATE"
+        }
+        req, err = self.ua.GenRequest(method, body, nil, eh2...)
         if err != nil {
             return nil, nil, err
         }
@@ -233,6 +266,11 @@ func (self *UaStateConnected) RecvEvent(event sippy_types.CCEvent) (sippy_types.
         self.ua.BeginClientTransaction(req, tr)
         return NewUacStateUpdating(self.ua, self.config), nil, nil
     }
+    if _event, ok := event.(*CCEventUpdateAnswer); ok {
+        // answer to the UPDATE received before the dialog has been confirmed
+        recvEarlyUpdateAnswer(self.ua, _event)
+        return nil, nil, nil
+    }
     if _event, ok := event.(*CCEventInfo); ok {
         body := _event.GetBody()
         req, err = self.ua.GenRequest("INFO", nil, nil, eh...)
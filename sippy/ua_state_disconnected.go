@@ -63,6 +63,12 @@ func (self *UaStateDisconnected) RecvRequest(req sippy_types.SipRequest, t sippy
 }
 
 func (self *UaStateDisconnected) goDead() {
+    if self.ua.HasActiveReferSubscription() {
+        // The NOTIFYs of the implicit REFER subscription are sent within
+        // this dialog, wait for the transfer outcome to be reported.
+        StartTimeout(self.goDead, self.ua.GetSessionLock(), self.ua.GetGoDeadTimeout(), 1, self.config.ErrorLogger())
+        return
+    }
     //print "Time in Disconnected state expired, going to the Dead state"
     self.ua.ChangeState(NewUaStateDead(self.ua, self.config), nil)
 }
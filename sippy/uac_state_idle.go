@@ -86,6 +86,9 @@ func (self *UacStateIdle) RecvEvent(_event sippy_types.CCEvent) (sippy_types.UaS
             return nil, nil, err
         }
         rUri.GetUrl().Port = nil
+        if self.ua.GetRTargetTransport() != "" {
+            self.ua.GetRTarget().Transport = self.ua.GetRTargetTransport()
+        }
         self.ua.SetLUri(sippy_header.NewSipFrom(sippy_header.NewSipAddress(event.GetCallerName(), sippy_header.NewSipURL(event.GetCLI(), self.config.GetMyAddress(), self.config.GetMyPort(), false)), self.config))
         self.ua.RegConsumer(self.ua, self.ua.GetCallId().CallId)
         lUri, err = self.ua.GetLUri().GetBody(self.config)
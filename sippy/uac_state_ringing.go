@@ -199,8 +199,12 @@ func (self *UacStateRinging) RecvResponse(resp sippy_types.SipResponse, tr sippy
         }
         event = NewCCEventRedirect(code, reason, body, urls, resp.GetRtime(), self.ua.GetOrigin())
     } else {
-        event = NewCCEventFail(code, reason, resp.GetRtime(), self.ua.GetOrigin())
-        event.SetReason(resp.GetReason())
+        event_fail := NewCCEventFail(code, reason, resp.GetRtime(), self.ua.GetOrigin())
+        event_fail.SetReason(resp.GetReason())
+        if hf := resp.GetFirstHF("retry-after"); hf != nil {
+            event_fail.retry_after = hf.GetCopyAsIface()
+        }
+        event = event_fail
     }
     self.ua.Enqueue(event)
     if self.ua.GetSetupTs() != nil && !self.ua.GetSetupTs().After(resp.GetRtime()) {
@@ -213,7 +217,12 @@ func (self *UacStateRinging) RecvResponse(resp sippy_types.SipResponse, tr sippy
 }
 
 func (self *UacStateRinging) RecvEvent(event sippy_types.CCEvent) (sippy_types.UaState, func(), error) {
-    switch event.(type) {
+    switch ev := event.(type) {
+    case *CCEventUpdate:
+        return nil, nil, sendEarlyUpdate(self.ua, ev, self.earlyDialog())
+    case *CCEventUpdateAnswer:
+        recvEarlyUpdateAnswer(self.ua, ev)
+        return nil, nil, nil
     case *CCEventFail:
     case *CCEventRedirect:
     case *CCEventDisconnect:
@@ -232,6 +241,23 @@ func (self *UacStateRinging) RecvEvent(event sippy_types.CCEvent) (sippy_types.U
     return NewUacStateCancelling(self.ua, self.config), func() { self.ua.DiscCb(event.GetRtime(), event.GetOrigin(), self.ua.GetLastScode(), nil) }, nil
 }
 
+// earlyDialog returns true once the early dialog has been established
+// and the initial offer has been answered, so that UPDATE can be used.
+func (self *UacStateRinging) earlyDialog() bool {
+    rUri, err := self.ua.GetRUri().GetBody(self.config)
+    if err != nil {
+        return false
+    }
+    return rUri.GetTag() != "" && self.ua.GetLSDP() != nil && self.ua.GetRSDP() != nil
+}
+
+func (self *UacStateRinging) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) (sippy_types.UaState, func()) {
+    if req.GetMethod() == "UPDATE" {
+        recvEarlyUpdate(self.ua, req, t, self.earlyDialog())
+    }
+    return nil, nil
+}
+
 func (self *UacStateRinging) ID() sippy_types.UaStateID {
     return sippy_types.UAC_STATE_RINGING
 }
@@ -233,6 +233,9 @@ func (self *UacStateTrying) RecvResponse(resp sippy_types.SipResponse, tr sippy_
         if resp.GetReason() != nil {
             event_fail.sip_reason = resp.GetReason().GetCopy()
         }
+        if hf := resp.GetFirstHF("retry-after"); hf != nil {
+            event_fail.retry_after = hf.GetCopyAsIface()
+        }
         event.SetReason(resp.GetReason())
     }
     self.ua.Enqueue(event)
@@ -54,6 +54,13 @@ func (self *UacStateUpdating) RecvRequest(req sippy_types.SipRequest, t sippy_ty
     if req.GetMethod() == "INVITE" {
         t.SendResponse(req.GenResponse(491, "Request Pending", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
         return nil, nil
+    } else if req.GetMethod() == "UPDATE" {
+        if req.GetBody() != nil {
+            t.SendResponse(req.GenResponse(491, "Request Pending", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
+        } else {
+            t.SendResponse(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
+        }
+        return nil, nil
     } else if req.GetMethod() == "BYE" {
         self.ua.GetClientTransaction().Cancel()
         t.SendResponse(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()), false, nil)
@@ -80,7 +87,12 @@ func (self *UacStateUpdating) RecvResponse(resp sippy_types.SipResponse, tr sipp
         return nil, nil
     }
     if code >= 200 && code < 300 {
-        if ! self.ua.GetLateMedia() || body == nil {
+        // The answer to UPDATE is final, there is no ACK to carry our answer
+        update := false
+        if cseq, err := resp.GetCSeq().GetBody(); err == nil && cseq.Method == "UPDATE" {
+            update = true
+        }
+        if update || ! self.ua.GetLateMedia() || body == nil {
             event = NewCCEventConnect(code, reason, body, resp.GetRtime(), self.ua.GetOrigin())
         } else {
             event = NewCCEventPreConnect(code, reason, body, resp.GetRtime(), self.ua.GetOrigin())
@@ -76,6 +76,9 @@ func (self *UasStateIdle) RecvRequest(req sippy_types.SipRequest, t sippy_types.
         return nil, nil
     }
     self.ua.SetRTarget(contact.GetUrl().GetCopy())
+    if contact.GetUrl().IsOutbound() {
+        self.ua.SetFlow(req.GetFlow())
+    }
     self.ua.UpdateRouting(self.ua.GetUasResp(), /*update_rtarget*/ false, /*reverse_routes*/ false)
     self.ua.SetRAddr0(self.ua.GetRAddr())
     t.SendResponseWithLossEmul(self.ua.GetUasResp(), false, nil, self.ua.GetUasLossEmul())
@@ -105,6 +105,11 @@ func (self *UasStateRinging) RecvEvent(_event sippy_types.CCEvent) (sippy_types.
         self.ua.CancelExpireTimer()
         self.ua.SetDisconnectTs(event.GetRtime())
         return NewUaStateFailed(self.ua, self.config), func() { self.ua.FailCb(event.GetRtime(), event.GetOrigin(), code) }, nil
+    case *CCEventUpdate:
+        return nil, nil, sendEarlyUpdate(self.ua, event, false)
+    case *CCEventUpdateAnswer:
+        recvEarlyUpdateAnswer(self.ua, event)
+        return nil, nil, nil
     case *CCEventDisconnect:
         code, reason := self.ua.OnEarlyUasDisconnect(event)
         eh = event.GetExtraHeaders()
@@ -137,6 +142,10 @@ func (self *UasStateRinging) RecvRequest(req sippy_types.SipRequest, t sippy_typ
         self.ua.CancelExpireTimer()
         self.ua.SetDisconnectTs(req.GetRtime())
         return NewUaStateDisconnected(self.ua, self.config), func() { self.ua.DiscCb(req.GetRtime(), self.ua.GetOrigin(), 0, req) }
+    } else if req.GetMethod() == "UPDATE" {
+        // without reliable provisional responses the early offer/answer
+        // exchange cannot be completed, so there is nothing to update
+        recvEarlyUpdate(self.ua, req, t, false)
     }
     return nil, nil
 }
@@ -73,15 +73,33 @@ func (self *UasStateRingingRel) RecvEvent(_event sippy_types.CCEvent) (sippy_typ
             return nil, nil, nil
         }
     case *CCEventUpdate:
-        // 200 OK's been received and re-INVITE has arrived but the last
-        // reliable provisional response is still not aknowledged.
-        // Memorize the event until PRACK is received.
-        self.pending_ev_update = event
-        return nil, nil, nil
+        if self.pending_ev_connect != nil || ! self.earlyAnswered() {
+            // 200 OK's been received and re-INVITE has arrived or the offer
+            // has arrived in the early dialog but the last reliable
+            // provisional response is still not aknowledged.
+            // Memorize the event until PRACK is received.
+            self.pending_ev_update = event
+            return nil, nil, nil
+        }
+        return nil, nil, sendEarlyUpdate(self.ua, event, self.ua.GetLSDP() != nil && self.ua.GetRSDP() != nil)
     }
     return self.UasStateRinging.RecvEvent(_event)
 }
 
+// earlyAnswered returns false while the reliable provisional response
+// carrying the answer has not been aknowledged yet.
+func (self *UasStateRingingRel) earlyAnswered() bool {
+    return self.prack_received || ! self.prack_wait
+}
+
+func (self *UasStateRingingRel) RecvRequest(req sippy_types.SipRequest, t sippy_types.ServerTransaction) (sippy_types.UaState, func()) {
+    if req.GetMethod() == "UPDATE" {
+        recvEarlyUpdate(self.ua, req, t, self.ua.GetLSDP() != nil && self.ua.GetRSDP() != nil && self.earlyAnswered())
+        return nil, nil
+    }
+    return self.UasStateRinging.RecvRequest(req, t)
+}
+
 func (self *UasStateRingingRel) RecvPRACK(req sippy_types.SipRequest, resp sippy_types.SipResponse) {
     var state sippy_types.UaState
     var cb func()
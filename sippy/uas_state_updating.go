@@ -52,8 +52,15 @@ func (self *UasStateUpdating) RecvRequest(req sippy_types.SipRequest, t sippy_ty
     if req.GetMethod() == "INVITE" {
         t.SendResponseWithLossEmul(req.GenResponse(491, "Request Pending", nil, self.ua.GetLocalUA().AsSipServer()), false, nil, self.ua.UasLossEmul())
         return nil, nil
+    } else if req.GetMethod() == "UPDATE" {
+        if req.GetBody() != nil {
+            t.SendResponseWithLossEmul(offerPending(self.ua, req), false, nil, self.ua.UasLossEmul())
+        } else {
+            t.SendResponseWithLossEmul(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()), false, nil, self.ua.UasLossEmul())
+        }
+        return nil, nil
     } else if req.GetMethod() == "BYE" {
-        self.ua.SendUasResponse(t, 487, "Request Terminated", nil, nil, false)
+        self.terminate(t)
         t.SendResponseWithLossEmul(req.GenResponse(200, "OK", nil, self.ua.GetLocalUA().AsSipServer()), false, nil, self.ua.UasLossEmul())
         //print "BYE received in the Updating state, going to the Disconnected state"
         event := NewCCEventDisconnect(nil, req.GetRtime(), self.ua.GetOrigin())
@@ -63,18 +70,10 @@ func (self *UasStateUpdating) RecvRequest(req sippy_types.SipRequest, t sippy_ty
         self.ua.SetDisconnectTs(req.GetRtime())
         return NewUaStateDisconnected(self.ua, self.config), func() { self.ua.DiscCb(req.GetRtime(), self.ua.GetOrigin(), 0, req) }
     } else if req.GetMethod() == "REFER" {
-        if req.GetReferTo() == nil {
-            t.SendResponseWithLossEmul(req.GenResponse(400, "Bad Request", nil, self.ua.GetLocalUA().AsSipServer()), false, nil, self.ua.UasLossEmul())
+        if ! self.ua.AcceptRefer(req, t) {
             return nil, nil
         }
-        self.ua.SendUasResponse(t, 487, "Request Terminated", nil, nil, false)
-        t.SendResponseWithLossEmul(req.GenResponse(202, "Accepted", nil, self.ua.GetLocalUA().AsSipServer()), false, nil, self.ua.UasLossEmul())
-        refer_to, err := req.GetReferTo().GetBody(self.config)
-        if err != nil {
-            self.config.ErrorLogger().Error("UasStateUpdating::RecvRequest: #1: " + err.Error())
-            return nil, nil
-        }
-        self.ua.Enqueue(NewCCEventDisconnect(refer_to.GetCopy(), req.GetRtime(), self.ua.GetOrigin()))
+        self.terminate(t)
         self.ua.CancelCreditTimer()
         self.ua.SetDisconnectTs(req.GetRtime())
         return NewUaStateDisconnected(self.ua, self.config), func() { self.ua.DiscCb(req.GetRtime(), self.ua.GetOrigin(), 0, req) }
@@ -83,7 +82,20 @@ func (self *UasStateUpdating) RecvRequest(req sippy_types.SipRequest, t sippy_ty
     return nil, nil
 }
 
+// terminate answers the pending re-INVITE or UPDATE when the dialog is
+// being torn down.
+func (self *UasStateUpdating) terminate(t sippy_types.ServerTransaction) {
+    if self.ua.GetUpdateResp() != nil {
+        self.ua.SendUpdateResponse(487, "Request Terminated", nil)
+    } else {
+        self.ua.SendUasResponse(t, 487, "Request Terminated", nil, nil, false)
+    }
+}
+
 func (self *UasStateUpdating) RecvEvent(_event sippy_types.CCEvent) (sippy_types.UaState, func(), error) {
+    if self.ua.GetUpdateResp() != nil {
+        return self.recvUpdateAnswer(_event)
+    }
     eh := _event.GetExtraHeaders()
     switch event := _event.(type) {
     case *CCEventRing:
@@ -145,6 +157,58 @@ func (self *UasStateUpdating) RecvEvent(_event sippy_types.CCEvent) (sippy_types
     return nil, nil, nil
 }
 
+// recvUpdateAnswer completes the offer/answer exchange started by UPDATE
+// in the confirmed dialog. Unlike re-INVITE there is no ACK to wait for.
+func (self *UasStateUpdating) recvUpdateAnswer(_event sippy_types.CCEvent) (sippy_types.UaState, func(), error) {
+    eh := _event.GetExtraHeaders()
+    var code int
+    var reason string
+    var body sippy_types.MsgBody
+
+    switch event := _event.(type) {
+    case *CCEventPreConnect:
+        code, reason, body = event.scode, event.scode_reason, event.body
+    case *CCEventConnect:
+        code, reason, body = event.scode, event.scode_reason, event.body
+    case *CCEventUpdateAnswer:
+        code, reason, body = event.scode, event.scode_reason, event.body
+    case *CCEventRedirect:
+        code, reason = event.scode, event.scode_reason
+    case *CCEventFail:
+        code, reason = event.scode, event.scode_reason
+        if code == 0 {
+            code, reason = 500, "Failed"
+        }
+        if event.warning != nil {
+            eh = append(eh, event.warning)
+        }
+    case *CCEventDisconnect:
+        self.ua.SendUpdateResponse(487, "Request Terminated", nil, eh...)
+        req, err := self.ua.GenRequest("BYE", nil, nil, eh...)
+        if err != nil {
+            return nil, nil, err
+        }
+        self.ua.BeginNewClientTransaction(req, nil)
+        self.ua.CancelCreditTimer()
+        self.ua.SetDisconnectTs(event.GetRtime())
+        return NewUaStateDisconnected(self.ua, self.config), func() { self.ua.DiscCb(event.GetRtime(), event.GetOrigin(), 0, nil) }, nil
+    default:
+        return nil, nil, nil
+    }
+    if code >= 300 {
+        self.ua.SetRSDP(nil)
+        self.ua.SendUpdateResponse(code, reason, nil, eh...)
+        return NewUaStateConnected(self.ua, self.config), nil, nil
+    }
+    if body != nil && body.NeedsUpdate() && self.ua.HasOnLocalSdpChange() {
+        self.ua.OnLocalSdpChange(body, self.ua.GetDelayedLocalSdpUpdate(_event))
+        return nil, nil, nil
+    }
+    self.ua.SetLSDP(body)
+    self.ua.SendUpdateResponse(code, reason, body, eh...)
+    return NewUaStateConnected(self.ua, self.config), nil, nil
+}
+
 func (self *UasStateUpdating) RecvCancel(rtime *sippy_time.MonoTime, inreq sippy_types.SipRequest) {
     req, err := self.ua.GenRequest("BYE", nil, nil)
     if err != nil {
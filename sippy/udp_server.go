@@ -54,6 +54,7 @@ type write_req struct {
     address     net.Addr
     data        []byte
     on_complete func()
+    on_error    func()
 }
 
 type resolv_req struct {
@@ -210,6 +211,18 @@ func zoneToUint32(zone string) uint32 {
     return uint32(n)
 }
 
+func setSockReuse(s int) error {
+    if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+        return err
+    }
+    if C.SO_REUSEPORT_EXISTS == 1 {
+        if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, C.SO_REUSEPORT, 1); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
 func NewUdpServer(config sippy_conf.Config, uopts *UdpServerOpts) (*UdpServer, error) {
     var laddress *net.UDPAddr
     var err error
@@ -230,16 +243,10 @@ func NewUdpServer(config sippy_conf.Config, uopts *UdpServerOpts) (*UdpServer, e
     s, err := syscall.Socket(proto, syscall.SOCK_DGRAM, 0)
     if err != nil { return nil, err }
     if laddress != nil {
-        if err = syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+        if err = setSockReuse(s); err != nil {
             syscall.Close(s)
             return nil, err
         }
-        if C.SO_REUSEPORT_EXISTS == 1 {
-            if err = syscall.SetsockoptInt(s, syscall.SOL_SOCKET, C.SO_REUSEPORT, 1); err != nil {
-                syscall.Close(s)
-                return nil, err
-            }
-        }
         var sockaddr syscall.Sockaddr
         if ip4 != nil {
             sockaddr = &syscall.SockaddrInet4{
@@ -333,6 +340,10 @@ func (self *UdpServer) Shutdown() {
     self.aresolvers = make([]*asyncResolver, 0)
 }
 
+func (self *UdpServer) getQueueLoad() float64 {
+    return float64(len(self.wi)) / float64(cap(self.wi))
+}
+
 func (self *UdpServer) GetLAddress() *sippy_net.HostPort {
     return self.uopts.LAddress
 }
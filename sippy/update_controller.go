@@ -0,0 +1,166 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "github.com/sippy/go-b2bua/sippy/headers"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+// updateController receives the response to the UPDATE sent within
+// an early dialog and reports the answer to the other leg.
+type updateController struct {
+    ua      sippy_types.UA
+}
+
+func newUpdateController(ua sippy_types.UA) *updateController {
+    return &updateController{
+        ua : ua,
+    }
+}
+
+func (self *updateController) RecvResponse(resp sippy_types.SipResponse, t sippy_types.ClientTransaction) {
+    code, reason := resp.GetSCode()
+    if code < 200 {
+        return
+    }
+    body := resp.GetBody()
+    event := NewCCEventUpdateAnswer(code, reason, body, resp.GetRtime(), self.ua.GetOrigin())
+    if code >= 300 || body == nil {
+        self.ua.EmitEvent(event)
+        return
+    }
+    if self.ua.HasOnRemoteSdpChange() {
+        err := self.ua.OnRemoteSdpChange(body, func(x sippy_types.MsgBody, ex sippy_types.SipHandlingError) {
+            if ex != nil {
+                self.ua.EmitEvent(ex.GetEvent(ccEventUpdateAnswerCtor))
+                return
+            }
+            self.ua.SetRSDP(x.GetCopy())
+            self.ua.EmitEvent(event)
+        })
+        if err != nil {
+            self.ua.EmitEvent(NewCCEventUpdateAnswer(488, "Not Acceptable Here", nil, resp.GetRtime(), self.ua.GetOrigin()))
+        }
+        return
+    }
+    self.ua.SetRSDP(body.GetCopy())
+    self.ua.EmitEvent(event)
+}
+
+func ccEventUpdateAnswerCtor(scode int, scode_t string, reason sippy_header.SipHeader) sippy_types.CCEvent {
+    if reason != nil {
+        return NewCCEventUpdateAnswer(scode, scode_t, nil, nil, "", reason)
+    }
+    return NewCCEventUpdateAnswer(scode, scode_t, nil, nil, "")
+}
+
+// recvEarlyUpdate passes the offer carried by UPDATE received within
+// an early dialog to the other leg. The answer is expected back as
+// CCEventUpdateAnswer, see recvEarlyUpdateAnswer().
+func recvEarlyUpdate(ua sippy_types.UA, req sippy_types.SipRequest, t sippy_types.ServerTransaction, ready bool) {
+    body := req.GetBody()
+    if body == nil {
+        t.SendResponseWithLossEmul(req.GenResponse(200, "OK", nil, ua.GetLocalUA().AsSipServer()), false, nil, ua.UasLossEmul())
+        return
+    }
+    if ! ready || ua.GetUpdateResp() != nil {
+        t.SendResponseWithLossEmul(offerPending(ua, req), false, nil, ua.UasLossEmul())
+        return
+    }
+    ua.SetUpdateResp(req.GenResponse(200, "OK", nil, ua.GetLocalUA().AsSipServer()))
+    event := NewCCEventUpdate(req.GetRtime(), ua.GetOrigin(), req.GetReason(), req.GetMaxForwards(), body)
+    if ua.HasOnRemoteSdpChange() {
+        err := ua.OnRemoteSdpChange(body, func(x sippy_types.MsgBody, ex sippy_types.SipHandlingError) {
+            if ex != nil {
+                ua.RecvEvent(ex.GetEvent(ccEventUpdateAnswerCtor))
+                return
+            }
+            ua.SetRSDP(x.GetCopy())
+            ua.EmitEvent(event)
+        })
+        if err != nil {
+            ua.SendUpdateResponse(488, "Not Acceptable Here", nil)
+        }
+        return
+    }
+    ua.SetRSDP(body.GetCopy())
+    ua.Enqueue(event)
+}
+
+// recvEarlyUpdateAnswer sends the answer received from the other leg
+// in response to the UPDATE accepted by recvEarlyUpdate().
+func recvEarlyUpdateAnswer(ua sippy_types.UA, event *CCEventUpdateAnswer) {
+    if ua.GetUpdateResp() == nil {
+        return
+    }
+    body := event.GetBody()
+    if event.scode >= 300 {
+        ua.SendUpdateResponse(event.scode, event.scode_reason, nil, event.GetExtraHeaders()...)
+        return
+    }
+    if body != nil && ua.HasOnLocalSdpChange() && body.NeedsUpdate() {
+        ua.OnLocalSdpChange(body, ua.GetDelayedLocalSdpUpdate(event))
+        return
+    }
+    ua.SetLSDP(body)
+    ua.SendUpdateResponse(event.scode, event.scode_reason, body, event.GetExtraHeaders()...)
+}
+
+// sendEarlyUpdate sends the offer received from the other leg as UPDATE
+// within the early dialog. The answer is reported back to the other leg
+// as CCEventUpdateAnswer by the updateController.
+func sendEarlyUpdate(ua sippy_types.UA, event *CCEventUpdate, ready bool) error {
+    body := event.GetBody()
+    if body == nil {
+        ua.Enqueue(NewCCEventUpdateAnswer(200, "OK", nil, event.GetRtime(), event.GetOrigin()))
+        return nil
+    }
+    if ! ready || ! ua.RemoteAllows("UPDATE") {
+        ua.Enqueue(NewCCEventUpdateAnswer(500, "Server Internal Error", nil, event.GetRtime(), event.GetOrigin(), newSipRetryAfter()))
+        return nil
+    }
+    if ua.HasOnLocalSdpChange() && body.NeedsUpdate() {
+        err := ua.OnLocalSdpChange(body, func(_ sippy_types.MsgBody, ex sippy_types.SipHandlingError) {
+            if ex != nil {
+                ua.EmitEvent(ex.GetEvent(ccEventUpdateAnswerCtor))
+                return
+            }
+            ua.RecvEvent(event)
+        })
+        if err != nil {
+            ua.Enqueue(NewCCEventUpdateAnswer(488, "Not Acceptable Here", nil, event.GetRtime(), event.GetOrigin()))
+        }
+        return nil
+    }
+    req, err := ua.GenRequest("UPDATE", body, nil, event.GetExtraHeaders()...)
+    if err != nil {
+        return err
+    }
+    ua.SetLSDP(body)
+    ua.BeginNewClientTransaction(req, newUpdateController(ua))
+    return nil
+}
@@ -0,0 +1,131 @@
+package sippy
+
+import (
+    "strconv"
+    "strings"
+    "testing"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/log"
+    "github.com/sippy/go-b2bua/sippy/net"
+    "github.com/sippy/go-b2bua/sippy/types"
+)
+
+type test_update_call_map struct {
+    test_call_map
+    event       sippy_types.CCEvent
+}
+
+func (self *test_update_call_map) OnNewDialog(req sippy_types.SipRequest, tr sippy_types.ServerTransaction) (sippy_types.UA, sippy_types.RequestReceiver, sippy_types.SipResponse) {
+    self.ua = NewUA(self.sip_tm, self.config, sippy_net.NewHostPort("1.1.1.1", "5060"), self, &self.lock, nil)
+    self.msg_body = req.GetBody()
+    return self.ua, self.ua, nil
+}
+
+func (self *test_update_call_map) RecvEvent(event sippy_types.CCEvent, ua sippy_types.UA) {
+    self.event = event
+}
+
+func testSdp(port string) []string {
+    sdp := []string{
+        "v=0",
+        "o=user1 53655765 2353687637 IN IP4 1.1.1.1",
+        "s=-",
+        "c=IN IP4 1.1.1.1",
+        "t=0 0",
+        "m=audio " + port + " RTP/AVP 0",
+        "a=rtpmap:0 PCMU/8000",
+        "",
+    }
+    return []string{
+        "Content-Type: application/sdp",
+        "Content-Length: " + strconv.Itoa(len(strings.Join(sdp, "\r\n"))),
+        "",
+        strings.Join(sdp, "\r\n"),
+    }
+}
+
+func Test_Update(t *testing.T) {
+    var err error
+
+    config := sippy_conf.NewConfig(sippy_log.NewErrorLogger(), NewTestSipLogger())
+    cmap := &test_update_call_map{ test_call_map : test_call_map{ config : config } }
+    tfactory := NewTestSipTransportFactory()
+    config.SetSipTransportFactory(tfactory)
+    cmap.sip_tm, err = NewSipTransactionManager(config, cmap)
+    if err != nil {
+        t.Fatal("Cannot create SIP transaction manager: " + err.Error())
+    }
+    go cmap.sip_tm.Run()
+    defer cmap.sip_tm.Shutdown()
+
+    tfactory.feed(append([]string{
+        "INVITE sip:bob@example.com SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK5e4d3c",
+        "Max-Forwards: 70",
+        "From: <sip:alice@example.com>;tag=a1b2c3",
+        "To: <sip:bob@example.com>",
+        "Contact: <sip:alice@1.1.1.1:5060>",
+        "Call-ID: 5e4d3c@example.com",
+        "CSeq: 1 INVITE",
+        "Allow: INVITE, ACK, CANCEL, BYE, UPDATE",
+    }, testSdp("11111")...))
+    expectMsg(t, tfactory, config, "SIP/2.0 100 ")
+    cmap.lock.Lock()
+    cmap.answer()
+    cmap.lock.Unlock()
+    resp := expectMsg(t, tfactory, config, "SIP/2.0 200 ")
+    tfactory.feed([]string{
+        "ACK sip:bob@1.1.1.1 SIP/2.0",
+        "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK5e4d3cack",
+        "Max-Forwards: 70",
+        "From: <sip:alice@example.com>;tag=a1b2c3",
+        resp.GetTo().String(),
+        "Call-ID: 5e4d3c@example.com",
+        "CSeq: 1 ACK",
+        "Content-Length: 0",
+        "",
+        "",
+    })
+    if ! cmap.ua.RemoteAllows("UPDATE") {
+        t.Fatal("The Allow header of the INVITE has not been taken into account")
+    }
+    update := func(cseq string) {
+        tfactory.feed(append([]string{
+            "UPDATE sip:bob@1.1.1.1 SIP/2.0",
+            "Via: SIP/2.0/UDP 1.1.1.1:5060;branch=z9hG4bK5e4d3cupd" + cseq,
+            "Max-Forwards: 70",
+            "From: <sip:alice@example.com>;tag=a1b2c3",
+            resp.GetTo().String(),
+            "Contact: <sip:alice@1.1.1.1:5060>",
+            "Call-ID: 5e4d3c@example.com",
+            "CSeq: " + cseq + " UPDATE",
+        }, testSdp("22222")...))
+    }
+    // The new offer is passed to the call controller
+    update("2")
+    event, ok := cmap.event.(*CCEventUpdate)
+    if ! ok || event.GetBody() == nil {
+        t.Fatal("No CCEventUpdate with SDP has been emitted")
+    }
+    if cmap.ua.GetStateName() != "Updating(UAS)" {
+        t.Fatalf("Unexpected UA state %s", cmap.ua.GetStateName())
+    }
+    // Another offer before the first one is answered
+    update("3")
+    resp = expectMsg(t, tfactory, config, "SIP/2.0 500 ")
+    if resp.GetFirstHF("retry-after") == nil {
+        t.Fatal("No Retry-After in 500 response to overlapping UPDATE")
+    }
+    // The answer goes into 200 OK, no ACK is expected
+    cmap.lock.Lock()
+    cmap.ua.RecvEvent(NewCCEventConnect(200, "OK", event.GetBody().GetCopy(), event.GetRtime(), "callee"))
+    cmap.lock.Unlock()
+    resp = expectMsg(t, tfactory, config, "SIP/2.0 200 ")
+    if resp.GetBody() == nil || ! strings.Contains(resp.GetBody().String(), "m=audio 22222 ") {
+        t.Fatal("No SDP answer in 200 OK to UPDATE")
+    }
+    if cmap.ua.GetStateName() != "Connected" {
+        t.Fatalf("Unexpected UA state %s", cmap.ua.GetStateName())
+    }
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "bufio"
+    "crypto/sha1"
+    "crypto/tls"
+    "encoding/base64"
+    "encoding/binary"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+const (
+    WS_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+    ws_op_continuation  = 0x0
+    ws_op_text          = 0x1
+    ws_op_binary        = 0x2
+    ws_op_close         = 0x8
+    ws_op_ping          = 0x9
+    ws_op_pong          = 0xa
+)
+
+// wsFramer implements the server side of the SIP over WebSocket
+// (RFC 7118). Each SIP message is carried in exactly one WebSocket
+// message.
+type wsFramer struct {
+}
+
+func wsAcceptKey(key string) string {
+    h := sha1.Sum([]byte(key + WS_GUID))
+    return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerHasToken(hdr http.Header, name, token string) bool {
+    for _, v := range hdr.Values(name) {
+        for _, t := range strings.Split(v, ",") {
+            if strings.EqualFold(strings.TrimSpace(t), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func (self wsFramer) handshake(conn net.Conn, rd *bufio.Reader) error {
+    req, err := http.ReadRequest(rd)
+    if err != nil {
+        return err
+    }
+    key := req.Header.Get("Sec-WebSocket-Key")
+    if req.Method != "GET" || key == "" || ! headerHasToken(req.Header, "Upgrade", "websocket") ||
+            ! headerHasToken(req.Header, "Connection", "Upgrade") {
+        conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"))
+        return errors.New("not a WebSocket upgrade request")
+    }
+    if ! headerHasToken(req.Header, "Sec-WebSocket-Protocol", "sip") {
+        conn.Write([]byte("HTTP/1.1 426 Upgrade Required\r\nSec-WebSocket-Protocol: sip\r\nContent-Length: 0\r\n\r\n"))
+        return errors.New("\"sip\" WebSocket subprotocol is not requested")
+    }
+    resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+        "Upgrade: websocket\r\n" +
+        "Connection: Upgrade\r\n" +
+        "Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n" +
+        "Sec-WebSocket-Protocol: sip\r\n\r\n"
+    _, err = conn.Write([]byte(resp))
+    return err
+}
+
+func wsFrame(opcode byte, data []byte) []byte {
+    var hdr []byte
+    l := len(data)
+    switch {
+    case l < 126:
+        hdr = []byte{ 0x80 | opcode, byte(l) }
+    case l < 65536:
+        hdr = []byte{ 0x80 | opcode, 126, 0, 0 }
+        binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+    default:
+        hdr = make([]byte, 10)
+        hdr[0], hdr[1] = 0x80 | opcode, 127
+        binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+    }
+    return append(hdr, data...)
+}
+
+func (self wsFramer) frameMessage(data []byte) []byte {
+    return wsFrame(ws_op_text, data)
+}
+
+func (self wsFramer) readMessage(rd *bufio.Reader, peer streamPeer) ([]byte, error) {
+    var msg []byte
+    for {
+        var hdr [2]byte
+        if _, err := io.ReadFull(rd, hdr[:]); err != nil {
+            return nil, err
+        }
+        fin := hdr[0] & 0x80 != 0
+        opcode := hdr[0] & 0x0f
+        masked := hdr[1] & 0x80 != 0
+        l := uint64(hdr[1] & 0x7f)
+        switch l {
+        case 126:
+            var ext [2]byte
+            if _, err := io.ReadFull(rd, ext[:]); err != nil {
+                return nil, err
+            }
+            l = uint64(binary.BigEndian.Uint16(ext[:]))
+        case 127:
+            var ext [8]byte
+            if _, err := io.ReadFull(rd, ext[:]); err != nil {
+                return nil, err
+            }
+            l = binary.BigEndian.Uint64(ext[:])
+        }
+        if l + uint64(len(msg)) > TCP_MAX_MSG_SIZE {
+            return nil, errors.New("WebSocket message is too long")
+        }
+        var mask [4]byte
+        if masked {
+            if _, err := io.ReadFull(rd, mask[:]); err != nil {
+                return nil, err
+            }
+        }
+        payload := make([]byte, l)
+        if _, err := io.ReadFull(rd, payload); err != nil {
+            return nil, err
+        }
+        if masked {
+            for i := range payload {
+                payload[i] ^= mask[i % 4]
+            }
+        }
+        switch opcode {
+        case ws_op_ping:
+            peer.reply(wsFrame(ws_op_pong, payload))
+            continue
+        case ws_op_pong:
+            continue
+        case ws_op_close:
+            peer.reply(wsFrame(ws_op_close, nil))
+            return nil, io.EOF
+        case ws_op_text, ws_op_binary, ws_op_continuation:
+            msg = append(msg, payload...)
+        default:
+            return nil, errors.New("unknown WebSocket opcode")
+        }
+        if fin {
+            return msg, nil
+        }
+    }
+}
+
+func wsDial(laddress, raddress *sippy_net.HostPort) (net.Conn, error) {
+    return nil, errors.New("WebSocket client connections are not supported")
+}
+
+func NewWsServer(config sippy_conf.Config, topts *TcpServerOpts, tls_config *tls.Config) (*TcpServer, error) {
+    listener, err := listenStream(topts.LAddress)
+    if err != nil {
+        return nil, err
+    }
+    if topts.pool == nil {
+        topts.pool = newStreamConnPool(config.ErrorLogger(), topts.IdleTimeout, wsDial, wsFramer{})
+    }
+    if tls_config != nil {
+        return newTcpServer(config, topts, tls.NewListener(listener, tls_config), "WSS", nil), nil
+    }
+    return newTcpServer(config, topts, listener, "WS", nil), nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2026 Sippy Software, Inc. All rights reserved.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+// list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+// this list of conditions and the following disclaimer in the documentation and/or
+// other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+// ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sippy
+
+import (
+    "crypto/tls"
+    "time"
+
+    "github.com/sippy/go-b2bua/sippy/conf"
+    "github.com/sippy/go-b2bua/sippy/net"
+)
+
+type ws_sip_transport_factory struct {
+    config      sippy_conf.Config
+    port        *sippy_net.MyPort
+    tls_config  *tls.Config
+    pool        *tcpConnPool
+}
+
+// The WebSocket transport can only accept the connections, the
+// requests towards the WebSocket clients are sent over the connections
+// they have established (RFC 7118 section 5). Such a connection cannot
+// be re-established once closed, so the idle connections are not reaped
+// unless SetIdleTimeout() says otherwise.
+func NewWsSipTransportFactory(config sippy_conf.Config, port *sippy_net.MyPort) *ws_sip_transport_factory {
+    return &ws_sip_transport_factory{
+        config      : config,
+        port        : port,
+        pool        : newStreamConnPool(config.ErrorLogger(), 0, wsDial, wsFramer{}),
+    }
+}
+
+func NewWssSipTransportFactory(config sippy_conf.Config, port *sippy_net.MyPort) (*ws_sip_transport_factory, error) {
+    tls_config, err := NewTlsConfig(config)
+    if err != nil {
+        return nil, err
+    }
+    // The browsers do not present the client certificates
+    tls_config.ClientAuth = tls.NoClientCert
+    self := NewWsSipTransportFactory(config, port)
+    self.tls_config = tls_config
+    return self, nil
+}
+
+func (self *ws_sip_transport_factory) SetIdleTimeout(idle_timeout time.Duration) {
+    self.pool.idle_timeout = idle_timeout
+}
+
+func (self *ws_sip_transport_factory) NewSipTransport(laddress *sippy_net.HostPort, handler sippy_net.DataPacketReceiver) (sippy_net.Transport, error) {
+    topts := NewTcpServerOpts(sippy_net.NewHostPort(laddress.Host.String(), self.port.String()), handler)
+    topts.pool = self.pool
+    return NewWsServer(self.config, topts, self.tls_config)
+}